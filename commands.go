@@ -0,0 +1,272 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+// commands are run as `logvoyant <name> [flags]` instead of starting the server
+var commands = map[string]func(args []string) error{
+	"schema": runSchema,
+	"export": runExport,
+	"import": runImport,
+	"keygen": runKeygen,
+}
+
+// runSchema inspects, dry-runs or applies database schema migrations
+func runSchema(args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	db := fs.String("db", "./logvoyant.db", "BoltDB database path")
+	dryRun := fs.Bool("dry-run", false, "Apply pending migrations in a transaction, then roll back")
+	apply := fs.Bool("migrate", false, "Apply pending migrations")
+	backup := fs.Bool("backup", true, "Back up the database before applying migrations")
+	compress := fs.Bool("compress", false, "Compress larger log values with zstd when re-encoding them")
+	keyFile := fs.String("encryption-key-file", "", keyFileUsage)
+	fs.Parse(args)
+
+	opts := storage.BoltOptions{Compress: *compress, BackupBeforeMigrate: *backup}
+	var info *storage.SchemaInfo
+	var err error
+	switch {
+	case *dryRun:
+		info, err = storage.DryRunMigrations(*db, opts)
+	case *apply:
+		if opts.Keys, err = storage.LoadKeyring(*keyFile); err != nil {
+			return err
+		}
+		var store storage.Storage
+		store, err = openStorage("bolt", *db, opts)
+		if err == nil {
+			store.Close()
+			info, err = storage.InspectSchema(*db)
+		}
+	default:
+		info, err = storage.InspectSchema(*db)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Schema version: %d (latest: %d)\n", info.Version, info.Latest)
+	if len(info.Pending) == 0 {
+		fmt.Println("No pending migrations")
+		return nil
+	}
+
+	if *dryRun {
+		fmt.Println("Pending migrations (dry run succeeded, nothing was written):")
+	} else {
+		fmt.Println("Pending migrations:")
+	}
+	for _, m := range info.Pending {
+		fmt.Printf("  %s\n", m)
+	}
+	return nil
+}
+
+// runExport dumps streams, logs, analyses and contexts to an NDJSON archive
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	db := fs.String("db", "./logvoyant.db", "Database path (the server must be stopped)")
+	backend := fs.String("storage", "bolt", "Storage backend: bolt or sqlite")
+	out := fs.String("o", "-", "Output file, - for stdout; a .gz suffix compresses it")
+	archiveDir := fs.String("archive-dir", "", "Also export logs from this segment archive")
+	keyFile := fs.String("encryption-key-file", "", keyFileUsage)
+	filter := transferFlags(fs)
+	fs.Parse(args)
+
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+	keys, err := storage.LoadKeyring(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	hot, err := openStorage(*backend, *db, storage.BoltOptions{Keys: keys})
+	if err != nil {
+		return err
+	}
+	defer hot.Close()
+
+	store := hot
+	if *archiveDir != "" {
+		archive, err := storage.NewSegmentArchive(*archiveDir)
+		if err != nil {
+			return err
+		}
+		if bolt, ok := hot.(*storage.BoltStorage); ok {
+			bolt.EncryptArchive(archive)
+		}
+		store = storage.NewTieredStorage(hot, archive)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+
+		if strings.HasSuffix(*out, ".gz") {
+			gz := gzip.NewWriter(file)
+			defer gz.Close()
+			w = gz
+		}
+	}
+
+	// Ctrl-C stops the export between records
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	stats, err := storage.Export(ctx, store, w, f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d streams, %d logs, %d analyses, %d contexts\n",
+		stats.Streams, stats.Logs, stats.Analyses, stats.Contexts)
+	return nil
+}
+
+// runImport loads an NDJSON archive produced by export into a database
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	db := fs.String("db", "./logvoyant.db", "Database path (the server must be stopped)")
+	backend := fs.String("storage", "bolt", "Storage backend: bolt or sqlite")
+	in := fs.String("i", "-", "Input file, - for stdin; a .gz suffix is decompressed")
+	keyFile := fs.String("encryption-key-file", "", keyFileUsage)
+	filter := transferFlags(fs)
+	fs.Parse(args)
+
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+	keys, err := storage.LoadKeyring(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		file, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+
+		if strings.HasSuffix(*in, ".gz") {
+			gz, err := gzip.NewReader(file)
+			if err != nil {
+				return err
+			}
+			defer gz.Close()
+			r = gz
+		}
+	}
+
+	store, err := openStorage(*backend, *db, storage.BoltOptions{Keys: keys})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	stats, err := storage.Import(ctx, store, r, f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Imported %d streams, %d logs, %d analyses, %d contexts\n",
+		stats.Streams, stats.Logs, stats.Analyses, stats.Contexts)
+	return nil
+}
+
+// runKeygen prints a new random encryption key
+func runKeygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	fs.Parse(args)
+
+	key, err := storage.GenerateKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
+
+// openStorage opens the named backend at path; opts only apply to bolt
+func openStorage(backend, path string, opts storage.BoltOptions) (storage.Storage, error) {
+	// Return nil explicitly on failure: a nil *BoltStorage in the interface
+	// would not compare equal to nil
+	switch backend {
+	case "bolt":
+		store, err := storage.OpenBoltStorage(path, opts)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case "sqlite":
+		if opts.Keys != nil {
+			return nil, fmt.Errorf("encryption at rest is only supported by the bolt backend")
+		}
+		store, err := storage.NewSQLiteStorage(path)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q (want bolt or sqlite)", backend)
+}
+
+const keyFileUsage = "File of base64 encryption keys, newest first (default: $" + storage.EncryptionKeyEnv + ")"
+
+// transferFlags registers -streams, -since and -until on fs
+func transferFlags(fs *flag.FlagSet) func() (storage.TransferFilter, error) {
+	streams := fs.String("streams", "", "Comma-separated stream IDs (default: all)")
+	since := fs.String("since", "", "Only data after this time (RFC3339 or a duration like 24h)")
+	until := fs.String("until", "", "Only data before this time (RFC3339 or a duration like 1h)")
+
+	return func() (storage.TransferFilter, error) {
+		var f storage.TransferFilter
+		if *streams != "" {
+			f.Streams = strings.Split(*streams, ",")
+		}
+
+		var err error
+		if f.Since, err = parseTimeFlag(*since); err != nil {
+			return f, fmt.Errorf("invalid -since: %w", err)
+		}
+		if f.Until, err = parseTimeFlag(*until); err != nil {
+			return f, fmt.Errorf("invalid -until: %w", err)
+		}
+		return f, nil
+	}
+}
+
+// parseTimeFlag accepts an RFC3339 time or a duration meaning "that long ago"
+func parseTimeFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
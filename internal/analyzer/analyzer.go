@@ -1,44 +1,86 @@
 package analyzer
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
 	"logvoyant/internal/storage"
 )
 
 type Config struct {
-	Storage    storage.Storage
-	GroqAPIKey string
+	Storage   storage.Storage
+	LLM       LLMProvider  // nil uses pattern matching only
+	Budget    PromptBudget // zero value uses DefaultPromptBudget
+	Templates *Templates   // nil uses the embedded defaults
 }
 
 type Analyzer struct {
 	config   *Config
-	llm      *GroqClient
+	llm      LLMProvider
 	fallback *FallbackAnalyzer
 }
 
 func New(cfg *Config) *Analyzer {
-	var llm *GroqClient
-	if cfg.GroqAPIKey != "" {
-		llm = NewGroqClient(cfg.GroqAPIKey)
+	if cfg.Budget == (PromptBudget{}) {
+		cfg.Budget = DefaultPromptBudget()
+	}
+	if cfg.Templates == nil {
+		templates, err := LoadTemplates("")
+		if err != nil {
+			panic(err) // The embedded defaults are broken
+		}
+		cfg.Templates = templates
 	}
-
 	return &Analyzer{
 		config:   cfg,
-		llm:      llm,
+		llm:      cfg.LLM,
 		fallback: NewFallbackAnalyzer(),
 	}
 }
 
-// Analyze runs context-aware analysis on logs
-func (a *Analyzer) Analyze(streamID string, logs []storage.LogLine) (*storage.Analysis, error) {
+// LLMStatus describes the configured provider and its health
+type LLMStatus struct {
+	Enabled  bool           `json:"enabled"`
+	Provider string         `json:"provider,omitempty"`
+	Breaker  *BreakerStatus `json:"breaker,omitempty"`
+}
+
+// LLMStatus reports which provider is used and, if it is wrapped in a
+// ResilientProvider, its breaker state
+func (a *Analyzer) LLMStatus() LLMStatus {
+	if a.llm == nil {
+		return LLMStatus{}
+	}
+	status := LLMStatus{Enabled: true, Provider: a.llm.Name()}
+	if r, ok := a.llm.(*ResilientProvider); ok {
+		breaker := r.Status()
+		status.Breaker = &breaker
+	}
+	return status
+}
+
+// Analyze runs context-aware analysis on logs. Cancelling ctx aborts the
+// LLM request instead of falling back to pattern matching.
+func (a *Analyzer) Analyze(ctx context.Context, streamID string, logs []storage.LogLine) (*storage.Analysis, error) {
+	return a.AnalyzeStream(ctx, streamID, logs, nil)
+}
+
+// AnalyzeStream is Analyze, but calls onUpdate with the summary and root
+// cause read so far while the LLM reply streams in. The returned analysis is
+// validated like Analyze's and may differ from the last update.
+func (a *Analyzer) AnalyzeStream(ctx context.Context, streamID string, logs []storage.LogLine, onUpdate func(AnalysisUpdate)) (*storage.Analysis, error) {
 	if len(logs) == 0 {
 		return nil, fmt.Errorf("no logs to analyze")
 	}
 
 	// 1. Load historical context
-	ctx, err := a.config.Storage.GetContext(streamID)
+	streamCtx, err := a.config.Storage.GetContext(ctx, streamID)
 	if err != nil {
 		return nil, err
 	}
@@ -47,17 +89,28 @@ func (a *Analyzer) Analyze(streamID string, logs []storage.LogLine) (*storage.An
 	var analysis *storage.Analysis
 	if a.llm != nil {
 		// Build enriched prompt with history
-		prompt := a.buildPrompt(streamID, logs, ctx)
+		prompt, err := a.buildPrompt(ctx, streamID, logs, streamCtx)
+		if err != nil {
+			return nil, err
+		}
 		
-		analysis, err = a.llm.Analyze(prompt)
+		analysis, err = a.askLLM(ctx, prompt, logs, streamCtx, onUpdate)
+		if err == nil {
+			analysis.Context = strings.TrimSpace(analysis.Context + "\n\n" + prompt.Lines)
+		}
 		if err != nil {
-			// LLM failed - fallback to pattern matching
-			fmt.Printf("LLM analysis failed (%v), using fallback\n", err)
-			analysis = a.fallback.Analyze(logs, ctx)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// LLM failed or its breaker is open - fallback to pattern matching
+			if !errors.Is(err, ErrCircuitOpen) {
+				fmt.Printf("LLM analysis via %s failed (%v), using fallback\n", a.llm.Name(), err)
+			}
+			analysis = a.fallback.Analyze(logs, streamCtx)
 		}
 	} else {
 		// No LLM configured, use fallback directly
-		analysis = a.fallback.Analyze(logs, ctx)
+		analysis = a.fallback.Analyze(logs, streamCtx)
 	}
 
 	analysis.StreamID = streamID
@@ -66,67 +119,176 @@ func (a *Analyzer) Analyze(streamID string, logs []storage.LogLine) (*storage.An
 	return analysis, nil
 }
 
-func (a *Analyzer) buildPrompt(streamID string, logs []storage.LogLine, ctx *storage.StreamContext) string {
-	prompt := fmt.Sprintf("# Log Analysis for Stream: %s\n\n", streamID)
+// Save stores analysis and adds its summary to the stream context, so later
+// prompts see it as history
+func (a *Analyzer) Save(ctx context.Context, analysis *storage.Analysis) error {
+	if err := a.config.Storage.StoreAnalysis(ctx, analysis); err != nil {
+		return err
+	}
 
-	// Add historical context
-	if len(ctx.Analyses) > 0 {
-		prompt += "## Historical Context\n"
-		for i, analysis := range ctx.Analyses {
-			if i >= 3 {
-				break // Only include last 3 analyses
-			}
-			resolvedStr := "UNRESOLVED"
-			if analysis.Resolved {
-				resolvedStr = "RESOLVED"
-			}
-			prompt += fmt.Sprintf("- %s: %s (%s, %s)\n",
-				analysis.Timestamp.Format("15:04"),
-				analysis.Summary,
-				analysis.Severity,
-				resolvedStr,
-			)
-		}
-		prompt += "\n"
+	// Update context with new analysis summary
+	err := a.config.Storage.MutateContext(ctx, analysis.StreamID, func(streamCtx *storage.StreamContext) error {
+		streamCtx.Analyses = append(streamCtx.Analyses, storage.AnalysisSummary{
+			Timestamp: analysis.Timestamp,
+			Summary:   analysis.Summary,
+			RootCause: analysis.RootCause,
+			Severity:  analysis.Severity,
+			Resolved:  false,
+		})
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to update context: %v", err)
+	}
+	return nil
+}
+
+// askLLM sends prompt and validates the reply, retrying once with the
+// validation error fed back. If the reply is still invalid, its valid fields
+// are merged with the fallback analysis.
+func (a *Analyzer) askLLM(ctx context.Context, prompt *Prompt, logs []storage.LogLine, streamCtx *storage.StreamContext, onUpdate func(AnalysisUpdate)) (*storage.Analysis, error) {
+	content, err := a.complete(ctx, prompt.System, prompt.User, onUpdate)
+	if err != nil {
+		return nil, err
+	}
+	analysis, err := parseAnalysis(content)
+	if err == nil {
+		return analysis, nil
 	}
 
-	// Add common patterns
-	if len(ctx.Patterns.CommonErrors) > 0 {
-		prompt += "## Common Error Patterns\n"
-		for _, pattern := range ctx.Patterns.CommonErrors {
-			prompt += fmt.Sprintf("- %s\n", pattern)
+	retry := fmt.Sprintf("%s\n\nYour previous reply could not be used: %v\nRespond again with only the corrected JSON object.\n", prompt.User, err)
+	if content, retryErr := a.complete(ctx, prompt.System, retry, onUpdate); retryErr == nil {
+		second, secondErr := parseAnalysis(content)
+		if secondErr == nil {
+			return second, nil
 		}
-		prompt += fmt.Sprintf("- Current error rate: %.1f%%\n\n", ctx.Patterns.ErrorRate*100)
-	}
-
-	// Add recent logs
-	prompt += "## Recent Logs (Last 100 Lines)\n"
-	for _, log := range logs {
-		prompt += fmt.Sprintf("[%s] [%s] %s\n",
-			log.Timestamp.Format("15:04:05"),
-			log.Level,
-			log.Message,
-		)
-	}
-
-	// Add analysis instructions
-	prompt += `
-
-## Analysis Tasks
-1. Is this related to any previous issues in the historical context?
-2. Identify the root cause
-3. Assign severity: P0 (critical), P1 (high), P2 (medium), P3 (low)
-4. Suggest 2-3 actionable fixes
-
-Respond in JSON format:
-{
-  "summary": "Brief one-line summary",
-  "root_cause": "Detailed root cause analysis",
-  "severity": "P0|P1|P2|P3",
-  "fixes": ["Fix 1", "Fix 2", "Fix 3"],
-  "context": "How this relates to previous issues"
+		if second != nil && problemCount(secondErr) <= problemCount(err) {
+			analysis, err = second, secondErr
+		}
+	} else if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if analysis == nil {
+		return nil, err
+	}
+	fmt.Printf("LLM analysis via %s partially invalid (%v), filling in from fallback\n", a.llm.Name(), err)
+	merged := mergeAnalysis(analysis, a.fallback.Analyze(logs, streamCtx))
+	merged.Context = strings.TrimSpace(merged.Context + "\n\nSome fields came from pattern matching because the model reply was invalid (" + err.Error() + ").")
+	return merged, nil
+}
+
+// problemCount ranks parse results: a syntax error is worse than any number
+// of rejected fields
+func problemCount(err error) int {
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		return len(invalid.Problems)
+	}
+	return 1 << 30
 }
-`
 
-	return prompt
-}
\ No newline at end of file
+// Prompt is a rendered pair of system and user messages
+type Prompt struct {
+	System string `json:"system"`
+	User   string `json:"prompt"`
+	Tokens int    `json:"tokens"`   // estimated
+	Lines  string `json:"included"` // which log lines made the cut
+
+	sel *logSelection
+}
+
+// Preview renders the prompt Analyze would send for logs, without calling
+// the LLM
+func (a *Analyzer) Preview(ctx context.Context, streamID string, logs []storage.LogLine) (*Prompt, error) {
+	streamCtx, err := a.config.Storage.GetContext(ctx, streamID)
+	if err != nil {
+		return nil, err
+	}
+	return a.buildPrompt(ctx, streamID, logs, streamCtx)
+}
+
+// promptPatterns returns the stream's most error-prone message templates,
+// then its most frequent ones
+func (a *Analyzer) promptPatterns(ctx context.Context, streamID string) ([]storage.LogPattern, error) {
+	patterns, err := a.config.Storage.GetPatterns(ctx, streamID)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return patterns[i].Errors > patterns[j].Errors
+	})
+	return patterns[:min(patternDepth, len(patterns))], nil
+}
+
+// promptEvents returns the stream's most recent events of the last day,
+// oldest first
+func (a *Analyzer) promptEvents(ctx context.Context, streamID string, now time.Time) ([]storage.Event, error) {
+	events, err := a.config.Storage.ListEvents(ctx, storage.ListEventsOptions{
+		StreamID: streamID,
+		Since:    now.Add(-24 * time.Hour),
+		Limit:    eventDepth,
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(events)
+	return events, nil
+}
+
+// buildPrompt renders the prompt templates with history, patterns and as
+// many of logs as the token budget allows
+func (a *Analyzer) buildPrompt(ctx context.Context, streamID string, logs []storage.LogLine, streamCtx *storage.StreamContext) (*Prompt, error) {
+	data := &PromptData{
+		StreamID:    streamID,
+		Context:     streamCtx,
+		History:     streamCtx.Analyses[max(0, len(streamCtx.Analyses)-historyDepth):],
+		NovelErrors: novelErrors(logs),
+		Now:         time.Now(),
+	}
+	stream, err := a.config.Storage.GetStream(ctx, streamID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	data.Stream = stream
+	data.Patterns, err = a.promptPatterns(ctx, streamID)
+	if err != nil {
+		return nil, err
+	}
+	data.Events, err = a.promptEvents(ctx, streamID, data.Now)
+	if err != nil {
+		return nil, err
+	}
+
+	system, err := a.config.Templates.render(SystemTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Render without logs to see what the budget leaves for them; the
+	// margin covers the "lines omitted" note
+	empty, err := a.config.Templates.render(AnalysisTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+	remaining := a.config.Budget.MaxTokens - estimateTokens(system+empty) - 16
+	sel := selectLogs(logs, a.config.Budget, max(remaining, 0))
+
+	for _, entry := range sel.entries {
+		data.Logs = append(data.Logs, entry.PromptLine)
+	}
+	data.Omitted = sel.total - sel.sent
+
+	user, err := a.config.Templates.render(AnalysisTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Prompt{
+		System: strings.TrimSpace(system),
+		User:   user,
+		Tokens: estimateTokens(system + user),
+		Lines:  sel.describe(),
+		sel:    sel,
+	}, nil
+}
@@ -0,0 +1,373 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+// AnomalyConfig tunes anomaly detection on log volume and level mix
+type AnomalyConfig struct {
+	Interval   time.Duration // how often streams are checked; default 5m
+	Weeks      int           // weeks of hour rollups in a baseline; default 8, at most 12
+	Threshold  float64       // robust z-score that counts as an anomaly; default 3.5
+	MinVolume  float64       // lines an hour before volume and mix are judged; default 30
+	MinSamples int           // past windows a baseline needs; default 3
+}
+
+// Anomaly metrics, recorded as storage.Event.Metric
+const (
+	MetricVolume     = "volume"
+	MetricWarnShare  = "warn_share"
+	MetricErrorShare = "error_share"
+)
+
+// A robust z-score divides by the median absolute deviation, which is zero
+// for a perfectly regular stream. These floors stand in for the least
+// spread worth trusting: about 15% in volume and two points of level share.
+const (
+	volumeMADFloor = 0.15 // of log(1+lines)
+	shareMADFloor  = 0.02
+	minShareChange = 0.05 // level shares must also move this much
+)
+
+const week = 7 * 24 * time.Hour
+
+// maxBaselineWeeks fits a baseline in the 90 days hour rollups are kept
+const maxBaselineWeeks = 12
+
+// AnomalyDetector compares each stream's last hour with the same hour of
+// the week in earlier weeks, built from hour rollups, and records an event
+// when the volume or the WARN or ERROR share is far off. Streams with too
+// little history fall back to the same hour on earlier days. An anomaly is
+// recorded once, when it starts.
+type AnomalyDetector struct {
+	store  storage.Storage
+	config AnomalyConfig
+	notify func(storage.Event) // may be nil
+	now    func() time.Time
+
+	mu     sync.Mutex
+	active map[string]map[string]bool // stream -> metric -> anomalous
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// window counts one hour of a stream's logs
+type window struct {
+	total, warn, errors float64
+}
+
+func (w window) add(b storage.RollupBucket, weight float64) window {
+	w.total += weight * float64(b.Total)
+	w.warn += weight * float64(b.Counts["WARN"])
+	w.errors += weight * float64(b.Counts["ERROR"]+b.Counts["FATAL"])
+	return w
+}
+
+func NewAnomalyDetector(store storage.Storage, cfg AnomalyConfig, notify func(storage.Event)) *AnomalyDetector {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Minute
+	}
+	if cfg.Weeks <= 0 {
+		cfg.Weeks = 8
+	}
+	cfg.Weeks = min(cfg.Weeks, maxBaselineWeeks)
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 3.5
+	}
+	if cfg.MinVolume <= 0 {
+		cfg.MinVolume = 30
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 3
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &AnomalyDetector{
+		store:  store,
+		config: cfg,
+		notify: notify,
+		now:    time.Now,
+		active: make(map[string]map[string]bool),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start checks every stream each Interval
+func (d *AnomalyDetector) Start() {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(d.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := d.Check(d.ctx); err != nil && d.ctx.Err() == nil {
+					log.Printf("Anomaly check failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop waits for a running check to finish
+func (d *AnomalyDetector) Stop() {
+	d.once.Do(func() {
+		d.cancel()
+		d.wg.Wait()
+	})
+}
+
+// Check looks at every stream once and records new anomalies
+func (d *AnomalyDetector) Check(ctx context.Context) error {
+	streams, err := d.store.ListStreams(ctx)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(streams))
+	for _, stream := range streams {
+		seen[stream.ID] = true
+		if err := d.checkStream(ctx, stream.ID); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Anomaly check of stream %s failed: %v", stream.ID, err)
+		}
+	}
+
+	// Forget deleted streams
+	d.mu.Lock()
+	for streamID := range d.active {
+		if !seen[streamID] {
+			delete(d.active, streamID)
+		}
+	}
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *AnomalyDetector) checkStream(ctx context.Context, streamID string) error {
+	end := d.now().Truncate(time.Minute)
+	start := end.Add(-time.Hour)
+
+	minutes, err := d.store.GetHistogram(ctx, streamID, storage.ResolutionMinute, start)
+	if err != nil {
+		return err
+	}
+	var current window
+	for _, b := range minutes {
+		if !b.Start.Before(start) && b.Start.Before(end) {
+			current = current.add(b, 1)
+		}
+	}
+
+	hours, err := d.store.GetHistogram(ctx, streamID, storage.ResolutionHour,
+		start.Add(-time.Duration(d.config.Weeks)*week-time.Hour))
+	if err != nil {
+		return err
+	}
+	samples, seasonality := d.baseline(hours, start)
+	if len(samples) < d.config.MinSamples {
+		d.setActive(streamID, nil)
+		return nil
+	}
+
+	var events []storage.Event
+	anomalous := make(map[string]bool)
+	for _, m := range d.judge(current, samples) {
+		anomalous[m.Metric] = true
+		if d.isActive(streamID, m.Metric) {
+			continue
+		}
+		m.ID = newID()
+		m.StreamID = streamID
+		m.Type = storage.EventAnomaly
+		m.Timestamp = end
+		m.Message += fmt.Sprintf(" (%s baseline)", seasonality)
+		if err := d.store.SaveEvent(ctx, &m); err != nil {
+			return fmt.Errorf("failed to store anomaly: %w", err)
+		}
+		events = append(events, m)
+	}
+	d.setActive(streamID, anomalous)
+
+	for _, e := range events {
+		log.Printf("Anomaly on stream %s: %s", streamID, e.Message)
+		if d.notify != nil {
+			d.notify(e)
+		}
+	}
+	return nil
+}
+
+// baseline returns the counts of the hour from start in earlier weeks, or on
+// earlier days if the stream is too new for that, skipping times before the
+// stream's first logs
+func (d *AnomalyDetector) baseline(hours []storage.RollupBucket, start time.Time) ([]window, string) {
+	byStart := make(map[int64]storage.RollupBucket, len(hours))
+	var first time.Time
+	for _, b := range hours {
+		byStart[b.Start.Unix()] = b
+		if b.Total > 0 && (first.IsZero() || b.Start.Before(first)) {
+			first = b.Start
+		}
+	}
+	if first.IsZero() {
+		return nil, ""
+	}
+
+	// A window straddles two hour buckets; weigh each by its overlap
+	sample := func(t time.Time) (window, bool) {
+		h := t.Truncate(time.Hour)
+		if h.Before(first) {
+			return window{}, false
+		}
+		weight := float64(h.Add(time.Hour).Sub(t)) / float64(time.Hour)
+		w := window{}.add(byStart[h.Unix()], weight)
+		return w.add(byStart[h.Add(time.Hour).Unix()], 1-weight), true
+	}
+
+	var weekly []window
+	for k := 1; k <= d.config.Weeks; k++ {
+		if w, ok := sample(start.Add(-time.Duration(k) * week)); ok {
+			weekly = append(weekly, w)
+		}
+	}
+	if len(weekly) >= d.config.MinSamples {
+		return weekly, "hour-of-week"
+	}
+
+	var daily []window
+	for k := 1; k <= 14; k++ {
+		if w, ok := sample(start.Add(-time.Duration(k) * 24 * time.Hour)); ok {
+			daily = append(daily, w)
+		}
+	}
+	return daily, "hour-of-day"
+}
+
+// judge returns an event for each metric of current that is far from the
+// samples, with Metric, Message, Value, Expected and Score set
+func (d *AnomalyDetector) judge(current window, samples []window) []storage.Event {
+	var events []storage.Event
+
+	totals := make([]float64, len(samples))
+	logTotals := make([]float64, len(samples))
+	for i, s := range samples {
+		totals[i] = s.total
+		logTotals[i] = math.Log1p(s.total)
+	}
+	usual := median(totals)
+	if math.Max(usual, current.total) >= d.config.MinVolume {
+		z := robustZ(math.Log1p(current.total), logTotals, volumeMADFloor)
+		if math.Abs(z) >= d.config.Threshold {
+			var msg string
+			switch {
+			case current.total == 0:
+				msg = fmt.Sprintf("Stream went quiet: no logs in the last hour, usually %.0f", usual)
+			case z < 0:
+				msg = fmt.Sprintf("Log volume dropped to %.0f lines in the last hour, usually %.0f", current.total, usual)
+			default:
+				msg = fmt.Sprintf("Log volume rose to %.0f lines in the last hour, %.1fx the usual %.0f",
+					current.total, current.total/math.Max(usual, 1), usual)
+			}
+			events = append(events, storage.Event{Metric: MetricVolume, Message: msg,
+				Value: current.total, Expected: usual, Score: z})
+		}
+	}
+
+	// Level mix only means something with enough lines now and before
+	if current.total < d.config.MinVolume {
+		return events
+	}
+	for _, level := range []struct {
+		metric, name string
+		count        func(window) float64
+	}{
+		{MetricWarnShare, "WARN", func(w window) float64 { return w.warn }},
+		{MetricErrorShare, "ERROR", func(w window) float64 { return w.errors }},
+	} {
+		var shares []float64
+		for _, s := range samples {
+			if s.total >= 1 {
+				shares = append(shares, level.count(s)/s.total)
+			}
+		}
+		if len(shares) < d.config.MinSamples {
+			continue
+		}
+		share, usualShare := level.count(current)/current.total, median(shares)
+		z := robustZ(share, shares, shareMADFloor)
+		if math.Abs(z) < d.config.Threshold || math.Abs(share-usualShare) < minShareChange {
+			continue
+		}
+		direction := "rose"
+		if z < 0 {
+			direction = "fell"
+		}
+		events = append(events, storage.Event{
+			Metric:   level.metric,
+			Message:  fmt.Sprintf("%s share %s to %.1f%% of lines in the last hour, usually %.1f%%", level.name, direction, share*100, usualShare*100),
+			Value:    share,
+			Expected: usualShare,
+			Score:    z,
+		})
+	}
+	return events
+}
+
+func (d *AnomalyDetector) isActive(streamID, metric string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.active[streamID][metric]
+}
+
+func (d *AnomalyDetector) setActive(streamID string, metrics map[string]bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(metrics) == 0 {
+		delete(d.active, streamID)
+		return
+	}
+	d.active[streamID] = metrics
+}
+
+// robustZ scores x against samples using the median and the median absolute
+// deviation, which a few past outliers cannot drag around
+func robustZ(x float64, samples []float64, madFloor float64) float64 {
+	m := median(samples)
+	deviations := make([]float64, len(samples))
+	for i, s := range samples {
+		deviations[i] = math.Abs(s - m)
+	}
+	mad := math.Max(median(deviations), madFloor)
+	return 0.6745 * (x - m) / mad
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,155 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+// hourOfLogs returns total lines spread over the hour from start, errors of
+// them at ERROR level
+func hourOfLogs(start time.Time, total, errors int) []storage.LogLine {
+	logs := make([]storage.LogLine, total)
+	for i := range logs {
+		logs[i] = storage.LogLine{
+			Timestamp: start.Add(time.Duration(i) * time.Hour / time.Duration(total)),
+			Level:     "INFO",
+			Message:   fmt.Sprintf("request %d served", i),
+		}
+		if i < errors {
+			logs[i].Level = "ERROR"
+		}
+	}
+	return logs
+}
+
+// newTestDetector returns a detector checking at now over a stream that
+// logged 100 lines, 2 of them errors, in the hour before now on each of the
+// given earlier days
+func newTestDetector(t *testing.T, now time.Time, daysBack ...int) (*AnomalyDetector, storage.Storage, *[]storage.Event) {
+	t.Helper()
+	store := storage.NewMemoryStorage()
+	for _, days := range daysBack {
+		start := now.Add(-time.Hour - time.Duration(days)*24*time.Hour)
+		if err := store.StoreLogs(context.Background(), "s", hourOfLogs(start, 100, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var notified []storage.Event
+	d := NewAnomalyDetector(store, AnomalyConfig{}, func(e storage.Event) { notified = append(notified, e) })
+	d.now = func() time.Time { return now }
+	return d, store, &notified
+}
+
+func TestAnomalyDetectorVolume(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	ctx := context.Background()
+
+	for name, tc := range map[string]struct {
+		lines  int
+		metric string
+		want   string
+	}{
+		"quiet":  {0, MetricVolume, "Stream went quiet: no logs in the last hour, usually 100 (hour-of-week baseline)"},
+		"surge":  {1000, MetricVolume, "Log volume rose to 1000 lines in the last hour, 10.0x the usual 100"},
+		"usual":  {110, "", ""},
+		"errors": {100, MetricErrorShare, "ERROR share rose to 40.0% of lines in the last hour, usually 2.0%"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d, store, notified := newTestDetector(t, now, 7, 14, 21, 28)
+			errors := 2
+			if tc.metric == MetricErrorShare {
+				errors = 40
+			}
+			if tc.lines > 0 {
+				store.StoreLogs(ctx, "s", hourOfLogs(now.Add(-time.Hour), tc.lines, errors))
+			}
+
+			if err := d.Check(ctx); err != nil {
+				t.Fatal(err)
+			}
+			events, err := store.ListEvents(ctx, storage.ListEventsOptions{StreamID: "s"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.metric == "" {
+				if len(events) != 0 {
+					t.Fatalf("usual hour recorded %+v", events)
+				}
+				return
+			}
+			if len(events) != 1 || len(*notified) != 1 {
+				t.Fatalf("got events %+v, notified %d", events, len(*notified))
+			}
+			e := events[0]
+			if e.Type != storage.EventAnomaly || e.Metric != tc.metric || !strings.HasPrefix(e.Message, tc.want) {
+				t.Errorf("event = %+v, want %s %q", e, tc.metric, tc.want)
+			}
+			if !e.Timestamp.Equal(now) {
+				t.Errorf("event at %v, want %v", e.Timestamp, now)
+			}
+		})
+	}
+}
+
+func TestAnomalyDetectorRecordsOncePerAnomaly(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	ctx := context.Background()
+	d, store, _ := newTestDetector(t, now, 7, 14, 21)
+
+	// Quiet for two checks, then back to normal, then quiet again
+	d.Check(ctx)
+	d.now = func() time.Time { return now.Add(5 * time.Minute) }
+	d.Check(ctx)
+	if events, _ := store.ListEvents(ctx, storage.ListEventsOptions{}); len(events) != 1 {
+		t.Fatalf("ongoing anomaly recorded %d times", len(events))
+	}
+
+	d.setActive("s", nil)
+	d.Check(ctx)
+	if events, _ := store.ListEvents(ctx, storage.ListEventsOptions{}); len(events) != 2 {
+		t.Fatalf("new anomaly after it cleared: got %d events, want 2", len(events))
+	}
+}
+
+func TestAnomalyDetectorBaselines(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	ctx := context.Background()
+
+	// A stream only a few days old is compared with earlier days
+	d, store, _ := newTestDetector(t, now, 1, 2, 3)
+	d.Check(ctx)
+	events, _ := store.ListEvents(ctx, storage.ListEventsOptions{})
+	if len(events) != 1 || !strings.HasSuffix(events[0].Message, "(hour-of-day baseline)") {
+		t.Fatalf("events = %+v", events)
+	}
+
+	// Too little history means no judgement
+	d, store, _ = newTestDetector(t, now, 1)
+	d.Check(ctx)
+	if events, _ := store.ListEvents(ctx, storage.ListEventsOptions{}); len(events) != 0 {
+		t.Fatalf("judged without a baseline: %+v", events)
+	}
+}
+
+func TestRobustZ(t *testing.T) {
+	samples := []float64{10, 11, 9, 10, 500} // one outlier barely moves the baseline
+	if z := robustZ(10, samples, 0.1); math.Abs(z) > 0.01 {
+		t.Errorf("typical value scored %v", z)
+	}
+	if z := robustZ(20, samples, 0.1); z < 3.5 {
+		t.Errorf("doubled value scored %v", z)
+	}
+	if z := robustZ(5, []float64{5, 5, 5}, 0.5); z != 0 {
+		t.Errorf("flat baseline scored %v", z)
+	}
+	if m := median([]float64{4, 1, 3, 2}); m != 2.5 {
+		t.Errorf("median = %v", m)
+	}
+}
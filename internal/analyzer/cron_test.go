@@ -0,0 +1,48 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2024, 5, 15, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 5, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 5, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 7 * * *", time.Date(2024, 5, 16, 7, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2024, 5, 16, 10, 30, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2024, 5, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2024, 5, 19, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 */3 *", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 8,18 * * *", time.Date(2024, 5, 15, 18, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either matches
+		{"0 0 1 * 5", time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tc := range tests {
+		cron, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tc.expr, err)
+			continue
+		}
+		if got := cron.Next(from); !got.Equal(tc.want) {
+			t.Errorf("%q: Next = %s, want %s", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseCronRejects(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@yearly"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded", expr)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"logvoyant/internal/storage"
+)
+
+// digestTopIssues caps how many issues a digest lists
+const digestTopIssues = 10
+
+// recurringSimilarity is the share of words two summaries must have in
+// common to count as the same issue
+const recurringSimilarity = 0.5
+
+// BuildDigest analyzes each stream's logs between since and until and
+// aggregates the results. Streams without errors in the window are counted
+// but not analyzed. The analyses are saved to their streams' history like
+// any other; the digest itself is not stored. Scheduled digests go through
+// JobQueue.BuildDigest instead, which limits how many analyses run at once.
+func (a *Analyzer) BuildDigest(ctx context.Context, name string, streamIDs []string, since, until time.Time) (*storage.Digest, error) {
+	return a.buildDigest(ctx, nil, name, streamIDs, since, until)
+}
+
+// buildDigest holds sem for each stream's analysis
+func (a *Analyzer) buildDigest(ctx context.Context, sem semaphore, name string, streamIDs []string, since, until time.Time) (*storage.Digest, error) {
+	store := a.config.Storage
+	if len(streamIDs) == 0 {
+		streams, err := store.ListStreams(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, stream := range streams {
+			streamIDs = append(streamIDs, stream.ID)
+		}
+	}
+
+	digest := &storage.Digest{
+		ID:         newID(),
+		Schedule:   name,
+		Since:      since,
+		Until:      until,
+		CreatedAt:  time.Now(),
+		Issues:     []storage.DigestIssue{},
+		Unresolved: []storage.DigestIssue{},
+	}
+	for _, streamID := range streamIDs {
+		issue, active, err := a.digestStream(ctx, sem, streamID, since, until)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.Printf("Digest %s: skipping stream %s: %v", name, streamID, err)
+			continue
+		}
+		if active {
+			digest.Streams++
+		}
+		if issue != nil {
+			digest.Issues = append(digest.Issues, *issue)
+		}
+	}
+
+	// Most severe first, then the noisiest
+	sort.SliceStable(digest.Issues, func(i, j int) bool {
+		x, y := digest.Issues[i], digest.Issues[j]
+		if x.Severity != y.Severity {
+			return x.Severity < y.Severity
+		}
+		return x.Errors > y.Errors
+	})
+	if len(digest.Issues) > digestTopIssues {
+		digest.Issues = digest.Issues[:digestTopIssues]
+	}
+
+	// Open P0/P1 issues, whenever they were found
+	for _, streamID := range streamIDs {
+		streamCtx, err := store.GetContext(ctx, streamID)
+		if err != nil {
+			continue
+		}
+		for _, summary := range streamCtx.Analyses {
+			if summary.Resolved || (summary.Severity != "P0" && summary.Severity != "P1") {
+				continue
+			}
+			digest.Unresolved = append(digest.Unresolved, storage.DigestIssue{
+				StreamID:  streamID,
+				Timestamp: summary.Timestamp,
+				Summary:   summary.Summary,
+				RootCause: summary.RootCause,
+				Severity:  summary.Severity,
+			})
+		}
+	}
+	sort.SliceStable(digest.Unresolved, func(i, j int) bool {
+		return digest.Unresolved[i].Timestamp.After(digest.Unresolved[j].Timestamp)
+	})
+	return digest, nil
+}
+
+// digestStream analyzes one stream's window. active reports whether the
+// stream logged anything; the issue is nil if none of it was an error.
+func (a *Analyzer) digestStream(ctx context.Context, sem semaphore, streamID string, since, until time.Time) (issue *storage.DigestIssue, active bool, err error) {
+	store := a.config.Storage
+	logs, err := store.GetLogs(ctx, streamID, storage.GetLogsOptions{Since: since, Until: until, Limit: MaxInputLines})
+	if err != nil {
+		return nil, false, err
+	}
+	errors := 0
+	for _, line := range logs {
+		if isErrorLevel(line.Level) {
+			errors++
+		}
+	}
+	if errors == 0 {
+		return nil, len(logs) > 0, nil
+	}
+
+	// Read the history before this analysis joins it
+	streamCtx, err := store.GetContext(ctx, streamID)
+	if err != nil {
+		return nil, true, err
+	}
+
+	if err := sem.acquire(ctx); err != nil {
+		return nil, true, err
+	}
+	analysis, err := a.Analyze(ctx, streamID, logs)
+	sem.release()
+	if err != nil {
+		return nil, true, err
+	}
+	if err := a.Save(ctx, analysis); err != nil {
+		return nil, true, fmt.Errorf("failed to store analysis: %w", err)
+	}
+
+	issue = &storage.DigestIssue{
+		StreamID:  streamID,
+		Timestamp: analysis.Timestamp,
+		Summary:   analysis.Summary,
+		RootCause: analysis.RootCause,
+		Severity:  analysis.Severity,
+		Errors:    errors,
+	}
+	for _, past := range streamCtx.Analyses {
+		if past.Timestamp.Before(since) && similarText(past.Summary, analysis.Summary) {
+			issue.Recurring = true
+			break
+		}
+	}
+	return issue, true, nil
+}
+
+// similarText reports whether two summaries share most of their words.
+// Numbers are ignored, so "12 errors" and "40 errors" match.
+func similarText(a, b string) bool {
+	wa, wb := wordSet(a), wordSet(b)
+	if len(wa) == 0 || len(wb) == 0 {
+		return false
+	}
+	shared := 0
+	for w := range wa {
+		if wb[w] {
+			shared++
+		}
+	}
+	union := len(wa) + len(wb) - shared
+	return float64(shared)/float64(union) >= recurringSimilarity
+}
+
+func wordSet(s string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		if len(w) > 2 {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+// FormatDigestMarkdown renders a digest for reading or posting to chat
+func FormatDigestMarkdown(d *storage.Digest) string {
+	var b strings.Builder
+	const stamp = "2006-01-02 15:04"
+
+	fmt.Fprintf(&b, "# %s digest\n\n", d.Schedule)
+	fmt.Fprintf(&b, "%s to %s, %d streams with logs.\n\n", d.Since.Format(stamp), d.Until.Format(stamp), d.Streams)
+
+	b.WriteString("## Top issues\n\n")
+	if len(d.Issues) == 0 {
+		b.WriteString("No errors in this period.\n\n")
+	}
+	for _, issue := range d.Issues {
+		label := "new"
+		if issue.Recurring {
+			label = "recurring"
+		}
+		fmt.Fprintf(&b, "- **%s** `%s` %s (%s, %d errors)\n", issue.Severity, issue.StreamID, issue.Summary, label, issue.Errors)
+		if issue.RootCause != "" {
+			fmt.Fprintf(&b, "  - Root cause: %s\n", issue.RootCause)
+		}
+	}
+	if len(d.Issues) > 0 {
+		b.WriteString("\n")
+	}
+
+	b.WriteString("## Unresolved P0/P1\n\n")
+	if len(d.Unresolved) == 0 {
+		b.WriteString("None.\n")
+	}
+	for _, issue := range d.Unresolved {
+		fmt.Fprintf(&b, "- **%s** `%s` %s (since %s)\n", issue.Severity, issue.StreamID, issue.Summary, issue.Timestamp.Format(stamp))
+	}
+	return b.String()
+}
@@ -0,0 +1,76 @@
+package analyzer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+func TestBuildDigest(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	now := time.Now()
+	since := now.Add(-24 * time.Hour)
+
+	db := make([]storage.LogLine, 10)
+	for i := range db {
+		db[i] = storage.LogLine{Timestamp: now.Add(-time.Duration(i+1) * time.Minute), Level: "ERROR", Message: "connection refused to postgres:5432"}
+	}
+	store.StoreLogs(ctx, "db", db)
+	store.StoreLogs(ctx, "quiet", []storage.LogLine{{Timestamp: now.Add(-time.Hour), Level: "INFO", Message: "ok"}})
+	store.StoreLogs(ctx, "stale", []storage.LogLine{{Timestamp: now.Add(-48 * time.Hour), Level: "ERROR", Message: "disk full"}})
+
+	// The same problem was seen, and never resolved, last week
+	store.MutateContext(ctx, "db", func(streamCtx *storage.StreamContext) error {
+		streamCtx.Analyses = append(streamCtx.Analyses, storage.AnalysisSummary{
+			Timestamp: now.Add(-7 * 24 * time.Hour),
+			Summary:   "Detected connection errors (3 errors, 0 warnings)",
+			Severity:  "P1",
+		})
+		return nil
+	})
+
+	a := New(&Config{Storage: store})
+	digest, err := a.BuildDigest(ctx, "morning", nil, since, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if digest.Streams != 2 {
+		t.Errorf("Streams = %d, want 2 (db and quiet)", digest.Streams)
+	}
+	if len(digest.Issues) != 1 {
+		t.Fatalf("Issues = %+v", digest.Issues)
+	}
+	issue := digest.Issues[0]
+	if issue.StreamID != "db" || issue.Errors != 10 || !issue.Recurring {
+		t.Errorf("issue = %+v", issue)
+	}
+	if len(digest.Unresolved) != 2 || !digest.Unresolved[0].Timestamp.After(digest.Unresolved[1].Timestamp) {
+		t.Errorf("Unresolved = %+v", digest.Unresolved)
+	}
+
+	// The analysis joins the stream's history
+	if history, _ := store.GetAnalysisHistory(ctx, "db", 0); len(history) != 1 {
+		t.Errorf("analysis history = %+v", history)
+	}
+
+	md := FormatDigestMarkdown(digest)
+	for _, want := range []string{"# morning digest", "`db` Detected connection errors", "recurring, 10 errors", "## Unresolved P0/P1"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown lacks %q:\n%s", want, md)
+		}
+	}
+}
+
+func TestSimilarText(t *testing.T) {
+	if !similarText("Detected connection errors (3 errors)", "Detected connection errors (40 errors)") {
+		t.Error("summaries differing only in counts should match")
+	}
+	if similarText("Detected connection errors", "Out of memory in worker pool") {
+		t.Error("unrelated summaries matched")
+	}
+}
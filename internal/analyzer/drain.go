@@ -0,0 +1,188 @@
+package analyzer
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Template mining follows Drain (He et al., "Drain: An Online Log Parsing
+// Approach with Fixed Depth Tree", ICWS 2017). Messages are routed by token
+// count and their first few tokens to a small set of templates, and join the
+// most similar one or start their own. Tokens that differ between a template
+// and a joining message become <*>.
+const (
+	drainDepth       = 4   // tree levels: token count, then drainDepth-2 leading tokens
+	drainSimilarity  = 0.4 // share of tokens a message must have in common with a template
+	drainMaxChildren = 100 // per node, after which new tokens route through <*>
+	drainWildcard    = "<*>"
+)
+
+// drainMasks replace variable tokens before mining, so they never split a
+// template. Each applies to a token without its surrounding punctuation, or
+// to the value of a key=value token.
+var drainMasks = []struct {
+	re   *regexp.Regexp
+	mask string
+}{
+	{regexp.MustCompile(`^\d{1,3}(\.\d{1,3}){3}(:\d+)?$`), "<IP>"},
+	{regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`), "<UUID>"},
+	{regexp.MustCompile(`^0[xX][0-9a-fA-F]+$`), "<HEX>"},
+	{regexp.MustCompile(`^[-+]?\d+(\.\d+)?([a-zµ]{1,2}|%)?$`), drainWildcard},                        // numbers, 250ms, 12kb, 99%
+	{regexp.MustCompile(`^[0-9a-fA-F]{8,}$`), "<HEX>"},                                               // SHAs, ObjectIDs, trace IDs
+	{regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*[-_#:][A-Za-z]*\d[A-Za-z]*\d[A-Za-z0-9]*$`), "<ID>"}, // req_7f3a9c, user-1234, job#42
+}
+
+// drain is one parse tree. It is not safe for concurrent use.
+type drain struct {
+	root     map[int]*drainNode // by token count
+	clusters []*drainCluster
+}
+
+type drainNode struct {
+	children map[string]*drainNode
+	clusters []*drainCluster // at leaves
+}
+
+type drainCluster struct {
+	tokens []string
+	leaf   *drainNode
+	data   any // owned by the caller
+}
+
+func newDrain() *drain {
+	return &drain{root: make(map[int]*drainNode)}
+}
+
+// drainTokens masks and splits a message
+func drainTokens(message string) []string {
+	tokens := strings.Fields(message)
+	for i, token := range tokens {
+		tokens[i] = maskToken(token)
+	}
+	return tokens
+}
+
+func maskToken(token string) string {
+	if k, v, ok := strings.Cut(token, "="); ok && k != "" {
+		return k + "=" + maskToken(v)
+	}
+	core := strings.TrimFunc(token, func(r rune) bool {
+		return strings.ContainsRune(`,;:()[]{}"'`, r)
+	})
+	if core == "" {
+		return token
+	}
+	for _, m := range drainMasks {
+		if m.re.MatchString(core) {
+			return strings.Replace(token, core, m.mask, 1)
+		}
+	}
+	return token
+}
+
+// add mines one tokenized message. It returns the cluster the message joined
+// and whether that cluster is new.
+func (d *drain) add(tokens []string) (*drainCluster, bool) {
+	leaf := d.leaf(tokens)
+	if c := leaf.match(tokens); c != nil {
+		for i, token := range tokens {
+			if c.tokens[i] != token {
+				c.tokens[i] = drainWildcard
+			}
+		}
+		return c, false
+	}
+
+	return d.insert(tokens), true
+}
+
+// insert adds tokens as a cluster of its own, even if a similar one exists
+func (d *drain) insert(tokens []string) *drainCluster {
+	leaf := d.leaf(tokens)
+	c := &drainCluster{tokens: append([]string(nil), tokens...), leaf: leaf}
+	leaf.clusters = append(leaf.clusters, c)
+	d.clusters = append(d.clusters, c)
+	return c
+}
+
+// remove drops a cluster from the tree
+func (d *drain) remove(c *drainCluster) {
+	c.leaf.clusters = removeCluster(c.leaf.clusters, c)
+	d.clusters = removeCluster(d.clusters, c)
+}
+
+func removeCluster(clusters []*drainCluster, c *drainCluster) []*drainCluster {
+	for i, other := range clusters {
+		if other == c {
+			return append(clusters[:i], clusters[i+1:]...)
+		}
+	}
+	return clusters
+}
+
+// leaf walks to the node for tokens, creating nodes as needed
+func (d *drain) leaf(tokens []string) *drainNode {
+	node := d.root[len(tokens)]
+	if node == nil {
+		node = &drainNode{children: make(map[string]*drainNode)}
+		d.root[len(tokens)] = node
+	}
+
+	for _, token := range tokens[:min(len(tokens), drainDepth-2)] {
+		key := token
+		if hasDigit(token) || strings.HasPrefix(token, "<") {
+			key = drainWildcard
+		}
+		child := node.children[key]
+		if child == nil {
+			if len(node.children) >= drainMaxChildren {
+				key = drainWildcard
+				child = node.children[key]
+			}
+			if child == nil {
+				child = &drainNode{children: make(map[string]*drainNode)}
+				node.children[key] = child
+			}
+		}
+		node = child
+	}
+	return node
+}
+
+// match returns the leaf's cluster most similar to tokens, or nil if none is
+// similar enough. Ties go to the cluster with more wildcards.
+func (n *drainNode) match(tokens []string) *drainCluster {
+	var best *drainCluster
+	bestSim, bestWild := -1.0, -1
+	for _, c := range n.clusters {
+		same, wild := 0, 0
+		for i, token := range c.tokens {
+			switch {
+			case token == drainWildcard:
+				wild++
+			case token == tokens[i]:
+				same++
+			}
+		}
+		sim := 1.0
+		if len(tokens) > 0 {
+			sim = float64(same) / float64(len(tokens))
+		}
+		if sim > bestSim || (sim == bestSim && wild > bestWild) {
+			best, bestSim, bestWild = c, sim, wild
+		}
+	}
+	if bestSim < drainSimilarity {
+		return nil
+	}
+	return best
+}
+
+func (c *drainCluster) template() string {
+	return strings.Join(c.tokens, " ")
+}
+
+func hasDigit(s string) bool {
+	return strings.IndexFunc(s, unicode.IsDigit) >= 0
+}
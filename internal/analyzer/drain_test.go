@@ -0,0 +1,78 @@
+package analyzer
+
+import "testing"
+
+func mine(messages ...string) *drain {
+	d := newDrain()
+	for _, msg := range messages {
+		d.add(drainTokens(msg))
+	}
+	return d
+}
+
+func TestDrainMasksVariables(t *testing.T) {
+	tests := map[string]string{
+		"user 123 failed login from 10.0.0.5":                      "user <*> failed login from <IP>",
+		"request 3f2b8c1e-9a4d-4e6f-8b2a-1c3d5e7f9a0b took 250ms":  "request <UUID> took <*>",
+		"fault at 0x7ffe12 (code=139)":                             "fault at <HEX> (code=<*>)",
+		"connect to 192.168.1.10:5432, retry=3":                    "connect to <IP>, retry=<*>",
+		"disk usage 99%":                                           "disk usage <*>",
+		"commit 9fceb02d0ae598e95dc970b74767f19372d61af8 rejected": "commit <HEX> rejected",
+		"trace 4bf92f3577b34da6a3ce929d0e0e4736 dropped":           "trace <HEX> dropped",
+		"req_7f3a9c failed for user-1234 in job#42:":               "<ID> failed for <ID> in <ID>:",
+		"api-v1 sent utf-8 body":                                   "api-v1 sent utf-8 body",
+		"upstream returned error":                                  "upstream returned error",
+	}
+	for msg, want := range tests {
+		d := mine(msg)
+		if got := d.clusters[0].template(); got != want {
+			t.Errorf("%q: got template %q, want %q", msg, got, want)
+		}
+	}
+}
+
+func TestDrainGeneralizesDifferingTokens(t *testing.T) {
+	d := mine(
+		"login failed for alice from 10.0.0.5",
+		"login failed for bob from 10.0.0.6",
+		"login failed for carol from 10.0.0.7",
+	)
+	if len(d.clusters) != 1 {
+		t.Fatalf("got %d templates, want 1", len(d.clusters))
+	}
+	if got, want := d.clusters[0].template(), "login failed for <*> from <IP>"; got != want {
+		t.Errorf("got template %q, want %q", got, want)
+	}
+}
+
+func TestDrainSeparatesUnrelatedMessages(t *testing.T) {
+	d := mine(
+		"connection refused by db-1",
+		"connection refused by db-2",
+		"cache miss for key sessions",
+		"payment declined",
+	)
+	if len(d.clusters) != 3 {
+		var templates []string
+		for _, c := range d.clusters {
+			templates = append(templates, c.template())
+		}
+		t.Fatalf("got templates %q, want 3", templates)
+	}
+	if got, want := d.clusters[0].template(), "connection refused by <*>"; got != want {
+		t.Errorf("got template %q, want %q", got, want)
+	}
+}
+
+func TestDrainRemove(t *testing.T) {
+	d := mine("payment declined", "cache miss")
+	d.remove(d.clusters[0])
+	if len(d.clusters) != 1 || d.clusters[0].template() != "cache miss" {
+		t.Fatalf("unexpected clusters after remove: %v", d.clusters)
+	}
+
+	c, created := d.add(drainTokens("payment declined"))
+	if !created || c.template() != "payment declined" {
+		t.Errorf("removed template was not relearned: created=%v template=%q", created, c.template())
+	}
+}
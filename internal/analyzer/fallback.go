@@ -204,14 +204,18 @@ func (f *FallbackAnalyzer) Analyze(logs []storage.LogLine, ctx *storage.StreamCo
 			analysis.Severity = "P1"
 		}
 	} else {
-		// Generic analysis - try to extract common error phrases
-		errorPhrases := f.extractCommonPhrases(errorMessages)
-		
-		if len(errorPhrases) > 0 {
-			analysis.Summary = fmt.Sprintf("Multiple errors detected: %s (%d errors, %d warnings)", 
-				strings.Join(errorPhrases[:min(2, len(errorPhrases))], ", "), errorCount, warnCount)
-			analysis.RootCause = fmt.Sprintf("Recurring issues detected: %s. Manual investigation recommended to identify root cause.", 
-				strings.Join(errorPhrases, ", "))
+		// Generic analysis - mine the error messages for recurring templates
+		templates := commonTemplates(errorMessages, 3)
+
+		if len(templates) > 0 {
+			analysis.Summary = fmt.Sprintf("Recurring error: %s (%d errors, %d warnings)",
+				templates[0].Template, errorCount, warnCount)
+			quoted := make([]string, len(templates))
+			for i, t := range templates {
+				quoted[i] = fmt.Sprintf("%q (%dx)", t.Template, t.Count)
+			}
+			analysis.RootCause = fmt.Sprintf("Recurring error messages: %s. Manual investigation recommended to identify root cause.",
+				strings.Join(quoted, ", "))
 		} else {
 			analysis.Summary = fmt.Sprintf("Generic errors detected (%d errors, %d warnings)", errorCount, warnCount)
 			analysis.RootCause = "Unable to identify specific pattern from error messages. Review full error logs for stack traces and context."
@@ -225,6 +229,14 @@ func (f *FallbackAnalyzer) Analyze(logs []storage.LogLine, ctx *storage.StreamCo
 		}
 	}
 
+	// Call out errors the stream never logged before
+	if novel := novelErrors(logs); len(novel) > 0 {
+		analysis.Summary += fmt.Sprintf("; new: %q", novel[0])
+		if len(novel) > 1 {
+			analysis.Summary += fmt.Sprintf(" (+%d more)", len(novel)-1)
+		}
+	}
+
 	// Check if related to previous issues
 	if len(ctx.Analyses) > 0 {
 		latest := ctx.Analyses[len(ctx.Analyses)-1]
@@ -233,70 +245,17 @@ func (f *FallbackAnalyzer) Analyze(logs []storage.LogLine, ctx *storage.StreamCo
 		}
 	}
 
-	return analysis
-}
-
-// extractCommonPhrases finds commonly repeated phrases in error messages
-func (f *FallbackAnalyzer) extractCommonPhrases(messages []string) []string {
-	if len(messages) == 0 {
-		return []string{}
-	}
-	
-	// Count word frequencies
-	wordCount := make(map[string]int)
-	for _, msg := range messages {
-		words := strings.Fields(msg)
-		for _, word := range words {
-			// Skip common words
-			if len(word) > 3 && !isCommonWord(word) {
-				wordCount[word]++
-			}
-		}
-	}
-	
-	// Find top repeated words
-	type wordFreq struct {
-		word  string
-		count int
-	}
-	var frequencies []wordFreq
-	for word, count := range wordCount {
-		if count > 1 { // Only words that appear multiple times
-			frequencies = append(frequencies, wordFreq{word, count})
-		}
-	}
-	
-	// Sort by frequency
-	if len(frequencies) == 0 {
-		return []string{}
-	}
-	
-	// Simple bubble sort for top 3
-	for i := 0; i < min(3, len(frequencies)); i++ {
-		for j := i + 1; j < len(frequencies); j++ {
-			if frequencies[j].count > frequencies[i].count {
-				frequencies[i], frequencies[j] = frequencies[j], frequencies[i]
-			}
+	// Point out errors this stream keeps running into
+	if known := knownErrors(ctx.Patterns.CommonErrors, errorMessages); len(known) > 0 {
+		recurring := fmt.Sprintf("Matches errors this stream has repeatedly seen: %s", strings.Join(known, "; "))
+		if analysis.Context != "" {
+			analysis.Context += ". " + recurring
+		} else {
+			analysis.Context = recurring
 		}
 	}
-	
-	// Return top phrases
-	result := []string{}
-	for i := 0; i < min(3, len(frequencies)); i++ {
-		result = append(result, frequencies[i].word)
-	}
-	return result
-}
 
-func isCommonWord(word string) bool {
-	common := []string{"error", "failed", "warning", "info", "the", "and", "for", "from", "with", "that", "this"}
-	wordLower := strings.ToLower(word)
-	for _, c := range common {
-		if wordLower == c {
-			return true
-		}
-	}
-	return false
+	return analysis
 }
 
 func min(a, b int) int {
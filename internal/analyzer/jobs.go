@@ -0,0 +1,507 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+// ErrQueueFull is returned by Submit when too many jobs are already waiting
+var ErrQueueFull = errors.New("analysis queue is full")
+
+var errJobCancelled = errors.New("cancelled")
+
+// jobPruneInterval is how often finished jobs past their retention are deleted
+const jobPruneInterval = time.Hour
+
+// JobConfig tunes the analysis job queue
+type JobConfig struct {
+	Workers   int           // analyses run at once; default 2
+	QueueSize int           // jobs that may wait for a worker; default 100
+	Timeout   time.Duration // per job; default 2m
+	Retention time.Duration // how long finished jobs are kept; default 24h
+}
+
+// JobQueue runs analyses on a fixed pool of workers and records each as a
+// storage.AnalysisJob. A request for a stream that already has a queued or
+// running job gets that job instead of a new one. Digests built through the
+// queue share its workers' limit.
+type JobQueue struct {
+	analyzer *Analyzer
+	store    storage.Storage
+	config   JobConfig
+	sem      semaphore     // held by each running job or digest analysis
+	ready    chan struct{} // signalled while queue has jobs for the workers
+
+	mu     sync.Mutex
+	active map[string]*activeJob // queued or running, by stream ID
+	queue  []string              // IDs of jobs waiting for a worker
+	saving int                   // jobs Submit is persisting, each holding a queue slot
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// activeJob is the in-memory side of a job that has not finished
+type activeJob struct {
+	id        string
+	saved     chan struct{}      // closed once the job is in storage
+	cancel    context.CancelFunc // set once a worker runs it
+	cancelled bool
+	watchers  map[chan AnalysisUpdate]struct{}
+}
+
+func NewJobQueue(a *Analyzer, store storage.Storage, cfg JobConfig) *JobQueue {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 2
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 100
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Minute
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = 24 * time.Hour
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &JobQueue{
+		analyzer: a,
+		store:    store,
+		config:   cfg,
+		sem:      make(semaphore, cfg.Workers),
+		ready:    make(chan struct{}, 1),
+		active:   make(map[string]*activeJob),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start requeues jobs a previous run left unfinished and starts the workers
+func (q *JobQueue) Start() {
+	if err := q.recover(); err != nil {
+		log.Printf("Failed to recover analysis jobs: %v", err)
+	}
+
+	for i := 0; i < q.config.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		ticker := time.NewTicker(jobPruneInterval)
+		defer ticker.Stop()
+
+		for {
+			if n, err := q.store.PruneJobs(q.ctx, time.Now().Add(-q.config.Retention)); err != nil && q.ctx.Err() == nil {
+				log.Printf("Failed to prune analysis jobs: %v", err)
+			} else if n > 0 {
+				log.Printf("Pruned %d finished analysis jobs", n)
+			}
+
+			select {
+			case <-q.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop cancels running jobs and waits for the workers to exit. Interrupted
+// jobs stay queued or running in storage and are picked up by the next Start.
+func (q *JobQueue) Stop() {
+	q.once.Do(func() {
+		q.cancel()
+		q.wg.Wait()
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for _, a := range q.active {
+			for ch := range a.watchers {
+				close(ch)
+			}
+			a.watchers = nil
+		}
+	})
+}
+
+// Submit queues an analysis of streamID, or returns the stream's queued or
+// running job if it has one
+func (q *JobQueue) Submit(ctx context.Context, streamID string) (*storage.AnalysisJob, error) {
+	q.mu.Lock()
+	if err := q.ctx.Err(); err != nil {
+		q.mu.Unlock()
+		return nil, fmt.Errorf("analysis queue stopped: %w", err)
+	}
+	if a, ok := q.active[streamID]; ok {
+		q.mu.Unlock()
+		<-a.saved
+		return q.store.GetJob(ctx, a.id)
+	}
+	if len(q.queue)+q.saving >= q.config.QueueSize {
+		q.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+
+	// The stream and a queue slot are held while the job is written, so
+	// the lock is not held across the write
+	job := &storage.AnalysisJob{
+		ID:        newID(),
+		StreamID:  streamID,
+		Status:    storage.JobQueued,
+		CreatedAt: time.Now(),
+	}
+	a := q.track(job)
+	q.saving++
+	q.mu.Unlock()
+
+	err := q.store.SaveJob(ctx, job)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.saving--
+	if err != nil {
+		delete(q.active, streamID)
+		close(a.saved)
+		return nil, err
+	}
+	q.push(a)
+	return job, nil
+}
+
+// BuildDigest builds a digest like Analyzer.BuildDigest, but each stream's
+// analysis waits for a free worker slot, so digests and queued jobs together
+// never run more than JobConfig.Workers analyses at once
+func (q *JobQueue) BuildDigest(ctx context.Context, name string, streamIDs []string, since, until time.Time) (*storage.Digest, error) {
+	return q.analyzer.buildDigest(ctx, q.sem, name, streamIDs, since, until)
+}
+
+// Get returns a job record
+func (q *JobQueue) Get(ctx context.Context, id string) (*storage.AnalysisJob, error) {
+	return q.store.GetJob(ctx, id)
+}
+
+// List returns the recorded jobs for a stream, or all jobs if streamID is empty
+func (q *JobQueue) List(ctx context.Context, streamID string) ([]storage.AnalysisJob, error) {
+	return q.store.ListJobs(ctx, streamID)
+}
+
+// Cancel stops a queued or running job, which then fails as cancelled. A job
+// that already finished is a conflict.
+func (q *JobQueue) Cancel(ctx context.Context, id string) error {
+	q.mu.Lock()
+	streamID, a := q.find(id)
+	if a == nil {
+		q.mu.Unlock()
+		job, err := q.store.GetJob(ctx, id)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("job %s is already %s: %w", id, job.Status, storage.ErrConflict)
+	}
+	a.cancelled = true
+	running := a.cancel != nil
+	if running {
+		a.cancel() // the worker records the outcome
+	} else {
+		q.unqueue(id) // frees its slot for new jobs
+	}
+	q.mu.Unlock()
+
+	if !running {
+		job, err := q.store.GetJob(ctx, id)
+		if err != nil {
+			return err
+		}
+		q.finish(job, nil, errJobCancelled)
+	}
+	log.Printf("Cancelled analysis job %s for stream %s", id, streamID)
+	return nil
+}
+
+// Watch returns a channel that receives the partial analysis of a job while
+// it runs and is closed when the job finishes. Only the latest update is
+// buffered, so a slow reader skips intermediate ones. stop unsubscribes.
+func (q *JobQueue) Watch(id string) (updates <-chan AnalysisUpdate, stop func()) {
+	ch := make(chan AnalysisUpdate, 1)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, a := q.find(id)
+	if a == nil || a.watchers == nil {
+		close(ch)
+		return ch, func() {}
+	}
+	a.watchers[ch] = struct{}{}
+
+	return ch, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if _, ok := a.watchers[ch]; ok {
+			delete(a.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+// recover requeues jobs left queued or running by a previous process
+func (q *JobQueue) recover() error {
+	jobs, err := q.store.ListJobs(q.ctx, "")
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range jobs {
+		job := &jobs[i]
+		if job.Finished() {
+			continue
+		}
+		if _, dup := q.active[job.StreamID]; dup || len(q.queue) >= q.config.QueueSize {
+			q.record(job, nil, errors.New("dropped after a restart"))
+			continue
+		}
+
+		job.Status = storage.JobQueued
+		job.StartedAt = time.Time{}
+		if err := q.store.SaveJob(q.ctx, job); err != nil {
+			return err
+		}
+		q.push(q.track(job))
+	}
+	return nil
+}
+
+// track registers job as the active job of its stream. The caller holds
+// q.mu and has checked there is room.
+func (q *JobQueue) track(job *storage.AnalysisJob) *activeJob {
+	a := &activeJob{
+		id:       job.ID,
+		saved:    make(chan struct{}),
+		watchers: make(map[chan AnalysisUpdate]struct{}),
+	}
+	q.active[job.StreamID] = a
+	return a
+}
+
+// push hands a stored job to the workers. The caller holds q.mu.
+func (q *JobQueue) push(a *activeJob) {
+	close(a.saved)
+	q.queue = append(q.queue, a.id)
+	q.signal()
+}
+
+// pop takes the next queued job, passing the signal on to another worker if
+// more are waiting
+func (q *JobQueue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.queue) == 0 {
+		return "", false
+	}
+	id := q.queue[0]
+	q.queue = q.queue[1:]
+	if len(q.queue) > 0 {
+		q.signal()
+	}
+	return id, true
+}
+
+// unqueue removes a job that has not reached a worker. The caller holds q.mu.
+func (q *JobQueue) unqueue(id string) {
+	if i := slices.Index(q.queue, id); i >= 0 {
+		q.queue = slices.Delete(q.queue, i, i+1)
+	}
+}
+
+func (q *JobQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *JobQueue) worker() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-q.ready:
+			if id, ok := q.pop(); ok {
+				q.run(id)
+			}
+		}
+	}
+}
+
+func (q *JobQueue) run(id string) {
+	job, err := q.store.GetJob(q.ctx, id)
+	if err != nil {
+		if q.ctx.Err() == nil {
+			log.Printf("Failed to load analysis job %s: %v", id, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(q.ctx, q.config.Timeout)
+	defer cancel()
+
+	q.mu.Lock()
+	a := q.active[job.StreamID]
+	if a == nil || a.id != id || a.cancelled {
+		// Cancelled while queued; Cancel recorded it
+		q.mu.Unlock()
+		return
+	}
+	a.cancel = cancel
+	q.mu.Unlock()
+
+	// A digest may be using the slot this worker would take
+	var analysis *storage.Analysis
+	if err = q.sem.acquire(ctx); err == nil {
+		job.Status = storage.JobRunning
+		job.StartedAt = time.Now()
+		if err := q.store.SaveJob(ctx, job); err != nil {
+			log.Printf("Failed to update analysis job %s: %v", id, err)
+		}
+
+		analysis, err = q.analyze(ctx, job.StreamID, a)
+		q.sem.release()
+	}
+	if q.ctx.Err() != nil {
+		return // Shutting down; the next Start requeues the job
+	}
+	if err != nil && ctx.Err() != nil {
+		q.mu.Lock()
+		cancelled := a.cancelled
+		q.mu.Unlock()
+		if cancelled {
+			err = errJobCancelled
+		} else if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", q.config.Timeout)
+		}
+	}
+	q.finish(job, analysis, err)
+}
+
+func (q *JobQueue) analyze(ctx context.Context, streamID string, a *activeJob) (*storage.Analysis, error) {
+	// The analyzer trims the logs to its prompt budget
+	logs, err := q.store.GetLogs(ctx, streamID, storage.GetLogsOptions{Limit: MaxInputLines})
+	if err != nil {
+		return nil, err
+	}
+	if len(logs) == 0 {
+		return nil, fmt.Errorf("no logs to analyze")
+	}
+
+	analysis, err := q.analyzer.AnalyzeStream(ctx, streamID, logs, func(update AnalysisUpdate) {
+		q.broadcast(a, update)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := q.analyzer.Save(ctx, analysis); err != nil {
+		return nil, fmt.Errorf("failed to store analysis: %w", err)
+	}
+	log.Printf("Analysis completed: %s (%s)", analysis.Summary, analysis.Severity)
+	return analysis, nil
+}
+
+// broadcast passes update to every watcher, replacing any update a watcher
+// has not read yet
+func (q *JobQueue) broadcast(a *activeJob, update AnalysisUpdate) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for ch := range a.watchers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- update
+	}
+}
+
+// finish records the outcome of a job and releases its stream for new jobs
+func (q *JobQueue) finish(job *storage.AnalysisJob, analysis *storage.Analysis, err error) {
+	q.record(job, analysis, err)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	a := q.active[job.StreamID]
+	if a == nil || a.id != job.ID {
+		return
+	}
+	delete(q.active, job.StreamID)
+	for ch := range a.watchers {
+		close(ch)
+	}
+	a.watchers = nil
+}
+
+// record saves a job as done or failed
+func (q *JobQueue) record(job *storage.AnalysisJob, analysis *storage.Analysis, err error) {
+	job.FinishedAt = time.Now()
+	if err != nil {
+		job.Status = storage.JobFailed
+		job.Error = err.Error()
+		log.Printf("Analysis job %s for stream %s failed: %v", job.ID, job.StreamID, err)
+	} else {
+		job.Status = storage.JobDone
+		job.Analysis = analysis
+	}
+
+	// Record the outcome even if the request that asked for it has gone
+	if err := q.store.SaveJob(context.Background(), job); err != nil {
+		log.Printf("Failed to save analysis job %s: %v", job.ID, err)
+	}
+}
+
+// find looks up an active job by ID. The caller holds q.mu.
+func (q *JobQueue) find(id string) (string, *activeJob) {
+	for streamID, a := range q.active {
+		if a.id == id {
+			return streamID, a
+		}
+	}
+	return "", nil
+}
+
+// semaphore bounds how many analyses run at once; a nil semaphore does not
+type semaphore chan struct{}
+
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// newID returns an ID for a job or digest that sorts by creation time
+func newID() string {
+	return fmt.Sprintf("%016x%08x", time.Now().UnixNano(), rand.Uint32())
+}
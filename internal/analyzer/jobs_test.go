@@ -0,0 +1,274 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+// gatedProvider blocks every call until release is closed, counting how many
+// calls run at once
+type gatedProvider struct {
+	release chan struct{}
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+func (p *gatedProvider) Name() string { return "gated" }
+
+func (p *gatedProvider) Complete(ctx context.Context, system, prompt string) (string, error) {
+	n := p.running.Add(1)
+	defer p.running.Add(-1)
+	for {
+		peak := p.peak.Load()
+		if n <= peak || p.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+
+	select {
+	case <-p.release:
+		return stubReply, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func newTestQueue(t *testing.T, llm LLMProvider, cfg JobConfig, streams ...string) (*JobQueue, storage.Storage) {
+	t.Helper()
+	store := storage.NewMemoryStorage()
+	for _, streamID := range streams {
+		store.StoreLogs(context.Background(), streamID, testLogs())
+	}
+	q := NewJobQueue(New(&Config{Storage: store, LLM: llm}), store, cfg)
+	t.Cleanup(q.Stop)
+	return q, store
+}
+
+func waitForStatus(t *testing.T, q *JobQueue, id, status string) *storage.AnalysisJob {
+	t.Helper()
+	var job *storage.AnalysisJob
+	waitFor(t, func() bool {
+		job, _ = q.Get(context.Background(), id)
+		return job != nil && job.Status == status
+	})
+	return job
+}
+
+func TestJobQueueDedupesAndLimitsConcurrency(t *testing.T) {
+	ctx := context.Background()
+	llm := &gatedProvider{release: make(chan struct{})}
+	q, store := newTestQueue(t, llm, JobConfig{Workers: 1}, "a", "b")
+	q.Start()
+
+	first, err := q.Submit(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := q.Submit(ctx, "a")
+	if err != nil || again.ID != first.ID {
+		t.Fatalf("duplicate submit got %+v, %v; want job %s", again, err, first.ID)
+	}
+	other, err := q.Submit(ctx, "b")
+	if err != nil || other.ID == first.ID {
+		t.Fatalf("submit for another stream got %+v, %v", other, err)
+	}
+
+	waitForStatus(t, q, first.ID, storage.JobRunning)
+	if job, _ := q.Get(ctx, other.ID); job.Status != storage.JobQueued {
+		t.Errorf("second job is %s while the only worker is busy", job.Status)
+	}
+
+	close(llm.release)
+	done := waitForStatus(t, q, other.ID, storage.JobDone)
+	if done.Analysis == nil || done.Analysis.Summary != "db down" {
+		t.Errorf("job result = %+v", done.Analysis)
+	}
+	if peak := llm.peak.Load(); peak != 1 {
+		t.Errorf("%d LLM calls ran at once with one worker", peak)
+	}
+
+	// The result is stored like a synchronous analysis
+	if history, _ := store.GetAnalysisHistory(ctx, "b", 0); len(history) != 1 {
+		t.Errorf("analysis history = %+v", history)
+	}
+
+	// A finished job no longer absorbs new requests
+	next, err := q.Submit(ctx, "a")
+	if err != nil || next.ID == first.ID {
+		t.Errorf("submit after completion got %+v, %v", next, err)
+	}
+}
+
+func TestDigestsShareTheWorkerLimit(t *testing.T) {
+	ctx := context.Background()
+	llm := &gatedProvider{release: make(chan struct{})}
+	q, _ := newTestQueue(t, llm, JobConfig{Workers: 1}, "a", "b")
+	q.Start()
+
+	job, err := q.Submit(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, q, job.ID, storage.JobRunning)
+
+	done := make(chan *storage.Digest)
+	go func() {
+		digest, err := q.BuildDigest(ctx, "daily", []string{"b"}, time.Now().Add(-time.Hour), time.Now().Add(time.Minute))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- digest
+	}()
+
+	// The digest's analysis waits for the job's worker slot
+	time.Sleep(50 * time.Millisecond)
+	if n := llm.running.Load(); n != 1 {
+		t.Errorf("%d analyses running with one worker", n)
+	}
+	close(llm.release)
+	if digest := <-done; digest == nil || len(digest.Issues) != 1 {
+		t.Fatalf("digest = %+v", digest)
+	}
+	if peak := llm.peak.Load(); peak != 1 {
+		t.Errorf("peak of %d analyses at once, want 1", peak)
+	}
+}
+
+func TestJobQueueCancel(t *testing.T) {
+	ctx := context.Background()
+	llm := &gatedProvider{release: make(chan struct{})}
+	q, _ := newTestQueue(t, llm, JobConfig{Workers: 1}, "a", "b")
+	q.Start()
+
+	running, _ := q.Submit(ctx, "a")
+	queued, _ := q.Submit(ctx, "b")
+	waitForStatus(t, q, running.ID, storage.JobRunning)
+
+	updates, stop := q.Watch(running.ID)
+	defer stop()
+
+	for _, id := range []string{queued.ID, running.ID} {
+		if err := q.Cancel(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+		if job := waitForStatus(t, q, id, storage.JobFailed); job.Error != "cancelled" {
+			t.Errorf("job %s error = %q", id, job.Error)
+		}
+	}
+	if _, ok := <-updates; ok {
+		t.Error("watch channel still open after cancel")
+	}
+	if llm.running.Load() != 0 {
+		t.Error("the LLM call was not aborted")
+	}
+
+	if err := q.Cancel(ctx, running.ID); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("cancelling a finished job: got %v, want ErrConflict", err)
+	}
+}
+
+func TestJobQueueRecoversAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	q, store := newTestQueue(t, nil, JobConfig{}, "a")
+
+	// Left running by a process that died
+	orphan := &storage.AnalysisJob{ID: "0001", StreamID: "a", Status: storage.JobRunning, CreatedAt: time.Now()}
+	if err := store.SaveJob(ctx, orphan); err != nil {
+		t.Fatal(err)
+	}
+
+	q.Start()
+	job := waitForStatus(t, q, orphan.ID, storage.JobDone)
+	if job.Analysis == nil {
+		t.Errorf("recovered job has no analysis: %+v", job)
+	}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	ctx := context.Background()
+	q, _ := newTestQueue(t, nil, JobConfig{QueueSize: 1}, "a", "b")
+
+	// Without workers nothing leaves the queue
+	if _, err := q.Submit(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Submit(ctx, "b"); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("got %v, want ErrQueueFull", err)
+	}
+}
+
+func TestCancelledQueuedJobFreesItsSlot(t *testing.T) {
+	ctx := context.Background()
+	q, _ := newTestQueue(t, nil, JobConfig{QueueSize: 1}, "a", "b")
+
+	job, err := q.Submit(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Cancel(ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Submit(ctx, "b"); err != nil {
+		t.Errorf("submit after cancelling the queued job: %v", err)
+	}
+}
+
+// slowSaveStorage holds SaveJob for stream "a" until release is closed,
+// signalling saving when it starts waiting
+type slowSaveStorage struct {
+	storage.Storage
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (s *slowSaveStorage) SaveJob(ctx context.Context, job *storage.AnalysisJob) error {
+	if job.StreamID == "a" {
+		s.saving <- struct{}{}
+		<-s.release
+	}
+	return s.Storage.SaveJob(ctx, job)
+}
+
+func TestSubmitDoesNotHoldTheQueueWhileSaving(t *testing.T) {
+	ctx := context.Background()
+	store := &slowSaveStorage{Storage: storage.NewMemoryStorage(), saving: make(chan struct{}, 2), release: make(chan struct{})}
+	q := NewJobQueue(New(&Config{Storage: store}), store, JobConfig{QueueSize: 2})
+	t.Cleanup(q.Stop)
+
+	first := make(chan *storage.AnalysisJob, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			job, err := q.Submit(ctx, "a")
+			if err != nil {
+				t.Error(err)
+			}
+			first <- job
+		}()
+	}
+	<-store.saving
+
+	done := make(chan error)
+	go func() {
+		_, err := q.Submit(ctx, "b")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Submit blocked behind another stream's save")
+	}
+
+	close(store.release)
+	a1, a2 := <-first, <-first
+	if a1 == nil || a2 == nil || a1.ID != a2.ID {
+		t.Errorf("concurrent submits for one stream got %+v and %+v, want the same job", a1, a2)
+	}
+}
@@ -1,105 +1,115 @@
 package analyzer
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
-
-	"logvoyant/internal/storage"
+	"time"
 )
 
-const groqAPIURL = "https://api.groq.com/openai/v1/chat/completions"
-
-type GroqClient struct {
-	apiKey string
-	client *http.Client
+// LLMProvider sends a prompt to a language model and returns its raw reply
+type LLMProvider interface {
+	Complete(ctx context.Context, system, prompt string) (string, error)
+	Name() string
 }
 
-type groqRequest struct {
-	Model    string          `json:"model"`
-	Messages []groqMessage   `json:"messages"`
-	Temp     float64         `json:"temperature"`
+// StreamingProvider is an LLMProvider that can deliver its reply as it is
+// generated. onDelta is called with each new piece of content; the full
+// reply is returned at the end.
+type StreamingProvider interface {
+	LLMProvider
+	Stream(ctx context.Context, system, prompt string, onDelta func(string)) (string, error)
 }
 
-type groqMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+// LLMConfig selects and tunes an LLMProvider
+type LLMConfig struct {
+	Provider    string // groq, openai or ollama
+	BaseURL     string // defaults to the provider's public endpoint
+	APIKey      string
+	Model       string // defaults to the provider's default model
+	Temperature float64
+	Timeout     time.Duration // per request, or until a stream starts; 0 means no timeout
 }
 
-type groqResponse struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
+// providerDefaults are the base URL and model used when LLMConfig leaves them empty
+var providerDefaults = map[string]struct{ baseURL, model string }{
+	"groq":   {"https://api.groq.com/openai/v1", "llama-3.3-70b-versatile"},
+	"openai": {"https://api.openai.com/v1", "gpt-4o-mini"},
+	"ollama": {"http://localhost:11434", "llama3.1"},
 }
 
-func NewGroqClient(apiKey string) *GroqClient {
-	return &GroqClient{
-		apiKey: apiKey,
-		client: &http.Client{},
+// NewProvider builds the provider named in cfg. "groq" and "openai" both use
+// the OpenAI chat completions API, so "openai" with a custom BaseURL covers
+// any compatible server (vLLM, LM Studio, llama.cpp).
+func NewProvider(cfg LLMConfig) (LLMProvider, error) {
+	defaults, ok := providerDefaults[cfg.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown LLM provider %q (want groq, openai or ollama)", cfg.Provider)
 	}
-}
-
-func (g *GroqClient) Analyze(prompt string) (*storage.Analysis, error) {
-	reqBody := groqRequest{
-		Model: "llama-3.3-70b-versatile",
-		Messages: []groqMessage{
-			{Role: "system", Content: "You are an expert log analyzer. Analyze logs and respond ONLY with valid JSON. No markdown, no code blocks, just pure JSON."},
-			{Role: "user", Content: prompt},
-		},
-		Temp: 0.3,
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = defaults.baseURL
 	}
-
-	body, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, err
+	if cfg.Model == "" {
+		cfg.Model = defaults.model
 	}
-
-	req, err := http.NewRequest("POST", groqAPIURL, bytes.NewBuffer(body))
-	if err != nil {
-		return nil, err
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	// Client.Timeout would also cover reading the body, cutting off long
+	// streamed replies. Streams only have to start within Timeout; Complete
+	// bounds the whole request through its context.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = cfg.Timeout
+	client := &http.Client{Transport: transport}
+
+	switch cfg.Provider {
+	case "ollama":
+		return &OllamaClient{config: cfg, client: client}, nil
+	case "groq":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("groq requires an API key")
+		}
 	}
+	return &OpenAIClient{name: cfg.Provider, config: cfg, client: client}, nil
+}
 
-	req.Header.Set("Authorization", "Bearer "+g.apiKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := g.client.Do(req)
-	if err != nil {
-		return nil, err
+// withTimeout bounds a whole non-streaming request by Timeout
+func (cfg LLMConfig) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if cfg.Timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-	defer resp.Body.Close()
+	return context.WithTimeout(ctx, cfg.Timeout)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("groq api error: %d - %s", resp.StatusCode, string(bodyBytes))
-	}
+// APIError is a non-200 reply from a provider
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // from the Retry-After header, 0 if absent
+}
 
-	var groqResp groqResponse
-	if err := json.NewDecoder(resp.Body).Decode(&groqResp); err != nil {
-		return nil, err
-	}
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s api error: %d - %s", e.Provider, e.StatusCode, e.Body)
+}
 
-	if len(groqResp.Choices) == 0 {
-		return nil, fmt.Errorf("no response from groq")
-	}
+// Temporary reports whether retrying the request may succeed
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
 
-	content := groqResp.Choices[0].Message.Content
-	
-	// Clean up markdown code blocks if present
-	content = strings.TrimSpace(content)
-	content = strings.TrimPrefix(content, "```json")
-	content = strings.TrimPrefix(content, "```")
-	content = strings.TrimSuffix(content, "```")
-	content = strings.TrimSpace(content)
-	
-	var analysis storage.Analysis
-	if err := json.Unmarshal([]byte(content), &analysis); err != nil {
-		return nil, fmt.Errorf("failed to parse analysis JSON: %w\nContent: %s", err, content)
+func newAPIError(provider string, resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	e := &APIError{Provider: provider, StatusCode: resp.StatusCode, Body: string(body)}
+
+	// Retry-After is either seconds or an HTTP date
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		} else if at, err := http.ParseTime(v); err == nil {
+			e.RetryAfter = time.Until(at)
+		}
 	}
-
-	return &analysis, nil
-}
\ No newline at end of file
+	return e
+}
@@ -0,0 +1,117 @@
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+const stubReply = `{"summary":"db down","root_cause":"connection refused","severity":"P1","fixes":["restart db"]}`
+
+func testLogs() []storage.LogLine {
+	return []storage.LogLine{{Timestamp: time.Now(), Level: "ERROR", Message: "connection refused", StreamID: "s"}}
+}
+
+func TestOpenAIProvider(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var req openAIRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "test-model" || req.Temp != 0.1 || len(req.Messages) != 2 {
+			t.Errorf("request = %+v", req)
+		}
+		// Models often wrap JSON in a code fence despite instructions
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []any{map[string]any{"message": map[string]string{"content": "```json\n" + stubReply + "\n```"}}},
+		})
+	}))
+	defer stub.Close()
+
+	llm, err := NewProvider(LLMConfig{
+		Provider:    "openai",
+		BaseURL:     stub.URL + "/v1/",
+		APIKey:      "secret",
+		Model:       "test-model",
+		Temperature: 0.1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := New(&Config{Storage: storage.NewMemoryStorage(), LLM: llm})
+	analysis, err := a.Analyze(context.Background(), "s", testLogs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysis.Summary != "db down" || analysis.Severity != "P1" || analysis.StreamID != "s" {
+		t.Errorf("analysis = %+v", analysis)
+	}
+}
+
+func TestOllamaProvider(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		var req ollamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Stream || req.Model != "llama3.1" {
+			t.Errorf("request = %+v", req)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"message": map[string]string{"role": "assistant", "content": stubReply}})
+	}))
+	defer stub.Close()
+
+	llm, err := NewProvider(LLMConfig{Provider: "ollama", BaseURL: stub.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := llm.Complete(context.Background(), "system", "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != stubReply {
+		t.Errorf("content = %q", content)
+	}
+}
+
+func TestProviderErrorFallsBack(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer stub.Close()
+
+	llm, err := NewProvider(LLMConfig{Provider: "openai", BaseURL: stub.URL, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := New(&Config{Storage: storage.NewMemoryStorage(), LLM: llm})
+	analysis, err := a.Analyze(context.Background(), "s", testLogs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysis.Summary == "" {
+		t.Errorf("expected a fallback analysis, got %+v", analysis)
+	}
+}
+
+func TestUnknownProvider(t *testing.T) {
+	if _, err := NewProvider(LLMConfig{Provider: "bard"}); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+	if _, err := NewProvider(LLMConfig{Provider: "groq"}); err == nil {
+		t.Error("expected an error for groq without an API key")
+	}
+}
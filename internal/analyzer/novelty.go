@@ -0,0 +1,249 @@
+package analyzer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+// NovelLabel is set to "true" on the first line of an error never seen
+// before on its stream
+const NovelLabel = "novel_error"
+
+const (
+	noveltySeedLines = 5000 // stored error lines a stream's history is seeded from
+	maxNovelEvents   = 10   // events per stored batch; the rest are only labelled
+	maxNovelErrors   = 10   // listed in prompts and fallback summaries
+)
+
+// NoveltyDetector spots errors a stream has never logged before. ERROR and
+// FATAL messages are fingerprinted with numbers, IDs and IPs masked, and
+// checked against the stream's fingerprint history in storage. The first
+// line with a new fingerprint gets NovelLabel, and once stored it is added
+// to the history and recorded as an event. Feed it with Label and Record,
+// typically from a storage.HookedStorage.
+//
+// Two batches of one stream stored at the same time may both label the
+// same new error; only one of them records it.
+type NoveltyDetector struct {
+	store  storage.Storage
+	notify func(storage.Event) // may be nil; called on the writer's goroutine
+	now    func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]bool // streams whose history is still being seeded
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+func NewNoveltyDetector(store storage.Storage, notify func(storage.Event)) *NoveltyDetector {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &NoveltyDetector{
+		store:   store,
+		notify:  notify,
+		now:     time.Now,
+		pending: make(map[string]bool),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Start seeds the history of the streams already stored in the background,
+// so logs from before detection was enabled do not count as new. Until a
+// stream is seeded its errors are not labelled.
+func (d *NoveltyDetector) Start() {
+	streams, err := d.store.ListStreams(d.ctx)
+	if err != nil {
+		log.Printf("Failed to list streams to seed error history: %v", err)
+		return
+	}
+	d.mu.Lock()
+	for _, stream := range streams {
+		d.pending[stream.ID] = true
+	}
+	d.mu.Unlock()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for _, stream := range streams {
+			if err := d.seed(d.ctx, stream.ID); err != nil {
+				if d.ctx.Err() != nil {
+					return
+				}
+				log.Printf("Failed to seed error history of stream %s: %v", stream.ID, err)
+			}
+			d.mu.Lock()
+			delete(d.pending, stream.ID)
+			d.mu.Unlock()
+		}
+	}()
+}
+
+// Stop waits for seeding to finish or give up
+func (d *NoveltyDetector) Stop() {
+	d.once.Do(func() {
+		d.cancel()
+		d.wg.Wait()
+	})
+}
+
+// Label marks the novel errors in logs about to be stored. It matches
+// storage.BeforeStoreHook and writes nothing; failures are logged and leave
+// logs unlabelled.
+func (d *NoveltyDetector) Label(ctx context.Context, streamID string, logs []storage.LogLine) {
+	first, fingerprints := errorFingerprints(logs)
+	if len(fingerprints) == 0 || d.seeding(streamID) {
+		return
+	}
+
+	novel, err := d.store.UnseenFingerprints(ctx, streamID, fingerprints)
+	if err != nil {
+		log.Printf("Failed to look up error fingerprints of stream %s: %v", streamID, err)
+		return
+	}
+	for _, fp := range novel {
+		line := &logs[first[fp]]
+		labels := make(map[string]string, len(line.Labels)+1)
+		maps.Copy(labels, line.Labels)
+		labels[NovelLabel] = "true"
+		line.Labels = labels
+	}
+}
+
+// Record adds the errors in logs that were just stored to the stream's
+// history and records an event for each labelled one new to it. It matches
+// storage.StoreHook, so nothing is recorded for logs that failed to store.
+func (d *NoveltyDetector) Record(streamID string, logs []storage.LogLine) {
+	first, fingerprints := errorFingerprints(logs)
+	if len(fingerprints) == 0 {
+		return
+	}
+
+	novel, err := d.store.RecordFingerprints(d.ctx, streamID, fingerprints, d.now())
+	if err != nil {
+		log.Printf("Failed to record error fingerprints of stream %s: %v", streamID, err)
+		return
+	}
+
+	var events int
+	for _, fp := range novel {
+		line := &logs[first[fp]]
+		if line.Labels[NovelLabel] != "true" {
+			continue // stored while the stream was being seeded
+		}
+		if events++; events <= maxNovelEvents {
+			d.record(d.ctx, streamID, line)
+		}
+	}
+	if events > maxNovelEvents {
+		log.Printf("New errors on stream %s: %d more not recorded as events", streamID, events-maxNovelEvents)
+	}
+}
+
+// Forget drops what is known about a deleted or merged stream
+func (d *NoveltyDetector) Forget(streamID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pending, streamID)
+}
+
+func (d *NoveltyDetector) seeding(streamID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pending[streamID]
+}
+
+// seed adds the errors already stored for a stream to its history
+func (d *NoveltyDetector) seed(ctx context.Context, streamID string) error {
+	stored, err := d.store.GetLogs(ctx, streamID, storage.GetLogsOptions{
+		Levels: []string{"ERROR", "FATAL"},
+		Limit:  noveltySeedLines,
+	})
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	_, fingerprints := errorFingerprints(stored)
+	if len(fingerprints) == 0 {
+		return nil
+	}
+	_, err = d.store.RecordFingerprints(ctx, streamID, fingerprints, d.now())
+	return err
+}
+
+// errorFingerprints returns the distinct fingerprints of the error lines in
+// logs, in order, and the first line with each
+func errorFingerprints(logs []storage.LogLine) (map[string]int, []string) {
+	first := make(map[string]int)
+	var fingerprints []string
+	for i, line := range logs {
+		if !isErrorLevel(line.Level) {
+			continue
+		}
+		fp := errorFingerprint(line.Message)
+		if _, ok := first[fp]; !ok {
+			first[fp] = i
+			fingerprints = append(fingerprints, fp)
+		}
+	}
+	return first, fingerprints
+}
+
+func (d *NoveltyDetector) record(ctx context.Context, streamID string, line *storage.LogLine) {
+	at := line.Timestamp
+	if at.IsZero() {
+		at = d.now()
+	}
+	message, _ := truncateMessage(line.Message, maxExampleLength)
+	event := storage.Event{
+		ID:        newID(),
+		StreamID:  streamID,
+		Type:      storage.EventNovelError,
+		Timestamp: at,
+		Message:   "New error: " + message,
+	}
+	if err := d.store.SaveEvent(ctx, &event); err != nil {
+		log.Printf("Failed to store new error event of stream %s: %v", streamID, err)
+		return
+	}
+	if d.notify != nil {
+		d.notify(event)
+	}
+}
+
+// errorFingerprint identifies an error message regardless of the numbers,
+// IDs and addresses in it
+func errorFingerprint(message string) string {
+	sum := sha256.Sum256([]byte(strings.Join(drainTokens(message), " ")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// novelErrors returns the distinct messages of lines labelled as novel, in
+// order, at most maxNovelErrors of them
+func novelErrors(logs []storage.LogLine) []string {
+	seen := make(map[string]bool)
+	var messages []string
+	for _, line := range logs {
+		if line.Labels[NovelLabel] != "true" || seen[line.Message] {
+			continue
+		}
+		seen[line.Message] = true
+		message, _ := truncateMessage(line.Message, maxExampleLength)
+		messages = append(messages, message)
+		if len(messages) == maxNovelErrors {
+			break
+		}
+	}
+	return messages
+}
@@ -0,0 +1,202 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+func errorLine(message string) storage.LogLine {
+	return storage.LogLine{Timestamp: time.Now(), Level: "ERROR", Message: message, Labels: map[string]string{"pod": "api-1"}}
+}
+
+func TestNoveltyDetectorLabelsFirstOccurrences(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewHookedStorage(storage.NewMemoryStorage())
+	var notified []storage.Event
+	d := NewNoveltyDetector(store, func(e storage.Event) { notified = append(notified, e) })
+	store.BeforeStore(d.Label)
+	store.OnStore(d.Record)
+
+	batch := []storage.LogLine{
+		errorLine("timeout after 250ms talking to 10.0.0.1:5432"),
+		{Timestamp: time.Now(), Level: "INFO", Message: "request served"},
+		errorLine("timeout after 900ms talking to 10.0.0.7:5432"), // same error, other numbers
+	}
+	if err := store.StoreLogs(ctx, "s", batch); err != nil {
+		t.Fatal(err)
+	}
+	if batch[0].Labels[NovelLabel] != "" {
+		t.Error("the caller's labels were changed")
+	}
+
+	novel := func() []storage.LogLine {
+		t.Helper()
+		logs, err := store.GetLogs(ctx, "s", storage.GetLogsOptions{Labels: map[string]string{NovelLabel: "true"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return logs
+	}
+	if logs := novel(); len(logs) != 1 || !strings.Contains(logs[0].Message, "250ms") || logs[0].Labels["pod"] != "api-1" {
+		t.Fatalf("novel lines = %+v", logs)
+	}
+
+	// Seen before, so only the new error is flagged
+	store.StoreLogs(ctx, "s", []storage.LogLine{errorLine("timeout after 5ms talking to 10.0.0.2:5432"), errorLine("disk full")})
+	if logs := novel(); len(logs) != 2 || logs[1].Message != "disk full" {
+		t.Fatalf("novel lines = %+v", logs)
+	}
+
+	events, _ := store.ListEvents(ctx, storage.ListEventsOptions{StreamID: "s", Type: storage.EventNovelError})
+	if len(events) != 2 || events[0].Message != "New error: disk full" || len(notified) != 2 {
+		t.Fatalf("events = %+v, notified %d", events, len(notified))
+	}
+}
+
+func TestNoveltyDetectorIgnoresIDs(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewHookedStorage(storage.NewMemoryStorage())
+	d := NewNoveltyDetector(store, nil)
+	store.BeforeStore(d.Label)
+	store.OnStore(d.Record)
+
+	pairs := [][2]string{
+		{"order 5f1d7a3c9e2b4a6d8c0e1f2a not found", "order 65a1b2c3d4e5f60718293a4b not found"},
+		{"request req_7f3a9c failed", "request req_01b2d4 failed"},
+		{"user-1234 over quota", "user-98 over quota"},
+		{"job#42 crashed", "job#977 crashed"},
+		{"span 4bf92f3577b34da6a3ce929d0e0e4736 timed out", "span 00f067aa0ba902b7e1c2d3f4a5b6c7d8 timed out"},
+	}
+	for i, p := range pairs {
+		streamID := fmt.Sprintf("s%d", i)
+		store.StoreLogs(ctx, streamID, []storage.LogLine{errorLine(p[0])})
+		store.StoreLogs(ctx, streamID, []storage.LogLine{errorLine(p[1])})
+
+		logs, _ := store.GetLogs(ctx, streamID, storage.GetLogsOptions{Labels: map[string]string{NovelLabel: "true"}})
+		if len(logs) != 1 || errorFingerprint(p[0]) != errorFingerprint(p[1]) {
+			t.Errorf("%q then %q: %d novel lines, want 1", p[0], p[1], len(logs))
+		}
+	}
+	if errorFingerprint("user-1234 over quota") == errorFingerprint("user-1234 banned") {
+		t.Error("different errors share a fingerprint")
+	}
+}
+
+func TestNoveltyDetectorSeedsFromStoredLogs(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	store.StoreLogs(ctx, "s", []storage.LogLine{errorLine("user 42 not found")})
+	store.UpdateStream(ctx, &storage.Stream{ID: "s"})
+
+	d := NewNoveltyDetector(store, nil)
+	d.Start()
+	defer d.Stop()
+	waitFor(t, func() bool { return !d.seeding("s") })
+
+	logs := []storage.LogLine{errorLine("user 7 not found"), errorLine("quota exceeded")}
+	d.Label(ctx, "s", logs)
+	if logs[0].Labels[NovelLabel] != "" || logs[1].Labels[NovelLabel] != "true" {
+		t.Errorf("labels = %v, %v", logs[0].Labels, logs[1].Labels)
+	}
+}
+
+func TestNoveltyDetectorSkipsStreamsBeingSeeded(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	d := NewNoveltyDetector(store, nil)
+	d.pending["s"] = true
+
+	logs := []storage.LogLine{errorLine("quota exceeded")}
+	d.Label(ctx, "s", logs)
+	d.Record("s", logs)
+	if logs[0].Labels[NovelLabel] != "" {
+		t.Errorf("labelled while seeding: %v", logs[0].Labels)
+	}
+	if events, _ := store.ListEvents(ctx, storage.ListEventsOptions{}); len(events) != 0 {
+		t.Errorf("got %d events while seeding", len(events))
+	}
+
+	// The error was still added to the history
+	d.Forget("s")
+	d.Label(ctx, "s", logs)
+	if logs[0].Labels[NovelLabel] != "" {
+		t.Error("error stored while seeding counted as new")
+	}
+}
+
+// failingStore fails every StoreLogs
+type failingStore struct {
+	storage.Storage
+}
+
+func (failingStore) StoreLogs(context.Context, string, []storage.LogLine) error {
+	return errors.New("disk full")
+}
+
+func TestNoveltyDetectorRecordsOnlyStoredErrors(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewMemoryStorage()
+	failing := storage.NewHookedStorage(failingStore{mem})
+	d := NewNoveltyDetector(mem, nil)
+	failing.BeforeStore(d.Label)
+	failing.OnStore(d.Record)
+
+	if err := failing.StoreLogs(ctx, "s", []storage.LogLine{errorLine("quota exceeded")}); err == nil {
+		t.Fatal("StoreLogs succeeded")
+	}
+	if events, _ := mem.ListEvents(ctx, storage.ListEventsOptions{}); len(events) != 0 {
+		t.Fatalf("recorded %d events for logs that were not stored", len(events))
+	}
+
+	// Stored successfully later, the error is still new
+	store := storage.NewHookedStorage(mem)
+	store.BeforeStore(d.Label)
+	store.OnStore(d.Record)
+	if err := store.StoreLogs(ctx, "s", []storage.LogLine{errorLine("quota exceeded")}); err != nil {
+		t.Fatal(err)
+	}
+	if logs, _ := mem.GetLogs(ctx, "s", storage.GetLogsOptions{Labels: map[string]string{NovelLabel: "true"}}); len(logs) != 1 {
+		t.Errorf("got %d novel lines, want 1", len(logs))
+	}
+	if events, _ := mem.ListEvents(ctx, storage.ListEventsOptions{}); len(events) != 1 {
+		t.Errorf("got %d events, want 1", len(events))
+	}
+}
+
+func TestNoveltyDetectorCapsEvents(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	d := NewNoveltyDetector(store, nil)
+
+	var logs []storage.LogLine
+	for i := 0; i < maxNovelEvents+5; i++ {
+		logs = append(logs, errorLine(fmt.Sprintf("failure %c", 'a'+i)))
+	}
+	d.Label(ctx, "s", logs)
+	d.Record("s", logs)
+	for i, line := range logs {
+		if line.Labels[NovelLabel] != "true" {
+			t.Errorf("line %d not labelled", i)
+		}
+	}
+	if events, _ := store.ListEvents(ctx, storage.ListEventsOptions{}); len(events) != maxNovelEvents {
+		t.Errorf("got %d events, want %d", len(events), maxNovelEvents)
+	}
+}
+
+func TestFallbackMentionsNovelErrors(t *testing.T) {
+	logs := []storage.LogLine{errorLine("disk full"), errorLine("disk full"), errorLine("quota exceeded")}
+	for i := range logs {
+		logs[i].Labels = map[string]string{NovelLabel: "true"}
+	}
+	analysis := NewFallbackAnalyzer().Analyze(logs, &storage.StreamContext{})
+	if !strings.HasSuffix(analysis.Summary, `; new: "disk full" (+1 more)`) {
+		t.Errorf("summary = %q", analysis.Summary)
+	}
+}
@@ -0,0 +1,120 @@
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OllamaClient talks to a local Ollama server, keeping analysis on-prem
+type OllamaClient struct {
+	config LLMConfig
+	client *http.Client
+}
+
+type ollamaRequest struct {
+	Model    string         `json:"model"`
+	Messages []chatMessage  `json:"messages"`
+	Stream   bool           `json:"stream"`
+	Format   string         `json:"format,omitempty"`
+	Options  map[string]any `json:"options,omitempty"`
+}
+
+type ollamaResponse struct {
+	Message chatMessage `json:"message"`
+	Done    bool        `json:"done"`
+	Error   string      `json:"error"`
+}
+
+func (c *OllamaClient) Name() string {
+	return "ollama"
+}
+
+func (c *OllamaClient) Complete(ctx context.Context, system, prompt string) (string, error) {
+	ctx, cancel := c.config.withTimeout(ctx)
+	defer cancel()
+	resp, err := c.post(ctx, system, prompt, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var out ollamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if out.Error != "" {
+		return "", fmt.Errorf("ollama: %s", out.Error)
+	}
+
+	return out.Message.Content, nil
+}
+
+// Stream reads Ollama's newline-delimited JSON stream, passing each message
+// fragment to onDelta as it arrives
+func (c *OllamaClient) Stream(ctx context.Context, system, prompt string, onDelta func(string)) (string, error) {
+	resp, err := c.post(ctx, system, prompt, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var chunk ollamaResponse
+		if err := dec.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if chunk.Error != "" {
+			return "", fmt.Errorf("ollama: %s", chunk.Error)
+		}
+		if delta := chunk.Message.Content; delta != "" {
+			content.WriteString(delta)
+			onDelta(delta)
+		}
+		if chunk.Done {
+			break
+		}
+	}
+	return content.String(), nil
+}
+
+// post sends the chat request and returns the response if it succeeded
+func (c *OllamaClient) post(ctx context.Context, system, prompt string, stream bool) (*http.Response, error) {
+	body, err := json.Marshal(ollamaRequest{
+		Model: c.config.Model,
+		Messages: []chatMessage{
+			{Role: "system", Content: system},
+			{Role: "user", Content: prompt},
+		},
+		Stream:  stream,
+		Format:  "json",
+		Options: map[string]any{"temperature": c.config.Temperature},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.config.BaseURL+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, newAPIError("ollama", resp)
+	}
+	return resp, nil
+}
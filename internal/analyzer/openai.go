@@ -0,0 +1,152 @@
+package analyzer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// OpenAIClient talks to any server implementing the OpenAI chat completions API
+type OpenAIClient struct {
+	name   string
+	config LLMConfig
+	client *http.Client
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Temp     float64       `json:"temperature"`
+	Stream   bool          `json:"stream,omitempty"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+// openAIChunk is one server-sent event of a streamed completion
+type openAIChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+func (c *OpenAIClient) Name() string {
+	return c.name
+}
+
+func (c *OpenAIClient) Complete(ctx context.Context, system, prompt string) (string, error) {
+	ctx, cancel := c.config.withTimeout(ctx)
+	defer cancel()
+	resp, err := c.post(ctx, system, prompt, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var out openAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if len(out.Choices) == 0 {
+		return "", fmt.Errorf("no response from %s", c.name)
+	}
+
+	return out.Choices[0].Message.Content, nil
+}
+
+// Stream requests a server-sent event stream and passes each content delta
+// to onDelta as it arrives
+func (c *OpenAIClient) Stream(ctx context.Context, system, prompt string, onDelta func(string)) (string, error) {
+	resp, err := c.post(ctx, system, prompt, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue // blank separators, comments and other SSE fields
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return content.String(), nil
+		}
+
+		var chunk openAIChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return "", fmt.Errorf("%s: bad stream chunk: %w", c.name, err)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		delta := chunk.Choices[0].Delta.Content
+		content.WriteString(delta)
+		onDelta(delta)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if content.Len() == 0 {
+		return "", fmt.Errorf("no response from %s", c.name)
+	}
+	// Some servers close the stream without [DONE]
+	return content.String(), nil
+}
+
+// post sends the chat request and returns the response if it succeeded
+func (c *OpenAIClient) post(ctx context.Context, system, prompt string, stream bool) (*http.Response, error) {
+	body, err := json.Marshal(openAIRequest{
+		Model: c.config.Model,
+		Messages: []chatMessage{
+			{Role: "system", Content: system},
+			{Role: "user", Content: prompt},
+		},
+		Temp:   c.config.Temperature,
+		Stream: stream,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.config.BaseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	if c.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, newAPIError(c.name, resp)
+	}
+	return resp, nil
+}
@@ -0,0 +1,331 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+// PatternConfig tunes template mining
+type PatternConfig struct {
+	MaxPatterns   int           // per stream; the least recently seen are dropped; default 500
+	FlushInterval time.Duration // how often changed patterns are saved; default 10s
+	ErrorHalfLife time.Duration // how fast error signatures fade; default 24h
+	CommonErrors  int           // signatures listed in StreamPatterns.CommonErrors; default 5
+}
+
+// Pattern examples are bounded so a chatty template stays small
+const (
+	maxPatternExamples = 3
+	maxExampleLength   = 500
+)
+
+// PatternMiner extracts message templates from each stream's logs as they
+// are stored, counting occurrences and keeping a few examples per template.
+// Feed it with Observe, typically from a storage.HookedStorage; patterns are
+// saved to storage in the background and reloaded when a stream is first
+// seen again. The templates of error lines also become the stream's error
+// signatures, which rank its StreamPatterns.CommonErrors.
+type PatternMiner struct {
+	store  storage.Storage
+	config PatternConfig
+
+	mu      sync.Mutex
+	streams map[string]*streamPatterns
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// streamPatterns is one stream's parse tree. Each cluster's data is its
+// *storage.LogPattern.
+type streamPatterns struct {
+	drain  *drain
+	nextID int
+	dirty  bool
+
+	hits          map[string]*signatureHits // by pattern ID
+	hasSignatures bool                      // the stored context has some to fade
+	rankedAt      time.Time
+}
+
+func NewPatternMiner(store storage.Storage, cfg PatternConfig) *PatternMiner {
+	if cfg.MaxPatterns <= 0 {
+		cfg.MaxPatterns = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 10 * time.Second
+	}
+	if cfg.ErrorHalfLife <= 0 {
+		cfg.ErrorHalfLife = 24 * time.Hour
+	}
+	if cfg.CommonErrors <= 0 {
+		cfg.CommonErrors = 5
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &PatternMiner{
+		store:   store,
+		config:  cfg,
+		streams: make(map[string]*streamPatterns),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Start saves changed patterns and signatures every FlushInterval
+func (m *PatternMiner) Start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.config.FlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-ticker.C:
+				m.flush(m.ctx)
+			}
+		}
+	}()
+}
+
+// Stop ends the background flushes and saves what is left
+func (m *PatternMiner) Stop() {
+	m.once.Do(func() {
+		m.cancel()
+		m.wg.Wait()
+		m.flush(context.Background())
+	})
+}
+
+// Observe mines freshly stored logs. It matches storage.StoreHook.
+func (m *PatternMiner) Observe(streamID string, logs []storage.LogLine) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sp := m.stream(streamID)
+	for _, line := range logs {
+		sp.add(line, m.config.ErrorHalfLife)
+	}
+	if len(sp.drain.clusters) > m.config.MaxPatterns {
+		sp.evict(m.config.MaxPatterns)
+	}
+}
+
+// Patterns returns a stream's patterns, most frequent first, including
+// counts not yet saved
+func (m *PatternMiner) Patterns(ctx context.Context, streamID string) ([]storage.LogPattern, error) {
+	if err := m.flushStream(ctx, streamID); err != nil {
+		return nil, err
+	}
+	return m.store.GetPatterns(ctx, streamID)
+}
+
+// Forget drops the in-memory state of a deleted or merged stream, so a
+// later flush does not bring its patterns back
+func (m *PatternMiner) Forget(streamID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.streams, streamID)
+}
+
+// stream returns the parse tree for streamID, rebuilding it from stored
+// patterns the first time. The caller holds m.mu.
+func (m *PatternMiner) stream(streamID string) *streamPatterns {
+	if sp, ok := m.streams[streamID]; ok {
+		return sp
+	}
+
+	sp := &streamPatterns{drain: newDrain(), hits: make(map[string]*signatureHits)}
+	stored, err := m.store.GetPatterns(m.ctx, streamID)
+	if err != nil && m.ctx.Err() == nil {
+		log.Printf("Failed to load patterns for stream %s: %v", streamID, err)
+	}
+	if streamCtx, err := m.store.GetContext(m.ctx, streamID); err == nil {
+		sp.hasSignatures = len(streamCtx.Patterns.Signatures) > 0
+	}
+	for i := range stored {
+		p := &stored[i]
+		c := sp.drain.insert(drainTokens(p.Template))
+		c.data = p
+		if id, err := strconv.Atoi(p.ID); err == nil && id >= sp.nextID {
+			sp.nextID = id + 1
+		}
+	}
+	m.streams[streamID] = sp
+	return sp
+}
+
+func (sp *streamPatterns) add(line storage.LogLine, halfLife time.Duration) {
+	c, created := sp.drain.add(drainTokens(line.Message))
+	ts := line.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	var p *storage.LogPattern
+	if created {
+		p = &storage.LogPattern{ID: strconv.Itoa(sp.nextID), FirstSeen: ts}
+		sp.nextID++
+		c.data = p
+	} else {
+		p = c.data.(*storage.LogPattern)
+	}
+
+	p.Template = c.template()
+	p.Count++
+	if isErrorLevel(line.Level) {
+		p.Errors++
+		h := sp.hits[p.ID]
+		if h == nil {
+			h = &signatureHits{}
+			sp.hits[p.ID] = h
+		}
+		h.add(ts, halfLife)
+	}
+	if ts.Before(p.FirstSeen) {
+		p.FirstSeen = ts
+	}
+	if ts.After(p.LastSeen) {
+		p.LastSeen = ts
+	}
+	if len(p.Examples) < maxPatternExamples {
+		example, _ := truncateMessage(line.Message, maxExampleLength)
+		if !slices.Contains(p.Examples, example) {
+			p.Examples = append(p.Examples, example)
+		}
+	}
+	sp.dirty = true
+}
+
+// evict drops the least recently seen patterns down to max
+func (sp *streamPatterns) evict(max int) {
+	clusters := append([]*drainCluster(nil), sp.drain.clusters...)
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].data.(*storage.LogPattern).LastSeen.Before(clusters[j].data.(*storage.LogPattern).LastSeen)
+	})
+	for _, c := range clusters[:len(clusters)-max] {
+		sp.drain.remove(c)
+	}
+}
+
+// snapshot copies the stream's patterns for saving, with their templates by
+// ID for renaming signatures
+func (sp *streamPatterns) snapshot() ([]storage.LogPattern, map[string]string) {
+	patterns := make([]storage.LogPattern, 0, len(sp.drain.clusters))
+	templates := make(map[string]string, len(sp.drain.clusters))
+	for _, c := range sp.drain.clusters {
+		p := *c.data.(*storage.LogPattern)
+		p.Examples = append([]string(nil), p.Examples...)
+		patterns = append(patterns, p)
+		templates[p.ID] = p.Template
+	}
+	return patterns, templates
+}
+
+// rankDue reports whether the stream's signatures should be saved: new
+// errors arrived, or stored scores have faded enough to change the ranking
+func (sp *streamPatterns) rankDue(now time.Time, halfLife time.Duration) bool {
+	return len(sp.hits) > 0 || (sp.hasSignatures && now.Sub(sp.rankedAt) >= halfLife/8)
+}
+
+// flush saves every stream whose patterns or signatures changed
+func (m *PatternMiner) flush(ctx context.Context) {
+	now := time.Now()
+	m.mu.Lock()
+	var dirty []string
+	for streamID, sp := range m.streams {
+		if sp.dirty || sp.rankDue(now, m.config.ErrorHalfLife) {
+			dirty = append(dirty, streamID)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, streamID := range dirty {
+		if err := m.flushStream(ctx, streamID); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to save patterns for stream %s: %v", streamID, err)
+		}
+	}
+}
+
+func (m *PatternMiner) flushStream(ctx context.Context, streamID string) error {
+	now := time.Now()
+	m.mu.Lock()
+	sp, ok := m.streams[streamID]
+	if !ok {
+		m.mu.Unlock()
+		return nil
+	}
+	dirty, rank := sp.dirty, sp.rankDue(now, m.config.ErrorHalfLife)
+	patterns, templates := sp.snapshot()
+	hits := sp.hits
+	sp.dirty = false
+	sp.hits = make(map[string]*signatureHits)
+	m.mu.Unlock()
+
+	// Put back whatever could not be saved, to try again next time
+	var err error
+	if dirty {
+		if err = m.store.SavePatterns(ctx, streamID, patterns); err != nil {
+			m.mu.Lock()
+			sp.dirty = true
+			m.mu.Unlock()
+		}
+	}
+	if rank {
+		var hasSignatures bool
+		rankErr := m.store.MutateContext(ctx, streamID, func(streamCtx *storage.StreamContext) error {
+			updateSignatures(&streamCtx.Patterns, hits, templates, now, m.config)
+			hasSignatures = len(streamCtx.Patterns.Signatures) > 0
+			return nil
+		})
+
+		m.mu.Lock()
+		if rankErr != nil {
+			for id, h := range hits {
+				if newer := sp.hits[id]; newer != nil {
+					h.merge(newer, m.config.ErrorHalfLife)
+				}
+				sp.hits[id] = h
+			}
+		} else {
+			sp.hasSignatures, sp.rankedAt = hasSignatures, now
+		}
+		m.mu.Unlock()
+		err = errors.Join(err, rankErr)
+	}
+	return err
+}
+
+// commonTemplates mines messages and returns the templates seen more than
+// once, most frequent first, with their counts
+func commonTemplates(messages []string, limit int) []storage.LogPattern {
+	d := newDrain()
+	counts := make(map[*drainCluster]int64)
+	for _, msg := range messages {
+		c, _ := d.add(drainTokens(msg))
+		counts[c]++
+	}
+
+	var patterns []storage.LogPattern
+	for _, c := range d.clusters {
+		if counts[c] > 1 {
+			patterns = append(patterns, storage.LogPattern{Template: c.template(), Count: counts[c]})
+		}
+	}
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return patterns[i].Count > patterns[j].Count
+	})
+	return patterns[:min(limit, len(patterns))]
+}
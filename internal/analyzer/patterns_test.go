@@ -0,0 +1,138 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+func TestPatternMinerCounts(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	m := NewPatternMiner(store, PatternConfig{})
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var logs []storage.LogLine
+	for i := 0; i < 5; i++ {
+		logs = append(logs, storage.LogLine{
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			Level:     "ERROR",
+			Message:   fmt.Sprintf("user %d failed login from 10.0.0.%d", i, i),
+		})
+	}
+	logs = append(logs, storage.LogLine{Timestamp: start, Level: "INFO", Message: "server started"})
+	m.Observe("s", logs)
+
+	patterns, err := m.Patterns(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patterns) != 2 {
+		t.Fatalf("got %d patterns, want 2", len(patterns))
+	}
+
+	p := patterns[0]
+	if p.Template != "user <*> failed login from <IP>" || p.Count != 5 || p.Errors != 5 {
+		t.Errorf("unexpected top pattern: %+v", p)
+	}
+	if !p.FirstSeen.Equal(start) || !p.LastSeen.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("got first/last seen %v/%v", p.FirstSeen, p.LastSeen)
+	}
+	if len(p.Examples) != maxPatternExamples || p.Examples[0] != "user 0 failed login from 10.0.0.0" {
+		t.Errorf("got examples %q", p.Examples)
+	}
+	if patterns[1].Template != "server started" || patterns[1].Errors != 0 {
+		t.Errorf("unexpected second pattern: %+v", patterns[1])
+	}
+}
+
+func TestPatternMinerReloadsStoredPatterns(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	m := NewPatternMiner(store, PatternConfig{})
+	m.Observe("s", []storage.LogLine{{Level: "ERROR", Message: "payment 1 declined"}})
+	m.Stop()
+
+	// A new miner picks up where the old one left off
+	m = NewPatternMiner(store, PatternConfig{})
+	m.Observe("s", []storage.LogLine{
+		{Level: "ERROR", Message: "payment 2 declined"},
+		{Level: "INFO", Message: "cache warmed"},
+	})
+	patterns, err := m.Patterns(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patterns) != 2 || patterns[0].Template != "payment <*> declined" || patterns[0].Count != 2 {
+		t.Fatalf("unexpected patterns: %+v", patterns)
+	}
+	if patterns[0].ID == patterns[1].ID {
+		t.Errorf("new pattern reused ID %s", patterns[0].ID)
+	}
+}
+
+func TestPatternMinerEvictsLeastRecentlySeen(t *testing.T) {
+	m := NewPatternMiner(storage.NewMemoryStorage(), PatternConfig{MaxPatterns: 2})
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	m.Observe("s", []storage.LogLine{
+		{Timestamp: start, Message: "oldest message here"},
+		{Timestamp: start.Add(time.Minute), Message: "middle entry"},
+		{Timestamp: start.Add(2 * time.Minute), Message: "newest"},
+	})
+
+	patterns, err := m.Patterns(context.Background(), "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patterns) != 2 {
+		t.Fatalf("got %d patterns, want 2", len(patterns))
+	}
+	for _, p := range patterns {
+		if p.Template == "oldest message here" {
+			t.Errorf("least recently seen pattern was kept")
+		}
+	}
+}
+
+func TestPatternMinerForget(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	m := NewPatternMiner(store, PatternConfig{})
+	m.Observe("s", []storage.LogLine{{Message: "payment declined"}})
+
+	m.Forget("s")
+	m.Stop()
+
+	patterns, err := store.GetPatterns(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patterns) != 0 {
+		t.Errorf("forgotten stream was saved: %+v", patterns)
+	}
+}
+
+func TestCommonTemplates(t *testing.T) {
+	got := commonTemplates([]string{
+		"timeout calling billing after 30s",
+		"payment declined",
+		"timeout calling billing after 31s",
+		"timeout calling ledger after 5s",
+		"cache miss",
+		"cache miss",
+	}, 3)
+
+	if len(got) != 2 {
+		t.Fatalf("got %d templates, want 2: %+v", len(got), got)
+	}
+	if got[0].Template != "timeout calling <*> after <*>" || got[0].Count != 3 {
+		t.Errorf("unexpected top template: %+v", got[0])
+	}
+	if got[1].Template != "cache miss" || got[1].Count != 2 {
+		t.Errorf("unexpected second template: %+v", got[1])
+	}
+}
@@ -0,0 +1,214 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"logvoyant/internal/storage"
+)
+
+// MaxInputLines is how many recent lines callers should hand to Analyze;
+// the prompt budget decides how many of them reach the model
+const MaxInputLines = 1000
+
+// minShrunkChars is the shortest prefix an error is cut down to
+const minShrunkChars = 80
+
+// PromptBudget bounds the size of an analysis prompt
+type PromptBudget struct {
+	MaxTokens    int // whole prompt, estimated at ~4 characters per token
+	MaxLineChars int // longer messages are truncated
+	ContextLines int // lines kept either side of each ERROR/FATAL line
+}
+
+func DefaultPromptBudget() PromptBudget {
+	return PromptBudget{
+		MaxTokens:    6000,
+		MaxLineChars: 500,
+		ContextLines: 3,
+	}
+}
+
+// Selection priorities, most important first
+const (
+	priorityError = iota
+	priorityErrorContext
+	priorityWarn
+	priorityOther
+)
+
+// PromptLine is a run of identical log lines collapsed into one, as shown to
+// prompt templates
+type PromptLine struct {
+	First, Last time.Time
+	Level       string
+	Message     string // possibly truncated
+	Labels      map[string]string
+	Count       int
+	Truncated   bool
+}
+
+// String renders the line the way the default template shows it
+func (l PromptLine) String() string {
+	line := fmt.Sprintf("[%s] [%s] %s", l.First.Format("15:04:05"), l.Level, l.Message)
+	if l.Count > 1 {
+		line += fmt.Sprintf(" (x%d, last %s)", l.Count, l.Last.Format("15:04:05"))
+	}
+	return line + "\n"
+}
+
+// logEntry tracks where a PromptLine came from while selecting
+type logEntry struct {
+	PromptLine
+	full      string // message before truncation
+	index     int    // position of the first occurrence
+	lastIndex int
+	priority  int
+}
+
+// shrink truncates the message so the entry fits in tokens, returning its
+// new cost. Entries that cannot keep a useful prefix are left alone.
+func (e *logEntry) shrink(tokens int) int {
+	overhead := estimateTokens(e.String()) - estimateTokens(e.Message) + 10
+	limit := (tokens - overhead) * 4
+	if limit < minShrunkChars {
+		return estimateTokens(e.String())
+	}
+	e.Message, e.Truncated = truncateMessage(e.full, limit)
+	return estimateTokens(e.String())
+}
+
+// logSelection records which lines made it into a prompt
+type logSelection struct {
+	entries   []*logEntry // chosen, in chronological order
+	total     int         // input lines
+	unique    int         // entries after deduplication
+	sent      int         // input lines represented by the chosen entries
+	errors    int         // chosen ERROR/FATAL entries
+	truncated int         // chosen entries whose message was cut
+}
+
+// selectLogs deduplicates logs and picks the most useful entries that fit in
+// maxTokens: ERROR/FATAL lines first, then the lines around them, then WARN,
+// then everything else, newest first within each group
+func selectLogs(logs []storage.LogLine, budget PromptBudget, maxTokens int) *logSelection {
+	// Lines near an error are context for it
+	nearError := make([]bool, len(logs))
+	for i, log := range logs {
+		if isErrorLevel(log.Level) {
+			for j := max(0, i-budget.ContextLines); j <= min(len(logs)-1, i+budget.ContextLines); j++ {
+				nearError[j] = true
+			}
+		}
+	}
+
+	byKey := make(map[string]*logEntry)
+	var entries []*logEntry
+	for i, log := range logs {
+		key := log.Level + "\x00" + log.Message
+		entry, ok := byKey[key]
+		if !ok {
+			message, truncated := truncateMessage(log.Message, budget.MaxLineChars)
+			entry = &logEntry{
+				PromptLine: PromptLine{
+					First:     log.Timestamp,
+					Level:     log.Level,
+					Message:   message,
+					Labels:    log.Labels,
+					Truncated: truncated,
+				},
+				full:     log.Message,
+				index:    i,
+				priority: priorityOther,
+			}
+			byKey[key] = entry
+			entries = append(entries, entry)
+		}
+		entry.Count++
+		entry.Last = log.Timestamp
+		entry.lastIndex = i
+
+		priority := priorityOther
+		switch {
+		case isErrorLevel(log.Level):
+			priority = priorityError
+		case nearError[i]:
+			priority = priorityErrorContext
+		case log.Level == "WARN":
+			priority = priorityWarn
+		}
+		entry.priority = min(entry.priority, priority)
+	}
+
+	ranked := append([]*logEntry(nil), entries...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].priority != ranked[j].priority {
+			return ranked[i].priority < ranked[j].priority
+		}
+		return ranked[i].lastIndex > ranked[j].lastIndex
+	})
+
+	sel := &logSelection{total: len(logs), unique: len(entries)}
+	used := 0
+	for _, entry := range ranked {
+		cost := estimateTokens(entry.String())
+		if used+cost > maxTokens && entry.priority == priorityError {
+			// Cut an error down rather than drop it
+			cost = entry.shrink(maxTokens - used)
+		}
+		if used+cost > maxTokens {
+			continue // A shorter entry may still fit
+		}
+		used += cost
+		sel.entries = append(sel.entries, entry)
+		sel.sent += entry.Count
+		if entry.priority == priorityError {
+			sel.errors++
+		}
+		if entry.Truncated {
+			sel.truncated++
+		}
+	}
+
+	sort.Slice(sel.entries, func(i, j int) bool { return sel.entries[i].index < sel.entries[j].index })
+	return sel
+}
+
+// describe summarizes the selection for Analysis.Context
+func (s *logSelection) describe() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Prompt included %d of %d log lines as %d entries (%d unique)", s.sent, s.total, len(s.entries), s.unique)
+	if s.errors > 0 {
+		fmt.Fprintf(&b, ", %d ERROR/FATAL", s.errors)
+	}
+	if s.truncated > 0 {
+		fmt.Fprintf(&b, ", %d truncated", s.truncated)
+	}
+	if len(s.entries) > 0 {
+		fmt.Fprintf(&b, "; %s to %s",
+			s.entries[0].First.Format("15:04:05"),
+			s.entries[len(s.entries)-1].Last.Format("15:04:05"))
+	}
+	return b.String() + "."
+}
+
+// truncateMessage cuts message to limit characters, keeping its start
+func truncateMessage(message string, limit int) (string, bool) {
+	if limit <= 0 || utf8.RuneCountInString(message) <= limit {
+		return message, false
+	}
+	runes := []rune(message)
+	return fmt.Sprintf("%s… [%d chars truncated]", string(runes[:limit]), len(runes)-limit), true
+}
+
+// estimateTokens approximates tokenizer output for English text and code
+func estimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
+
+func isErrorLevel(level string) bool {
+	return level == "ERROR" || level == "FATAL"
+}
@@ -0,0 +1,65 @@
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+func TestSelectLogsDeduplicatesAndPrioritizesErrors(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	var logs []storage.LogLine
+	add := func(level, message string) {
+		logs = append(logs, storage.LogLine{Timestamp: start.Add(time.Duration(len(logs)) * time.Second), Level: level, Message: message})
+	}
+
+	for i := 0; i < 200; i++ {
+		add("INFO", "health check ok")
+		add("DEBUG", fmt.Sprintf("request %d served", i))
+	}
+	add("INFO", "opening connection to db")
+	add("ERROR", "connection refused: "+strings.Repeat("x", 2000))
+	add("INFO", "retrying")
+
+	budget := DefaultPromptBudget()
+	sel := selectLogs(logs, budget, 150)
+
+	var hasError, hasContext bool
+	for _, entry := range sel.entries {
+		switch {
+		case entry.Level == "ERROR":
+			hasError = true
+			if !entry.Truncated || len(entry.Message) > budget.MaxLineChars+40 {
+				t.Errorf("long error message was not truncated: %d chars", len(entry.Message))
+			}
+		case entry.Message == "opening connection to db" || entry.Message == "retrying":
+			hasContext = true
+		case entry.Message == "health check ok" && entry.Count != 200:
+			t.Errorf("health check count = %d, want 200", entry.Count)
+		}
+	}
+	if !hasError || !hasContext {
+		t.Errorf("error or its context missing from a tight budget: %+v", sel.entries)
+	}
+	if sel.sent >= sel.total {
+		t.Errorf("expected lines to be omitted under a tight budget")
+	}
+
+	used := 0
+	for i, entry := range sel.entries {
+		used += estimateTokens(entry.String())
+		if i > 0 && entry.index < sel.entries[i-1].index {
+			t.Error("entries are not in chronological order")
+		}
+	}
+	if used > 150 {
+		t.Errorf("selection uses %d tokens, budget is 150", used)
+	}
+
+	if d := sel.describe(); !strings.Contains(d, "of 403 log lines") || !strings.Contains(d, "1 truncated") {
+		t.Errorf("describe() = %q", d)
+	}
+}
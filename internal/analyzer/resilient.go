@@ -0,0 +1,275 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the provider while it is
+// considered unhealthy
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// ResilienceConfig tunes retries and the circuit breaker
+type ResilienceConfig struct {
+	Timeout          time.Duration // per attempt, or until a stream starts; 0 relies on the caller's deadline
+	MaxRetries       int           // extra attempts after a 429, 5xx or transport error
+	BaseBackoff      time.Duration // doubled per retry, with jitter
+	MaxBackoff       time.Duration // a longer Retry-After is not waited for
+	FailureThreshold int           // consecutive failed calls that open the breaker
+	Cooldown         time.Duration // how long the breaker stays open before a probe
+}
+
+// DefaultResilienceConfig returns conservative settings for hosted APIs
+func DefaultResilienceConfig() ResilienceConfig {
+	return ResilienceConfig{
+		Timeout:          30 * time.Second,
+		MaxRetries:       2,
+		BaseBackoff:      500 * time.Millisecond,
+		MaxBackoff:       10 * time.Second,
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+	}
+}
+
+// BreakerStatus is a snapshot of a ResilientProvider's health
+type BreakerStatus struct {
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	OpenedAt            time.Time `json:"opened_at,omitempty"`
+	RetryAt             time.Time `json:"retry_at,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+	Calls               int64     `json:"calls"`
+	Retries             int64     `json:"retries"`
+	Rejected            int64     `json:"rejected"`
+}
+
+// ResilientProvider wraps an LLMProvider with per-attempt deadlines, retries
+// and a circuit breaker. While the breaker is open calls fail fast with
+// ErrCircuitOpen so the analyzer goes straight to its fallback.
+type ResilientProvider struct {
+	inner  LLMProvider
+	config ResilienceConfig
+
+	mu      sync.Mutex
+	status  BreakerStatus
+	probing bool // a half-open probe is in flight
+}
+
+func NewResilientProvider(inner LLMProvider, cfg ResilienceConfig) *ResilientProvider {
+	return &ResilientProvider{
+		inner:  inner,
+		config: cfg,
+		status: BreakerStatus{State: BreakerClosed},
+	}
+}
+
+func (p *ResilientProvider) Name() string {
+	return p.inner.Name()
+}
+
+// Status returns the breaker state and call counters
+func (p *ResilientProvider) Status() BreakerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refresh()
+	return p.status
+}
+
+func (p *ResilientProvider) Complete(ctx context.Context, system, prompt string) (string, error) {
+	return p.call(ctx, func(ctx context.Context) (string, error) {
+		if p.config.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, p.config.Timeout)
+			defer cancel()
+		}
+		return p.inner.Complete(ctx, system, prompt)
+	}, nil)
+}
+
+// Stream streams from the wrapped provider if it can, else delivers the
+// whole reply as a single delta. The attempt timeout only covers the wait
+// for the first delta, so a long reply is not cut off while it flows. A
+// stream that already produced content is not retried, since the caller has
+// seen it.
+func (p *ResilientProvider) Stream(ctx context.Context, system, prompt string, onDelta func(string)) (string, error) {
+	streamer, ok := p.inner.(StreamingProvider)
+	if !ok {
+		content, err := p.Complete(ctx, system, prompt)
+		if err == nil {
+			onDelta(content)
+		}
+		return content, err
+	}
+
+	started := false
+	return p.call(ctx, func(ctx context.Context) (string, error) {
+		if p.config.Timeout <= 0 {
+			return streamer.Stream(ctx, system, prompt, func(delta string) {
+				started = true
+				onDelta(delta)
+			})
+		}
+
+		ctx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+		silent := fmt.Errorf("%s: no reply within %s: %w", p.Name(), p.config.Timeout, context.DeadlineExceeded)
+		timer := time.AfterFunc(p.config.Timeout, func() { cancel(silent) })
+		defer timer.Stop()
+
+		content, err := streamer.Stream(ctx, system, prompt, func(delta string) {
+			timer.Stop()
+			started = true
+			onDelta(delta)
+		})
+		if err != nil && context.Cause(ctx) == silent {
+			return "", silent
+		}
+		return content, err
+	}, func() bool { return !started })
+}
+
+// call runs fn under the breaker, retrying temporary failures while
+// canRetry (if set) allows it
+func (p *ResilientProvider) call(ctx context.Context, fn func(context.Context) (string, error), canRetry func() bool) (string, error) {
+	if !p.allow() {
+		return "", fmt.Errorf("%s: %w", p.Name(), ErrCircuitOpen)
+	}
+
+	for attempt := 0; ; attempt++ {
+		content, err := fn(ctx)
+		if err == nil {
+			p.record(nil)
+			return content, nil
+		}
+		if ctx.Err() != nil {
+			// The caller gave up; that says nothing about the provider
+			p.release()
+			return "", ctx.Err()
+		}
+		if attempt >= p.config.MaxRetries || !retryable(err) || (canRetry != nil && !canRetry()) {
+			p.record(err)
+			return "", err
+		}
+
+		// A provider asking for more than MaxBackoff, or for a wait past the
+		// caller's deadline, gets its error passed on instead
+		wait := p.backoff(attempt, err)
+		deadline, ok := ctx.Deadline()
+		if (p.config.MaxBackoff > 0 && wait > p.config.MaxBackoff) || (ok && time.Until(deadline) < wait) {
+			p.record(err)
+			return "", err
+		}
+
+		p.mu.Lock()
+		p.status.Retries++
+		p.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			p.release()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff is exponential with jitter, unless the provider said how long to wait
+func (p *ResilientProvider) backoff(attempt int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+
+	d := p.config.BaseBackoff << attempt
+	if d <= 0 || (p.config.MaxBackoff > 0 && d > p.config.MaxBackoff) {
+		d = p.config.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryable reports whether err is a rate limit, server error or transport
+// failure rather than a rejected request
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	return true
+}
+
+// allow admits a call unless the breaker is open. Once the cooldown has
+// passed a single probe is let through; its result closes or reopens it.
+func (p *ResilientProvider) allow() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refresh()
+
+	switch p.status.State {
+	case BreakerOpen:
+		p.status.Rejected++
+		return false
+	case BreakerHalfOpen:
+		if p.probing {
+			p.status.Rejected++
+			return false
+		}
+		p.probing = true
+	}
+	p.status.Calls++
+	return true
+}
+
+// refresh moves an open breaker to half-open after the cooldown
+func (p *ResilientProvider) refresh() {
+	if p.status.State == BreakerOpen && !time.Now().Before(p.status.RetryAt) {
+		p.status.State = BreakerHalfOpen
+	}
+}
+
+// record updates the breaker with the outcome of an admitted call
+func (p *ResilientProvider) record(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.probing = false
+
+	if err == nil {
+		p.status.State = BreakerClosed
+		p.status.ConsecutiveFailures = 0
+		p.status.OpenedAt = time.Time{}
+		p.status.RetryAt = time.Time{}
+		return
+	}
+
+	p.status.ConsecutiveFailures++
+	p.status.LastError = err.Error()
+	threshold := p.config.FailureThreshold
+	if p.status.State == BreakerHalfOpen || (threshold > 0 && p.status.ConsecutiveFailures >= threshold) {
+		now := time.Now()
+		p.status.State = BreakerOpen
+		p.status.OpenedAt = now
+		p.status.RetryAt = now.Add(p.config.Cooldown)
+	}
+}
+
+// release ends an admitted call without judging the provider
+func (p *ResilientProvider) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.probing = false
+}
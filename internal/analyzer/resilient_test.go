@@ -0,0 +1,160 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// scriptedProvider returns errs in order, then succeeds
+type scriptedProvider struct {
+	errs  []error
+	calls int
+}
+
+func (p *scriptedProvider) Name() string { return "scripted" }
+
+func (p *scriptedProvider) Complete(ctx context.Context, system, prompt string) (string, error) {
+	p.calls++
+	if p.calls <= len(p.errs) {
+		return "", p.errs[p.calls-1]
+	}
+	return "ok", nil
+}
+
+func fastConfig() ResilienceConfig {
+	return ResilienceConfig{
+		MaxRetries:       2,
+		BaseBackoff:      time.Millisecond,
+		MaxBackoff:       5 * time.Millisecond,
+		FailureThreshold: 2,
+		Cooldown:         50 * time.Millisecond,
+	}
+}
+
+func TestRetriesTemporaryErrors(t *testing.T) {
+	inner := &scriptedProvider{errs: []error{
+		&APIError{StatusCode: http.StatusTooManyRequests},
+		&APIError{StatusCode: http.StatusBadGateway},
+	}}
+	p := NewResilientProvider(inner, fastConfig())
+
+	content, err := p.Complete(context.Background(), "", "")
+	if err != nil || content != "ok" {
+		t.Fatalf("got %q, %v", content, err)
+	}
+	if inner.calls != 3 {
+		t.Errorf("calls = %d, want 3", inner.calls)
+	}
+	if s := p.Status(); s.State != BreakerClosed || s.Retries != 2 {
+		t.Errorf("status = %+v", s)
+	}
+}
+
+func TestDoesNotRetryRejectedRequests(t *testing.T) {
+	inner := &scriptedProvider{errs: []error{&APIError{StatusCode: http.StatusUnauthorized}}}
+	p := NewResilientProvider(inner, fastConfig())
+
+	if _, err := p.Complete(context.Background(), "", ""); err == nil {
+		t.Fatal("expected an error")
+	}
+	if inner.calls != 1 {
+		t.Errorf("calls = %d, want 1", inner.calls)
+	}
+}
+
+func TestHonorsRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"choices":[{"message":{"content":"ok"}}]}`))
+	}))
+	defer stub.Close()
+
+	llm, _ := NewProvider(LLMConfig{Provider: "openai", BaseURL: stub.URL})
+	cfg := fastConfig()
+	cfg.MaxBackoff = 2 * time.Second
+	p := NewResilientProvider(llm, cfg)
+
+	start := time.Now()
+	if _, err := p.Complete(context.Background(), "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want at least the 1s Retry-After", elapsed)
+	}
+
+	// A Retry-After beyond the caller's deadline fails fast instead
+	calls.Store(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := p.Complete(ctx, "", ""); err == nil {
+		t.Error("expected the 429 to be returned")
+	}
+}
+
+func TestRetryAfterBeyondMaxBackoffIsNotRetried(t *testing.T) {
+	inner := &scriptedProvider{errs: []error{&APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour}}}
+	p := NewResilientProvider(inner, fastConfig())
+
+	start := time.Now()
+	_, err := p.Complete(context.Background(), "", "")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != time.Hour {
+		t.Fatalf("got %v, want the 429", err)
+	}
+	if inner.calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("calls = %d after %v, want one call and no wait", inner.calls, time.Since(start))
+	}
+	if s := p.Status(); s.Retries != 0 || s.ConsecutiveFailures != 1 {
+		t.Errorf("status = %+v", s)
+	}
+}
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	down := &APIError{StatusCode: http.StatusServiceUnavailable}
+	inner := &scriptedProvider{errs: []error{down, down, down, down, down, down}}
+	cfg := fastConfig()
+	cfg.MaxRetries = 0
+	p := NewResilientProvider(inner, cfg)
+
+	for i := 0; i < 2; i++ {
+		p.Complete(context.Background(), "", "")
+	}
+	if s := p.Status(); s.State != BreakerOpen {
+		t.Fatalf("state = %s, want open", s.State)
+	}
+
+	// Open: fail fast without calling the provider
+	if _, err := p.Complete(context.Background(), "", ""); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("got %v, want ErrCircuitOpen", err)
+	}
+	if inner.calls != 2 {
+		t.Errorf("calls = %d, want 2", inner.calls)
+	}
+
+	// A failed probe reopens the breaker
+	time.Sleep(cfg.Cooldown)
+	p.Complete(context.Background(), "", "")
+	if s := p.Status(); s.State != BreakerOpen {
+		t.Fatalf("state after failed probe = %s, want open", s.State)
+	}
+
+	// A successful probe closes it
+	inner.errs = nil
+	time.Sleep(cfg.Cooldown)
+	if _, err := p.Complete(context.Background(), "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if s := p.Status(); s.State != BreakerClosed || s.ConsecutiveFailures != 0 {
+		t.Errorf("status = %+v", s)
+	}
+}
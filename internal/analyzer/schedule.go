@@ -0,0 +1,146 @@
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+// DigestSchedule runs a digest on a cron schedule
+type DigestSchedule struct {
+	Name    string           `json:"name"`
+	Cron    string           `json:"cron"`              // five fields, or @daily, @hourly, ...
+	Window  storage.Duration `json:"window,omitempty"`  // logs covered, ending at the run; default 24h
+	Streams []string         `json:"streams,omitempty"` // default: every stream
+	Notify  bool             `json:"notify,omitempty"`  // deliver the digest as a notification
+}
+
+// LoadDigestSchedules reads a JSON array of schedules. A missing file means
+// no schedules.
+func LoadDigestSchedules(path string) ([]DigestSchedule, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var schedules []DigestSchedule
+	if err := json.Unmarshal(data, &schedules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	names := make(map[string]bool)
+	for _, s := range schedules {
+		if s.Name == "" || names[s.Name] {
+			return nil, fmt.Errorf("%s: every schedule needs a unique name", path)
+		}
+		names[s.Name] = true
+		if _, err := ParseCron(s.Cron); err != nil {
+			return nil, fmt.Errorf("%s: schedule %s: %w", path, s.Name, err)
+		}
+	}
+	return schedules, nil
+}
+
+// Scheduler builds and stores digests on their schedules. Runs missed while
+// the process was down are not made up. Digests are built through a
+// JobQueue so they count against its worker limit.
+type Scheduler struct {
+	jobs      *JobQueue
+	store     storage.Storage
+	schedules []DigestSchedule
+	deliver   func(*storage.Digest) // for schedules with Notify; may be nil
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+func NewScheduler(jobs *JobQueue, store storage.Storage, schedules []DigestSchedule, deliver func(*storage.Digest)) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		jobs:      jobs,
+		store:     store,
+		schedules: schedules,
+		deliver:   deliver,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Start runs each schedule on its own goroutine
+func (s *Scheduler) Start() {
+	for _, schedule := range s.schedules {
+		cron, err := ParseCron(schedule.Cron)
+		if err != nil {
+			log.Printf("Digest schedule %s disabled: %v", schedule.Name, err)
+			continue
+		}
+
+		s.wg.Add(1)
+		go func(schedule DigestSchedule) {
+			defer s.wg.Done()
+			for {
+				next := cron.Next(time.Now())
+				if next.IsZero() {
+					log.Printf("Digest schedule %s never runs", schedule.Name)
+					return
+				}
+
+				timer := time.NewTimer(time.Until(next))
+				select {
+				case <-s.ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+
+				if _, err := s.Run(s.ctx, schedule); err != nil && s.ctx.Err() == nil {
+					log.Printf("Digest %s failed: %v", schedule.Name, err)
+				}
+			}
+		}(schedule)
+	}
+}
+
+// Stop cancels running digests and waits for the schedules to exit
+func (s *Scheduler) Stop() {
+	s.once.Do(func() {
+		s.cancel()
+		s.wg.Wait()
+	})
+}
+
+// Run builds a digest of the window ending now, stores it and delivers it if
+// the schedule asks for that
+func (s *Scheduler) Run(ctx context.Context, schedule DigestSchedule) (*storage.Digest, error) {
+	window := time.Duration(schedule.Window)
+	if window <= 0 {
+		window = 24 * time.Hour
+	}
+	until := time.Now()
+
+	digest, err := s.jobs.BuildDigest(ctx, schedule.Name, schedule.Streams, until.Add(-window), until)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.SaveDigest(ctx, digest); err != nil {
+		return nil, fmt.Errorf("failed to store digest: %w", err)
+	}
+	log.Printf("Digest %s: %d issues, %d unresolved P0/P1", schedule.Name, len(digest.Issues), len(digest.Unresolved))
+
+	if schedule.Notify && s.deliver != nil {
+		s.deliver(digest)
+	}
+	return digest, nil
+}
@@ -0,0 +1,70 @@
+package analyzer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+func TestLoadDigestSchedules(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "schedules.json")
+
+	if schedules, err := LoadDigestSchedules(path); err != nil || schedules != nil {
+		t.Fatalf("missing file: %+v, %v", schedules, err)
+	}
+
+	os.WriteFile(path, []byte(`[{"name": "morning", "cron": "0 7 * * *", "window": "12h", "notify": true}]`), 0644)
+	schedules, err := LoadDigestSchedules(path)
+	if err != nil || len(schedules) != 1 || time.Duration(schedules[0].Window) != 12*time.Hour {
+		t.Fatalf("got %+v, %v", schedules, err)
+	}
+
+	for _, bad := range []string{
+		`[{"name": "x", "cron": "0 7 * *"}]`,
+		`[{"cron": "@daily"}]`,
+		`[{"name": "x", "cron": "@daily"}, {"name": "x", "cron": "@hourly"}]`,
+	} {
+		os.WriteFile(path, []byte(bad), 0644)
+		if _, err := LoadDigestSchedules(path); err == nil {
+			t.Errorf("accepted %s", bad)
+		}
+	}
+}
+
+func TestSchedulerRunStoresAndDelivers(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	store.StoreLogs(ctx, "a", testLogs())
+
+	var delivered []*storage.Digest
+	jobs := NewJobQueue(New(&Config{Storage: store}), store, JobConfig{})
+	s := NewScheduler(jobs, store, nil, func(d *storage.Digest) {
+		delivered = append(delivered, d)
+	})
+
+	digest, err := s.Run(ctx, DigestSchedule{Name: "quiet", Cron: "@daily"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 0 {
+		t.Error("delivered a digest whose schedule does not notify")
+	}
+	if stored, err := store.GetDigest(ctx, digest.ID); err != nil || stored.Schedule != "quiet" {
+		t.Fatalf("stored digest = %+v, %v", stored, err)
+	}
+
+	if _, err := s.Run(ctx, DigestSchedule{Name: "loud", Cron: "@daily", Notify: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 1 || delivered[0].Schedule != "loud" {
+		t.Errorf("delivered = %+v", delivered)
+	}
+	if all, _ := store.ListDigests(ctx, 0); len(all) != 2 || all[0].Schedule != "loud" {
+		t.Errorf("ListDigests = %+v", all)
+	}
+}
@@ -0,0 +1,129 @@
+package analyzer
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+// Error signatures are the templates of a stream's error lines, kept in its
+// StreamContext. Each has a score that adds one per occurrence and halves
+// every ErrorHalfLife, so CommonErrors follows what a stream fails with now
+// rather than what it failed with most ever.
+const (
+	maxErrorSignatures = 50   // per stream, lowest scores dropped first
+	minSignatureScore  = 0.05 // below this a signature is forgotten
+	minCommonScore     = 1.0  // a common error still scores at least one recent occurrence
+)
+
+// signatureHits are one template's error occurrences not yet saved
+type signatureHits struct {
+	count int64
+	score float64 // as of at
+	at    time.Time
+}
+
+func (h *signatureHits) add(ts time.Time, halfLife time.Duration) {
+	h.merge(&signatureHits{count: 1, score: 1, at: ts}, halfLife)
+}
+
+func (h *signatureHits) merge(other *signatureHits, halfLife time.Duration) {
+	h.count += other.count
+	h.score, h.at = addScore(h.score, h.at, other.score, other.at, halfLife)
+}
+
+// decayScore returns score, measured at from, as of to
+func decayScore(score float64, from, to time.Time, halfLife time.Duration) float64 {
+	if !to.After(from) {
+		return score
+	}
+	return score * math.Exp2(-float64(to.Sub(from))/float64(halfLife))
+}
+
+// addScore sums two scores measured at different times, as of the later one
+func addScore(score float64, at time.Time, other float64, otherAt time.Time, halfLife time.Duration) (float64, time.Time) {
+	if otherAt.After(at) {
+		score, at, other, otherAt = other, otherAt, score, at
+	}
+	return score + decayScore(other, otherAt, at, halfLife), at
+}
+
+// updateSignatures folds hits into a stream's signatures, renames them to
+// their patterns' current templates, drops the faded ones and ranks the
+// rest into CommonErrors
+func updateSignatures(p *storage.StreamPatterns, hits map[string]*signatureHits, templates map[string]string, now time.Time, cfg PatternConfig) {
+	byID := make(map[string]int, len(p.Signatures))
+	for i, sig := range p.Signatures {
+		byID[sig.PatternID] = i
+	}
+	for id, h := range hits {
+		i, ok := byID[id]
+		if !ok {
+			p.Signatures = append(p.Signatures, storage.ErrorSignature{PatternID: id})
+			i = len(p.Signatures) - 1
+			byID[id] = i
+		}
+		sig := &p.Signatures[i]
+		sig.Count += h.count
+		sig.Score, sig.LastSeen = addScore(sig.Score, sig.LastSeen, h.score, h.at, cfg.ErrorHalfLife)
+	}
+
+	current := make(map[string]float64, len(p.Signatures))
+	kept := p.Signatures[:0]
+	for _, sig := range p.Signatures {
+		if template, ok := templates[sig.PatternID]; ok {
+			sig.Template = template
+		}
+		score := decayScore(sig.Score, sig.LastSeen, now, cfg.ErrorHalfLife)
+		if sig.Template == "" || score < minSignatureScore {
+			continue
+		}
+		current[sig.PatternID] = score
+		kept = append(kept, sig)
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return current[kept[i].PatternID] > current[kept[j].PatternID]
+	})
+	p.Signatures = kept[:min(maxErrorSignatures, len(kept))]
+
+	p.CommonErrors = []string{}
+	for _, sig := range p.Signatures {
+		if len(p.CommonErrors) == cfg.CommonErrors {
+			break
+		}
+		if sig.Count > 1 && current[sig.PatternID] >= minCommonScore {
+			p.CommonErrors = append(p.CommonErrors, sig.Template)
+		}
+	}
+}
+
+// knownErrors returns the common errors that any of messages matches,
+// ignoring case
+func knownErrors(commonErrors []string, messages []string) []string {
+	var known []string
+	for _, template := range commonErrors {
+		want := strings.Fields(template)
+		for _, msg := range messages {
+			if matchesTemplate(want, drainTokens(msg)) {
+				known = append(known, template)
+				break
+			}
+		}
+	}
+	return known
+}
+
+func matchesTemplate(template, tokens []string) bool {
+	if len(template) != len(tokens) {
+		return false
+	}
+	for i, token := range template {
+		if token != drainWildcard && !strings.EqualFold(token, tokens[i]) {
+			return false
+		}
+	}
+	return true
+}
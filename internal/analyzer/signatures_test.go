@@ -0,0 +1,127 @@
+package analyzer
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+func TestScoreDecay(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := decayScore(8, start, start.Add(3*time.Hour), time.Hour); got != 1 {
+		t.Errorf("8 after three half-lives = %v, want 1", got)
+	}
+	if got := decayScore(8, start, start.Add(-time.Hour), time.Hour); got != 8 {
+		t.Errorf("decaying backwards changed the score to %v", got)
+	}
+
+	// Order does not matter; the sum is as of the later time
+	for _, swap := range []bool{false, true} {
+		a, aAt, b, bAt := 4.0, start, 1.0, start.Add(time.Hour)
+		if swap {
+			a, aAt, b, bAt = b, bAt, a, aAt
+		}
+		score, at := addScore(a, aAt, b, bAt, time.Hour)
+		if score != 3 || !at.Equal(start.Add(time.Hour)) {
+			t.Errorf("addScore = %v at %v, want 3 at %v", score, at, start.Add(time.Hour))
+		}
+	}
+}
+
+func TestUpdateSignatures(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cfg := PatternConfig{ErrorHalfLife: time.Hour, CommonErrors: 2}
+	p := &storage.StreamPatterns{
+		CommonErrors: []string{"stale"},
+		Signatures: []storage.ErrorSignature{
+			{PatternID: "1", Template: "disk full", Count: 100, Score: 100, LastSeen: now.Add(-10 * time.Hour)},
+			{PatternID: "2", Template: "timeout after <*>", Count: 3, Score: 3, LastSeen: now},
+			{PatternID: "3", Template: "forgotten", Count: 5, Score: 1, LastSeen: now.Add(-24 * time.Hour)},
+		},
+	}
+	hits := map[string]*signatureHits{
+		"2": {count: 1, score: 1, at: now},
+		"4": {count: 1, score: 1, at: now},
+		"5": {count: 6, score: 6, at: now},
+	}
+	templates := map[string]string{
+		"2": "timeout after <*> calling <*>",
+		"4": "payment declined",
+		"5": "cache miss",
+	}
+	updateSignatures(p, hits, templates, now, cfg)
+
+	var got []string
+	for _, sig := range p.Signatures {
+		got = append(got, sig.PatternID)
+	}
+	if strings.Join(got, ",") != "5,2,4,1" {
+		t.Fatalf("signatures ranked %v, want 5,2,4,1", got)
+	}
+	if sig := p.Signatures[1]; sig.Template != "timeout after <*> calling <*>" || sig.Count != 4 || sig.Score != 4 {
+		t.Errorf("updated signature = %+v", sig)
+	}
+
+	// Seen once, or faded below one recent occurrence, is not common
+	if strings.Join(p.CommonErrors, ",") != "cache miss,timeout after <*> calling <*>" {
+		t.Errorf("common errors = %q", p.CommonErrors)
+	}
+
+	// A quiet stream's errors fade out of CommonErrors
+	updateSignatures(p, nil, nil, now.Add(3*time.Hour), cfg)
+	if len(p.CommonErrors) != 0 {
+		t.Errorf("common errors after three quiet half-lives = %q", p.CommonErrors)
+	}
+	if math.Abs(p.Signatures[0].Score-6) > 1e-9 {
+		t.Errorf("stored score changed without new hits: %v", p.Signatures[0].Score)
+	}
+}
+
+func TestPatternMinerMaintainsCommonErrors(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+	m := NewPatternMiner(store, PatternConfig{})
+
+	now := time.Now()
+	m.Observe("s", []storage.LogLine{
+		{Timestamp: now, Level: "ERROR", Message: "timeout calling billing after 30s"},
+		{Timestamp: now, Level: "ERROR", Message: "timeout calling billing after 31s"},
+		{Timestamp: now, Level: "ERROR", Message: "payment 7 declined"},
+		{Timestamp: now, Level: "INFO", Message: "request served in 12ms"},
+		{Timestamp: now, Level: "INFO", Message: "request served in 15ms"},
+	})
+	if _, err := m.Patterns(ctx, "s"); err != nil {
+		t.Fatal(err)
+	}
+
+	streamCtx, err := store.GetContext(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streamCtx.Patterns.Signatures) != 2 {
+		t.Fatalf("signatures = %+v", streamCtx.Patterns.Signatures)
+	}
+	if got := streamCtx.Patterns.CommonErrors; len(got) != 1 || got[0] != "timeout calling billing after <*>" {
+		t.Errorf("common errors = %q", got)
+	}
+}
+
+func TestKnownErrors(t *testing.T) {
+	common := []string{"timeout calling <*> after <*>", "connection refused by <IP>", "disk full"}
+	messages := []string{"timeout calling ledger after 5s", "connection refused by 10.0.0.1", "something else"}
+	got := knownErrors(common, messages)
+	if strings.Join(got, "; ") != "timeout calling <*> after <*>; connection refused by <IP>" {
+		t.Errorf("known errors = %q", got)
+	}
+
+	f := NewFallbackAnalyzer()
+	analysis := f.Analyze([]storage.LogLine{{Level: "ERROR", Message: "Disk full"}},
+		&storage.StreamContext{Patterns: storage.StreamPatterns{CommonErrors: common}})
+	if !strings.Contains(analysis.Context, "repeatedly seen: disk full") {
+		t.Errorf("fallback context = %q", analysis.Context)
+	}
+}
@@ -0,0 +1,196 @@
+package analyzer
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+// SpikeConfig tunes when a stream's error counts trigger an analysis
+type SpikeConfig struct {
+	Window    time.Duration // errors are counted per window; default 1m
+	Threshold float64       // error share of a window that counts as a spike; 0 disables
+	Sigma     float64       // standard deviations above the baseline that count as a spike; 0 disables
+	MinErrors int           // errors a window needs before either check applies; default 5
+	Warmup    int           // windows seen before the baseline is trusted; default 5
+	Cooldown  time.Duration // minimum time between triggers for one stream; default 10m
+}
+
+// DefaultSpikeConfig triggers on a 20% error rate or a 3 sigma jump
+func DefaultSpikeConfig() SpikeConfig {
+	return SpikeConfig{
+		Window:    time.Minute,
+		Threshold: 0.2,
+		Sigma:     3,
+		MinErrors: 5,
+		Warmup:    5,
+		Cooldown:  10 * time.Minute,
+	}
+}
+
+// baselineAlpha weights each finished window in the moving baseline
+const baselineAlpha = 0.1
+
+// maxIdleWindows caps how many empty windows are folded into the baseline
+// after a stream has been quiet
+const maxIdleWindows = 60
+
+// Spike describes why a stream triggered
+type Spike struct {
+	StreamID string    `json:"stream_id"`
+	Errors   int       `json:"errors"`   // in the current window
+	Total    int       `json:"total"`    // lines in the current window
+	Baseline float64   `json:"baseline"` // mean errors per window
+	Reason   string    `json:"reason"`
+	At       time.Time `json:"at"`
+}
+
+// SpikeWatcher keeps per-stream error counts and calls trigger when a
+// stream's errors cross the threshold or jump above their baseline. Feed it
+// with Observe, typically from a storage.HookedStorage.
+type SpikeWatcher struct {
+	config  SpikeConfig
+	trigger func(Spike)
+	now     func() time.Time
+
+	mu      sync.Mutex
+	streams map[string]*spikeState
+}
+
+type spikeState struct {
+	windowStart   time.Time
+	total, errors int
+
+	windows  int     // finished windows folded into the baseline
+	mean     float64 // errors per window, exponentially weighted
+	variance float64
+
+	lastTrigger time.Time
+}
+
+func NewSpikeWatcher(cfg SpikeConfig, trigger func(Spike)) *SpikeWatcher {
+	defaults := DefaultSpikeConfig()
+	if cfg.Window <= 0 {
+		cfg.Window = defaults.Window
+	}
+	if cfg.MinErrors <= 0 {
+		cfg.MinErrors = defaults.MinErrors
+	}
+	if cfg.Warmup <= 0 {
+		cfg.Warmup = defaults.Warmup
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = defaults.Cooldown
+	}
+	return &SpikeWatcher{
+		config:  cfg,
+		trigger: trigger,
+		now:     time.Now,
+		streams: make(map[string]*spikeState),
+	}
+}
+
+// Observe counts freshly stored logs. It matches storage.StoreHook. Lines
+// stamped before the current window, such as a backlog read at startup, are
+// ignored.
+func (w *SpikeWatcher) Observe(streamID string, logs []storage.LogLine) {
+	now := w.now()
+	spike, ok := w.observe(streamID, logs, now)
+	if ok {
+		w.trigger(spike)
+	}
+}
+
+func (w *SpikeWatcher) observe(streamID string, logs []storage.LogLine, now time.Time) (Spike, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	st := w.streams[streamID]
+	if st == nil {
+		// Windows are aligned so lines stamped just before the first
+		// observation still count
+		st = &spikeState{windowStart: now.Truncate(w.config.Window)}
+		w.streams[streamID] = st
+	}
+	st.advance(now, w.config.Window)
+
+	for _, log := range logs {
+		if !log.Timestamp.IsZero() && log.Timestamp.Before(st.windowStart) {
+			continue
+		}
+		st.total++
+		if isErrorLevel(log.Level) {
+			st.errors++
+		}
+	}
+
+	if st.errors < w.config.MinErrors || now.Sub(st.lastTrigger) < w.config.Cooldown {
+		return Spike{}, false
+	}
+	reason := w.check(st)
+	if reason == "" {
+		return Spike{}, false
+	}
+
+	st.lastTrigger = now
+	return Spike{
+		StreamID: streamID,
+		Errors:   st.errors,
+		Total:    st.total,
+		Baseline: st.mean,
+		Reason:   reason,
+		At:       now,
+	}, true
+}
+
+// check returns why the current window is a spike, or "" if it is not
+func (w *SpikeWatcher) check(st *spikeState) string {
+	if w.config.Threshold > 0 && st.total > 0 {
+		rate := float64(st.errors) / float64(st.total)
+		if rate >= w.config.Threshold {
+			return fmt.Sprintf("error rate %.0f%% over %s is above %.0f%%", rate*100, w.config.Window, w.config.Threshold*100)
+		}
+	}
+
+	if w.config.Sigma > 0 && st.windows >= w.config.Warmup {
+		// A floor of one error keeps a perfectly steady stream from
+		// triggering on a single extra error
+		limit := st.mean + w.config.Sigma*math.Max(math.Sqrt(st.variance), 1)
+		if float64(st.errors) > limit {
+			return fmt.Sprintf("%d errors in %s against a baseline of %.1f", st.errors, w.config.Window, st.mean)
+		}
+	}
+	return ""
+}
+
+// advance closes finished windows, folding them into the baseline
+func (st *spikeState) advance(now time.Time, window time.Duration) {
+	elapsed := int(now.Sub(st.windowStart) / window)
+	if elapsed <= 0 {
+		return
+	}
+
+	st.fold(float64(st.errors))
+	for i := 1; i < min(elapsed, maxIdleWindows); i++ {
+		st.fold(0)
+	}
+	st.windowStart = st.windowStart.Add(time.Duration(elapsed) * window)
+	st.total, st.errors = 0, 0
+}
+
+// fold adds one window's error count to the exponentially weighted mean
+// and variance
+func (st *spikeState) fold(errors float64) {
+	st.windows++
+	if st.windows == 1 {
+		st.mean = errors
+		return
+	}
+	diff := errors - st.mean
+	incr := baselineAlpha * diff
+	st.mean += incr
+	st.variance = (1 - baselineAlpha) * (st.variance + diff*incr)
+}
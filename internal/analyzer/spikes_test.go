@@ -0,0 +1,106 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+// spikeClock drives a SpikeWatcher with a fake clock and records triggers
+type spikeClock struct {
+	now    time.Time
+	spikes []Spike
+}
+
+func newTestWatcher(cfg SpikeConfig) (*SpikeWatcher, *spikeClock) {
+	clock := &spikeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	w := NewSpikeWatcher(cfg, func(s Spike) { clock.spikes = append(clock.spikes, s) })
+	w.now = func() time.Time { return clock.now }
+	return w, clock
+}
+
+// batch returns total lines stamped at ts, errors of them at ERROR level
+func batch(total, errors int, ts time.Time) []storage.LogLine {
+	logs := make([]storage.LogLine, total)
+	for i := range logs {
+		logs[i] = storage.LogLine{Timestamp: ts, Level: "INFO", Message: "ok"}
+		if i < errors {
+			logs[i].Level = "ERROR"
+		}
+	}
+	return logs
+}
+
+func TestSpikeThresholdAndCooldown(t *testing.T) {
+	w, clock := newTestWatcher(SpikeConfig{Threshold: 0.2, Cooldown: 10 * time.Minute})
+
+	w.Observe("s", batch(20, 4, clock.now))
+	if len(clock.spikes) != 0 {
+		t.Fatalf("triggered below MinErrors: %+v", clock.spikes)
+	}
+
+	w.Observe("s", batch(10, 10, clock.now))
+	if len(clock.spikes) != 1 || !strings.Contains(clock.spikes[0].Reason, "error rate") {
+		t.Fatalf("spikes = %+v", clock.spikes)
+	}
+	if s := clock.spikes[0]; s.Errors != 14 || s.Total != 30 {
+		t.Errorf("spike counts = %+v", s)
+	}
+
+	// Still spiking, but within the cooldown
+	clock.now = clock.now.Add(5 * time.Minute)
+	w.Observe("s", batch(10, 10, clock.now))
+	if len(clock.spikes) != 1 {
+		t.Fatalf("triggered during cooldown: %+v", clock.spikes)
+	}
+
+	clock.now = clock.now.Add(6 * time.Minute)
+	w.Observe("s", batch(10, 10, clock.now))
+	if len(clock.spikes) != 2 {
+		t.Fatalf("no trigger after cooldown: %+v", clock.spikes)
+	}
+
+	// Streams have their own cooldowns
+	w.Observe("other", batch(10, 10, clock.now))
+	if len(clock.spikes) != 3 || clock.spikes[2].StreamID != "other" {
+		t.Fatalf("spikes = %+v", clock.spikes)
+	}
+}
+
+func TestSpikeBaseline(t *testing.T) {
+	w, clock := newTestWatcher(SpikeConfig{Sigma: 3})
+
+	// A steady 5 errors per minute is normal for this stream
+	for i := 0; i < 10; i++ {
+		w.Observe("s", batch(100, 5, clock.now))
+		clock.now = clock.now.Add(time.Minute)
+	}
+	w.Observe("s", batch(100, 7, clock.now))
+	if len(clock.spikes) != 0 {
+		t.Fatalf("triggered within the baseline: %+v", clock.spikes)
+	}
+
+	w.Observe("s", batch(100, 13, clock.now))
+	if len(clock.spikes) != 1 || !strings.Contains(clock.spikes[0].Reason, "baseline of 5.0") {
+		t.Fatalf("spikes = %+v", clock.spikes)
+	}
+}
+
+func TestSpikeIgnoresBacklog(t *testing.T) {
+	w, clock := newTestWatcher(SpikeConfig{Threshold: 0.2})
+
+	// A file tailer reading old lines at startup is not a spike
+	w.Observe("s", batch(50, 50, clock.now.Add(-time.Hour)))
+	if len(clock.spikes) != 0 {
+		t.Fatalf("triggered on backlog: %+v", clock.spikes)
+	}
+
+	// Lines from earlier in the current minute still count
+	clock.now = clock.now.Add(30 * time.Second)
+	w.Observe("s", batch(10, 10, clock.now.Add(-10*time.Second)))
+	if len(clock.spikes) != 1 {
+		t.Fatalf("spikes = %+v", clock.spikes)
+	}
+}
@@ -0,0 +1,167 @@
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// AnalysisUpdate is the part of an analysis readable from a reply that is
+// still streaming in
+type AnalysisUpdate struct {
+	Summary   string `json:"summary"`
+	RootCause string `json:"root_cause"`
+}
+
+// complete sends a prompt, streaming the reply through onUpdate when the
+// provider supports it
+func (a *Analyzer) complete(ctx context.Context, system, user string, onUpdate func(AnalysisUpdate)) (string, error) {
+	streamer, ok := a.llm.(StreamingProvider)
+	if onUpdate == nil || !ok {
+		return a.llm.Complete(ctx, system, user)
+	}
+
+	reply := partialReply{summary: partialField{key: "summary"}, rootCause: partialField{key: "root_cause"}}
+	var last AnalysisUpdate
+	return streamer.Stream(ctx, system, user, func(delta string) {
+		if update := reply.write(delta); update != last {
+			last = update
+			onUpdate(update)
+		}
+	})
+}
+
+// partialReply follows the fields of an analysis while its reply streams in
+type partialReply struct {
+	content   strings.Builder
+	summary   partialField
+	rootCause partialField
+}
+
+func (r *partialReply) write(delta string) AnalysisUpdate {
+	r.content.WriteString(delta)
+	content := r.content.String()
+	r.summary.update(content)
+	r.rootCause.update(content)
+	return AnalysisUpdate{Summary: r.summary.value.String(), RootCause: r.rootCause.value.String()}
+}
+
+// partialString returns the value of the string field key in a JSON object
+// that may be cut off anywhere, decoded as far as it goes
+func partialString(content, key string) string {
+	f := partialField{key: key}
+	f.update(content)
+	return f.value.String()
+}
+
+// partialField decodes one string field of a JSON object that is still
+// streaming in. Each update only scans and decodes what was added since the
+// last one, so following a whole reply takes time linear in its length.
+type partialField struct {
+	key      string
+	searched int  // offset the key has been searched for up to
+	keyEnd   int  // offset just past the key, 0 until it is found
+	start    int  // offset just past the value's opening quote, 0 until found
+	scanned  int  // offset the value has been scanned for its closing quote up to
+	decoded  int  // offset the value has been decoded up to
+	escaped  bool // the last scanned byte starts an escape
+	done     bool // the value is closed, or is not a string
+	value    strings.Builder
+}
+
+// update catches up with content, which must extend what was passed before
+func (f *partialField) update(content string) {
+	if f.done {
+		return
+	}
+	if f.start == 0 && !f.findValue(content) {
+		return
+	}
+
+	end := f.scanned
+	for ; end < len(content); end++ {
+		if f.escaped {
+			f.escaped = false
+		} else if content[end] == '\\' {
+			f.escaped = true
+		} else if content[end] == '"' {
+			f.done = true
+			break
+		}
+	}
+	f.scanned = end
+
+	raw := content[f.decoded:end]
+	for raw != "" {
+		if !f.done {
+			// Hold back a multi-byte character or surrogate pair that was
+			// cut in half until the rest of it arrives
+			for i := 0; i < utf8.UTFMax && !utf8.ValidString(raw); i++ {
+				raw = raw[:len(raw)-1]
+			}
+			raw = strings.TrimSuffix(raw, highSurrogate(raw))
+		}
+		var s string
+		if err := json.Unmarshal([]byte(repairJSON(`"`+raw+`"`)), &s); err == nil {
+			f.value.WriteString(s)
+			f.decoded += len(raw)
+			return
+		}
+		// An escape sequence cut in half
+		cut := strings.LastIndexByte(raw, '\\')
+		if cut < 0 {
+			return
+		}
+		raw = raw[:cut]
+	}
+}
+
+// findValue looks for the key and the opening quote of its value in the
+// part of content not yet searched, reporting whether the value has started
+func (f *partialField) findValue(content string) bool {
+	if f.keyEnd == 0 {
+		quoted := `"` + f.key + `"`
+		i := strings.Index(content[f.searched:], quoted)
+		if i < 0 {
+			f.searched = max(f.searched, len(content)-len(quoted)+1)
+			return false
+		}
+		f.keyEnd = f.searched + i + len(quoted)
+	}
+
+	// The separator is short, so it is simply rescanned until complete
+	rest := strings.TrimLeft(content[f.keyEnd:], " \t\r\n")
+	if rest == "" {
+		return false
+	}
+	rest, ok := strings.CutPrefix(rest, ":")
+	if !ok {
+		f.done = true
+		return false
+	}
+	rest = strings.TrimLeft(rest, " \t\r\n")
+	if rest == "" {
+		return false
+	}
+	if rest[0] != '"' {
+		f.done = true
+		return false
+	}
+	f.start = len(content) - len(rest) + 1
+	f.scanned, f.decoded = f.start, f.start
+	return true
+}
+
+// highSurrogate returns the \uD800-\uDBFF escape ending raw, if any; alone it
+// would decode to U+FFFD instead of pairing with the escape that follows
+func highSurrogate(raw string) string {
+	if len(raw) < 6 || raw[len(raw)-6] != '\\' || raw[len(raw)-5] != 'u' {
+		return ""
+	}
+	if n, err := strconv.ParseUint(raw[len(raw)-4:], 16, 16); err != nil || n < 0xD800 || n > 0xDBFF {
+		return ""
+	}
+	return raw[len(raw)-6:]
+}
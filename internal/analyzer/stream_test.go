@@ -0,0 +1,233 @@
+package analyzer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"logvoyant/internal/storage"
+)
+
+func TestPartialString(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`{"summ`, ""},
+		{`{"summary": `, ""},
+		{`{"summary": "db`, "db"},
+		{`{"summary": "db down", "sev`, "db down"},
+		{`{"summary": "say \"hi`, `say "hi`},
+		{`{"summary": "line\`, "line"},
+		{`{"summary": "line\n`, "line\n"},
+		{`{"summary": "caf\u00`, "caf"},
+		{"{\"summary\": \"caf\xc3", "caf"},
+		{"{\"summary\": \"raw\nnewline\"", "raw\nnewline"},
+	}
+	for _, tt := range tests {
+		if got := partialString(tt.content, "summary"); got != tt.want {
+			t.Errorf("partialString(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestPartialReplyByteByByte(t *testing.T) {
+	content := `{"summary": "caf\u00e9 \"down\" \ud83d\ude00 – a\\b\n", "root_cause": "Ünïcode"}`
+	want := AnalysisUpdate{Summary: "café \"down\" 😀 – a\\b\n", RootCause: "Ünïcode"}
+
+	reply := partialReply{summary: partialField{key: "summary"}, rootCause: partialField{key: "root_cause"}}
+	var got AnalysisUpdate
+	for i := 0; i < len(content); i++ {
+		got = reply.write(content[i : i+1])
+		if summary := partialString(content[:i+1], "summary"); got.Summary != summary {
+			t.Fatalf("after %q: summary = %q, decoding from scratch gives %q", content[:i+1], got.Summary, summary)
+		}
+		if !utf8.ValidString(got.Summary) || strings.ContainsRune(got.Summary, utf8.RuneError) {
+			t.Fatalf("after %q: summary = %q has a broken character", content[:i+1], got.Summary)
+		}
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// sseStub serves reply as OpenAI chat completion chunks of a few bytes each
+func sseStub(t *testing.T, reply string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openAIRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			t.Errorf("request did not ask for a stream: %+v", req)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < len(reply); i += 7 {
+			chunk, _ := json.Marshal(map[string]any{
+				"choices": []any{map[string]any{"delta": map[string]string{"content": reply[i:min(i+7, len(reply))]}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+func TestAnalyzeStream(t *testing.T) {
+	stub := sseStub(t, stubReply)
+	defer stub.Close()
+
+	llm, err := NewProvider(LLMConfig{Provider: "openai", BaseURL: stub.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(&Config{
+		Storage: storage.NewMemoryStorage(),
+		LLM:     NewResilientProvider(llm, DefaultResilienceConfig()),
+	})
+
+	var updates []AnalysisUpdate
+	analysis, err := a.AnalyzeStream(context.Background(), "s", testLogs(), func(update AnalysisUpdate) {
+		updates = append(updates, update)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysis.Summary != "db down" || analysis.Severity != "P1" {
+		t.Errorf("analysis = %+v", analysis)
+	}
+	if len(updates) < 3 {
+		t.Fatalf("got %d updates, want the text to arrive in pieces", len(updates))
+	}
+	last := updates[len(updates)-1]
+	if last.Summary != "db down" || last.RootCause != "connection refused" {
+		t.Errorf("last update = %+v", last)
+	}
+}
+
+func TestOllamaStream(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			t.Errorf("request did not ask for a stream: %+v", req)
+		}
+		enc := json.NewEncoder(w)
+		enc.Encode(map[string]any{"message": map[string]string{"content": `{"summary":`}})
+		enc.Encode(map[string]any{"message": map[string]string{"content": `"db down"}`}})
+		enc.Encode(map[string]any{"done": true})
+	}))
+	defer stub.Close()
+
+	llm, err := NewProvider(LLMConfig{Provider: "ollama", BaseURL: stub.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deltas []string
+	content, err := llm.(StreamingProvider).Stream(context.Background(), "system", "prompt", func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != `{"summary":"db down"}` || len(deltas) != 2 {
+		t.Errorf("content = %q from %d deltas", content, len(deltas))
+	}
+}
+
+func TestCancelAbortsStream(t *testing.T) {
+	aborted := make(chan struct{})
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `data: {"choices":[{"delta":{"content":"{\"summary\":\"db"}}]}`+"\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			close(aborted)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer stub.Close()
+
+	llm, err := NewProvider(LLMConfig{Provider: "openai", BaseURL: stub.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(&Config{Storage: storage.NewMemoryStorage(), LLM: NewResilientProvider(llm, fastConfig())})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = a.AnalyzeStream(ctx, "s", testLogs(), func(update AnalysisUpdate) {
+		cancel() // the client went away after the first words
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+
+	select {
+	case <-aborted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream request was not aborted")
+	}
+}
+
+func TestStreamOutlastsTimeout(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, piece := range []string{`{"summary":`, `"db`, ` down"`, `}`} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", piece)
+			w.(http.Flusher).Flush()
+			time.Sleep(40 * time.Millisecond)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer stub.Close()
+
+	llm, err := NewProvider(LLMConfig{Provider: "openai", BaseURL: stub.URL, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := fastConfig()
+	cfg.Timeout = 100 * time.Millisecond
+
+	content, err := NewResilientProvider(llm, cfg).Stream(context.Background(), "system", "prompt", func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != `{"summary":"db down"}` {
+		t.Errorf("content = %q", content)
+	}
+}
+
+func TestSilentStreamTimesOut(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer stub.Close()
+
+	llm, err := NewProvider(LLMConfig{Provider: "openai", BaseURL: stub.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := fastConfig()
+	cfg.Timeout = 50 * time.Millisecond
+	cfg.MaxRetries = 0
+
+	start := time.Now()
+	_, err = NewResilientProvider(llm, cfg).Stream(context.Background(), "system", "prompt", func(string) {})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("gave up after %s", elapsed)
+	}
+}
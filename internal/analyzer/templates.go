@@ -0,0 +1,215 @@
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+// Prompt template names. Each is read from the template directory if present,
+// else from the embedded defaults in prompts/.
+const (
+	SystemTemplate   = "system.tmpl"
+	AnalysisTemplate = "analysis.tmpl"
+)
+
+var templateNames = []string{SystemTemplate, AnalysisTemplate}
+
+//go:embed prompts/*.tmpl
+var defaultTemplates embed.FS
+
+// PromptData is what prompt templates are executed over
+type PromptData struct {
+	StreamID    string
+	Stream      *storage.Stream // nil if the stream has no metadata yet
+	Context     *storage.StreamContext
+	History     []storage.AnalysisSummary // the most recent analyses, oldest first
+	Patterns    []storage.LogPattern      // the stream's message templates, most errors first
+	Events      []storage.Event           // detected in the last day, oldest first
+	NovelErrors []string                  // errors in the logs the stream never logged before
+	Logs        []PromptLine              // selected to fit the token budget
+	Omitted     int                       // input lines left out of Logs
+	Now         time.Time
+}
+
+// historyDepth is how many past analyses PromptData.History carries,
+// patternDepth how many message templates PromptData.Patterns does and
+// eventDepth how many events PromptData.Events does
+const (
+	historyDepth = 3
+	patternDepth = 10
+	eventDepth   = 5
+)
+
+var templateFuncs = template.FuncMap{
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"join":    strings.Join,
+	"percent": func(f float64) string { return fmt.Sprintf("%.1f%%", f*100) },
+	"label": func(labels map[string]string, key string) string {
+		return labels[key]
+	},
+}
+
+// Templates holds the prompt templates, optionally overridden from a
+// directory and reloaded when the files there change
+type Templates struct {
+	dir string
+
+	mu        sync.RWMutex
+	templates map[string]*template.Template
+	defaults  map[string]*template.Template
+	mtimes    map[string]time.Time
+}
+
+// LoadTemplates parses the embedded defaults and any overrides in dir. A
+// missing dir is not an error; an override that fails to parse is.
+func LoadTemplates(dir string) (*Templates, error) {
+	t := &Templates{
+		dir:      dir,
+		defaults: make(map[string]*template.Template),
+	}
+	for _, name := range templateNames {
+		text, err := defaultTemplates.ReadFile("prompts/" + name)
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(name).Funcs(templateFuncs).Parse(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("default template %s: %w", name, err)
+		}
+		t.defaults[name] = tmpl
+	}
+
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload rereads overrides from the directory. On error the previous
+// templates stay in use.
+func (t *Templates) Reload() error {
+	templates := make(map[string]*template.Template)
+	mtimes := make(map[string]time.Time)
+
+	for _, name := range templateNames {
+		templates[name] = t.defaults[name]
+		if t.dir == "" {
+			continue
+		}
+
+		path := filepath.Join(t.dir, name)
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		tmpl, err := template.New(name).Funcs(templateFuncs).Parse(string(text))
+		if err != nil {
+			return fmt.Errorf("template %s: %w", path, err)
+		}
+		templates[name] = tmpl
+		mtimes[name] = info.ModTime()
+	}
+
+	t.mu.Lock()
+	t.templates = templates
+	t.mtimes = mtimes
+	t.mu.Unlock()
+	return nil
+}
+
+// Watch polls the directory every interval and reloads when a template is
+// added, changed or removed, until ctx is cancelled
+func (t *Templates) Watch(ctx context.Context, interval time.Duration) {
+	if t.dir == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !t.changed() {
+			continue
+		}
+		if err := t.Reload(); err != nil {
+			log.Printf("Keeping previous prompt templates: %v", err)
+			// Remember the broken files so the error is logged once per edit
+			t.mu.Lock()
+			t.mtimes = t.currentMtimes()
+			t.mu.Unlock()
+			continue
+		}
+		log.Printf("Reloaded prompt templates from %s", t.dir)
+	}
+}
+
+func (t *Templates) changed() bool {
+	current := t.currentMtimes()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if len(current) != len(t.mtimes) {
+		return true
+	}
+	for name, mtime := range current {
+		if !t.mtimes[name].Equal(mtime) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Templates) currentMtimes() map[string]time.Time {
+	mtimes := make(map[string]time.Time)
+	for _, name := range templateNames {
+		if info, err := os.Stat(filepath.Join(t.dir, name)); err == nil {
+			mtimes[name] = info.ModTime()
+		}
+	}
+	return mtimes
+}
+
+// render executes the named template, falling back to the embedded default
+// if an override fails on this data
+func (t *Templates) render(name string, data *PromptData) (string, error) {
+	t.mu.RLock()
+	tmpl := t.templates[name]
+	t.mu.RUnlock()
+
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, data)
+	if err == nil || tmpl == t.defaults[name] {
+		return buf.String(), err
+	}
+
+	log.Printf("Prompt template %s failed (%v), using the default", name, err)
+	buf.Reset()
+	err = t.defaults[name].Execute(&buf, data)
+	return buf.String(), err
+}
@@ -0,0 +1,132 @@
+package analyzer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"logvoyant/internal/storage"
+)
+
+func TestDefaultTemplates(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	store.MutateContext(ctx, "s", func(streamCtx *storage.StreamContext) error {
+		streamCtx.Analyses = []storage.AnalysisSummary{{Summary: "db down", Severity: "P1"}}
+		streamCtx.Patterns.CommonErrors = []string{"connection refused"}
+		streamCtx.Patterns.ErrorRate = 0.25
+		return nil
+	})
+	store.SavePatterns(ctx, "s", []storage.LogPattern{
+		{ID: "1", Template: "request <*> ok", Count: 40},
+		{ID: "2", Template: "connection refused by <IP>", Count: 3, Errors: 3,
+			LastSeen: time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)},
+	})
+
+	quietAt := time.Now().Add(-time.Hour)
+	store.SaveEvent(ctx, &storage.Event{ID: "e", StreamID: "s", Type: storage.EventAnomaly,
+		Timestamp: quietAt, Message: "Stream went quiet: no logs in the last hour, usually 120"})
+
+	logs := append(testLogs(), storage.LogLine{Timestamp: time.Now(), Level: "ERROR", Message: "disk full",
+		Labels: map[string]string{NovelLabel: "true"}, StreamID: "s"})
+	a := New(&Config{Storage: store})
+	prompt, err := a.Preview(ctx, "s", logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"# Log Analysis for Stream: s",
+		"db down (P1, UNRESOLVED)",
+		"- connection refused\n- Current error rate: 25.0%",
+		"## Recent Events\n- " + quietAt.Format("15:04") + ": Stream went quiet: no logs in the last hour, usually 120\n",
+		"## First-Time Errors\nThese errors were never seen on this stream before:\n- disk full\n",
+		"## Message Patterns\n- connection refused by <IP> (3 seen, 3 errors, last 09:30)\n- request <*> ok (40 seen",
+		"[ERROR] connection refused",
+		`"root_cause"`,
+	} {
+		if !strings.Contains(prompt.User, want) {
+			t.Errorf("prompt is missing %q:\n%s", want, prompt.User)
+		}
+	}
+	if !strings.HasPrefix(prompt.System, "You are an expert log analyzer") {
+		t.Errorf("system = %q", prompt.System)
+	}
+}
+
+func TestTemplateOverrideAndReload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, AnalysisTemplate)
+	write := func(text string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`Owner: {{with .Stream}}{{.Source}}{{end}} {{range .Logs}}{{label .Labels "team"}}{{end}}`)
+	templates, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewMemoryStorage()
+	store.UpdateStream(ctx, &storage.Stream{ID: "s", Source: "kubectl"})
+	logs := testLogs()
+	logs[0].Labels = map[string]string{"team": "payments"}
+
+	a := New(&Config{Storage: store, Templates: templates})
+	prompt, err := a.Preview(ctx, "s", logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prompt.User != "Owner: kubectl payments" {
+		t.Errorf("prompt = %q", prompt.User)
+	}
+
+	// Edits are picked up by Watch
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go templates.Watch(watchCtx, 10*time.Millisecond)
+
+	write("Runbook: https://runbooks.example/{{.StreamID}}")
+	future := time.Now().Add(time.Second)
+	os.Chtimes(path, future, future)
+	waitFor(t, func() bool {
+		prompt, _ = a.Preview(ctx, "s", logs)
+		return prompt.User == "Runbook: https://runbooks.example/s"
+	})
+
+	// A broken edit keeps the last good template
+	write("{{.Broken")
+	if err := templates.Reload(); err == nil {
+		t.Error("expected a parse error")
+	}
+	if prompt, _ = a.Preview(ctx, "s", logs); prompt.User != "Runbook: https://runbooks.example/s" {
+		t.Errorf("prompt after a broken edit = %q", prompt.User)
+	}
+
+	// Removing the override restores the default
+	os.Remove(path)
+	if err := templates.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if prompt, _ = a.Preview(ctx, "s", logs); !strings.HasPrefix(prompt.User, "# Log Analysis") {
+		t.Errorf("prompt after removing the override = %q", prompt.User)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
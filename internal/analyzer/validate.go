@@ -0,0 +1,241 @@
+package analyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"logvoyant/internal/storage"
+)
+
+// MaxFixes caps how many suggested fixes an analysis keeps
+const MaxFixes = 5
+
+var severities = []string{"P0", "P1", "P2", "P3"}
+
+// severityAliases maps common ways models describe severity onto P0-P3
+var severityAliases = map[string]string{
+	"critical": "P0", "blocker": "P0", "emergency": "P0", "fatal": "P0",
+	"high": "P1", "major": "P1", "error": "P1", "urgent": "P1",
+	"medium": "P2", "moderate": "P2", "normal": "P2", "warning": "P2", "warn": "P2",
+	"low": "P3", "minor": "P3", "info": "P3", "informational": "P3", "trivial": "P3",
+}
+
+var severityCode = regexp.MustCompile(`(?i)^(?:p|sev)[- ]?([0-3])\b`)
+
+// ValidationError lists the fields of a model reply that were rejected
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid analysis: " + strings.Join(e.Problems, "; ")
+}
+
+// llmAnalysis is a lenient view of a model reply: fixes may be a single
+// string and unknown fields are ignored
+type llmAnalysis struct {
+	Summary   string          `json:"summary"`
+	RootCause string          `json:"root_cause"`
+	Severity  string          `json:"severity"`
+	Fixes     json.RawMessage `json:"fixes"`
+	Context   string          `json:"context"`
+}
+
+// parseAnalysis extracts, repairs and validates a model reply. Fields that
+// fail validation are left empty in the returned analysis, which is non-nil
+// whenever the reply held a JSON object, and the problems are returned as a
+// *ValidationError.
+func parseAnalysis(content string) (*storage.Analysis, error) {
+	object, err := extractJSON(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw llmAnalysis
+	if err := json.Unmarshal([]byte(object), &raw); err != nil {
+		if err := json.Unmarshal([]byte(repairJSON(object)), &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse analysis JSON: %w\nContent: %s", err, content)
+		}
+	}
+
+	analysis := &storage.Analysis{
+		Summary:   strings.TrimSpace(raw.Summary),
+		RootCause: strings.TrimSpace(raw.RootCause),
+		Context:   strings.TrimSpace(raw.Context),
+	}
+	var problems []string
+
+	if analysis.Summary == "" {
+		problems = append(problems, `"summary" must be a non-empty string`)
+	}
+
+	if severity, ok := normalizeSeverity(raw.Severity); ok {
+		analysis.Severity = severity
+	} else {
+		problems = append(problems, fmt.Sprintf(`"severity" must be one of %s, got %q`, strings.Join(severities, ", "), raw.Severity))
+	}
+
+	fixes, err := decodeFixes(raw.Fixes)
+	if err != nil {
+		problems = append(problems, `"fixes" must be an array of strings`)
+	}
+	if len(fixes) > MaxFixes {
+		fixes = fixes[:MaxFixes]
+	}
+	analysis.Fixes = fixes
+
+	if len(problems) > 0 {
+		return analysis, &ValidationError{Problems: problems}
+	}
+	return analysis, nil
+}
+
+// mergeAnalysis fills the fields the model left empty or got wrong from the
+// fallback analysis
+func mergeAnalysis(partial, fallback *storage.Analysis) *storage.Analysis {
+	merged := *partial
+	if merged.Summary == "" {
+		merged.Summary = fallback.Summary
+	}
+	if merged.RootCause == "" {
+		merged.RootCause = fallback.RootCause
+	}
+	if merged.Severity == "" {
+		merged.Severity = fallback.Severity
+	}
+	if len(merged.Fixes) == 0 {
+		merged.Fixes = fallback.Fixes
+	}
+	if merged.Context == "" {
+		merged.Context = fallback.Context
+	}
+	return &merged
+}
+
+func normalizeSeverity(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if m := severityCode.FindStringSubmatch(s); m != nil {
+		return "P" + m[1], true
+	}
+
+	// "High", "HIGH - data loss" and "high severity" all count
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !('a' <= r && r <= 'z')
+	})
+	if len(words) == 0 {
+		return "", false
+	}
+	severity, ok := severityAliases[words[0]]
+	return severity, ok
+}
+
+func decodeFixes(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		var single string
+		if err := json.Unmarshal(raw, &single); err != nil {
+			return nil, err
+		}
+		list = []string{single}
+	}
+
+	var fixes []string
+	for _, fix := range list {
+		if fix = strings.TrimSpace(fix); fix != "" {
+			fixes = append(fixes, fix)
+		}
+	}
+	return fixes, nil
+}
+
+// extractJSON returns the first balanced JSON object in content, skipping
+// code fences and any prose around it
+func extractJSON(content string) (string, error) {
+	start := strings.IndexByte(content, '{')
+	if start < 0 {
+		return "", fmt.Errorf("no JSON object in reply: %q", truncateReply(content))
+	}
+
+	depth := 0
+	inString, escaped := false, false
+	for i := start; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return content[start : i+1], nil
+			}
+		}
+	}
+
+	// Truncated reply: close what was opened and let repair try
+	return content[start:] + strings.Repeat("}", depth), nil
+}
+
+// repairJSON fixes the mistakes models commonly make: trailing commas and
+// raw newlines or tabs inside strings
+func repairJSON(s string) string {
+	var b strings.Builder
+	inString, escaped := false, false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			case c == '\n':
+				b.WriteString(`\n`)
+				continue
+			case c == '\r':
+				continue
+			case c == '\t':
+				b.WriteString(`\t`)
+				continue
+			}
+			b.WriteByte(c)
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case ',':
+			// Drop a comma if the next significant character closes a container
+			j := i + 1
+			for j < len(s) && strings.IndexByte(" \t\r\n", s[j]) >= 0 {
+				j++
+			}
+			if j < len(s) && (s[j] == '}' || s[j] == ']') {
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func truncateReply(s string) string {
+	s, _ = truncateMessage(strings.TrimSpace(s), 200)
+	return s
+}
@@ -0,0 +1,106 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"logvoyant/internal/storage"
+)
+
+func TestParseAnalysis(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    string
+		severity string
+		fixes    int
+		invalid  bool
+	}{
+		{"plain", `{"summary":"s","severity":"P2","fixes":["a"]}`, "P2", 1, false},
+		{"prose and fence", "Sure! Here you go:\n```json\n{\"summary\":\"s\",\"severity\":\"P1\"}\n```\nHope it helps.", "P1", 0, false},
+		{"trailing commas", `{"summary":"s","severity":"P0","fixes":["a","b",],}`, "P0", 2, false},
+		{"raw newline in string", "{\"summary\":\"line one\nline two\",\"severity\":\"P3\"}", "P3", 0, false},
+		{"severity word", `{"summary":"s","severity":"High"}`, "P1", 0, false},
+		{"severity sev code", `{"summary":"s","severity":"SEV-0 (outage)"}`, "P0", 0, false},
+		{"fixes as string", `{"summary":"s","severity":"P2","fixes":"restart it"}`, "P2", 1, false},
+		{"too many fixes", `{"summary":"s","severity":"P2","fixes":["1","2","3","4","5","6","7"]}`, "P2", MaxFixes, false},
+		{"bad severity", `{"summary":"s","severity":"whatever","fixes":["a"]}`, "", 1, true},
+		{"empty summary", `{"summary":"  ","severity":"P2"}`, "P2", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis, err := parseAnalysis(tt.reply)
+			var invalid *ValidationError
+			if tt.invalid != errors.As(err, &invalid) {
+				t.Fatalf("err = %v, want invalid=%v", err, tt.invalid)
+			}
+			if !tt.invalid && err != nil {
+				t.Fatal(err)
+			}
+			if analysis.Severity != tt.severity || len(analysis.Fixes) != tt.fixes {
+				t.Errorf("analysis = %+v", analysis)
+			}
+		})
+	}
+
+	if _, err := parseAnalysis("I could not analyze these logs."); err == nil {
+		t.Error("expected an error for a reply without JSON")
+	}
+}
+
+// replyProvider returns canned replies in order and records the prompts
+type replyProvider struct {
+	replies []string
+	prompts []string
+}
+
+func (p *replyProvider) Name() string { return "replies" }
+
+func (p *replyProvider) Complete(ctx context.Context, system, prompt string) (string, error) {
+	p.prompts = append(p.prompts, prompt)
+	reply := p.replies[0]
+	if len(p.replies) > 1 {
+		p.replies = p.replies[1:]
+	}
+	return reply, nil
+}
+
+func TestRetryWithValidationError(t *testing.T) {
+	llm := &replyProvider{replies: []string{
+		`{"summary":"db down","severity":"sort of bad"}`,
+		`{"summary":"db down","severity":"P1","fixes":["restart db"]}`,
+	}}
+	a := New(&Config{Storage: storage.NewMemoryStorage(), LLM: llm})
+
+	analysis, err := a.Analyze(context.Background(), "s", testLogs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysis.Severity != "P1" || len(llm.prompts) != 2 {
+		t.Fatalf("analysis = %+v after %d calls", analysis, len(llm.prompts))
+	}
+	if !strings.Contains(llm.prompts[1], `"severity" must be one of`) {
+		t.Errorf("retry prompt does not explain the problem:\n%s", llm.prompts[1])
+	}
+}
+
+func TestPartialAnalysisMergesFallback(t *testing.T) {
+	llm := &replyProvider{replies: []string{`{"summary":"db down","root_cause":"pool exhausted","severity":"??"}`}}
+	a := New(&Config{Storage: storage.NewMemoryStorage(), LLM: llm})
+
+	analysis, err := a.Analyze(context.Background(), "s", testLogs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysis.Summary != "db down" || analysis.RootCause != "pool exhausted" {
+		t.Errorf("valid LLM fields were not kept: %+v", analysis)
+	}
+	if analysis.Severity == "" || len(analysis.Fixes) == 0 {
+		t.Errorf("invalid fields were not filled from the fallback: %+v", analysis)
+	}
+	if !strings.Contains(analysis.Context, "pattern matching") {
+		t.Errorf("context does not note the merge: %q", analysis.Context)
+	}
+}
@@ -1,6 +1,8 @@
 package ingest
 
 import (
+	"context"
+	"errors"
 	"log"
 	"os"
 	"path/filepath"
@@ -10,8 +12,8 @@ import (
 	"logvoyant/internal/storage"
 )
 
-// DiscoverAndStart finds log files and starts tailing them
-func DiscoverAndStart(store storage.Storage, hub LogBroadcaster) error {
+// DiscoverAndStart finds log files and starts tailing them until ctx is done
+func DiscoverAndStart(ctx context.Context, store storage.Storage, hub LogBroadcaster) error {
 	var logPaths []string
 
 	// Common log locations to check
@@ -63,36 +65,58 @@ func DiscoverAndStart(store storage.Storage, hub LogBroadcaster) error {
 	
 	// Start tailing each file
 	for _, path := range logPaths {
-		streamID := "file:" + path
+		streamID, err := storage.ResolveStream(ctx, store, "file:"+path)
+		if errors.Is(err, storage.ErrRemoved) {
+			log.Printf("Not tailing %s: %v", path, err)
+			continue
+		} else if err != nil {
+			log.Printf("Failed to look up stream of %s: %v", path, err)
+			continue
+		}
 		
-		// Create stream entry
-		stream := &storage.Stream{
-			ID:     streamID,
-			Name:   filepath.Base(path),
-			Source: "file",
-			Active: true,
+		// Create the stream the first time; a known one keeps its name and
+		// labels, which may have been edited
+		if err := activateStream(ctx, store, streamID, path); err != nil {
+			log.Printf("Failed to register stream %s: %v", streamID, err)
+			continue
 		}
-		store.UpdateStream(stream)
 		
-		// Initialize context
-		ctx, _ := store.GetContext(streamID)
-		if ctx.StreamID == "" {
-			ctx.StreamID = streamID
-			ctx.FirstSeen = time.Now()
-			ctx.Analyses = []storage.AnalysisSummary{}
-			ctx.Patterns = storage.StreamPatterns{CommonErrors: []string{}}
-			store.UpdateContext(streamID, ctx)
+		// Initialize context; a missing one is created empty
+		err = store.MutateContext(ctx, streamID, func(streamCtx *storage.StreamContext) error {
+			if streamCtx.FirstSeen.IsZero() {
+				streamCtx.FirstSeen = time.Now()
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to initialize context for %s: %v", streamID, err)
 		}
 
 		// Start tailer in background
 		tailer := NewFileTailer(path, streamID, store, hub)
 		go func(t *FileTailer, p string) {
 			log.Printf("📂 Tailing: %s", p)
-			if err := t.Start(); err != nil {
+			if err := t.Start(ctx); err != nil {
 				log.Printf("❌ Tailer error for %s: %v", p, err)
 			}
 		}(tailer, path)
 	}
 
 	return nil
+}
+
+// activateStream marks the stream of a discovered file active, creating it
+// if it does not exist yet
+func activateStream(ctx context.Context, store storage.Storage, streamID, path string) error {
+	stream, err := store.GetStream(ctx, streamID)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		stream = &storage.Stream{ID: streamID, Name: filepath.Base(path), Source: "file"}
+	case err != nil:
+		return err
+	case stream.Active:
+		return nil
+	}
+	stream.Active = true
+	return store.UpdateStream(ctx, stream)
 }
\ No newline at end of file
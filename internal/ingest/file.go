@@ -2,6 +2,8 @@ package ingest
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -33,8 +35,8 @@ func NewFileTailer(path, streamID string, store storage.Storage, hub LogBroadcas
 	}
 }
 
-// Start begins tailing the file
-func (f *FileTailer) Start() error {
+// Start begins tailing the file and stops when ctx is done
+func (f *FileTailer) Start(ctx context.Context) error {
 	file, err := os.Open(f.path)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %w", err)
@@ -80,7 +82,9 @@ func (f *FileTailer) Start() error {
 		
 		if len(logsToStore) > 0 {
 			log.Printf("Storing %d logs for %s", len(logsToStore), f.streamID)
-			if err := f.storage.StoreLogs(f.streamID, logsToStore); err != nil {
+			if err := f.store(ctx, logsToStore); errors.Is(err, storage.ErrRemoved) {
+				return err
+			} else if err != nil {
 				log.Printf("Failed to store logs: %v", err)
 			}
 			
@@ -100,6 +104,10 @@ func (f *FileTailer) Start() error {
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		line := scanner.Text()
 		if line == "" {
 			continue
@@ -108,9 +116,13 @@ func (f *FileTailer) Start() error {
 		logLine := f.parseLine(line)
 		
 		// Store in database
-		if err := f.storage.StoreLogs(f.streamID, []storage.LogLine{logLine}); err != nil {
+		logs := []storage.LogLine{logLine}
+		if err := f.store(ctx, logs); errors.Is(err, storage.ErrRemoved) {
+			return err
+		} else if err != nil {
 			log.Printf("Failed to store log: %v", err)
 		}
+		logLine = logs[0]
 
 		// Broadcast to WebSocket clients
 		if f.hub != nil {
@@ -121,6 +133,27 @@ func (f *FileTailer) Start() error {
 	return scanner.Err()
 }
 
+// store saves logs to the tailer's stream. If the stream was merged into
+// another, the tailer follows it there; once it was deleted, store returns
+// storage.ErrRemoved and the tailer should stop.
+func (f *FileTailer) store(ctx context.Context, logs []storage.LogLine) error {
+	err := f.storage.StoreLogs(ctx, f.streamID, logs)
+	if !errors.Is(err, storage.ErrRemoved) {
+		return err
+	}
+
+	target, err := storage.ResolveStream(ctx, f.storage, f.streamID)
+	if err != nil {
+		return err
+	}
+	log.Printf("Stream %s was merged into %s; tailing %s into it", f.streamID, target, f.path)
+	f.streamID = target
+	for i := range logs {
+		logs[i].StreamID = target
+	}
+	return f.storage.StoreLogs(ctx, f.streamID, logs)
+}
+
 // parseLine attempts to extract structured data from log line
 func (f *FileTailer) parseLine(line string) storage.LogLine {
 	logLine := storage.LogLine{
@@ -161,13 +194,13 @@ func (f *FileTailer) parseLine(line string) storage.LogLine {
 }
 
 // TailMultipleFiles starts multiple tailers
-func TailMultipleFiles(paths []string, store storage.Storage, hub LogBroadcaster) error {
+func TailMultipleFiles(ctx context.Context, paths []string, store storage.Storage, hub LogBroadcaster) error {
 	for _, path := range paths {
 		streamID := fmt.Sprintf("file:%s", path)
 		tailer := NewFileTailer(path, streamID, store, hub)
 		
 		go func(t *FileTailer) {
-			if err := t.Start(); err != nil {
+			if err := t.Start(ctx); err != nil {
 				log.Printf("Tailer error for %s: %v", t.path, err)
 			}
 		}(tailer)
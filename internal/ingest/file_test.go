@@ -0,0 +1,72 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"logvoyant/internal/storage"
+)
+
+func TestFileTailerFollowsRemovedStreams(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("[INFO] started\n[ERROR] disk full\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewMemoryStorage()
+	for _, id := range []string{"file:" + path, "other"} {
+		store.UpdateStream(ctx, &storage.Stream{ID: id, Active: true})
+	}
+
+	// A merged stream's file is tailed into the stream it was merged into
+	if err := store.MergeStreams(ctx, "file:"+path, "other"); err != nil {
+		t.Fatal(err)
+	}
+	tailer := NewFileTailer(path, "file:"+path, store, nil)
+	if err := tailer.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if logs, _ := store.GetLogs(ctx, "other", storage.GetLogsOptions{}); len(logs) != 2 || logs[1].StreamID != "other" {
+		t.Fatalf("merged stream got %+v", logs)
+	}
+	if _, err := store.GetStream(ctx, "file:"+path); err == nil {
+		t.Error("tailing brought the merged stream back")
+	}
+
+	// A deleted one stops the tailer without bringing it back
+	if err := store.DeleteStream(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+	tailer = NewFileTailer(path, "file:"+path, store, nil)
+	if err := tailer.Start(ctx); !errors.Is(err, storage.ErrRemoved) {
+		t.Fatalf("Start after deletion: got %v, want ErrRemoved", err)
+	}
+	for _, id := range []string{"file:" + path, "other"} {
+		if _, err := store.GetStream(ctx, id); err == nil {
+			t.Errorf("stream %s came back", id)
+		}
+	}
+}
+
+func TestActivateStreamKeepsEdits(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	if err := activateStream(ctx, store, "file:/var/log/app.log", "/var/log/app.log"); err != nil {
+		t.Fatal(err)
+	}
+	if stream, _ := store.GetStream(ctx, "file:/var/log/app.log"); stream == nil || stream.Name != "app.log" || !stream.Active {
+		t.Fatalf("new stream = %+v", stream)
+	}
+
+	name := "billing"
+	store.PatchStream(ctx, "file:/var/log/app.log", storage.StreamPatch{Name: &name, Labels: map[string]string{"team": "payments"}})
+	if err := activateStream(ctx, store, "file:/var/log/app.log", "/var/log/app.log"); err != nil {
+		t.Fatal(err)
+	}
+	if stream, _ := store.GetStream(ctx, "file:/var/log/app.log"); stream.Name != "billing" || stream.Labels["team"] != "payments" {
+		t.Errorf("rediscovery undid the edits: %+v", stream)
+	}
+}
@@ -1,23 +1,27 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"logvoyant/internal/analyzer"
 	"logvoyant/internal/storage"
 )
 
 func (s *Server) handleListStreams(w http.ResponseWriter, r *http.Request) {
-	streams, err := s.config.Storage.ListStreams()
+	streams, err := s.config.Storage.ListStreams(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondError(w, err)
 		return
 	}
 
@@ -25,19 +29,87 @@ func (s *Server) handleListStreams(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGetStream(w http.ResponseWriter, r *http.Request) {
-	streamID := chi.URLParam(r, "id")
+	streamID := streamIDParam(r)
 
-	stream, err := s.config.Storage.GetStream(streamID)
+	stream, err := s.config.Storage.GetStream(r.Context(), streamID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		respondError(w, err)
 		return
 	}
 
 	respondJSON(w, stream)
 }
 
+func (s *Server) handlePatchStream(w http.ResponseWriter, r *http.Request) {
+	streamID := streamIDParam(r)
+
+	var patch storage.StreamPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stream, err := s.config.Storage.PatchStream(r.Context(), streamID, patch)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respondJSON(w, stream)
+}
+
+func (s *Server) handleDeleteStream(w http.ResponseWriter, r *http.Request) {
+	streamID := streamIDParam(r)
+
+	if err := s.config.Storage.DeleteStream(r.Context(), streamID); err != nil {
+		respondError(w, err)
+		return
+	}
+	s.forget(streamID)
+
+	respondJSON(w, map[string]bool{"success": true})
+}
+
+func (s *Server) handleMergeStream(w http.ResponseWriter, r *http.Request) {
+	streamID := streamIDParam(r)
+
+	var req struct {
+		Into string `json:"into"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Into == "" {
+		http.Error(w, "missing target stream (into)", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.config.Storage.MergeStreams(r.Context(), streamID, req.Into); err != nil {
+		respondError(w, err)
+		return
+	}
+	s.forget(streamID)
+
+	stream, err := s.config.Storage.GetStream(r.Context(), req.Into)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respondJSON(w, stream)
+}
+
+// forget drops the watchers' in-memory state of a deleted or merged stream
+func (s *Server) forget(streamID string) {
+	s.patterns.Forget(streamID)
+	if s.novelty != nil {
+		s.novelty.Forget(streamID)
+	}
+}
+
 func (s *Server) handleGetLogs(w http.ResponseWriter, r *http.Request) {
-	streamID := chi.URLParam(r, "id")
+	streamID := streamIDParam(r)
 
 	// Parse query params
 	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
@@ -55,91 +127,276 @@ func (s *Server) handleGetLogs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	opts := storage.GetLogsOptions{
-		Limit: limit,
-		Since: since,
+		Limit:  limit,
+		Since:  since,
+		Search: r.URL.Query().Get("search"),
+	}
+	if levels := r.URL.Query().Get("level"); levels != "" {
+		opts.Levels = strings.Split(strings.ToUpper(levels), ",")
+	}
+	// Repeatable label=key:value filters
+	for _, label := range r.URL.Query()["label"] {
+		key, value, ok := strings.Cut(label, ":")
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid label filter %q, want key:value", label), http.StatusBadRequest)
+			return
+		}
+		if opts.Labels == nil {
+			opts.Labels = map[string]string{}
+		}
+		opts.Labels[key] = value
 	}
 
-	logs, err := s.config.Storage.GetLogs(streamID, opts)
+	logs, err := s.config.Storage.GetLogs(r.Context(), streamID, opts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondError(w, err)
 		return
 	}
 
 	respondJSON(w, logs)
 }
 
+func (s *Server) handleGetHistogram(w http.ResponseWriter, r *http.Request) {
+	streamID := streamIDParam(r)
+
+	resolution := r.URL.Query().Get("resolution")
+	if resolution == "" {
+		resolution = storage.ResolutionMinute
+	}
+
+	// Default window: last hour of minutes or last day of hours
+	window := time.Hour
+	if resolution == storage.ResolutionHour {
+		window = 24 * time.Hour
+	}
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		duration, err := time.ParseDuration(sinceStr)
+		if err != nil || duration <= 0 {
+			http.Error(w, fmt.Sprintf("invalid since %q, want a positive duration", sinceStr), http.StatusBadRequest)
+			return
+		}
+		window = duration
+	}
+
+	// Storage refuses windows longer than the resolution's rollups are kept
+	buckets, err := s.config.Storage.GetHistogram(r.Context(), streamID, resolution, time.Now().Add(-window))
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respondJSON(w, buckets)
+}
+
+// handleAnalyze queues an analysis and answers 202 with the job; poll
+// /api/jobs/{jobID} for the result. A stream with a queued or running job
+// gets that job back.
 func (s *Server) handleAnalyze(w http.ResponseWriter, r *http.Request) {
-	streamID := chi.URLParam(r, "id")
-	
-	// Decode URL encoding
-	decodedStreamID, err := url.QueryUnescape(streamID)
+	streamID := streamIDParam(r)
+
+	job, err := s.jobs.Submit(r.Context(), streamID)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	log.Printf("Analysis job %s for stream %s is %s", job.ID, streamID, job.Status)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := s.jobs.List(r.Context(), r.URL.Query().Get("stream"))
 	if err != nil {
-		decodedStreamID = streamID
+		respondError(w, err)
+		return
+	}
+	if jobs == nil {
+		jobs = []storage.AnalysisJob{}
 	}
-	
-	log.Printf("Analysis requested for stream: %s (decoded: %s)", streamID, decodedStreamID)
+	respondJSON(w, jobs)
+}
 
-	// Get recent logs
-	logs, err := s.config.Storage.GetLogs(decodedStreamID, storage.GetLogsOptions{Limit: 100})
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.jobs.Get(r.Context(), chi.URLParam(r, "jobID"))
 	if err != nil {
-		log.Printf("Failed to get logs: %v", err)
-		respondJSON(w, map[string]string{"error": fmt.Sprintf("failed to get logs: %v", err)})
+		respondError(w, err)
 		return
 	}
+	respondJSON(w, job)
+}
 
-	if len(logs) == 0 {
-		log.Printf("No logs found for stream: %s", decodedStreamID)
-		respondJSON(w, map[string]string{"error": "no logs to analyze"})
+// handleCancelJob cancels a queued or running job. A running job fails once
+// its LLM request has been aborted, so the returned record may still say
+// running.
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "jobID")
+	if err := s.jobs.Cancel(r.Context(), id); err != nil {
+		respondError(w, err)
 		return
 	}
-	
-	log.Printf("Found %d logs for analysis", len(logs))
 
-	// Run analysis
-	analysis, err := s.analyzer.Analyze(decodedStreamID, logs)
+	job, err := s.jobs.Get(r.Context(), id)
 	if err != nil {
-		log.Printf("Analysis failed: %v", err)
-		respondJSON(w, map[string]string{"error": fmt.Sprintf("analysis failed: %v", err)})
+		respondError(w, err)
 		return
 	}
-	
-	log.Printf("Analysis completed: %s (%s)", analysis.Summary, analysis.Severity)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleListEvents lists events newest first, for one stream under
+// /streams/{id}/events or for all of them. It filters by ?stream=, ?type=
+// and ?since= (a duration) and returns at most ?limit= (default 100).
+func (s *Server) handleListEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts := storage.ListEventsOptions{
+		StreamID: query.Get("stream"),
+		Type:     query.Get("type"),
+		Limit:    100,
+	}
+	if chi.URLParam(r, "id") != "" {
+		opts.StreamID = streamIDParam(r)
+	}
+	if sinceStr := query.Get("since"); sinceStr != "" {
+		duration, err := time.ParseDuration(sinceStr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since: %v", err), http.StatusBadRequest)
+			return
+		}
+		opts.Since = time.Now().Add(-duration)
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 {
+		opts.Limit = limit
+	}
 
-	// Store analysis
-	if err := s.config.Storage.StoreAnalysis(analysis); err != nil {
-		log.Printf("Failed to store analysis: %v", err)
-		respondJSON(w, map[string]string{"error": fmt.Sprintf("failed to store analysis: %v", err)})
+	events, err := s.config.Storage.ListEvents(r.Context(), opts)
+	if err != nil {
+		respondError(w, err)
 		return
 	}
+	if events == nil {
+		events = []storage.Event{}
+	}
+	respondJSON(w, events)
+}
 
-	// Update context with new analysis summary
-	ctx, _ := s.config.Storage.GetContext(decodedStreamID)
-	ctx.Analyses = append(ctx.Analyses, storage.AnalysisSummary{
-		Timestamp: analysis.Timestamp,
-		Summary:   analysis.Summary,
-		RootCause: analysis.RootCause,
-		Severity:  analysis.Severity,
-		Resolved:  false,
-	})
-	s.config.Storage.UpdateContext(decodedStreamID, ctx)
+func (s *Server) handleListDigests(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit == 0 {
+		limit = 30
+	}
 
-	respondJSON(w, analysis)
+	digests, err := s.config.Storage.ListDigests(r.Context(), limit)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if digests == nil {
+		digests = []storage.Digest{}
+	}
+	respondJSON(w, digests)
+}
+
+// handleGetDigest returns a digest as JSON, or as Markdown with
+// ?format=markdown. "latest" is the most recent digest.
+func (s *Server) handleGetDigest(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "digestID")
+
+	var digest *storage.Digest
+	if id == "latest" {
+		digests, err := s.config.Storage.ListDigests(r.Context(), 1)
+		if err != nil {
+			respondError(w, err)
+			return
+		}
+		if len(digests) == 0 {
+			respondError(w, fmt.Errorf("no digests yet: %w", storage.ErrNotFound))
+			return
+		}
+		digest = &digests[0]
+	} else {
+		var err error
+		if digest, err = s.config.Storage.GetDigest(r.Context(), id); err != nil {
+			respondError(w, err)
+			return
+		}
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		respondJSON(w, digest)
+	case "markdown", "md":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write([]byte(analyzer.FormatDigestMarkdown(digest)))
+	default:
+		http.Error(w, "format must be json or markdown", http.StatusBadRequest)
+	}
+}
+
+// handlePromptPreview renders the prompt an analysis would send, so template
+// edits can be checked without calling the LLM
+func (s *Server) handlePromptPreview(w http.ResponseWriter, r *http.Request) {
+	streamID := streamIDParam(r)
+
+	logs, err := s.config.Storage.GetLogs(r.Context(), streamID, storage.GetLogsOptions{Limit: analyzer.MaxInputLines})
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	prompt, err := s.analyzer.Preview(r.Context(), streamID, logs)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respondJSON(w, prompt)
 }
 
 func (s *Server) handleGetContext(w http.ResponseWriter, r *http.Request) {
-	streamID := chi.URLParam(r, "id")
+	streamID := streamIDParam(r)
 
-	ctx, err := s.config.Storage.GetContext(streamID)
+	streamCtx, err := s.config.Storage.GetContext(r.Context(), streamID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondError(w, err)
+		return
+	}
+
+	respondJSON(w, streamCtx)
+}
+
+// handlePatterns returns a stream's message templates, most frequent first.
+// ?errors=true keeps only templates seen at error level.
+func (s *Server) handlePatterns(w http.ResponseWriter, r *http.Request) {
+	streamID := streamIDParam(r)
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	errorsOnly := r.URL.Query().Get("errors") == "true"
+
+	patterns, err := s.patterns.Patterns(r.Context(), streamID)
+	if err != nil {
+		respondError(w, err)
 		return
 	}
 
-	respondJSON(w, ctx)
+	result := []storage.LogPattern{}
+	for _, p := range patterns {
+		if errorsOnly && p.Errors == 0 {
+			continue
+		}
+		if limit > 0 && len(result) == limit {
+			break
+		}
+		result = append(result, p)
+	}
+	respondJSON(w, result)
 }
 
 func (s *Server) handleResolve(w http.ResponseWriter, r *http.Request) {
-	streamID := chi.URLParam(r, "id")
+	streamID := streamIDParam(r)
 
 	var req struct {
 		AnalysisIndex int    `json:"analysis_index"`
@@ -150,28 +407,158 @@ func (s *Server) handleResolve(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, err := s.config.Storage.GetContext(streamID)
+	err := s.config.Storage.MutateContext(r.Context(), streamID, func(streamCtx *storage.StreamContext) error {
+		if req.AnalysisIndex < 0 || req.AnalysisIndex >= len(streamCtx.Analyses) {
+			return fmt.Errorf("%w: invalid analysis index", storage.ErrInvalid)
+		}
+		streamCtx.Analyses[req.AnalysisIndex].Resolved = true
+		streamCtx.Analyses[req.AnalysisIndex].ResolutionNote = req.Note
+		return nil
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondError(w, err)
 		return
 	}
 
-	if req.AnalysisIndex >= len(ctx.Analyses) {
-		http.Error(w, "invalid analysis index", http.StatusBadRequest)
+	respondJSON(w, map[string]bool{"success": true})
+}
+
+func (s *Server) handleGetStreamRetention(w http.ResponseWriter, r *http.Request) {
+	streamID := streamIDParam(r)
+
+	policy, err := s.config.Compactor.EffectivePolicy(r.Context(), streamID)
+	if err != nil {
+		respondError(w, err)
 		return
 	}
 
-	ctx.Analyses[req.AnalysisIndex].Resolved = true
-	ctx.Analyses[req.AnalysisIndex].ResolutionNote = req.Note
+	respondJSON(w, policy)
+}
 
-	if err := s.config.Storage.UpdateContext(streamID, ctx); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+func (s *Server) handleListRetention(w http.ResponseWriter, r *http.Request) {
+	rules, err := s.config.Storage.ListRetentionRules(r.Context())
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"defaults": s.config.Compactor.Defaults(),
+		"rules":    rules,
+	})
+}
+
+func (s *Server) handleSetRetention(w http.ResponseWriter, r *http.Request) {
+	var rule storage.RetentionRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := rule.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.config.Storage.SetRetentionRule(r.Context(), rule); err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respondJSON(w, rule)
+}
+
+func (s *Server) handleDeleteRetention(w http.ResponseWriter, r *http.Request) {
+	scope := r.URL.Query().Get("scope")
+	target := r.URL.Query().Get("target")
+
+	if err := s.config.Storage.DeleteRetentionRule(r.Context(), scope, target); err != nil {
+		respondError(w, err)
 		return
 	}
 
 	respondJSON(w, map[string]bool{"success": true})
 }
 
+func (s *Server) handleLLMStatus(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, s.analyzer.LLMStatus())
+}
+
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	backuper, ok := storage.Unwrap(s.config.Storage).(storage.Backuper)
+	if !ok {
+		http.Error(w, "storage backend does not support online backup", http.StatusNotImplemented)
+		return
+	}
+
+	filename := fmt.Sprintf("logvoyant-%s.db", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// Headers are already sent once streaming starts, so errors can only be logged
+	if _, err := backuper.Backup(w); err != nil {
+		log.Printf("Backup failed: %v", err)
+	}
+}
+
+func (s *Server) handleEncryptionStatus(w http.ResponseWriter, r *http.Request) {
+	rotator, ok := storage.Unwrap(s.config.Storage).(storage.KeyRotator)
+	if !ok {
+		respondJSON(w, storage.EncryptionStatus{})
+		return
+	}
+	respondJSON(w, rotator.EncryptionStatus())
+}
+
+// handleRotateKey switches to the keyring's primary key; values are resealed
+// in the background, so progress is reported via handleEncryptionStatus
+func (s *Server) handleRotateKey(w http.ResponseWriter, r *http.Request) {
+	rotator, ok := storage.Unwrap(s.config.Storage).(storage.KeyRotator)
+	if !ok {
+		http.Error(w, "storage backend does not support encryption", http.StatusNotImplemented)
+		return
+	}
+
+	if err := rotator.RotateKey(r.Context()); err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(rotator.EncryptionStatus())
+}
+
+// streamIDParam returns the {id} URL parameter with URL encoding removed
+func streamIDParam(r *http.Request) string {
+	streamID := chi.URLParam(r, "id")
+	if decoded, err := url.QueryUnescape(streamID); err == nil {
+		return decoded
+	}
+	return streamID
+}
+
+// respondError maps storage and context errors to HTTP statuses
+func respondError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, storage.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, storage.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, analyzer.ErrQueueFull):
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		// The client went away; there is nobody to answer
+		log.Printf("Request canceled: %v", err)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func respondJSON(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(data); err != nil {
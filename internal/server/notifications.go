@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"logvoyant/internal/analyzer"
+	"logvoyant/internal/storage"
+)
+
+// Notification is pushed to every client of /ws/notifications. Type is
+// "spike" when a spike is detected, "analysis" when its analysis is ready,
+// "anomaly" when a stream's volume or level mix leaves its baseline,
+// "novel_error" when a stream logs an error it never logged before and
+// "digest" when a scheduled digest is delivered.
+type Notification struct {
+	Type      string               `json:"type"`
+	StreamID  string               `json:"stream_id,omitempty"`
+	Spike     *analyzer.Spike      `json:"spike,omitempty"`
+	Job       *storage.AnalysisJob `json:"job,omitempty"`
+	Analysis  *storage.Analysis    `json:"analysis,omitempty"`
+	Digest    *storage.Digest      `json:"digest,omitempty"`
+	Event     *storage.Event       `json:"event,omitempty"`
+	Timestamp time.Time            `json:"timestamp"`
+}
+
+// notificationBacklog is how many notifications may wait for a client before
+// it is dropped as too slow
+const notificationBacklog = 64
+
+// NotificationHub fans notifications out to every connected client
+type NotificationHub struct {
+	mu      sync.Mutex
+	clients map[*websocket.Conn]chan Notification // each client's backlog
+}
+
+func NewNotificationHub() *NotificationHub {
+	return &NotificationHub{clients: make(map[*websocket.Conn]chan Notification)}
+}
+
+// Broadcast queues n for every client without waiting on any of them,
+// dropping clients that cannot keep up
+func (h *NotificationHub) Broadcast(n Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for conn, backlog := range h.clients {
+		select {
+		case backlog <- n:
+		default:
+			log.Printf("Notification client %s is not keeping up; dropping it", conn.RemoteAddr())
+			h.drop(conn)
+		}
+	}
+}
+
+// add registers a client and starts writing its notifications
+func (h *NotificationHub) add(conn *websocket.Conn) {
+	backlog := make(chan Notification, notificationBacklog)
+	h.mu.Lock()
+	h.clients[conn] = backlog
+	h.mu.Unlock()
+
+	go func() {
+		for n := range backlog {
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if err := conn.WriteJSON(n); err != nil {
+				log.Printf("Notification write error: %v", err)
+				h.remove(conn)
+				return
+			}
+		}
+	}()
+}
+
+func (h *NotificationHub) remove(conn *websocket.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(conn)
+}
+
+// drop closes a client and ends its writer. The caller holds h.mu.
+func (h *NotificationHub) drop(conn *websocket.Conn) {
+	if backlog, ok := h.clients[conn]; ok {
+		delete(h.clients, conn)
+		close(backlog)
+		conn.Close()
+	}
+}
+
+func (s *Server) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	s.notifications.add(conn)
+
+	// Clients only listen; reading notices when they leave
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			s.notifications.remove(conn)
+			return
+		}
+	}
+}
+
+// analyzeSpike queues an analysis of a stream whose errors spiked and
+// notifies clients when it is ready. A stream that already has an analysis
+// queued or running shares it.
+func (s *Server) analyzeSpike(spike analyzer.Spike) {
+	log.Printf("Error spike on stream %s: %s", spike.StreamID, spike.Reason)
+
+	job, err := s.jobs.Submit(context.Background(), spike.StreamID)
+	if err != nil {
+		log.Printf("Failed to queue analysis of spike on stream %s: %v", spike.StreamID, err)
+		return
+	}
+	s.notifications.Broadcast(Notification{
+		Type:      "spike",
+		StreamID:  spike.StreamID,
+		Spike:     &spike,
+		Job:       job,
+		Timestamp: time.Now(),
+	})
+
+	updates, stop := s.jobs.Watch(job.ID)
+	defer stop()
+	for range updates {
+	}
+
+	job, err = s.jobs.Get(context.Background(), job.ID)
+	if err != nil || job.Status != storage.JobDone {
+		return // Failed jobs are logged by the queue
+	}
+	s.notifications.Broadcast(Notification{
+		Type:      "analysis",
+		StreamID:  spike.StreamID,
+		Spike:     &spike,
+		Job:       job,
+		Analysis:  job.Analysis,
+		Timestamp: time.Now(),
+	})
+}
@@ -2,10 +2,15 @@ package server
 
 import (
 	"context"
+	"crypto/subtle"
 	"embed"
 	"fmt"
 	"io/fs"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -20,7 +25,17 @@ type Config struct {
 	Port        int
 	Storage     storage.Storage
 	StaticFiles embed.FS
-	GroqAPIKey  string
+	LLM         analyzer.LLMProvider // nil disables LLM analysis
+	Budget      analyzer.PromptBudget
+	Templates   *analyzer.Templates // nil uses the built-in prompts
+	Jobs        analyzer.JobConfig
+	Spikes      *analyzer.SpikeConfig // nil disables automatic analysis of error spikes
+	Patterns    analyzer.PatternConfig
+	Anomalies   *analyzer.AnomalyConfig // nil disables anomaly detection
+	NovelErrors bool                    // label and record errors new to their stream
+	Digests     []analyzer.DigestSchedule
+	Compactor   *storage.Compactor
+	AdminToken  string // required by /api/admin; "" serves it to localhost only
 }
 
 type Server struct {
@@ -28,7 +43,14 @@ type Server struct {
 	router   *chi.Mux
 	server   *http.Server
 	analyzer *analyzer.Analyzer
+	jobs     *analyzer.JobQueue
+	digests  *analyzer.Scheduler
+	patterns *analyzer.PatternMiner
+	anomaly  *analyzer.AnomalyDetector // nil if disabled
+	novelty  *analyzer.NoveltyDetector // nil if disabled
 	hub      *WebSocketHub
+
+	notifications *NotificationHub
 }
 
 func New(cfg *Config) *Server {
@@ -37,20 +59,25 @@ func New(cfg *Config) *Server {
 	// Middleware
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
 		AllowCredentials: false,
 	}))
 
 	// Initialize analyzer
 	anlz := analyzer.New(&analyzer.Config{
-		Storage:    cfg.Storage,
-		GroqAPIKey: cfg.GroqAPIKey,
+		Storage:   cfg.Storage,
+		LLM:       cfg.LLM,
+		Budget:    cfg.Budget,
+		Templates: cfg.Templates,
 	})
 
+	// Analyses run in the background on a bounded worker pool
+	jobs := analyzer.NewJobQueue(anlz, cfg.Storage, cfg.Jobs)
+	jobs.Start()
+
 	// Initialize WebSocket hub
 	hub := NewWebSocketHub()
 	go hub.Run()
@@ -59,9 +86,70 @@ func New(cfg *Config) *Server {
 		config:   cfg,
 		router:   r,
 		analyzer: anlz,
+		jobs:     jobs,
 		hub:      hub,
+
+		notifications: NewNotificationHub(),
+	}
+
+	// Watchers see logs as they are stored: the pattern miner always, the
+	// spike detector and new error detection if enabled
+	srv.patterns = analyzer.NewPatternMiner(cfg.Storage, cfg.Patterns)
+	srv.patterns.Start()
+	if hooked, ok := cfg.Storage.(*storage.HookedStorage); ok {
+		hooked.OnStore(srv.patterns.Observe)
+
+		// Error spikes in newly stored logs queue an analysis on their own
+		if cfg.Spikes != nil {
+			watcher := analyzer.NewSpikeWatcher(*cfg.Spikes, func(spike analyzer.Spike) {
+				go srv.analyzeSpike(spike)
+			})
+			hooked.OnStore(watcher.Observe)
+		}
+
+		// Errors new to a stream are labelled before they are stored and
+		// recorded once they are
+		if cfg.NovelErrors {
+			srv.novelty = analyzer.NewNoveltyDetector(cfg.Storage, func(event storage.Event) {
+				srv.notifications.Broadcast(Notification{
+					Type:      "novel_error",
+					StreamID:  event.StreamID,
+					Event:     &event,
+					Timestamp: time.Now(),
+				})
+			})
+			hooked.BeforeStore(srv.novelty.Label)
+			hooked.OnStore(srv.novelty.Record)
+			srv.novelty.Start()
+		}
+	} else {
+		log.Printf("Pattern mining, spike analysis and new error detection need a storage.HookedStorage; disabled")
+	}
+
+	// Unusual volume or level mix is recorded as events and pushed to
+	// notification clients
+	if cfg.Anomalies != nil {
+		srv.anomaly = analyzer.NewAnomalyDetector(cfg.Storage, *cfg.Anomalies, func(event storage.Event) {
+			srv.notifications.Broadcast(Notification{
+				Type:      "anomaly",
+				StreamID:  event.StreamID,
+				Event:     &event,
+				Timestamp: time.Now(),
+			})
+		})
+		srv.anomaly.Start()
 	}
 
+	// Digests are stored, and pushed to notification clients if asked to
+	srv.digests = analyzer.NewScheduler(jobs, cfg.Storage, cfg.Digests, func(digest *storage.Digest) {
+		srv.notifications.Broadcast(Notification{
+			Type:      "digest",
+			Digest:    digest,
+			Timestamp: time.Now(),
+		})
+	})
+	srv.digests.Start()
+
 	srv.setupRoutes()
 
 	return srv
@@ -73,20 +161,100 @@ func (s *Server) setupRoutes() {
 	if err != nil {
 		panic(err)
 	}
-	s.router.Handle("/*", http.FileServer(http.FS(staticFS)))
+	// Everything but backups is cut off after a minute
+	s.router.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(60 * time.Second))
+		s.setupTimedRoutes(r, staticFS)
+	})
+
+	// A backup streams the whole database, which can take longer
+	s.router.With(s.requireAdmin).Get("/api/admin/backup", s.handleBackup)
+}
+
+func (s *Server) setupTimedRoutes(router chi.Router, staticFS fs.FS) {
+	router.Handle("/*", http.FileServer(http.FS(staticFS)))
 
 	// API routes
-	s.router.Route("/api", func(r chi.Router) {
+	router.Route("/api", func(r chi.Router) {
 		r.Get("/streams", s.handleListStreams)
 		r.Get("/streams/{id}", s.handleGetStream)
+		r.Patch("/streams/{id}", s.handlePatchStream)
+		r.Delete("/streams/{id}", s.handleDeleteStream)
+		r.Post("/streams/{id}/merge", s.handleMergeStream)
 		r.Get("/streams/{id}/logs", s.handleGetLogs)
+		r.Get("/streams/{id}/histogram", s.handleGetHistogram)
 		r.Post("/streams/{id}/analyze", s.handleAnalyze)
+		r.Get("/streams/{id}/prompt", s.handlePromptPreview)
 		r.Get("/streams/{id}/context", s.handleGetContext)
+		r.Get("/streams/{id}/patterns", s.handlePatterns)
+		r.Get("/streams/{id}/events", s.handleListEvents)
 		r.Post("/streams/{id}/resolve", s.handleResolve)
+		r.Get("/streams/{id}/retention", s.handleGetStreamRetention)
+
+		r.Get("/jobs", s.handleListJobs)
+		r.Get("/jobs/{jobID}", s.handleGetJob)
+		r.Delete("/jobs/{jobID}", s.handleCancelJob)
+
+		r.Get("/events", s.handleListEvents)
+
+		r.Get("/digests", s.handleListDigests)
+		r.Get("/digests/{digestID}", s.handleGetDigest)
+
+		r.Get("/retention", s.handleListRetention)
+		r.Put("/retention", s.handleSetRetention)
+		r.Delete("/retention", s.handleDeleteRetention)
+
+		r.Get("/llm/status", s.handleLLMStatus)
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(s.requireAdmin)
+			r.Get("/encryption", s.handleEncryptionStatus)
+			r.Post("/rotate-key", s.handleRotateKey)
+		})
 	})
 
 	// WebSocket
-	s.router.Get("/ws/streams/{id}", s.handleWebSocket)
+	router.Get("/ws/streams/{id}", s.handleWebSocket)
+	router.Get("/ws/streams/{id}/analyze", s.handleAnalyzeStream)
+	router.Get("/ws/notifications", s.handleNotifications)
+}
+
+// requireAdmin guards the /api/admin endpoints. With an admin token they
+// need it as a bearer token; without one they only answer clients on this
+// machine, and not pages from other origins, which the open CORS policy
+// would otherwise let read the response.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := s.config.AdminToken; token != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "admin token required", http.StatusUnauthorized)
+				return
+			}
+		} else if !isLocalRequest(r) {
+			http.Error(w, "admin endpoints only answer localhost unless an admin token is set", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLocalRequest reports whether r comes from a loopback address and, if
+// sent by a browser, from a page served by this server
+func isLocalRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == r.Host
+	}
+	return true
 }
 
 func (s *Server) Start() error {
@@ -100,7 +268,17 @@ func (s *Server) Start() error {
 func (s *Server) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	return s.server.Shutdown(ctx)
+	err := s.server.Shutdown(ctx)
+	s.digests.Stop()
+	if s.anomaly != nil {
+		s.anomaly.Stop()
+	}
+	if s.novelty != nil {
+		s.novelty.Stop()
+	}
+	s.jobs.Stop()
+	s.patterns.Stop()
+	return err
 }
 
 func (s *Server) Hub() *WebSocketHub {
@@ -1,10 +1,12 @@
 package server
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"net/url"
 	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
@@ -112,7 +114,7 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	client := &Client{conn: conn, streamID: decodedStreamID}
 	s.hub.register <- client
 
-	logs, err := s.config.Storage.GetLogs(decodedStreamID, storage.GetLogsOptions{Limit: 100})
+	logs, err := s.config.Storage.GetLogs(r.Context(), decodedStreamID, storage.GetLogsOptions{Limit: 100})
 	log.Printf("Attempting to fetch logs for stream: %s, found: %d, err: %v", decodedStreamID, len(logs), err)
 	
 	if err == nil && len(logs) > 0 {
@@ -133,4 +135,90 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 	}
-}
\ No newline at end of file
+}
+
+// analysisMessage is sent on the analysis socket: the "job" first, "partial"
+// while the reply streams in, then a final "analysis" or "error". Clients
+// send {"type": "cancel"} to cancel the job.
+type analysisMessage struct {
+	Type      string               `json:"type"`
+	Job       *storage.AnalysisJob `json:"job,omitempty"`
+	Summary   string               `json:"summary,omitempty"`
+	RootCause string               `json:"root_cause,omitempty"`
+	Analysis  *storage.Analysis    `json:"analysis,omitempty"`
+	Error     string               `json:"error,omitempty"`
+}
+
+// handleAnalyzeStream queues an analysis job, or joins the stream's queued or
+// running one, and pushes the summary and root cause to the client as they
+// are generated. A cancel message aborts the job and its upstream LLM
+// request; disconnecting leaves it running so the result is still stored.
+func (s *Server) handleAnalyzeStream(w http.ResponseWriter, r *http.Request) {
+	streamID := streamIDParam(r)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+	defer conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+
+	// The socket outlives the request timeout, so it gets a context of its own
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	job, err := s.jobs.Submit(ctx, streamID)
+	if err != nil {
+		conn.WriteJSON(analysisMessage{Type: "error", Error: err.Error()})
+		return
+	}
+	updates, stop := s.jobs.Watch(job.ID)
+	defer stop()
+
+	go func() {
+		defer cancel()
+		for {
+			var msg analysisMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type == "cancel" {
+				if err := s.jobs.Cancel(context.Background(), job.ID); err != nil {
+					log.Printf("Failed to cancel analysis job %s: %v", job.ID, err)
+				}
+			}
+		}
+	}()
+
+	if err := conn.WriteJSON(analysisMessage{Type: "job", Job: job}); err != nil {
+		return
+	}
+	for finished := false; !finished; {
+		select {
+		case <-ctx.Done():
+			return // The client left; the job carries on
+		case update, ok := <-updates:
+			if !ok {
+				finished = true
+				break
+			}
+			if err := conn.WriteJSON(analysisMessage{Type: "partial", Summary: update.Summary, RootCause: update.RootCause}); err != nil {
+				return
+			}
+		}
+	}
+
+	job, err = s.jobs.Get(ctx, job.ID)
+	switch {
+	case err != nil:
+		conn.WriteJSON(analysisMessage{Type: "error", Error: err.Error()})
+	case job.Status == storage.JobDone:
+		conn.WriteJSON(analysisMessage{Type: "analysis", Job: job, Analysis: job.Analysis})
+	case job.Status == storage.JobFailed:
+		conn.WriteJSON(analysisMessage{Type: "error", Job: job, Error: "analysis failed: " + job.Error})
+	default:
+		conn.WriteJSON(analysisMessage{Type: "error", Job: job, Error: "analysis interrupted by shutdown"})
+	}
+}
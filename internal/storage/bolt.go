@@ -1,86 +1,153 @@
 package storage
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
 
 var (
-	logsBucketPrefix = []byte("logs:")
-	contextBucket    = []byte("context")
-	analysisBucket   = []byte("analysis")
-	streamsBucket    = []byte("streams")
+	logsBucketPrefix   = []byte("logs:")
+	contextBucket      = []byte("context")
+	analysisBucket     = []byte("analysis")
+	streamsBucket      = []byte("streams")
+	retentionBucket    = []byte("retention")
+	rollupsBucket      = []byte("rollups")
+	labelsetsBucket    = []byte("labelsets")
+	jobsBucket         = []byte("jobs")
+	digestsBucket      = []byte("digests")
+	patternsBucket     = []byte("patterns")
+	eventsBucket       = []byte("events")
+	fingerprintsBucket = []byte("fingerprints")
+	tombstonesBucket   = []byte("tombstones")
 )
 
 type BoltStorage struct {
-	db *bolt.DB
+	db      *bolt.DB
+	opts    BoltOptions
+	crypt   *envelope       // nil when encryption is off
+	archive *SegmentArchive // sealed with crypt, see EncryptArchive
+
+	bg        context.Context // cancelled on Close
+	stop      context.CancelFunc
+	reencrypt reencryptState
+}
+
+// BoltOptions tunes how BoltStorage writes data
+type BoltOptions struct {
+	Compress            bool     // zstd-compress larger log values
+	BackupBeforeMigrate bool     // copy the file aside before running migrations
+	Keys                *Keyring // encrypt log lines, contexts and analyses; nil stores plaintext
 }
 
 func NewBoltStorage(path string) (*BoltStorage, error) {
+	return OpenBoltStorage(path, BoltOptions{})
+}
+
+func OpenBoltStorage(path string, opts BoltOptions) (*BoltStorage, error) {
 	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
 	if err != nil {
 		return nil, fmt.Errorf("failed to open bolt db: %w", err)
 	}
 
-	// Initialize buckets
-	err = db.Update(func(tx *bolt.Tx) error {
-		buckets := [][]byte{contextBucket, analysisBucket, streamsBucket}
-		for _, bucket := range buckets {
-			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
-				return err
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create buckets: %w", err)
+	// Bring the schema up to date
+	if err := migrate(db, path, opts); err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	return &BoltStorage{db: db}, nil
+	s := &BoltStorage{db: db, opts: opts}
+	if err := s.initEncryption(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s.bg, s.stop = context.WithCancel(context.Background())
+
+	return s, nil
 }
 
 func (s *BoltStorage) Close() error {
+	s.stop()
+	s.reencrypt.wg.Wait()
 	return s.db.Close()
 }
 
-// StoreLogs saves logs to stream-specific bucket with ring buffer (keep last 10k)
-func (s *BoltStorage) StoreLogs(streamID string, logs []LogLine) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
-		bucketName := append(logsBucketPrefix, []byte(streamID)...)
-		bucket, err := tx.CreateBucketIfNotExists(bucketName)
+// Backup writes a consistent snapshot of the database file to w
+func (s *BoltStorage) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// view runs fn in a read transaction unless ctx is already done. Long scans
+// inside fn check ctx themselves.
+func (s *BoltStorage) view(ctx context.Context, fn func(*bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.View(fn)
+}
+
+// update runs fn in a write transaction unless ctx is already done. Returning
+// ctx.Err() from fn rolls the transaction back.
+func (s *BoltStorage) update(ctx context.Context, fn func(*bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(fn)
+}
+
+// StoreLogs saves logs to stream-specific bucket. Old entries are trimmed by
+// the Compactor, not here, so writes stay cheap.
+func (s *BoltStorage) StoreLogs(ctx context.Context, streamID string, logs []LogLine) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		if t := readTombstoneTx(tx, streamID); t != nil {
+			return t.err()
+		}
+		bucket, err := tx.CreateBucketIfNotExists(logsBucketName(streamID))
+		if err != nil {
+			return err
+		}
+		codec, err := newLogCodec(tx, streamID, s.opts.Compress, s.crypt)
 		if err != nil {
 			return err
 		}
 
+		// Only lines that did not overwrite one are counted, so re-reading
+		// the tail of a file does not inflate the rollups
+		var added []LogLine
 		errorCount := 0
 		for _, log := range logs {
 			key := []byte(log.Timestamp.Format(time.RFC3339Nano))
-			data, err := json.Marshal(log)
+			data, err := codec.encode(log)
 			if err != nil {
 				return err
 			}
+			existed := bucket.Get(key) != nil
 			if err := bucket.Put(key, data); err != nil {
 				return err
 			}
+			if existed {
+				continue
+			}
 			
+			added = append(added, log)
 			if log.Level == "ERROR" || log.Level == "FATAL" {
 				errorCount++
 			}
 		}
 
-		// Ring buffer: delete old entries if > 10k
-		stats := bucket.Stats()
-		if stats.KeyN > 10000 {
-			c := bucket.Cursor()
-			toDelete := stats.KeyN - 10000
-			count := 0
-			for k, _ := c.First(); k != nil && count < toDelete; k, _ = c.Next() {
-				bucket.Delete(k)
-				count++
-			}
+		if err := updateRollups(tx, streamID, added); err != nil {
+			return err
 		}
 
 		// Update stream metadata
@@ -99,25 +166,26 @@ func (s *BoltStorage) StoreLogs(streamID string, logs []LogLine) error {
 			
 			// Update stats
 			stream.LastSeen = time.Now()
-			totalLogs := int64(stats.KeyN)
 			
 			// Get context for error count
 			ctxBucket := tx.Bucket(contextBucket)
 			if ctxBucket != nil {
 				ctxData := ctxBucket.Get([]byte(streamID))
-				var ctx StreamContext
+				var streamCtx StreamContext
 				if ctxData != nil {
-					json.Unmarshal(ctxData, &ctx)
-					ctx.TotalLogs = totalLogs
-					ctx.ErrorCount += int64(errorCount)
-					ctx.LastSeen = time.Now()
+					if err := s.crypt.unmarshal(ctxData, &streamCtx); err != nil {
+						return fmt.Errorf("failed to read context of %s: %w", streamID, err)
+					}
+					streamCtx.TotalLogs += int64(len(added))
+					streamCtx.ErrorCount += int64(errorCount)
+					streamCtx.LastSeen = time.Now()
 					
-					if totalLogs > 0 {
-						ctx.Patterns.ErrorRate = float64(ctx.ErrorCount) / float64(totalLogs)
+					if streamCtx.TotalLogs > 0 {
+						streamCtx.Patterns.ErrorRate = float64(streamCtx.ErrorCount) / float64(streamCtx.TotalLogs)
 					}
 					
 					// Update context
-					updatedCtx, _ := json.Marshal(ctx)
+					updatedCtx, _ := s.crypt.marshal(streamCtx)
 					ctxBucket.Put([]byte(streamID), updatedCtx)
 				}
 			}
@@ -131,23 +199,30 @@ func (s *BoltStorage) StoreLogs(streamID string, logs []LogLine) error {
 	})
 }
 
-func (s *BoltStorage) GetLogs(streamID string, opts GetLogsOptions) ([]LogLine, error) {
+func (s *BoltStorage) GetLogs(ctx context.Context, streamID string, opts GetLogsOptions) ([]LogLine, error) {
 	var logs []LogLine
 
-	err := s.db.View(func(tx *bolt.Tx) error {
-		bucketName := append(logsBucketPrefix, []byte(streamID)...)
-		bucket := tx.Bucket(bucketName)
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(logsBucketName(streamID))
 		if bucket == nil {
 			return nil // No logs yet
 		}
+		codec, err := newLogCodec(tx, streamID, s.opts.Compress, s.crypt)
+		if err != nil {
+			return err
+		}
 
 		c := bucket.Cursor()
 		count := 0
 
 		// Start from most recent
 		for k, v := c.Last(); k != nil && (opts.Limit == 0 || count < opts.Limit); k, v = c.Prev() {
-			var log LogLine
-			if err := json.Unmarshal(v, &log); err != nil {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			log, err := codec.decode(v)
+			if err != nil {
 				continue
 			}
 
@@ -155,7 +230,10 @@ func (s *BoltStorage) GetLogs(streamID string, opts GetLogsOptions) ([]LogLine,
 			if !opts.Since.IsZero() && log.Timestamp.Before(opts.Since) {
 				break
 			}
-			if len(opts.Levels) > 0 && !contains(opts.Levels, log.Level) {
+			if !opts.Until.IsZero() && log.Timestamp.After(opts.Until) {
+				continue
+			}
+			if !opts.matches(log) {
 				continue
 			}
 
@@ -169,10 +247,10 @@ func (s *BoltStorage) GetLogs(streamID string, opts GetLogsOptions) ([]LogLine,
 	return logs, err
 }
 
-func (s *BoltStorage) ListStreams() ([]Stream, error) {
+func (s *BoltStorage) ListStreams(ctx context.Context) ([]Stream, error) {
 	var streams []Stream
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(ctx, func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(streamsBucket)
 		if bucket == nil {
 			return nil
@@ -181,28 +259,31 @@ func (s *BoltStorage) ListStreams() ([]Stream, error) {
 		ctxBucket := tx.Bucket(contextBucket)
 
 		return bucket.ForEach(func(k, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
 			var stream Stream
 			if err := json.Unmarshal(v, &stream); err != nil {
 				return err
 			}
 			
-			// Get log count from logs bucket
-			logsBucketName := append(logsBucketPrefix, k...)
-			logsBucket := tx.Bucket(logsBucketName)
-			if logsBucket != nil {
-				stats := logsBucket.Stats()
-				stream.LogsPerMin = stats.KeyN // Total logs for now
+			// Compute rates from rollups
+			if rollups := tx.Bucket(rollupsBucket).Bucket(k); rollups != nil {
+				now := time.Now()
+				minutes := readRollups(rollups, ResolutionMinute, now.Add(-time.Hour))
+				hours := readRollups(rollups, ResolutionHour, now.Add(-24*time.Hour))
+				applyRollupStats(&stream, minutes, hours, now)
 			}
 			
 			// Enrich with context data
 			if ctxBucket != nil {
 				ctxData := ctxBucket.Get(k)
 				if ctxData != nil {
-					var ctx StreamContext
-					if err := json.Unmarshal(ctxData, &ctx); err == nil {
-						stream.ErrorRate = ctx.Patterns.ErrorRate
-						if len(ctx.Analyses) > 0 {
-							latest := ctx.Analyses[len(ctx.Analyses)-1]
+					var streamCtx StreamContext
+					if err := s.crypt.unmarshal(ctxData, &streamCtx); err == nil {
+						if len(streamCtx.Analyses) > 0 {
+							latest := streamCtx.Analyses[len(streamCtx.Analyses)-1]
 							stream.ContextSummary = fmt.Sprintf("Last: %s (%s)", latest.Summary, latest.Severity)
 						}
 					}
@@ -217,18 +298,18 @@ func (s *BoltStorage) ListStreams() ([]Stream, error) {
 	return streams, err
 }
 
-func (s *BoltStorage) GetStream(streamID string) (*Stream, error) {
+func (s *BoltStorage) GetStream(ctx context.Context, streamID string) (*Stream, error) {
 	var stream Stream
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(ctx, func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(streamsBucket)
 		if bucket == nil {
-			return fmt.Errorf("stream not found")
+			return fmt.Errorf("stream %q: %w", streamID, ErrNotFound)
 		}
 
 		data := bucket.Get([]byte(streamID))
 		if data == nil {
-			return fmt.Errorf("stream not found")
+			return fmt.Errorf("stream %q: %w", streamID, ErrNotFound)
 		}
 
 		return json.Unmarshal(data, &stream)
@@ -240,43 +321,57 @@ func (s *BoltStorage) GetStream(streamID string) (*Stream, error) {
 	return &stream, nil
 }
 
-func (s *BoltStorage) UpdateStream(stream *Stream) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+func (s *BoltStorage) UpdateStream(ctx context.Context, stream *Stream) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(streamsBucket)
 		data, err := json.Marshal(stream)
 		if err != nil {
 			return err
 		}
+		if err := tx.Bucket(tombstonesBucket).Delete([]byte(stream.ID)); err != nil {
+			return err
+		}
 		return bucket.Put([]byte(stream.ID), data)
 	})
 }
 
-func (s *BoltStorage) GetContext(streamID string) (*StreamContext, error) {
-	var ctx StreamContext
+// GetHistogram returns a contiguous series of rollup buckets from since to now
+func (s *BoltStorage) GetHistogram(ctx context.Context, streamID string, resolution string, since time.Time) ([]RollupBucket, error) {
+	step, err := histogramStep(resolution, since)
+	if err != nil {
+		return nil, err
+	}
 
-	err := s.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(contextBucket)
-		data := bucket.Get([]byte(streamID))
-		if data == nil {
-			// Return empty context if not found
-			ctx = StreamContext{
-				StreamID:  streamID,
-				FirstSeen: time.Now(),
-				Analyses:  []AnalysisSummary{},
-				Patterns:  StreamPatterns{CommonErrors: []string{}},
-			}
-			return nil
+	var buckets []RollupBucket
+	err = s.view(ctx, func(tx *bolt.Tx) error {
+		if rollups := tx.Bucket(rollupsBucket).Bucket([]byte(streamID)); rollups != nil {
+			buckets = readRollups(rollups, resolution, since)
 		}
-		return json.Unmarshal(data, &ctx)
+		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return &ctx, err
+	return fillRollupGaps(buckets, step, since, time.Now()), nil
 }
 
-func (s *BoltStorage) UpdateContext(streamID string, ctx *StreamContext) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+func (s *BoltStorage) GetContext(ctx context.Context, streamID string) (*StreamContext, error) {
+	var streamCtx *StreamContext
+
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		streamCtx, err = s.readContextTx(tx, streamID)
+		return err
+	})
+
+	return streamCtx, err
+}
+
+func (s *BoltStorage) UpdateContext(ctx context.Context, streamID string, streamCtx *StreamContext) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(contextBucket)
-		data, err := json.Marshal(ctx)
+		data, err := s.crypt.marshal(streamCtx)
 		if err != nil {
 			return err
 		}
@@ -284,22 +379,56 @@ func (s *BoltStorage) UpdateContext(streamID string, ctx *StreamContext) error {
 	})
 }
 
-func (s *BoltStorage) StoreAnalysis(analysis *Analysis) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
+// MutateContext applies fn to the stream's context inside one write
+// transaction, so concurrent read-modify-write cycles cannot lose updates.
+// If fn returns an error nothing is written.
+func (s *BoltStorage) MutateContext(ctx context.Context, streamID string, fn func(*StreamContext) error) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		streamCtx, err := s.readContextTx(tx, streamID)
+		if err != nil {
+			return err
+		}
+		if err := fn(streamCtx); err != nil {
+			return err
+		}
+
+		data, err := s.crypt.marshal(streamCtx)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(contextBucket).Put([]byte(streamID), data)
+	})
+}
+
+// readContextTx returns the stored context, or an empty one if not found
+func (s *BoltStorage) readContextTx(tx *bolt.Tx, streamID string) (*StreamContext, error) {
+	data := tx.Bucket(contextBucket).Get([]byte(streamID))
+	if data == nil {
+		return newStreamContext(streamID), nil
+	}
+
+	var streamCtx StreamContext
+	if err := s.crypt.unmarshal(data, &streamCtx); err != nil {
+		return nil, err
+	}
+	return &streamCtx, nil
+}
+
+func (s *BoltStorage) StoreAnalysis(ctx context.Context, analysis *Analysis) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(analysisBucket)
-		key := fmt.Sprintf("%s:%s", analysis.StreamID, analysis.Timestamp.Format(time.RFC3339))
-		data, err := json.Marshal(analysis)
+		data, err := s.crypt.marshal(analysis)
 		if err != nil {
 			return err
 		}
-		return bucket.Put([]byte(key), data)
+		return bucket.Put(analysisKey(analysis), data)
 	})
 }
 
-func (s *BoltStorage) GetAnalysisHistory(streamID string, limit int) ([]Analysis, error) {
+func (s *BoltStorage) GetAnalysisHistory(ctx context.Context, streamID string, limit int) ([]Analysis, error) {
 	var analyses []Analysis
 
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(ctx, func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(analysisBucket)
 		c := bucket.Cursor()
 		prefix := []byte(streamID + ":")
@@ -309,9 +438,12 @@ func (s *BoltStorage) GetAnalysisHistory(streamID string, limit int) ([]Analysis
 			if limit > 0 && count >= limit {
 				break
 			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 
 			var analysis Analysis
-			if err := json.Unmarshal(v, &analysis); err != nil {
+			if err := s.crypt.unmarshal(v, &analysis); err != nil {
 				continue
 			}
 			analyses = append(analyses, analysis)
@@ -324,6 +456,222 @@ func (s *BoltStorage) GetAnalysisHistory(streamID string, limit int) ([]Analysis
 	return analyses, err
 }
 
+func (s *BoltStorage) ListRetentionRules(ctx context.Context) ([]RetentionRule, error) {
+	var rules []RetentionRule
+
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(retentionBucket).ForEach(func(k, v []byte) error {
+			var rule RetentionRule
+			if err := json.Unmarshal(v, &rule); err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+			return nil
+		})
+	})
+
+	return rules, err
+}
+
+func (s *BoltStorage) SetRetentionRule(ctx context.Context, rule RetentionRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		data, err := json.Marshal(rule)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(retentionBucket).Put(retentionKey(rule.Scope, rule.Target), data)
+	})
+}
+
+func (s *BoltStorage) DeleteRetentionRule(ctx context.Context, scope, target string) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(retentionBucket).Delete(retentionKey(scope, target))
+	})
+}
+
+// EnforceRetention trims the oldest logs of a stream until it satisfies policy,
+// handing them to evict (if set) first. The lines to drop are picked in a
+// read transaction so evict's archive I/O does not hold up writers; a line
+// overwritten in the meantime is kept.
+func (s *BoltStorage) EnforceRetention(ctx context.Context, streamID string, policy RetentionPolicy, evict EvictFunc) (int, error) {
+	type doomedLog struct{ key, value []byte }
+	var doomed []doomedLog
+	var evicted []LogLine
+
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(logsBucketName(streamID))
+		if bucket == nil {
+			return nil
+		}
+
+		// First pass: current size of the stream
+		lines := 0
+		var size int64
+		bucket.ForEach(func(k, v []byte) error {
+			lines++
+			size += int64(len(k) + len(v))
+			return nil
+		})
+
+		// Second pass: collect oldest keys until the policy holds
+		var cutoff time.Time
+		if policy.MaxAge > 0 {
+			cutoff = time.Now().Add(-time.Duration(policy.MaxAge))
+		}
+		codec, err := newLogCodec(tx, streamID, s.opts.Compress, s.crypt)
+		if err != nil {
+			return err
+		}
+
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			expired := false
+			if !cutoff.IsZero() {
+				if ts, err := time.Parse(time.RFC3339Nano, string(k)); err == nil && ts.Before(cutoff) {
+					expired = true
+				}
+			}
+			overLines := policy.MaxLines > 0 && lines > policy.MaxLines
+			overBytes := policy.MaxBytes > 0 && size > policy.MaxBytes
+			if !expired && !overLines && !overBytes {
+				break
+			}
+			doomed = append(doomed, doomedLog{append([]byte(nil), k...), append([]byte(nil), v...)})
+			if evict != nil {
+				if log, err := codec.decode(v); err == nil {
+					evicted = append(evicted, log)
+				}
+			}
+			lines--
+			size -= int64(len(k) + len(v))
+		}
+		return nil
+	})
+	if err != nil || len(doomed) == 0 {
+		return 0, err
+	}
+
+	if evict != nil && len(evicted) > 0 {
+		if err := evict(evicted); err != nil {
+			return 0, fmt.Errorf("failed to evict logs: %w", err)
+		}
+	}
+
+	deleted := 0
+	err = s.update(ctx, func(tx *bolt.Tx) error {
+		deleted = 0
+		bucket := tx.Bucket(logsBucketName(streamID))
+		if bucket == nil {
+			return nil
+		}
+		for _, d := range doomed {
+			if !bytes.Equal(bucket.Get(d.key), d.value) {
+				continue
+			}
+			if err := bucket.Delete(d.key); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// updateRollups adds logs to the stream's minute/hour counters and drops
+// buckets that have aged out
+func updateRollups(tx *bolt.Tx, streamID string, logs []LogLine) error {
+	rollups, err := tx.Bucket(rollupsBucket).CreateBucketIfNotExists([]byte(streamID))
+	if err != nil {
+		return err
+	}
+
+	pending := make(map[string]*RollupBucket)
+	addToRollups(pending, logs)
+
+	for key, bucket := range pending {
+		if data := rollups.Get([]byte(key)); data != nil {
+			var existing RollupBucket
+			if err := json.Unmarshal(data, &existing); err == nil {
+				bucket.merge(&existing)
+			}
+		}
+		data, err := json.Marshal(bucket)
+		if err != nil {
+			return err
+		}
+		if err := rollups.Put([]byte(key), data); err != nil {
+			return err
+		}
+	}
+
+	// Prune expired buckets
+	now := time.Now()
+	for resolution, keep := range map[string]time.Duration{
+		ResolutionMinute: minuteRollupRetention,
+		ResolutionHour:   hourRollupRetention,
+	} {
+		prefix := rollupKey(resolution, time.Unix(0, 0))[:2]
+		cutoff := rollupKey(resolution, now.Add(-keep))
+
+		var expired [][]byte
+		c := rollups.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) && bytes.Compare(k, cutoff) < 0; k, _ = c.Next() {
+			expired = append(expired, append([]byte(nil), k...))
+		}
+		for _, k := range expired {
+			if err := rollups.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readRollups decodes a stream's buckets of one resolution starting at since
+func readRollups(rollups *bolt.Bucket, resolution string, since time.Time) []RollupBucket {
+	var buckets []RollupBucket
+
+	step, _ := resolutionStep(resolution)
+	prefix := rollupKey(resolution, time.Unix(0, 0))[:2]
+	c := rollups.Cursor()
+	for k, v := c.Seek(rollupKey(resolution, since.Truncate(step))); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		var bucket RollupBucket
+		if err := json.Unmarshal(v, &bucket); err != nil {
+			continue
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets
+}
+
+// analysisKey orders a stream's analyses by time under a "<stream>:" prefix
+func analysisKey(analysis *Analysis) []byte {
+	return []byte(fmt.Sprintf("%s:%s", analysis.StreamID, analysis.Timestamp.Format(time.RFC3339)))
+}
+
+func logsBucketName(streamID string) []byte {
+	name := make([]byte, 0, len(logsBucketPrefix)+len(streamID))
+	name = append(name, logsBucketPrefix...)
+	return append(name, streamID...)
+}
+
+func retentionKey(scope, target string) []byte {
+	return []byte(scope + ":" + target)
+}
+
 // Helper function
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
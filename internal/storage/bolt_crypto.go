@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// reencryptState tracks the background pass that reseals stale values
+type reencryptState struct {
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	running  bool
+	again    bool // a rotation happened while running
+	resealed int64
+	lastErr  error
+}
+
+// initEncryption loads the data keys, refusing to open an encrypted
+// database without a keyring
+func (s *BoltStorage) initEncryption() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if s.opts.Keys == nil {
+			if hasDataKeys(tx) {
+				return fmt.Errorf("database is encrypted; provide its key file or set %s: %w", EncryptionKeyEnv, ErrNoKey)
+			}
+			return nil
+		}
+
+		state, err := loadKeyState(tx, s.opts.Keys, false)
+		if err != nil {
+			return err
+		}
+		s.crypt = &envelope{state: state}
+		return nil
+	})
+}
+
+// EncryptArchive seals the blocks of segments written to archive with this
+// store's keys, and includes the archive in re-encryption so its segments
+// follow key rotations. Call it before the archive is used and before
+// StartReencryption. It is a no-op without encryption.
+func (s *BoltStorage) EncryptArchive(archive *SegmentArchive) {
+	if s.crypt == nil {
+		return
+	}
+	archive.crypt = s.crypt
+	s.archive = archive
+}
+
+// RotateKey reloads the keyring, rewraps every data key under its primary
+// key and seals new values with a fresh data key. Existing values are
+// resealed in the background; once that finishes the old data keys are
+// deleted and old key-encryption keys may be removed from the keyring.
+func (s *BoltStorage) RotateKey(ctx context.Context) error {
+	if s.crypt == nil {
+		return fmt.Errorf("%w: encryption is not enabled", ErrInvalid)
+	}
+
+	keys, err := s.crypt.current().keys.Reload()
+	if err != nil {
+		return err
+	}
+	if keys == nil {
+		return fmt.Errorf("%w: no encryption keys configured", ErrInvalid)
+	}
+
+	var state *keyState
+	err = s.update(ctx, func(tx *bolt.Tx) error {
+		var err error
+		state, err = loadKeyState(tx, keys, true)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.crypt.swap(state)
+
+	log.Printf("Rotated encryption key: now using key %s, data key %d", keys.PrimaryID(), state.active)
+	s.StartReencryption()
+	return nil
+}
+
+// EncryptionStatus reports the active keys and re-encryption progress
+func (s *BoltStorage) EncryptionStatus() EncryptionStatus {
+	if s.crypt == nil {
+		return EncryptionStatus{}
+	}
+
+	state := s.crypt.current()
+	status := EncryptionStatus{
+		Enabled: true,
+		KeyID:   state.keys.PrimaryID(),
+		DataKey: state.active,
+	}
+	s.db.View(func(tx *bolt.Tx) error {
+		status.DataKeys = countDataKeys(tx)
+		return nil
+	})
+
+	s.reencrypt.mu.Lock()
+	defer s.reencrypt.mu.Unlock()
+	status.Reencrypting = s.reencrypt.running
+	status.Resealed = s.reencrypt.resealed
+	if s.reencrypt.lastErr != nil {
+		status.LastError = s.reencrypt.lastErr.Error()
+	}
+	return status
+}
+
+// StartReencryption reseals plaintext values and values sealed with an old
+// data key in the background. It is a no-op without encryption, and queues
+// another pass if one is already running.
+func (s *BoltStorage) StartReencryption() {
+	if s.crypt == nil {
+		return
+	}
+
+	r := &s.reencrypt
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		r.again = true
+		return
+	}
+	r.running = true
+	r.lastErr = nil
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+		for {
+			active := s.crypt.current().active
+			err := s.reencryptAll(s.bg)
+			if err == nil {
+				// Every value is on the active key unless a rotation raced us
+				if s.crypt.current().active == active {
+					err = s.db.Update(func(tx *bolt.Tx) error {
+						return retireDataKeys(tx, active)
+					})
+				}
+			}
+
+			r.mu.Lock()
+			if err != nil {
+				r.lastErr = err
+				if s.bg.Err() == nil {
+					log.Printf("Re-encryption failed: %v", err)
+				}
+			}
+			if !r.again || s.bg.Err() != nil {
+				r.running = false
+				r.mu.Unlock()
+				return
+			}
+			r.again = false
+			r.mu.Unlock()
+		}
+	}()
+}
+
+// reencryptAll walks every bucket holding sealed values, then the archive
+func (s *BoltStorage) reencryptAll(ctx context.Context) error {
+	buckets := [][]byte{contextBucket, analysisBucket, jobsBucket, digestsBucket, patternsBucket, eventsBucket}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if bytes.HasPrefix(name, logsBucketPrefix) {
+				buckets = append(buckets, append([]byte(nil), name...))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range buckets {
+		if err := s.reencryptBucket(ctx, name); err != nil {
+			return fmt.Errorf("bucket %s: %w", name, err)
+		}
+	}
+
+	if s.archive == nil {
+		return nil
+	}
+	resealed, err := s.archive.reseal(ctx)
+	s.reencrypt.mu.Lock()
+	s.reencrypt.resealed += int64(resealed)
+	s.reencrypt.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("archive: %w", err)
+	}
+	return nil
+}
+
+// reencryptBucket reseals stale values a batch per transaction, so writers
+// are never held up for long
+func (s *BoltStorage) reencryptBucket(ctx context.Context, name []byte) error {
+	var after []byte
+	for {
+		done := true
+		err := s.update(ctx, func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(name)
+			if bucket == nil {
+				return nil // Deleted since the pass began
+			}
+
+			// Collect first: bolt cursors must not see their bucket modified
+			var keys, values [][]byte
+			c := bucket.Cursor()
+			k, v := c.First()
+			if after != nil {
+				k, v = c.Seek(after)
+				if bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+			scanned := 0
+			for ; k != nil && scanned < reencryptBatch; k, v = c.Next() {
+				scanned++
+				after = append(after[:0], k...)
+				if v == nil || !s.crypt.stale(v) {
+					continue // Nested bucket or already current
+				}
+				keys = append(keys, append([]byte(nil), k...))
+				values = append(values, append([]byte(nil), v...))
+			}
+			done = k == nil
+
+			for i, key := range keys {
+				plain, err := s.crypt.open(values[i])
+				if err != nil {
+					return fmt.Errorf("key %s: %w", key, err)
+				}
+				sealed, err := s.crypt.seal(plain)
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put(key, sealed); err != nil {
+					return err
+				}
+			}
+
+			s.reencrypt.mu.Lock()
+			s.reencrypt.resealed += int64(len(keys))
+			s.reencrypt.mu.Unlock()
+			return nil
+		})
+		if err != nil || done {
+			return err
+		}
+	}
+}
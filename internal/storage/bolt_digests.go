@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SaveDigest creates or replaces a digest, keyed by its ID
+func (s *BoltStorage) SaveDigest(ctx context.Context, digest *Digest) error {
+	if err := digest.Validate(); err != nil {
+		return err
+	}
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		data, err := s.crypt.marshal(digest)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(digestsBucket).Put([]byte(digest.ID), data)
+	})
+}
+
+func (s *BoltStorage) GetDigest(ctx context.Context, id string) (*Digest, error) {
+	var digest Digest
+
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		data := tx.Bucket(digestsBucket).Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("digest %q: %w", id, ErrNotFound)
+		}
+		return s.crypt.unmarshal(data, &digest)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &digest, nil
+}
+
+func (s *BoltStorage) ListDigests(ctx context.Context, limit int) ([]Digest, error) {
+	var digests []Digest
+
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		c := tx.Bucket(digestsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(digests) >= limit {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var digest Digest
+			if err := s.crypt.unmarshal(v, &digest); err != nil {
+				return err
+			}
+			digests = append(digests, digest)
+		}
+		return nil
+	})
+
+	return digests, err
+}
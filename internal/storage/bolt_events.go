@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// eventPrefix starts the keys of a stream's events, which sort by time
+// within it. As with patterns, the NUL ends the stream ID.
+func eventPrefix(streamID string) []byte {
+	return []byte(streamID + "\x00")
+}
+
+func eventKey(e *Event) []byte {
+	return append(eventTimeKey(e.StreamID, e.Timestamp), e.ID...)
+}
+
+func eventTimeKey(streamID string, t time.Time) []byte {
+	return append(eventPrefix(streamID), fmt.Sprintf("%020d\x00", t.UnixNano())...)
+}
+
+// SaveEvent stores an event and drops the stream's events older than
+// eventRetention
+func (s *BoltStorage) SaveEvent(ctx context.Context, event *Event) error {
+	if err := event.Validate(); err != nil {
+		return err
+	}
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(eventsBucket)
+		data, err := s.crypt.marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(eventKey(event), data); err != nil {
+			return err
+		}
+
+		prefix := eventPrefix(event.StreamID)
+		cutoff := eventTimeKey(event.StreamID, time.Now().Add(-eventRetention))
+		var expired [][]byte
+		c := bucket.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) && bytes.Compare(k, cutoff) < 0; k, _ = c.Next() {
+			expired = append(expired, append([]byte(nil), k...))
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorage) ListEvents(ctx context.Context, opts ListEventsOptions) ([]Event, error) {
+	var events []Event
+
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		var prefix, start []byte
+		if opts.StreamID != "" {
+			prefix = eventPrefix(opts.StreamID)
+			start = prefix
+			if !opts.Since.IsZero() {
+				start = eventTimeKey(opts.StreamID, opts.Since)
+			}
+		}
+
+		c := tx.Bucket(eventsBucket).Cursor()
+		k, v := c.First()
+		if start != nil {
+			k, v = c.Seek(start)
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var event Event
+			if err := s.crypt.unmarshal(v, &event); err != nil {
+				return err
+			}
+			if opts.match(&event) {
+				events = append(events, event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sortEvents(events, opts.Limit), nil
+}
+
+// moveEventsTx gives srcID's events to dstID
+func (s *BoltStorage) moveEventsTx(tx *bolt.Tx, srcID, dstID string) error {
+	bucket := tx.Bucket(eventsBucket)
+	prefix := eventPrefix(srcID)
+
+	var events []Event
+	c := bucket.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		var event Event
+		if err := s.crypt.unmarshal(v, &event); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	for _, event := range events {
+		event.StreamID = dstID
+		data, err := s.crypt.marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(eventKey(&event), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteEventsTx(tx *bolt.Tx, streamID string) error {
+	bucket := tx.Bucket(eventsBucket)
+	prefix := eventPrefix(streamID)
+
+	var keys [][]byte
+	c := bucket.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// fingerprintPrefix starts the keys of a stream's fingerprints; values are
+// the time each was first seen, as big-endian Unix nanoseconds so they
+// compare as bytes
+func fingerprintPrefix(streamID string) []byte {
+	return []byte(streamID + "\x00")
+}
+
+func fingerprintTime(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
+// RecordFingerprints adds fingerprints to the stream's history and returns
+// those it did not have, in one write transaction
+func (s *BoltStorage) RecordFingerprints(ctx context.Context, streamID string, fingerprints []string, at time.Time) ([]string, error) {
+	if err := validateFingerprints(fingerprints); err != nil {
+		return nil, err
+	}
+
+	var novel []string
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		novel = nil
+		bucket := tx.Bucket(fingerprintsBucket)
+		for _, fp := range fingerprints {
+			key := append(fingerprintPrefix(streamID), fp...)
+			if bucket.Get(key) != nil {
+				continue
+			}
+			if err := bucket.Put(key, fingerprintTime(at)); err != nil {
+				return err
+			}
+			novel = append(novel, fp)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return novel, nil
+}
+
+// UnseenFingerprints returns the fingerprints missing from the stream's
+// history, like RecordFingerprints but without adding them
+func (s *BoltStorage) UnseenFingerprints(ctx context.Context, streamID string, fingerprints []string) ([]string, error) {
+	if err := validateFingerprints(fingerprints); err != nil {
+		return nil, err
+	}
+
+	var unseen []string
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		unseen = nil
+		bucket := tx.Bucket(fingerprintsBucket)
+		for _, fp := range fingerprints {
+			if bucket.Get(append(fingerprintPrefix(streamID), fp...)) == nil && !slices.Contains(unseen, fp) {
+				unseen = append(unseen, fp)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return unseen, nil
+}
+
+// mergeFingerprintsTx adds srcID's fingerprints to dstID's history, keeping
+// the earlier first-seen time
+func mergeFingerprintsTx(tx *bolt.Tx, srcID, dstID string) error {
+	bucket := tx.Bucket(fingerprintsBucket)
+	prefix := fingerprintPrefix(srcID)
+
+	type entry struct{ key, value []byte }
+	var entries []entry
+	c := bucket.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		key := append(fingerprintPrefix(dstID), k[len(prefix):]...)
+		entries = append(entries, entry{key, append([]byte(nil), v...)})
+	}
+
+	for _, e := range entries {
+		if existing := bucket.Get(e.key); existing != nil && bytes.Compare(existing, e.value) <= 0 {
+			continue
+		}
+		if err := bucket.Put(e.key, e.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteFingerprintsTx(tx *bolt.Tx, streamID string) error {
+	bucket := tx.Bucket(fingerprintsBucket)
+	prefix := fingerprintPrefix(streamID)
+
+	var keys [][]byte
+	c := bucket.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SaveJob creates or replaces a job record, keyed by its ID
+func (s *BoltStorage) SaveJob(ctx context.Context, job *AnalysisJob) error {
+	if err := job.Validate(); err != nil {
+		return err
+	}
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		data, err := s.crypt.marshal(job)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+}
+
+func (s *BoltStorage) GetJob(ctx context.Context, id string) (*AnalysisJob, error) {
+	var job AnalysisJob
+
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("job %q: %w", id, ErrNotFound)
+		}
+		return s.crypt.unmarshal(data, &job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *BoltStorage) ListJobs(ctx context.Context, streamID string) ([]AnalysisJob, error) {
+	var jobs []AnalysisJob
+
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var job AnalysisJob
+			if err := s.crypt.unmarshal(v, &job); err != nil {
+				return err
+			}
+			if streamID == "" || job.StreamID == streamID {
+				jobs = append(jobs, job)
+			}
+			return nil
+		})
+	})
+
+	return jobs, err
+}
+
+// PruneJobs deletes jobs that finished before the given time
+func (s *BoltStorage) PruneJobs(ctx context.Context, before time.Time) (int, error) {
+	deleted := 0
+
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		var expired [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var job AnalysisJob
+			if err := s.crypt.unmarshal(v, &job); err != nil {
+				return err
+			}
+			if jobFinishedBefore(&job, before) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		deleted = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// patternPrefix starts the keys of a stream's patterns. The NUL keeps one
+// stream's prefix from matching another stream whose ID extends it.
+func patternPrefix(streamID string) []byte {
+	return []byte(streamID + "\x00")
+}
+
+func patternKey(streamID, id string) []byte {
+	return append(patternPrefix(streamID), id...)
+}
+
+// SavePatterns replaces the stream's patterns
+func (s *BoltStorage) SavePatterns(ctx context.Context, streamID string, patterns []LogPattern) error {
+	if err := validatePatterns(patterns); err != nil {
+		return err
+	}
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		if err := deletePatternsTx(tx, streamID); err != nil {
+			return err
+		}
+		bucket := tx.Bucket(patternsBucket)
+		for _, p := range patterns {
+			p.StreamID = streamID
+			data, err := s.crypt.marshal(p)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(patternKey(streamID, p.ID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStorage) GetPatterns(ctx context.Context, streamID string) ([]LogPattern, error) {
+	var patterns []LogPattern
+
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		prefix := patternPrefix(streamID)
+		c := tx.Bucket(patternsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var p LogPattern
+			if err := s.crypt.unmarshal(v, &p); err != nil {
+				return err
+			}
+			patterns = append(patterns, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortPatterns(patterns)
+	return patterns, nil
+}
+
+func deletePatternsTx(tx *bolt.Tx, streamID string) error {
+	bucket := tx.Bucket(patternsBucket)
+	prefix := patternPrefix(streamID)
+
+	var keys [][]byte
+	c := bucket.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// PatchStream renames or relabels a stream
+func (s *BoltStorage) PatchStream(ctx context.Context, streamID string, patch StreamPatch) (*Stream, error) {
+	var stream Stream
+
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(streamsBucket)
+		data := bucket.Get([]byte(streamID))
+		if data == nil {
+			return fmt.Errorf("stream %q: %w", streamID, ErrNotFound)
+		}
+		if err := json.Unmarshal(data, &stream); err != nil {
+			return err
+		}
+
+		patch.apply(&stream)
+
+		updated, err := json.Marshal(stream)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(streamID), updated)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return &stream, nil
+}
+
+// DeleteStream removes a stream with its logs, rollups, context, analyses,
+// patterns, events and error fingerprints
+func (s *BoltStorage) DeleteStream(ctx context.Context, streamID string) error {
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		if tx.Bucket(streamsBucket).Get([]byte(streamID)) == nil {
+			return fmt.Errorf("stream %q: %w", streamID, ErrNotFound)
+		}
+		if err := deleteStreamTx(tx, streamID); err != nil {
+			return err
+		}
+		return putTombstoneTx(tx, Tombstone{StreamID: streamID, At: time.Now()})
+	})
+}
+
+// MergeStreams folds srcID's logs, rollups, analyses, events, error
+// fingerprints and context into dstID, then deletes srcID. Its patterns are
+// dropped; dstID's are relearned from new logs.
+//
+// A log that cannot be decoded aborts the merge rather than being lost.
+func (s *BoltStorage) MergeStreams(ctx context.Context, srcID, dstID string) error {
+	if srcID == dstID {
+		return fmt.Errorf("cannot merge a stream into itself: %w", ErrConflict)
+	}
+
+	return s.update(ctx, func(tx *bolt.Tx) error {
+		streams := tx.Bucket(streamsBucket)
+		for _, id := range []string{srcID, dstID} {
+			if streams.Get([]byte(id)) == nil {
+				return fmt.Errorf("stream %q: %w", id, ErrNotFound)
+			}
+		}
+
+		if err := s.mergeLogsTx(ctx, tx, srcID, dstID); err != nil {
+			return err
+		}
+		if err := mergeRollupsTx(tx, srcID, dstID); err != nil {
+			return err
+		}
+		if err := s.mergeAnalysesTx(tx, srcID, dstID); err != nil {
+			return err
+		}
+		if err := s.moveEventsTx(tx, srcID, dstID); err != nil {
+			return err
+		}
+		if err := mergeFingerprintsTx(tx, srcID, dstID); err != nil {
+			return err
+		}
+		if err := s.mergeContextTx(tx, srcID, dstID); err != nil {
+			return err
+		}
+
+		if err := deleteStreamTx(tx, srcID); err != nil {
+			return err
+		}
+		return putTombstoneTx(tx, Tombstone{StreamID: srcID, MergedInto: dstID, At: time.Now()})
+	})
+}
+
+// GetTombstone returns the record of a deleted or merged stream
+func (s *BoltStorage) GetTombstone(ctx context.Context, streamID string) (*Tombstone, error) {
+	var t *Tombstone
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		if t = readTombstoneTx(tx, streamID); t == nil {
+			return fmt.Errorf("stream %q has no tombstone: %w", streamID, ErrNotFound)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// readTombstoneTx returns the stream's tombstone, or nil if it has none
+func readTombstoneTx(tx *bolt.Tx, streamID string) *Tombstone {
+	data := tx.Bucket(tombstonesBucket).Get([]byte(streamID))
+	if data == nil {
+		return nil
+	}
+	t := Tombstone{StreamID: streamID}
+	json.Unmarshal(data, &t)
+	return &t
+}
+
+func putTombstoneTx(tx *bolt.Tx, t Tombstone) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(tombstonesBucket).Put([]byte(t.StreamID), data)
+}
+
+func (s *BoltStorage) mergeLogsTx(ctx context.Context, tx *bolt.Tx, srcID, dstID string) error {
+	src := tx.Bucket(logsBucketName(srcID))
+	if src == nil {
+		return nil
+	}
+	dst, err := tx.CreateBucketIfNotExists(logsBucketName(dstID))
+	if err != nil {
+		return err
+	}
+
+	srcCodec, err := newLogCodec(tx, srcID, s.opts.Compress, s.crypt)
+	if err != nil {
+		return err
+	}
+	dstCodec, err := newLogCodec(tx, dstID, s.opts.Compress, s.crypt)
+	if err != nil {
+		return err
+	}
+
+	var logs []LogLine
+	err = src.ForEach(func(k, v []byte) error {
+		log, err := srcCodec.decode(v)
+		if err != nil {
+			return fmt.Errorf("failed to read log %s of %s: %w", k, srcID, err)
+		}
+		logs = append(logs, log)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, log := range logs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		log.StreamID = dstID
+
+		// Nudge the timestamp until the key is free rather than overwrite
+		key := []byte(log.Timestamp.Format(time.RFC3339Nano))
+		for dst.Get(key) != nil {
+			log.Timestamp = log.Timestamp.Add(time.Nanosecond)
+			key = []byte(log.Timestamp.Format(time.RFC3339Nano))
+		}
+
+		data, err := dstCodec.encode(log)
+		if err != nil {
+			return err
+		}
+		if err := dst.Put(key, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mergeRollupsTx(tx *bolt.Tx, srcID, dstID string) error {
+	parent := tx.Bucket(rollupsBucket)
+	src := parent.Bucket([]byte(srcID))
+	if src == nil {
+		return nil
+	}
+	dst, err := parent.CreateBucketIfNotExists([]byte(dstID))
+	if err != nil {
+		return err
+	}
+
+	return src.ForEach(func(k, v []byte) error {
+		var bucket RollupBucket
+		if err := json.Unmarshal(v, &bucket); err != nil {
+			return nil
+		}
+		if data := dst.Get(k); data != nil {
+			var existing RollupBucket
+			if err := json.Unmarshal(data, &existing); err == nil {
+				bucket.merge(&existing)
+			}
+		}
+		data, err := json.Marshal(bucket)
+		if err != nil {
+			return err
+		}
+		return dst.Put(k, data)
+	})
+}
+
+func (s *BoltStorage) mergeAnalysesTx(tx *bolt.Tx, srcID, dstID string) error {
+	bucket := tx.Bucket(analysisBucket)
+	prefix := []byte(srcID + ":")
+
+	var analyses []Analysis
+	c := bucket.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		var analysis Analysis
+		if err := s.crypt.unmarshal(v, &analysis); err != nil {
+			continue
+		}
+		analyses = append(analyses, analysis)
+	}
+
+	for _, analysis := range analyses {
+		analysis.StreamID = dstID
+		key := analysisKey(&analysis)
+		for bucket.Get(key) != nil {
+			key = append(key, '~')
+		}
+		data, err := s.crypt.marshal(analysis)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(key, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *BoltStorage) mergeContextTx(tx *bolt.Tx, srcID, dstID string) error {
+	bucket := tx.Bucket(contextBucket)
+
+	srcData := bucket.Get([]byte(srcID))
+	if srcData == nil {
+		return nil
+	}
+	var src StreamContext
+	if err := s.crypt.unmarshal(srcData, &src); err != nil {
+		return err
+	}
+
+	dst := StreamContext{StreamID: dstID}
+	if dstData := bucket.Get([]byte(dstID)); dstData != nil {
+		if err := s.crypt.unmarshal(dstData, &dst); err != nil {
+			return err
+		}
+	}
+
+	mergeContexts(&dst, &src)
+
+	data, err := s.crypt.marshal(dst)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(dstID), data)
+}
+
+// deleteStreamTx removes every trace of a stream inside tx
+func deleteStreamTx(tx *bolt.Tx, streamID string) error {
+	id := []byte(streamID)
+
+	if err := tx.Bucket(streamsBucket).Delete(id); err != nil {
+		return err
+	}
+	if err := tx.Bucket(contextBucket).Delete(id); err != nil {
+		return err
+	}
+	if err := tx.Bucket(retentionBucket).Delete(retentionKey(ScopeStream, streamID)); err != nil {
+		return err
+	}
+
+	if err := tx.DeleteBucket(logsBucketName(streamID)); err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	for _, parent := range [][]byte{rollupsBucket, labelsetsBucket} {
+		if err := tx.Bucket(parent).DeleteBucket(id); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+	}
+
+	if err := deletePatternsTx(tx, streamID); err != nil {
+		return err
+	}
+	if err := deleteEventsTx(tx, streamID); err != nil {
+		return err
+	}
+	if err := deleteFingerprintsTx(tx, streamID); err != nil {
+		return err
+	}
+
+	// Analyses are keyed "<stream>:<timestamp>"
+	analyses := tx.Bucket(analysisBucket)
+	prefix := []byte(streamID + ":")
+	var keys [][]byte
+	c := analyses.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		if err := analyses.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Log values are stored in a compact binary format:
+//
+//	version byte | flags byte | payload (zstd-compressed if flagCompressed)
+//
+// payload:
+//
+//	varint unix nanos | varint zone offset seconds | level | message | raw | uvarint label set ID
+//
+// Raw is stored as a diff against Message since they are usually identical
+// or differ only by a timestamp/level prefix. Label sets are interned per
+// stream in the labelsets bucket. Values starting with '{' are legacy JSON.
+// With encryption enabled the whole value is sealed (see crypto.go).
+//
+// Most of the saving comes from the format itself: a 260-byte JSON line with
+// three labels takes 75 bytes. Values are compressed one at a time, which
+// only pays off for long ones such as stack traces (about 5x smaller); zstd
+// makes lines of a few hundred bytes larger, so a compressed payload is only
+// kept when it is smaller. TestLogCodecSizes checks these proportions.
+const (
+	logFormatV1 byte = 0x01
+
+	flagCompressed byte = 1 << 0
+
+	// Payloads smaller than this are not worth compressing
+	compressThreshold = 128
+)
+
+// Raw encodings
+const (
+	rawSameAsMessage byte = iota
+	rawWrapsMessage       // prefix + message + suffix
+	rawLiteral
+)
+
+var levelCodes = []string{"", "ERROR", "WARN", "INFO", "DEBUG", "FATAL"}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// logCodec encodes log lines for one stream inside a bolt transaction
+type logCodec struct {
+	streamID string
+	labels   *bolt.Bucket // nil in read-only transactions before any labels exist
+	compress bool
+	crypt    *envelope // nil stores plaintext
+
+	setIDs map[string]uint64
+	sets   map[uint64]map[string]string
+}
+
+func newLogCodec(tx *bolt.Tx, streamID string, compress bool, crypt *envelope) (*logCodec, error) {
+	c := &logCodec{
+		streamID: streamID,
+		compress: compress,
+		crypt:    crypt,
+		setIDs:   make(map[string]uint64),
+		sets:     make(map[uint64]map[string]string),
+	}
+
+	parent := tx.Bucket(labelsetsBucket)
+	if parent == nil {
+		return c, nil
+	}
+	if tx.Writable() {
+		bucket, err := parent.CreateBucketIfNotExists([]byte(streamID))
+		if err != nil {
+			return nil, err
+		}
+		c.labels = bucket
+	} else {
+		c.labels = parent.Bucket([]byte(streamID))
+	}
+	return c, nil
+}
+
+func (c *logCodec) encode(log LogLine) ([]byte, error) {
+	var p bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+
+	putVarint := func(v int64) { p.Write(tmp[:binary.PutVarint(tmp[:], v)]) }
+	putUvarint := func(v uint64) { p.Write(tmp[:binary.PutUvarint(tmp[:], v)]) }
+	putString := func(s string) { putUvarint(uint64(len(s))); p.WriteString(s) }
+
+	_, offset := log.Timestamp.Zone()
+	putVarint(log.Timestamp.UnixNano())
+	putVarint(int64(offset))
+
+	if code := levelCode(log.Level); code > 0 {
+		p.WriteByte(code)
+	} else {
+		p.WriteByte(0)
+		putString(log.Level)
+	}
+
+	putString(log.Message)
+
+	switch i := strings.Index(log.Raw, log.Message); {
+	case log.Raw == log.Message:
+		p.WriteByte(rawSameAsMessage)
+	case log.Message != "" && i >= 0:
+		p.WriteByte(rawWrapsMessage)
+		putString(log.Raw[:i])
+		putString(log.Raw[i+len(log.Message):])
+	default:
+		p.WriteByte(rawLiteral)
+		putString(log.Raw)
+	}
+
+	setID, err := c.internLabels(log.Labels)
+	if err != nil {
+		return nil, err
+	}
+	putUvarint(setID)
+
+	payload := p.Bytes()
+	flags := byte(0)
+	if c.compress && len(payload) >= compressThreshold {
+		if compressed := zstdEncoder.EncodeAll(payload, nil); len(compressed) < len(payload) {
+			payload = compressed
+			flags |= flagCompressed
+		}
+	}
+
+	return c.crypt.seal(append([]byte{logFormatV1, flags}, payload...))
+}
+
+func (c *logCodec) decode(data []byte) (LogLine, error) {
+	var log LogLine
+
+	data, err := c.crypt.open(data)
+	if err != nil {
+		return log, err
+	}
+	if len(data) > 0 && data[0] == '{' {
+		err := json.Unmarshal(data, &log)
+		return log, err
+	}
+	if len(data) < 2 || data[0] != logFormatV1 {
+		return log, fmt.Errorf("unknown log format")
+	}
+
+	payload := data[2:]
+	if data[1]&flagCompressed != 0 {
+		if payload, err = zstdDecoder.DecodeAll(payload, nil); err != nil {
+			return log, err
+		}
+	}
+
+	r := bytes.NewReader(payload)
+	readString := func() (string, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return "", err
+		}
+		if n > uint64(r.Len()) {
+			return "", fmt.Errorf("corrupt log value")
+		}
+		buf := make([]byte, n)
+		r.Read(buf)
+		return string(buf), nil
+	}
+
+	nanos, err := binary.ReadVarint(r)
+	if err != nil {
+		return log, err
+	}
+	offset, err := binary.ReadVarint(r)
+	if err != nil {
+		return log, err
+	}
+	log.Timestamp = time.Unix(0, nanos).In(zoneFor(int(offset)))
+
+	code, err := r.ReadByte()
+	if err != nil {
+		return log, err
+	}
+	if code > 0 && int(code) < len(levelCodes) {
+		log.Level = levelCodes[code]
+	} else if log.Level, err = readString(); err != nil {
+		return log, err
+	}
+
+	if log.Message, err = readString(); err != nil {
+		return log, err
+	}
+
+	mode, err := r.ReadByte()
+	if err != nil {
+		return log, err
+	}
+	switch mode {
+	case rawSameAsMessage:
+		log.Raw = log.Message
+	case rawWrapsMessage:
+		prefix, err := readString()
+		if err != nil {
+			return log, err
+		}
+		suffix, err := readString()
+		if err != nil {
+			return log, err
+		}
+		log.Raw = prefix + log.Message + suffix
+	default:
+		if log.Raw, err = readString(); err != nil {
+			return log, err
+		}
+	}
+
+	setID, err := binary.ReadUvarint(r)
+	if err != nil {
+		return log, err
+	}
+	if log.Labels, err = c.lookupLabels(setID); err != nil {
+		return log, err
+	}
+
+	log.StreamID = c.streamID
+	return log, nil
+}
+
+// internLabels returns the ID of a label set, allocating one if it is new
+func (c *logCodec) internLabels(labels map[string]string) (uint64, error) {
+	if len(labels) == 0 {
+		return 0, nil
+	}
+
+	canonical := canonicalLabels(labels)
+	if id, ok := c.setIDs[canonical]; ok {
+		return id, nil
+	}
+	if c.labels == nil {
+		return 0, fmt.Errorf("label sets not writable")
+	}
+
+	setKey := append([]byte("set:"), canonical...)
+	if data := c.labels.Get(setKey); data != nil {
+		id := binary.BigEndian.Uint64(data)
+		c.setIDs[canonical] = id
+		return id, nil
+	}
+
+	id, err := c.labels.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	idBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(idBytes, id)
+	if err := c.labels.Put(setKey, idBytes); err != nil {
+		return 0, err
+	}
+	if err := c.labels.Put(append([]byte("id:"), idBytes...), []byte(canonical)); err != nil {
+		return 0, err
+	}
+
+	c.setIDs[canonical] = id
+	return id, nil
+}
+
+func (c *logCodec) lookupLabels(id uint64) (map[string]string, error) {
+	if id == 0 {
+		return map[string]string{}, nil
+	}
+	if labels, ok := c.sets[id]; ok {
+		return copyLabels(labels), nil
+	}
+	if c.labels == nil {
+		return nil, fmt.Errorf("unknown label set %d", id)
+	}
+
+	idBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(idBytes, id)
+	data := c.labels.Get(append([]byte("id:"), idBytes...))
+	if data == nil {
+		return nil, fmt.Errorf("unknown label set %d", id)
+	}
+
+	labels, err := parseCanonicalLabels(data)
+	if err != nil {
+		return nil, err
+	}
+	c.sets[id] = labels
+	return copyLabels(labels), nil
+}
+
+// canonicalLabels serializes labels with sorted keys so equal sets match
+func canonicalLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	for _, k := range keys {
+		for _, s := range []string{k, labels[k]} {
+			b.Write(tmp[:binary.PutUvarint(tmp[:], uint64(len(s)))])
+			b.WriteString(s)
+		}
+	}
+	return b.String()
+}
+
+func parseCanonicalLabels(data []byte) (map[string]string, error) {
+	labels := make(map[string]string)
+	r := bytes.NewReader(data)
+
+	for r.Len() > 0 {
+		var pair [2]string
+		for i := range pair {
+			n, err := binary.ReadUvarint(r)
+			if err != nil || n > uint64(r.Len()) {
+				return nil, fmt.Errorf("corrupt label set")
+			}
+			buf := make([]byte, n)
+			r.Read(buf)
+			pair[i] = string(buf)
+		}
+		labels[pair[0]] = pair[1]
+	}
+	return labels, nil
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
+
+func levelCode(level string) byte {
+	for i, l := range levelCodes {
+		if i > 0 && l == level {
+			return byte(i)
+		}
+	}
+	return 0
+}
+
+func zoneFor(offset int) *time.Location {
+	if offset == 0 {
+		return time.UTC
+	}
+	return time.FixedZone("", offset)
+}
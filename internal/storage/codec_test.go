@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// withCodec runs fn with a codec for stream "s" in a write transaction of
+// a database opened with opts
+func withCodec(t *testing.T, opts BoltOptions, fn func(c *logCodec)) {
+	t.Helper()
+	store, err := OpenBoltStorage(filepath.Join(t.TempDir(), "test.db"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		c, err := newLogCodec(tx, "s", opts.Compress, store.crypt)
+		if err != nil {
+			return err
+		}
+		fn(c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogCodecRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 10, 0, 0, 123456789, time.FixedZone("", 2*3600))
+	long := strings.Repeat("at com.example.Service.handle(Service.java:42)\n", 20)
+	logs := []LogLine{
+		{Timestamp: ts, Level: "INFO", Message: "ready", Raw: "ready"},
+		{Timestamp: ts.UTC(), Level: "ERROR", Message: "boom", Raw: "2024-03-01 [ERROR] boom (pid 7)", Labels: map[string]string{"pod": "api-1"}},
+		{Timestamp: ts, Level: "NOTICE", Message: "custom level", Raw: "something else entirely", Labels: map[string]string{"pod": "api-1", "ns": "prod"}},
+		{Timestamp: ts, Level: "FATAL", Message: "", Raw: "no message"},
+		{Timestamp: ts, Level: "ERROR", Message: long, Raw: long, Labels: map[string]string{"pod": "api-2"}},
+	}
+
+	key, _ := GenerateKey()
+	keys, _ := ParseKeyring(key)
+	for name, opts := range map[string]BoltOptions{
+		"plain":      {},
+		"compressed": {Compress: true},
+		"encrypted":  {Compress: true, Keys: keys},
+	} {
+		t.Run(name, func(t *testing.T) {
+			withCodec(t, opts, func(c *logCodec) {
+				for i, want := range logs {
+					data, err := c.encode(want)
+					if err != nil {
+						t.Fatalf("encode %d: %v", i, err)
+					}
+					if isSealed(data) != (opts.Keys != nil) {
+						t.Errorf("line %d sealed = %v", i, isSealed(data))
+					}
+					got, err := c.decode(data)
+					if err != nil {
+						t.Fatalf("decode %d: %v", i, err)
+					}
+					want.StreamID = "s"
+					if want.Labels == nil {
+						want.Labels = map[string]string{}
+					}
+					if !got.Timestamp.Equal(want.Timestamp) || got.Timestamp.Format(time.RFC3339) != want.Timestamp.Format(time.RFC3339) {
+						t.Errorf("line %d time = %v, want %v", i, got.Timestamp, want.Timestamp)
+					}
+					got.Timestamp = want.Timestamp
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("line %d = %+v, want %+v", i, got, want)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestLogCodecDecodesLegacyJSON(t *testing.T) {
+	withCodec(t, BoltOptions{}, func(c *logCodec) {
+		data := []byte(`{"timestamp":"2024-03-01T10:00:00Z","level":"WARN","message":"disk 91% full","raw":"[WARN] disk 91% full","labels":{"host":"db-1"},"stream_id":"s"}`)
+		log, err := c.decode(data)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if log.Level != "WARN" || log.Message != "disk 91% full" || log.Raw != "[WARN] disk 91% full" || log.Labels["host"] != "db-1" {
+			t.Errorf("legacy line = %+v", log)
+		}
+		if !log.Timestamp.Equal(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)) {
+			t.Errorf("legacy time = %v", log.Timestamp)
+		}
+
+		if _, err := c.decode([]byte{0x7f, 0}); err == nil {
+			t.Error("unknown format decoded without error")
+		}
+	})
+}
+
+func TestLogCodecCompressedFlag(t *testing.T) {
+	short := LogLine{Timestamp: time.Now(), Level: "INFO", Message: "ok"}
+	long := LogLine{Timestamp: time.Now(), Level: "ERROR", Message: strings.Repeat("retrying connection to db-1\n", 40)}
+
+	withCodec(t, BoltOptions{Compress: true}, func(c *logCodec) {
+		data, _ := c.encode(short)
+		if data[0] != logFormatV1 || data[1]&flagCompressed != 0 {
+			t.Errorf("short line header = % x, want uncompressed", data[:2])
+		}
+		data, _ = c.encode(long)
+		if data[1]&flagCompressed == 0 || len(data) > len(long.Message)/4 {
+			t.Errorf("long line: flags %x, %d bytes for %d of message", data[1], len(data), len(long.Message))
+		}
+	})
+	withCodec(t, BoltOptions{}, func(c *logCodec) {
+		if data, _ := c.encode(long); data[1]&flagCompressed != 0 {
+			t.Error("compressed without the option")
+		}
+	})
+}
+
+// TestLogCodecSizes backs the numbers in the format comment: the binary
+// format does most of the work and compression never makes a value larger
+func TestLogCodecSizes(t *testing.T) {
+	labels := map[string]string{"pod": "api-7d9f8b-x2k4p", "namespace": "prod", "container": "api"}
+	corpus := map[string]func(i int) string{
+		"short": func(i int) string { return fmt.Sprintf("GET /api/v1/users/%d 200 %dms", i, i%300) },
+		"medium": func(i int) string {
+			return fmt.Sprintf("request completed method=GET path=/api/v1/orders/%d status=200 duration=%dms user_id=%d trace_id=4bf92f3577b34da6a3ce929d0e0e%04d upstream=orders-svc.prod.svc.cluster.local:8080", i, i%300, i*7, i)
+		},
+		"stack": func(i int) string {
+			return "java.lang.NullPointerException: name is null\n" + strings.Repeat(fmt.Sprintf("\tat com.example.orders.OrderService.process(OrderService.java:%d)\n", i%500), 12)
+		},
+	}
+
+	sizes := make(map[string][3]int) // JSON, binary, compressed bytes
+	withCodec(t, BoltOptions{}, func(plain *logCodec) {
+		withCodec(t, BoltOptions{Compress: true}, func(compressed *logCodec) {
+			for name, message := range corpus {
+				var s [3]int
+				for i := 0; i < 200; i++ {
+					log := LogLine{Timestamp: time.Now(), Level: "INFO", Message: message(i), Labels: labels}
+					log.Raw = "2024-03-01T10:00:00Z INFO " + log.Message
+					js, _ := json.Marshal(log)
+					bin, _ := plain.encode(log)
+					z, _ := compressed.encode(log)
+					s[0], s[1], s[2] = s[0]+len(js), s[1]+len(bin), s[2]+len(z)
+				}
+				sizes[name] = s
+				t.Logf("%-6s JSON %5d, binary %5d, compressed %5d bytes/line", name, s[0]/200, s[1]/200, s[2]/200)
+			}
+		})
+	})
+
+	if s := sizes["short"]; s[1]*3 > s[0] {
+		t.Errorf("short lines: binary %d is not a third of JSON %d", s[1], s[0])
+	}
+	for name, s := range sizes {
+		if s[2] > s[1] {
+			t.Errorf("%s lines: compression grew %d to %d bytes", name, s[1], s[2])
+		}
+	}
+	if s := sizes["stack"]; s[2]*3 > s[1] {
+		t.Errorf("stack traces: compressed %d is not a third of binary %d", s[2], s[1])
+	}
+}
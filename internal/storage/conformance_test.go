@@ -0,0 +1,1075 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// backends lists every Storage implementation; each runs the same suite
+var backends = map[string]func(t *testing.T) Storage{
+	"bolt": func(t *testing.T) Storage {
+		store, err := NewBoltStorage(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("failed to open bolt storage: %v", err)
+		}
+		return store
+	},
+	"bolt-encrypted": func(t *testing.T) Storage {
+		key, _ := GenerateKey()
+		keys, err := ParseKeyring(key)
+		if err != nil {
+			t.Fatalf("failed to parse key: %v", err)
+		}
+		store, err := OpenBoltStorage(filepath.Join(t.TempDir(), "test.db"), BoltOptions{Keys: keys})
+		if err != nil {
+			t.Fatalf("failed to open encrypted bolt storage: %v", err)
+		}
+		return store
+	},
+	"memory": func(t *testing.T) Storage {
+		return NewMemoryStorage()
+	},
+	"hooked": func(t *testing.T) Storage {
+		store := NewHookedStorage(NewMemoryStorage())
+		store.OnStore(func(streamID string, logs []LogLine) {})
+		return store
+	},
+	"tiered": func(t *testing.T) Storage {
+		hot, err := NewBoltStorage(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("failed to open bolt storage: %v", err)
+		}
+		archive, err := NewSegmentArchive(filepath.Join(t.TempDir(), "archive"))
+		if err != nil {
+			t.Fatalf("failed to open archive: %v", err)
+		}
+		return NewTieredStorage(hot, archive)
+	},
+	"sqlite": func(t *testing.T) Storage {
+		store, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "test.sqlite"))
+		if err != nil {
+			t.Fatalf("failed to open sqlite storage: %v", err)
+		}
+		return store
+	},
+}
+
+var conformanceTests = []struct {
+	name string
+	run  func(t *testing.T, store Storage)
+}{
+	{"StoreAndGetLogs", testStoreAndGetLogs},
+	{"GetLogsFilters", testGetLogsFilters},
+	{"LabelAndSearchFilters", testLabelAndSearchFilters},
+	{"SameTimestampOverwrites", testSameTimestampOverwrites},
+	{"RingBuffer", testRingBuffer},
+	{"RetentionByAgeEvicts", testRetentionByAgeEvicts},
+	{"RetentionRules", testRetentionRules},
+	{"Streams", testStreams},
+	{"ContextUpdates", testContextUpdates},
+	{"MutateContext", testMutateContext},
+	{"MutateContextConcurrent", testMutateContextConcurrent},
+	{"AnalysisPrefixOrdering", testAnalysisPrefixOrdering},
+	{"Jobs", testJobs},
+	{"Digests", testDigests},
+	{"Patterns", testPatterns},
+	{"Events", testEvents},
+	{"Fingerprints", testFingerprints},
+	{"Histogram", testHistogram},
+	{"DeleteStream", testDeleteStream},
+	{"MergeStreams", testMergeStreams},
+	{"TypedErrors", testTypedErrors},
+	{"Cancellation", testCancellation},
+}
+
+func TestConformance(t *testing.T) {
+	for name, open := range backends {
+		t.Run(name, func(t *testing.T) {
+			for _, tc := range conformanceTests {
+				t.Run(tc.name, func(t *testing.T) {
+					store := open(t)
+					defer store.Close()
+					tc.run(t, store)
+				})
+			}
+		})
+	}
+}
+
+// makeLogs returns n logs one second apart ending at end; every third is an ERROR
+func makeLogs(streamID string, n int, end time.Time) []LogLine {
+	logs := make([]LogLine, n)
+	for i := range logs {
+		level := "INFO"
+		if i%3 == 0 {
+			level = "ERROR"
+		}
+		msg := fmt.Sprintf("line %d", i)
+		logs[i] = LogLine{
+			Timestamp: end.Add(-time.Duration(n-1-i) * time.Second),
+			Level:     level,
+			Message:   msg,
+			Raw:       "[" + level + "] " + msg,
+			Labels:    map[string]string{"pod": "api-1"},
+			StreamID:  streamID,
+		}
+	}
+	return logs
+}
+
+func mustStore(t *testing.T, store Storage, streamID string, logs []LogLine) {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.StoreLogs(ctx, streamID, logs); err != nil {
+		t.Fatalf("StoreLogs: %v", err)
+	}
+}
+
+// archiveOldest moves all but the newest keep lines of a stream to the
+// archive of a tiered store; other stores are left alone
+func archiveOldest(t *testing.T, store Storage, streamID string, keep int) {
+	t.Helper()
+	tiered, ok := store.(*TieredStorage)
+	if !ok {
+		return
+	}
+	_, err := tiered.EnforceRetention(context.Background(), streamID, RetentionPolicy{MaxLines: keep}, func(logs []LogLine) error {
+		return tiered.archive.Write(streamID, logs)
+	})
+	if err != nil {
+		t.Fatalf("EnforceRetention: %v", err)
+	}
+}
+
+func mustGet(t *testing.T, store Storage, streamID string, opts GetLogsOptions) []LogLine {
+	t.Helper()
+	ctx := context.Background()
+	logs, err := store.GetLogs(ctx, streamID, opts)
+	if err != nil {
+		t.Fatalf("GetLogs: %v", err)
+	}
+	return logs
+}
+
+func testStoreAndGetLogs(t *testing.T, store Storage) {
+	in := makeLogs("s", 10, time.Now())
+	mustStore(t, store, "s", in)
+
+	got := mustGet(t, store, "s", GetLogsOptions{})
+	if len(got) != len(in) {
+		t.Fatalf("got %d logs, want %d", len(got), len(in))
+	}
+	for i := range in {
+		if !got[i].Timestamp.Equal(in[i].Timestamp) || got[i].Message != in[i].Message ||
+			got[i].Raw != in[i].Raw || got[i].Level != in[i].Level ||
+			got[i].Labels["pod"] != "api-1" || got[i].StreamID != "s" {
+			t.Fatalf("log %d = %+v, want %+v", i, got[i], in[i])
+		}
+	}
+
+	// Limit keeps the newest, still in chronological order
+	got = mustGet(t, store, "s", GetLogsOptions{Limit: 3})
+	if len(got) != 3 || got[2].Message != "line 9" || got[0].Message != "line 7" {
+		t.Fatalf("limit 3 returned %v", got)
+	}
+
+	if logs := mustGet(t, store, "missing", GetLogsOptions{}); len(logs) != 0 {
+		t.Fatalf("unknown stream returned %d logs", len(logs))
+	}
+}
+
+func testGetLogsFilters(t *testing.T, store Storage) {
+	end := time.Now()
+	mustStore(t, store, "s", makeLogs("s", 12, end))
+
+	errors := mustGet(t, store, "s", GetLogsOptions{Levels: []string{"ERROR"}})
+	if len(errors) != 4 {
+		t.Fatalf("got %d ERROR logs, want 4", len(errors))
+	}
+	for _, log := range errors {
+		if log.Level != "ERROR" {
+			t.Fatalf("level filter let through %s", log.Level)
+		}
+	}
+
+	since := mustGet(t, store, "s", GetLogsOptions{Since: end.Add(-4500 * time.Millisecond)})
+	if len(since) != 5 {
+		t.Fatalf("since returned %d logs, want 5", len(since))
+	}
+
+	until := mustGet(t, store, "s", GetLogsOptions{Until: end.Add(-9500 * time.Millisecond)})
+	if len(until) != 2 {
+		t.Fatalf("until returned %d logs, want 2", len(until))
+	}
+}
+
+func testLabelAndSearchFilters(t *testing.T, store Storage) {
+	now := time.Now()
+	logs := []LogLine{
+		{Timestamp: now.Add(-3 * time.Second), Level: "ERROR", Message: "Connection TIMEOUT to db", Labels: map[string]string{"pod": "api-1", "env": "prod"}},
+		{Timestamp: now.Add(-2 * time.Second), Level: "INFO", Message: "request ok", Labels: map[string]string{"pod": "api-2", "env": "prod"}},
+		{Timestamp: now.Add(-1 * time.Second), Level: "WARN", Message: "slow timeout budget", Labels: map[string]string{"pod": "api-1", "env": "dev"}},
+		{Timestamp: now, Level: "INFO", Message: "ok"},
+	}
+	mustStore(t, store, "s", logs)
+
+	cases := []struct {
+		name string
+		opts GetLogsOptions
+		want []string
+	}{
+		{"label", GetLogsOptions{Labels: map[string]string{"pod": "api-1"}}, []string{"Connection TIMEOUT to db", "slow timeout budget"}},
+		{"labels", GetLogsOptions{Labels: map[string]string{"pod": "api-1", "env": "prod"}}, []string{"Connection TIMEOUT to db"}},
+		{"missing label", GetLogsOptions{Labels: map[string]string{"team": "core"}}, nil},
+		{"search", GetLogsOptions{Search: "timeout"}, []string{"Connection TIMEOUT to db", "slow timeout budget"}},
+		{"search substring", GetLogsOptions{Search: "eout bud"}, []string{"slow timeout budget"}},
+		{"short search", GetLogsOptions{Search: "ok"}, []string{"request ok", "ok"}},
+		{"quoted search", GetLogsOptions{Search: `"ok`}, nil},
+		{"combined", GetLogsOptions{Search: "timeout", Levels: []string{"WARN"}, Labels: map[string]string{"env": "dev"}}, []string{"slow timeout budget"}},
+		{"limit", GetLogsOptions{Search: "o", Limit: 1}, []string{"ok"}},
+	}
+	for _, tc := range cases {
+		got := mustGet(t, store, "s", tc.opts)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %d logs, want %v", tc.name, len(got), tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i].Message != tc.want[i] {
+				t.Errorf("%s: log %d = %q, want %q", tc.name, i, got[i].Message, tc.want[i])
+			}
+		}
+	}
+}
+
+func testSameTimestampOverwrites(t *testing.T, store Storage) {
+	ts := time.Now()
+	mustStore(t, store, "s", []LogLine{{Timestamp: ts, Level: "INFO", Message: "first"}})
+	mustStore(t, store, "s", []LogLine{{Timestamp: ts, Level: "WARN", Message: "second"}})
+
+	got := mustGet(t, store, "s", GetLogsOptions{})
+	if len(got) != 1 || got[0].Message != "second" {
+		t.Fatalf("got %v, want the second write only", got)
+	}
+}
+
+func testRingBuffer(t *testing.T, store Storage) {
+	ctx := context.Background()
+	mustStore(t, store, "s", makeLogs("s", 25, time.Now()))
+
+	deleted, err := store.EnforceRetention(ctx, "s", RetentionPolicy{MaxLines: 10}, nil)
+	if err != nil {
+		t.Fatalf("EnforceRetention: %v", err)
+	}
+	if deleted != 15 {
+		t.Fatalf("deleted %d logs, want 15", deleted)
+	}
+
+	got := mustGet(t, store, "s", GetLogsOptions{})
+	if len(got) != 10 || got[0].Message != "line 15" || got[9].Message != "line 24" {
+		t.Fatalf("ring buffer kept %v", got)
+	}
+
+	// Already within policy: nothing to do
+	if deleted, _ := store.EnforceRetention(ctx, "s", RetentionPolicy{MaxLines: 10}, nil); deleted != 0 {
+		t.Fatalf("second pass deleted %d logs", deleted)
+	}
+}
+
+func testRetentionByAgeEvicts(t *testing.T, store Storage) {
+	ctx := context.Background()
+	end := time.Now()
+	mustStore(t, store, "s", makeLogs("s", 10, end))
+
+	// Writers are not held up while evicted logs are archived
+	var evicted []LogLine
+	deleted, err := store.EnforceRetention(ctx, "s", RetentionPolicy{MaxAge: Duration(4500 * time.Millisecond)}, func(logs []LogLine) error {
+		evicted = append(evicted, logs...)
+		stored := make(chan error, 1)
+		go func() { stored <- store.StoreLogs(ctx, "s", makeLogs("s", 1, end.Add(time.Second))) }()
+		select {
+		case err := <-stored:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Error("StoreLogs blocked while evicting")
+			return nil
+		}
+	})
+	if err != nil {
+		t.Fatalf("EnforceRetention: %v", err)
+	}
+	if deleted != 5 || len(evicted) != 5 || evicted[0].Message != "line 0" {
+		t.Fatalf("deleted %d, evicted %v", deleted, evicted)
+	}
+	if got := mustGet(t, store, "s", GetLogsOptions{}); len(got) != 6 {
+		t.Fatalf("%d logs left, want the 5 newest and the one stored while evicting", len(got))
+	}
+
+	// A failing evict keeps the logs
+	_, err = store.EnforceRetention(ctx, "s", RetentionPolicy{MaxLines: 1}, func([]LogLine) error {
+		return fmt.Errorf("archive unavailable")
+	})
+	if err == nil {
+		t.Fatal("expected evict error to be returned")
+	}
+	if got := mustGet(t, store, "s", GetLogsOptions{}); len(got) != 6 {
+		t.Fatalf("failed eviction still deleted logs: %d left", len(got))
+	}
+}
+
+func testRetentionRules(t *testing.T, store Storage) {
+	ctx := context.Background()
+	if err := store.SetRetentionRule(ctx, RetentionRule{Scope: "bogus"}); err == nil {
+		t.Fatal("invalid rule was accepted")
+	}
+
+	rules := []RetentionRule{
+		{Scope: ScopeDefault, Policy: RetentionPolicy{MaxLines: 100}},
+		{Scope: ScopeSource, Target: "file", Policy: RetentionPolicy{MaxLines: 50}},
+		{Scope: ScopeStream, Target: "s", Policy: RetentionPolicy{MaxAge: Duration(time.Hour)}},
+	}
+	for _, rule := range rules {
+		if err := store.SetRetentionRule(ctx, rule); err != nil {
+			t.Fatalf("SetRetentionRule: %v", err)
+		}
+	}
+
+	got, err := store.ListRetentionRules(ctx)
+	if err != nil || len(got) != 3 {
+		t.Fatalf("ListRetentionRules = %v, %v", got, err)
+	}
+
+	fallback := RetentionPolicy{MaxLines: 10000}
+	if p := ResolveRetention(got, Stream{ID: "s", Source: "file"}, fallback); p.MaxAge != Duration(time.Hour) {
+		t.Fatalf("stream rule not preferred: %+v", p)
+	}
+	if p := ResolveRetention(got, Stream{ID: "t", Source: "file"}, fallback); p.MaxLines != 50 {
+		t.Fatalf("source rule not preferred: %+v", p)
+	}
+	if p := ResolveRetention(got, Stream{ID: "t", Source: "docker"}, fallback); p.MaxLines != 100 {
+		t.Fatalf("stored default not used: %+v", p)
+	}
+
+	if err := store.DeleteRetentionRule(ctx, ScopeSource, "file"); err != nil {
+		t.Fatalf("DeleteRetentionRule: %v", err)
+	}
+	if got, _ := store.ListRetentionRules(ctx); len(got) != 2 {
+		t.Fatalf("rule not deleted: %v", got)
+	}
+}
+
+func testStreams(t *testing.T, store Storage) {
+	ctx := context.Background()
+	if _, err := store.GetStream(ctx, "missing"); err == nil {
+		t.Fatal("GetStream of unknown stream succeeded")
+	}
+
+	if err := store.UpdateStream(ctx, &Stream{ID: "b", Name: "b.log", Source: "file", Active: true}); err != nil {
+		t.Fatalf("UpdateStream: %v", err)
+	}
+	// StoreLogs creates streams it hasn't seen
+	mustStore(t, store, "a", makeLogs("a", 1, time.Now()))
+
+	streams, err := store.ListStreams(ctx)
+	if err != nil || len(streams) != 2 || streams[0].ID != "a" || streams[1].ID != "b" {
+		t.Fatalf("ListStreams = %v, %v", streams, err)
+	}
+
+	name := "renamed"
+	stream, err := store.PatchStream(ctx, "b", StreamPatch{Name: &name, Labels: map[string]string{"team": "core"}})
+	if err != nil || stream.Name != "renamed" || stream.Labels["team"] != "core" || stream.Source != "file" {
+		t.Fatalf("PatchStream = %+v, %v", stream, err)
+	}
+	if _, err := store.PatchStream(ctx, "missing", StreamPatch{Name: &name}); err == nil {
+		t.Fatal("PatchStream of unknown stream succeeded")
+	}
+
+	stream, err = store.GetStream(ctx, "b")
+	if err != nil || stream.Name != "renamed" || stream.Labels["team"] != "core" {
+		t.Fatalf("GetStream after patch = %+v, %v", stream, err)
+	}
+}
+
+func testContextUpdates(t *testing.T, store Storage) {
+	ctx := context.Background()
+	streamCtx, err := store.GetContext(ctx, "s")
+	if err != nil || streamCtx.StreamID != "s" || len(streamCtx.Analyses) != 0 {
+		t.Fatalf("empty context = %+v, %v", streamCtx, err)
+	}
+
+	// Counters only move once the context exists
+	mustStore(t, store, "s", makeLogs("s", 3, time.Now().Add(-time.Minute)))
+	if streamCtx, _ := store.GetContext(ctx, "s"); streamCtx.TotalLogs != 0 {
+		t.Fatalf("counters moved without a context: %+v", streamCtx)
+	}
+
+	streamCtx.Analyses = append(streamCtx.Analyses, AnalysisSummary{Timestamp: time.Now(), Summary: "db down", Severity: "P1"})
+	if err := store.UpdateContext(ctx, "s", streamCtx); err != nil {
+		t.Fatalf("UpdateContext: %v", err)
+	}
+
+	now := time.Now()
+	mustStore(t, store, "s", makeLogs("s", 6, now))
+	streamCtx, err = store.GetContext(ctx, "s")
+	if err != nil {
+		t.Fatalf("GetContext: %v", err)
+	}
+	if streamCtx.TotalLogs != 6 || streamCtx.ErrorCount != 2 || len(streamCtx.Analyses) != 1 || streamCtx.Analyses[0].Summary != "db down" {
+		t.Fatalf("context after StoreLogs = %+v", streamCtx)
+	}
+	if streamCtx.Patterns.ErrorRate < 0.33 || streamCtx.Patterns.ErrorRate > 0.34 {
+		t.Fatalf("error rate = %v, want 1/3", streamCtx.Patterns.ErrorRate)
+	}
+
+	// Overwritten lines are not counted again
+	mustStore(t, store, "s", makeLogs("s", 6, now))
+	if again, _ := store.GetContext(ctx, "s"); again.TotalLogs != 6 || again.ErrorCount != 2 {
+		t.Fatalf("counters after storing duplicates = %d logs, %d errors", again.TotalLogs, again.ErrorCount)
+	}
+
+	// Returned contexts are copies
+	streamCtx.Analyses[0].Summary = "mutated"
+	if again, _ := store.GetContext(ctx, "s"); again.Analyses[0].Summary != "db down" {
+		t.Fatal("mutating a returned context changed stored state")
+	}
+}
+
+func testMutateContext(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	// A failing mutation writes nothing, not even the empty context
+	boom := errors.New("boom")
+	err := store.MutateContext(ctx, "s", func(streamCtx *StreamContext) error {
+		streamCtx.TotalLogs = 42
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("MutateContext error = %v, want boom", err)
+	}
+	mustStore(t, store, "s", makeLogs("s", 3, time.Now()))
+	if streamCtx, _ := store.GetContext(ctx, "s"); streamCtx.TotalLogs != 0 {
+		t.Fatalf("failed mutation was stored: %+v", streamCtx)
+	}
+
+	err = store.MutateContext(ctx, "s", func(streamCtx *StreamContext) error {
+		if streamCtx.StreamID != "s" || streamCtx.Analyses == nil {
+			t.Errorf("missing context not initialized: %+v", streamCtx)
+		}
+		streamCtx.Analyses = append(streamCtx.Analyses, AnalysisSummary{Summary: "first"})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("MutateContext: %v", err)
+	}
+
+	streamCtx, _ := store.GetContext(ctx, "s")
+	if len(streamCtx.Analyses) != 1 || streamCtx.Analyses[0].Summary != "first" {
+		t.Fatalf("mutation not stored: %+v", streamCtx)
+	}
+}
+
+func testMutateContextConcurrent(t *testing.T, store Storage) {
+	ctx := context.Background()
+	const (
+		writers   = 16
+		mutations = 25
+		batches   = 10
+		perBatch  = 3
+	)
+
+	// Counters only move once the context exists
+	if err := store.MutateContext(ctx, "s", func(*StreamContext) error { return nil }); err != nil {
+		t.Fatalf("MutateContext: %v", err)
+	}
+
+	base := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*(mutations+batches))
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < mutations; i++ {
+				errs <- store.MutateContext(ctx, "s", func(streamCtx *StreamContext) error {
+					streamCtx.Analyses = append(streamCtx.Analyses, AnalysisSummary{Summary: fmt.Sprintf("%d-%d", w, i)})
+					return nil
+				})
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for b := 0; b < batches; b++ {
+				logs := make([]LogLine, perBatch)
+				for i := range logs {
+					n := (w*batches+b)*perBatch + i
+					logs[i] = LogLine{Timestamp: base.Add(time.Duration(n) * time.Microsecond), Level: "ERROR", Message: "x"}
+				}
+				errs <- store.StoreLogs(ctx, "s", logs)
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("concurrent write failed: %v", err)
+		}
+	}
+
+	streamCtx, err := store.GetContext(ctx, "s")
+	if err != nil {
+		t.Fatalf("GetContext: %v", err)
+	}
+	if got, want := len(streamCtx.Analyses), writers*mutations; got != want {
+		t.Errorf("lost analyses: got %d, want %d", got, want)
+	}
+	if got, want := streamCtx.TotalLogs, int64(writers*batches*perBatch); got != want {
+		t.Errorf("lost log counts: got %d, want %d", got, want)
+	}
+	if streamCtx.ErrorCount != streamCtx.TotalLogs {
+		t.Errorf("error count %d != total %d", streamCtx.ErrorCount, streamCtx.TotalLogs)
+	}
+}
+
+func testAnalysisPrefixOrdering(t *testing.T, store Storage) {
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i, id := range []string{"a", "ab", "a", "a", "b"} {
+		err := store.StoreAnalysis(ctx, &Analysis{
+			StreamID:  id,
+			Timestamp: base.Add(time.Duration(5-i) * time.Minute),
+			Summary:   fmt.Sprintf("%s-%d", id, i),
+		})
+		if err != nil {
+			t.Fatalf("StoreAnalysis: %v", err)
+		}
+	}
+
+	history, err := store.GetAnalysisHistory(ctx, "a", 0)
+	if err != nil {
+		t.Fatalf("GetAnalysisHistory: %v", err)
+	}
+	want := []string{"a-3", "a-2", "a-0"}
+	if len(history) != len(want) {
+		t.Fatalf("history = %v, want %v", history, want)
+	}
+	for i := range want {
+		if history[i].Summary != want[i] {
+			t.Fatalf("history[%d] = %s, want %s", i, history[i].Summary, want[i])
+		}
+	}
+
+	if limited, _ := store.GetAnalysisHistory(ctx, "a", 2); len(limited) != 2 || limited[0].Summary != "a-3" {
+		t.Fatalf("limited history = %v", limited)
+	}
+}
+
+func testJobs(t *testing.T, store Storage) {
+	ctx := context.Background()
+	now := time.Now()
+	jobs := []AnalysisJob{
+		{ID: "01", StreamID: "a", Status: JobDone, CreatedAt: now, FinishedAt: now.Add(-2 * time.Hour),
+			Analysis: &Analysis{StreamID: "a", Summary: "old", Fixes: []string{"restart"}}},
+		{ID: "02", StreamID: "b", Status: JobFailed, Error: "boom", CreatedAt: now, FinishedAt: now},
+		{ID: "03", StreamID: "a", Status: JobQueued, CreatedAt: now},
+	}
+	for i := range jobs {
+		if err := store.SaveJob(ctx, &jobs[i]); err != nil {
+			t.Fatalf("SaveJob: %v", err)
+		}
+	}
+
+	jobs[2].Status = JobRunning
+	if err := store.SaveJob(ctx, &jobs[2]); err != nil {
+		t.Fatalf("SaveJob update: %v", err)
+	}
+	job, err := store.GetJob(ctx, "03")
+	if err != nil || job.Status != JobRunning {
+		t.Fatalf("GetJob = %+v, %v", job, err)
+	}
+	if job, _ := store.GetJob(ctx, "01"); job.Analysis == nil || job.Analysis.Fixes[0] != "restart" {
+		t.Fatalf("job analysis not kept: %+v", job)
+	}
+
+	if all, _ := store.ListJobs(ctx, ""); len(all) != 3 || all[0].ID != "01" || all[2].ID != "03" {
+		t.Fatalf("ListJobs(all) = %+v", all)
+	}
+	if forA, _ := store.ListJobs(ctx, "a"); len(forA) != 2 || forA[1].ID != "03" {
+		t.Fatalf("ListJobs(a) = %+v", forA)
+	}
+
+	// Only finished jobs older than the cutoff go
+	deleted, err := store.PruneJobs(ctx, now.Add(-time.Hour))
+	if err != nil || deleted != 1 {
+		t.Fatalf("PruneJobs = %d, %v", deleted, err)
+	}
+	if _, err := store.GetJob(ctx, "01"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("pruned job: got %v, want ErrNotFound", err)
+	}
+	if remaining, _ := store.ListJobs(ctx, ""); len(remaining) != 2 {
+		t.Fatalf("after prune: %+v", remaining)
+	}
+
+	if err := store.SaveJob(ctx, &AnalysisJob{ID: "04", StreamID: "a", Status: "paused"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("unknown status: got %v, want ErrInvalid", err)
+	}
+}
+
+func testDigests(t *testing.T, store Storage) {
+	ctx := context.Background()
+	now := time.Now()
+	for _, id := range []string{"02", "01", "03"} {
+		digest := &Digest{ID: id, Schedule: "daily", Since: now.Add(-24 * time.Hour), Until: now, CreatedAt: now,
+			Issues: []DigestIssue{{StreamID: "a", Summary: "db down " + id, Severity: "P1"}}}
+		if err := store.SaveDigest(ctx, digest); err != nil {
+			t.Fatalf("SaveDigest: %v", err)
+		}
+	}
+
+	digest, err := store.GetDigest(ctx, "02")
+	if err != nil || len(digest.Issues) != 1 || digest.Issues[0].Summary != "db down 02" {
+		t.Fatalf("GetDigest = %+v, %v", digest, err)
+	}
+	if _, err := store.GetDigest(ctx, "04"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing digest: got %v, want ErrNotFound", err)
+	}
+
+	if all, _ := store.ListDigests(ctx, 0); len(all) != 3 || all[0].ID != "03" || all[2].ID != "01" {
+		t.Fatalf("ListDigests(0) = %+v", all)
+	}
+	if latest, _ := store.ListDigests(ctx, 2); len(latest) != 2 || latest[0].ID != "03" || latest[1].ID != "02" {
+		t.Fatalf("ListDigests(2) = %+v", latest)
+	}
+
+	if err := store.SaveDigest(ctx, &Digest{}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("digest without ID: got %v, want ErrInvalid", err)
+	}
+}
+
+func testPatterns(t *testing.T, store Storage) {
+	ctx := context.Background()
+	now := time.Now()
+	store.StoreLogs(ctx, "a", []LogLine{{Timestamp: now, Level: "INFO", Message: "hello"}})
+
+	patterns := []LogPattern{
+		{ID: "1", Template: "user <*> logged in", Count: 5, FirstSeen: now, LastSeen: now, Examples: []string{"user 1 logged in"}},
+		{ID: "2", Template: "user <*> failed login from <IP>", Count: 9, Errors: 9, FirstSeen: now, LastSeen: now},
+		{ID: "3", Template: "cache miss", Count: 5, FirstSeen: now, LastSeen: now},
+	}
+	if err := store.SavePatterns(ctx, "a", patterns); err != nil {
+		t.Fatalf("SavePatterns: %v", err)
+	}
+	// A stream whose ID extends "a" keeps its own patterns
+	if err := store.SavePatterns(ctx, "ab", patterns[:1]); err != nil {
+		t.Fatalf("SavePatterns: %v", err)
+	}
+
+	got, err := store.GetPatterns(ctx, "a")
+	if err != nil || len(got) != 3 {
+		t.Fatalf("GetPatterns = %+v, %v", got, err)
+	}
+	if got[0].ID != "2" || got[1].ID != "1" || got[2].ID != "3" {
+		t.Errorf("order = %s, %s, %s; want 2, 1, 3", got[0].ID, got[1].ID, got[2].ID)
+	}
+	if got[1].StreamID != "a" || len(got[1].Examples) != 1 || got[1].Examples[0] != "user 1 logged in" {
+		t.Errorf("pattern = %+v", got[1])
+	}
+
+	// Saving replaces the whole set
+	if err := store.SavePatterns(ctx, "a", patterns[2:]); err != nil {
+		t.Fatalf("SavePatterns: %v", err)
+	}
+	if got, _ := store.GetPatterns(ctx, "a"); len(got) != 1 || got[0].ID != "3" {
+		t.Errorf("after replace: %+v", got)
+	}
+	if got, _ := store.GetPatterns(ctx, "ab"); len(got) != 1 {
+		t.Errorf("other stream: %+v", got)
+	}
+
+	if err := store.SavePatterns(ctx, "a", []LogPattern{{ID: "1", Template: "x"}, {ID: "1", Template: "y"}}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("duplicate IDs: got %v, want ErrInvalid", err)
+	}
+
+	if err := store.DeleteStream(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.GetPatterns(ctx, "a"); len(got) != 0 {
+		t.Errorf("patterns survived DeleteStream: %+v", got)
+	}
+}
+
+func testEvents(t *testing.T, store Storage) {
+	ctx := context.Background()
+	now := time.Now()
+	mustStore(t, store, "a", makeLogs("a", 1, now))
+	mustStore(t, store, "ab", makeLogs("ab", 1, now))
+	mustStore(t, store, "b", makeLogs("b", 1, now))
+
+	events := []Event{
+		{ID: "1", StreamID: "a", Type: EventAnomaly, Timestamp: now.Add(-2 * time.Hour), Message: "quiet", Metric: "volume", Value: 0, Expected: 120, Score: -8},
+		{ID: "2", StreamID: "a", Type: EventAnomaly, Timestamp: now.Add(-time.Hour), Message: "loud"},
+		{ID: "3", StreamID: "ab", Type: "other", Timestamp: now.Add(-30 * time.Minute), Message: "other"},
+		{ID: "4", StreamID: "b", Type: EventAnomaly, Timestamp: now, Message: "newest"},
+	}
+	for i := range events {
+		if err := store.SaveEvent(ctx, &events[i]); err != nil {
+			t.Fatalf("SaveEvent: %v", err)
+		}
+	}
+	if err := store.SaveEvent(ctx, &Event{ID: "x", StreamID: "a"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("incomplete event: got %v, want ErrInvalid", err)
+	}
+	// Events past retention are pruned as new ones arrive
+	store.SaveEvent(ctx, &Event{ID: "0", StreamID: "a", Type: EventAnomaly, Timestamp: now.Add(-100 * 24 * time.Hour)})
+
+	ids := func(events []Event) string {
+		var ids []string
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+	for name, tc := range map[string]struct {
+		opts ListEventsOptions
+		want string
+	}{
+		"all":    {ListEventsOptions{}, "4,3,2,1"},
+		"stream": {ListEventsOptions{StreamID: "a"}, "2,1"},
+		"type":   {ListEventsOptions{Type: EventAnomaly}, "4,2,1"},
+		"since":  {ListEventsOptions{StreamID: "a", Since: now.Add(-90 * time.Minute)}, "2"},
+		"limit":  {ListEventsOptions{Limit: 2}, "4,3"},
+	} {
+		got, err := store.ListEvents(ctx, tc.opts)
+		if err != nil {
+			t.Fatalf("%s: ListEvents: %v", name, err)
+		}
+		if ids(got) != tc.want {
+			t.Errorf("%s: got events %s, want %s", name, ids(got), tc.want)
+		}
+	}
+
+	got, _ := store.ListEvents(ctx, ListEventsOptions{StreamID: "a", Limit: 1, Since: now.Add(-3 * time.Hour)})
+	if len(got) != 1 || got[0].StreamID != "a" || got[0].Message != "loud" {
+		t.Fatalf("event = %+v", got)
+	}
+
+	// Merging moves events; deleting drops them
+	if err := store.MergeStreams(ctx, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = store.ListEvents(ctx, ListEventsOptions{StreamID: "b"})
+	if ids(got) != "4,2,1" || got[2].StreamID != "b" || got[2].Metric != "volume" || got[2].Expected != 120 {
+		t.Errorf("merged events = %+v", got)
+	}
+	if err := store.DeleteStream(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.ListEvents(ctx, ListEventsOptions{}); ids(got) != "3" {
+		t.Errorf("events after DeleteStream = %s, want 3", ids(got))
+	}
+}
+
+func testFingerprints(t *testing.T, store Storage) {
+	ctx := context.Background()
+	now := time.Now()
+	mustStore(t, store, "a", makeLogs("a", 1, now))
+	mustStore(t, store, "ab", makeLogs("ab", 1, now))
+	mustStore(t, store, "b", makeLogs("b", 1, now))
+
+	record := func(streamID string, fps ...string) string {
+		t.Helper()
+		novel, err := store.RecordFingerprints(ctx, streamID, fps, now)
+		if err != nil {
+			t.Fatalf("RecordFingerprints: %v", err)
+		}
+		return strings.Join(novel, ",")
+	}
+
+	if got := record("a", "f1", "f2", "f1"); got != "f1,f2" {
+		t.Errorf("first sighting = %q, want f1,f2", got)
+	}
+	if got := record("a", "f2", "f3"); got != "f3" {
+		t.Errorf("second batch = %q, want f3", got)
+	}
+	if got := record("ab", "f1"); got != "f1" {
+		t.Errorf("stream ab shares a's history: got %q", got)
+	}
+	if got := record("a"); got != "" {
+		t.Errorf("empty batch = %q", got)
+	}
+	if _, err := store.RecordFingerprints(ctx, "a", []string{"f4", ""}, now); !errors.Is(err, ErrInvalid) {
+		t.Errorf("empty fingerprint: got %v, want ErrInvalid", err)
+	}
+
+	// Looking up records nothing
+	if unseen, err := store.UnseenFingerprints(ctx, "a", []string{"f4", "f1", "f4", "f5"}); err != nil || strings.Join(unseen, ",") != "f4,f5" {
+		t.Errorf("UnseenFingerprints = %q, %v; want f4,f5", unseen, err)
+	}
+	if unseen, _ := store.UnseenFingerprints(ctx, "a", []string{"f4"}); len(unseen) != 1 {
+		t.Errorf("UnseenFingerprints recorded f4")
+	}
+	if _, err := store.UnseenFingerprints(ctx, "a", []string{""}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("empty fingerprint: got %v, want ErrInvalid", err)
+	}
+
+	// Merging carries the history over; deleting forgets it
+	record("b", "f9")
+	if err := store.MergeStreams(ctx, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := record("b", "f1", "f3", "f9", "f5"); got != "f5" {
+		t.Errorf("after merge = %q, want f5", got)
+	}
+	if err := store.DeleteStream(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := record("b", "f1"); got != "f1" {
+		t.Errorf("after DeleteStream = %q, want f1", got)
+	}
+	if got := record("ab", "f1"); got != "" {
+		t.Errorf("other stream lost its history: got %q", got)
+	}
+}
+
+func testHistogram(t *testing.T, store Storage) {
+	ctx := context.Background()
+	now := time.Now()
+	mustStore(t, store, "s", makeLogs("s", 6, now))
+
+	buckets, err := store.GetHistogram(ctx, "s", ResolutionMinute, now.Add(-10*time.Minute))
+	if err != nil {
+		t.Fatalf("GetHistogram: %v", err)
+	}
+	if len(buckets) < 10 {
+		t.Fatalf("histogram has %d buckets, want a contiguous series", len(buckets))
+	}
+	// Ranges past what a resolution keeps would only be empty padding
+	if _, err := store.GetHistogram(ctx, "s", ResolutionMinute, now.Add(-48*time.Hour)); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("two days of minutes: got %v, want ErrInvalid", err)
+	}
+	if _, err := store.GetHistogram(ctx, "s", ResolutionHour, now.Add(-48*time.Hour)); err != nil {
+		t.Fatalf("two days of hours: %v", err)
+	}
+	var total, errors int64
+	for _, b := range buckets {
+		total += b.Total
+		errors += b.Counts["ERROR"]
+	}
+	if total != 6 || errors != 2 {
+		t.Fatalf("histogram counts total=%d errors=%d", total, errors)
+	}
+
+	// Storing the same lines again, as a restarted tailer does, counts nothing
+	logs := makeLogs("s", 6, now)
+	mustStore(t, store, "s", append(logs, logs[5]))
+	buckets, _ = store.GetHistogram(ctx, "s", ResolutionMinute, now.Add(-10*time.Minute))
+	total = 0
+	for _, b := range buckets {
+		total += b.Total
+	}
+	if total != 6 {
+		t.Fatalf("histogram total after storing duplicates = %d, want 6", total)
+	}
+
+	if _, err := store.GetHistogram(ctx, "s", "fortnight", now); err == nil {
+		t.Fatal("unknown resolution accepted")
+	}
+
+	streams, _ := store.ListStreams(ctx)
+	if len(streams) != 1 || streams[0].ErrorRates.Last1h < 0.33 || streams[0].ErrorRates.Last1h > 0.34 {
+		t.Fatalf("stream rates = %+v", streams)
+	}
+}
+
+func testDeleteStream(t *testing.T, store Storage) {
+	ctx := context.Background()
+	mustStore(t, store, "s", makeLogs("s", 5, time.Now()))
+	store.UpdateContext(ctx, "s", &StreamContext{StreamID: "s", Analyses: []AnalysisSummary{{Summary: "x"}}})
+	store.StoreAnalysis(ctx, &Analysis{StreamID: "s", Timestamp: time.Now(), Summary: "x"})
+	store.StoreAnalysis(ctx, &Analysis{StreamID: "s2", Timestamp: time.Now(), Summary: "keep"})
+	store.SetRetentionRule(ctx, RetentionRule{Scope: ScopeStream, Target: "s", Policy: RetentionPolicy{MaxLines: 1}})
+
+	if err := store.DeleteStream(ctx, "s"); err != nil {
+		t.Fatalf("DeleteStream: %v", err)
+	}
+	if err := store.DeleteStream(ctx, "s"); err == nil {
+		t.Fatal("deleting a missing stream succeeded")
+	}
+
+	if _, err := store.GetStream(ctx, "s"); err == nil {
+		t.Fatal("stream still exists")
+	}
+	if logs := mustGet(t, store, "s", GetLogsOptions{}); len(logs) != 0 {
+		t.Fatalf("%d logs survived", len(logs))
+	}
+	if streamCtx, _ := store.GetContext(ctx, "s"); len(streamCtx.Analyses) != 0 {
+		t.Fatal("context survived")
+	}
+	if history, _ := store.GetAnalysisHistory(ctx, "s", 0); len(history) != 0 {
+		t.Fatal("analyses survived")
+	}
+	if history, _ := store.GetAnalysisHistory(ctx, "s2", 0); len(history) != 1 {
+		t.Fatal("another stream's analyses were deleted")
+	}
+	if rules, _ := store.ListRetentionRules(ctx); len(rules) != 0 {
+		t.Fatalf("retention rule survived: %v", rules)
+	}
+
+	// Sources still writing to it do not bring it back
+	if err := store.StoreLogs(ctx, "s", makeLogs("s", 1, time.Now())); !errors.Is(err, ErrRemoved) {
+		t.Fatalf("StoreLogs to a deleted stream: got %v, want ErrRemoved", err)
+	}
+	if _, err := store.GetStream(ctx, "s"); err == nil {
+		t.Fatal("StoreLogs re-created a deleted stream")
+	}
+	if _, err := ResolveStream(ctx, store, "s"); !errors.Is(err, ErrRemoved) {
+		t.Fatalf("ResolveStream of a deleted stream: got %v, want ErrRemoved", err)
+	}
+	if _, err := store.GetTombstone(ctx, "s2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetTombstone of a live stream: got %v, want ErrNotFound", err)
+	}
+
+	// Creating it explicitly does
+	if err := store.UpdateStream(ctx, &Stream{ID: "s", Name: "again", Active: true}); err != nil {
+		t.Fatal(err)
+	}
+	mustStore(t, store, "s", makeLogs("s", 2, time.Now()))
+	if logs := mustGet(t, store, "s", GetLogsOptions{}); len(logs) != 2 {
+		t.Fatalf("re-created stream has %d logs, want 2", len(logs))
+	}
+}
+
+func testMergeStreams(t *testing.T, store Storage) {
+	ctx := context.Background()
+	now := time.Now()
+	mustStore(t, store, "src", makeLogs("src", 4, now))
+	mustStore(t, store, "dst", makeLogs("dst", 3, now))
+	store.UpdateContext(ctx, "src", &StreamContext{StreamID: "src", TotalLogs: 4, ErrorCount: 2,
+		Analyses: []AnalysisSummary{{Timestamp: now.Add(-time.Minute), Summary: "old"}},
+		Patterns: StreamPatterns{CommonErrors: []string{"disk full"},
+			Signatures: []ErrorSignature{{PatternID: "1", Template: "disk full", Count: 2, Score: 2, LastSeen: now}}}})
+	store.UpdateContext(ctx, "dst", &StreamContext{StreamID: "dst", TotalLogs: 3, ErrorCount: 1,
+		Analyses: []AnalysisSummary{{Timestamp: now, Summary: "new"}},
+		Patterns: StreamPatterns{CommonErrors: []string{"timeout"},
+			Signatures: []ErrorSignature{{PatternID: "1", Template: "timeout", Count: 3, Score: 3, LastSeen: now}}}})
+	store.StoreAnalysis(ctx, &Analysis{StreamID: "src", Timestamp: now, Summary: "from src"})
+	// A tiered store merges archived lines too, whose timestamps collide
+	archiveOldest(t, store, "src", 2)
+	archiveOldest(t, store, "dst", 1)
+
+	if err := store.MergeStreams(ctx, "src", "src"); err == nil {
+		t.Fatal("merging a stream into itself succeeded")
+	}
+	if err := store.MergeStreams(ctx, "src", "missing"); err == nil {
+		t.Fatal("merging into a missing stream succeeded")
+	}
+	if err := store.MergeStreams(ctx, "src", "dst"); err != nil {
+		t.Fatalf("MergeStreams: %v", err)
+	}
+
+	// Colliding timestamps are kept, not overwritten
+	logs := mustGet(t, store, "dst", GetLogsOptions{})
+	if len(logs) != 7 {
+		t.Fatalf("merged stream has %d logs, want 7", len(logs))
+	}
+	messages := make(map[string]int)
+	for _, log := range logs {
+		if log.StreamID != "dst" {
+			t.Fatalf("merged log kept stream ID %q", log.StreamID)
+		}
+		messages[log.Message]++
+	}
+	if messages["line 0"] != 2 || messages["line 1"] != 2 || messages["line 2"] != 2 || messages["line 3"] != 1 {
+		t.Fatalf("merged messages = %v", messages)
+	}
+
+	// Logs still sent to the source are refused; their new home is dst
+	if err := store.StoreLogs(ctx, "src", makeLogs("src", 1, now)); !errors.Is(err, ErrRemoved) {
+		t.Fatalf("StoreLogs to a merged stream: got %v, want ErrRemoved", err)
+	}
+	if id, err := ResolveStream(ctx, store, "src"); err != nil || id != "dst" {
+		t.Fatalf("ResolveStream(src) = %q, %v; want dst", id, err)
+	}
+
+	streamCtx, _ := store.GetContext(ctx, "dst")
+	if streamCtx.TotalLogs != 7 || streamCtx.ErrorCount != 3 || len(streamCtx.Analyses) != 2 || streamCtx.Analyses[0].Summary != "old" {
+		t.Fatalf("merged context = %+v", streamCtx)
+	}
+	// The source's signatures refer to its own patterns, so only its common
+	// errors carry over
+	if len(streamCtx.Patterns.CommonErrors) != 2 || len(streamCtx.Patterns.Signatures) != 1 ||
+		streamCtx.Patterns.Signatures[0].Template != "timeout" || streamCtx.Patterns.Signatures[0].Count != 3 {
+		t.Fatalf("merged patterns = %+v", streamCtx.Patterns)
+	}
+
+	history, _ := store.GetAnalysisHistory(ctx, "dst", 0)
+	if len(history) != 1 || history[0].StreamID != "dst" {
+		t.Fatalf("merged analyses = %v", history)
+	}
+
+	if _, err := store.GetStream(ctx, "src"); err == nil {
+		t.Fatal("source stream survived the merge")
+	}
+
+	buckets, _ := store.GetHistogram(ctx, "dst", ResolutionHour, now.Add(-2*time.Hour))
+	var total int64
+	for _, b := range buckets {
+		total += b.Total
+	}
+	if total != 7 {
+		t.Fatalf("merged rollups total %d, want 7", total)
+	}
+}
+
+func testTypedErrors(t *testing.T, store Storage) {
+	ctx := context.Background()
+	mustStore(t, store, "s", makeLogs("s", 1, time.Now()))
+
+	name := "x"
+	notFound := map[string]error{}
+	_, notFound["GetStream"] = store.GetStream(ctx, "missing")
+	_, notFound["PatchStream"] = store.PatchStream(ctx, "missing", StreamPatch{Name: &name})
+	notFound["DeleteStream"] = store.DeleteStream(ctx, "missing")
+	notFound["MergeStreams"] = store.MergeStreams(ctx, "s", "missing")
+	for op, err := range notFound {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: got %v, want ErrNotFound", op, err)
+		}
+	}
+
+	if err := store.MergeStreams(ctx, "s", "s"); !errors.Is(err, ErrConflict) {
+		t.Errorf("self-merge: got %v, want ErrConflict", err)
+	}
+}
+
+func testCancellation(t *testing.T, store Storage) {
+	mustStore(t, store, "s", makeLogs("s", 10, time.Now()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := store.GetLogs(ctx, "s", GetLogsOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetLogs: got %v, want context.Canceled", err)
+	}
+	if _, err := store.ListStreams(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListStreams: got %v, want context.Canceled", err)
+	}
+	if err := store.StoreLogs(ctx, "s", makeLogs("s", 1, time.Now().Add(time.Hour))); !errors.Is(err, context.Canceled) {
+		t.Errorf("StoreLogs: got %v, want context.Canceled", err)
+	}
+	if _, err := store.EnforceRetention(ctx, "s", RetentionPolicy{MaxLines: 1}, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("EnforceRetention: got %v, want context.Canceled", err)
+	}
+
+	// Nothing changed under the cancelled context
+	if logs := mustGet(t, store, "s", GetLogsOptions{}); len(logs) != 10 {
+		t.Fatalf("cancelled calls changed the stream: %d logs", len(logs))
+	}
+}
@@ -0,0 +1,418 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Encryption at rest uses envelope encryption. Log lines, contexts and
+// analyses are sealed with AES-256-GCM data keys (DEKs) that live in the
+// meta bucket, each wrapped by a key-encryption key (KEK) from a Keyring.
+// Rotating the KEK only rewraps the DEKs; values are then resealed under a
+// fresh DEK in the background, a batch per transaction.
+//
+// Sealed values are:
+//
+//	sealedMagic | uint32 DEK ID | nonce | ciphertext
+//
+// Plaintext values are JSON or logFormatV1 and never start with sealedMagic,
+// so both kinds coexist while a database is being encrypted. Stream metadata,
+// rollup counts and label sets are not encrypted. Blocks of archived
+// segments are sealed the same way, see BoltStorage.EncryptArchive.
+const (
+	sealedMagic byte = 0xE1
+
+	// KeySize is the length of a key-encryption key in bytes
+	KeySize = 32
+
+	// EncryptionKeyEnv holds comma-separated base64 keys when no key file is given
+	EncryptionKeyEnv = "LOGVOYANT_ENCRYPTION_KEY"
+
+	reencryptBatch = 500
+)
+
+var (
+	dekPrefix    = []byte("dek:")
+	activeDEKKey = []byte("dek_active")
+
+	// ErrNoKey is returned when sealed data is read without a matching key
+	ErrNoKey = errors.New("encryption key not available")
+)
+
+// Keyring holds key-encryption keys. The first key wraps new data keys; the
+// others only unwrap data keys that have not been rewrapped yet.
+type Keyring struct {
+	keys []kek
+	file string // reread on rotation; empty if the keys came from the env
+}
+
+type kek struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// LoadKeyring reads keys from file, one base64 key per line, or from
+// EncryptionKeyEnv if file is empty. It returns nil if neither is set.
+func LoadKeyring(file string) (*Keyring, error) {
+	if file == "" {
+		env := os.Getenv(EncryptionKeyEnv)
+		if env == "" {
+			return nil, nil
+		}
+		return ParseKeyring(env)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	keys, err := ParseKeyring(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	keys.file = file
+	return keys, nil
+}
+
+// ParseKeyring parses base64 keys separated by newlines or commas. Blank
+// lines and lines starting with # are ignored.
+func ParseKeyring(text string) (*Keyring, error) {
+	k := &Keyring{}
+	seen := make(map[string]bool)
+
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ',' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(raw) != KeySize {
+			return nil, fmt.Errorf("%w: encryption keys must be %d bytes, base64-encoded", ErrInvalid, KeySize)
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(raw)
+		id := hex.EncodeToString(sum[:8])
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		k.keys = append(k.keys, kek{id: id, aead: aead})
+	}
+
+	if len(k.keys) == 0 {
+		return nil, fmt.Errorf("%w: no encryption keys found", ErrInvalid)
+	}
+	return k, nil
+}
+
+// Reload rereads the keyring from where it was loaded
+func (k *Keyring) Reload() (*Keyring, error) {
+	if k.file == "" {
+		return LoadKeyring("")
+	}
+	return LoadKeyring(k.file)
+}
+
+// PrimaryID is the fingerprint of the key that wraps new data keys
+func (k *Keyring) PrimaryID() string {
+	return k.keys[0].id
+}
+
+func (k *Keyring) find(id string) (kek, bool) {
+	for _, key := range k.keys {
+		if key.id == id {
+			return key, true
+		}
+	}
+	return kek{}, false
+}
+
+// GenerateKey returns a new random key-encryption key, base64-encoded
+func GenerateKey() (string, error) {
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// EncryptionStatus reports the key state of an encrypted store
+type EncryptionStatus struct {
+	Enabled      bool   `json:"enabled"`
+	KeyID        string `json:"key_id,omitempty"`
+	DataKey      uint32 `json:"data_key,omitempty"`
+	DataKeys     int    `json:"data_keys,omitempty"`
+	Reencrypting bool   `json:"reencrypting"`
+	Resealed     int64  `json:"resealed"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// wrappedDEK is how a data key is stored in the meta bucket
+type wrappedDEK struct {
+	KEK string `json:"kek"`
+	Key []byte `json:"key"`
+}
+
+// keyState is an immutable snapshot of the usable data keys
+type keyState struct {
+	keys   *Keyring
+	deks   map[uint32]cipher.AEAD
+	active uint32
+}
+
+// envelope seals and opens values. A nil envelope passes plaintext through
+// and refuses sealed values.
+type envelope struct {
+	mu    sync.RWMutex
+	state *keyState
+}
+
+func (e *envelope) current() *keyState {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.state
+}
+
+func (e *envelope) swap(state *keyState) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Keep retired keys readable for transactions that are still open
+	for id, aead := range e.state.deks {
+		if _, ok := state.deks[id]; !ok {
+			state.deks[id] = aead
+		}
+	}
+	e.state = state
+}
+
+func (e *envelope) seal(plain []byte) ([]byte, error) {
+	if e == nil {
+		return plain, nil
+	}
+	state := e.current()
+	aead := state.deks[state.active]
+
+	out := make([]byte, 5+aead.NonceSize(), 5+aead.NonceSize()+len(plain)+aead.Overhead())
+	out[0] = sealedMagic
+	binary.BigEndian.PutUint32(out[1:5], state.active)
+	if _, err := rand.Read(out[5:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, out[5:], plain, nil), nil
+}
+
+func (e *envelope) open(data []byte) ([]byte, error) {
+	if !isSealed(data) {
+		return data, nil
+	}
+	if e == nil {
+		return nil, ErrNoKey
+	}
+
+	id := binary.BigEndian.Uint32(data[1:5])
+	aead, ok := e.current().deks[id]
+	if !ok {
+		return nil, fmt.Errorf("data key %d: %w", id, ErrNoKey)
+	}
+	n := 5 + aead.NonceSize()
+	if len(data) < n {
+		return nil, fmt.Errorf("corrupt sealed value")
+	}
+	return aead.Open(nil, data[5:n], data[n:], nil)
+}
+
+// marshal encodes v as JSON and seals it
+func (e *envelope) marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return e.seal(data)
+}
+
+// unmarshal opens data and decodes it as JSON into v
+func (e *envelope) unmarshal(data []byte, v any) error {
+	plain, err := e.open(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plain, v)
+}
+
+// stale reports whether data is plaintext or sealed with a non-active key
+func (e *envelope) stale(data []byte) bool {
+	if !isSealed(data) {
+		return true
+	}
+	return binary.BigEndian.Uint32(data[1:5]) != e.current().active
+}
+
+func isSealed(data []byte) bool {
+	return len(data) > 5 && data[0] == sealedMagic
+}
+
+// loadKeyState unwraps the data keys in the meta bucket with keys, rewrapping
+// any held by an older key under the primary one. A new active data key is
+// created if there is none or rotate is set.
+func loadKeyState(tx *bolt.Tx, keys *Keyring, rotate bool) (*keyState, error) {
+	meta := tx.Bucket(metaBucket)
+	state := &keyState{keys: keys, deks: make(map[uint32]cipher.AEAD)}
+	primary := keys.keys[0]
+
+	type rewrap struct {
+		key []byte
+		raw []byte
+	}
+	var rewraps []rewrap
+
+	c := meta.Cursor()
+	for k, v := c.Seek(dekPrefix); k != nil && bytes.HasPrefix(k, dekPrefix); k, v = c.Next() {
+		var stored wrappedDEK
+		if err := json.Unmarshal(v, &stored); err != nil {
+			return nil, fmt.Errorf("corrupt data key %s: %w", k, err)
+		}
+		wrapper, ok := keys.find(stored.KEK)
+		if !ok {
+			return nil, fmt.Errorf("data key %s is wrapped by key %s, which is not in the keyring: %w", k[len(dekPrefix):], stored.KEK, ErrNoKey)
+		}
+		raw, err := unwrapKey(wrapper, k, stored.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap data key %s: %w", k[len(dekPrefix):], err)
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		var id uint32
+		fmt.Sscanf(string(k[len(dekPrefix):]), "%d", &id)
+		state.deks[id] = aead
+		if stored.KEK != primary.id {
+			rewraps = append(rewraps, rewrap{key: append([]byte(nil), k...), raw: raw})
+		}
+	}
+
+	for _, r := range rewraps {
+		if err := putDEK(meta, primary, r.key, r.raw); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Sscanf(string(meta.Get(activeDEKKey)), "%d", &state.active)
+	if _, ok := state.deks[state.active]; ok && !rotate {
+		return state, nil
+	}
+
+	// Create a new active data key
+	seq, err := meta.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+	id := uint32(seq)
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	if err := putDEK(meta, primary, dekKey(id), raw); err != nil {
+		return nil, err
+	}
+	if err := meta.Put(activeDEKKey, []byte(fmt.Sprintf("%d", id))); err != nil {
+		return nil, err
+	}
+	if state.deks[id], err = newAEAD(raw); err != nil {
+		return nil, err
+	}
+	state.active = id
+	return state, nil
+}
+
+// hasDataKeys reports whether the database has ever been encrypted
+func hasDataKeys(tx *bolt.Tx) bool {
+	meta := tx.Bucket(metaBucket)
+	if meta == nil {
+		return false
+	}
+	k, _ := meta.Cursor().Seek(dekPrefix)
+	return k != nil && bytes.HasPrefix(k, dekPrefix)
+}
+
+// retireDataKeys deletes every data key but the active one
+func retireDataKeys(tx *bolt.Tx, active uint32) error {
+	meta := tx.Bucket(metaBucket)
+	var doomed [][]byte
+	c := meta.Cursor()
+	for k, _ := c.Seek(dekPrefix); k != nil && bytes.HasPrefix(k, dekPrefix); k, _ = c.Next() {
+		if !bytes.Equal(k, dekKey(active)) {
+			doomed = append(doomed, append([]byte(nil), k...))
+		}
+	}
+	for _, k := range doomed {
+		if err := meta.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func countDataKeys(tx *bolt.Tx) int {
+	n := 0
+	c := tx.Bucket(metaBucket).Cursor()
+	for k, _ := c.Seek(dekPrefix); k != nil && bytes.HasPrefix(k, dekPrefix); k, _ = c.Next() {
+		n++
+	}
+	return n
+}
+
+func dekKey(id uint32) []byte {
+	return []byte(fmt.Sprintf("%s%010d", dekPrefix, id))
+}
+
+// putDEK wraps raw with wrapper, binding it to its meta key
+func putDEK(meta *bolt.Bucket, wrapper kek, key, raw []byte) error {
+	nonce := make([]byte, wrapper.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := json.Marshal(wrappedDEK{
+		KEK: wrapper.id,
+		Key: wrapper.aead.Seal(nonce, nonce, raw, key),
+	})
+	if err != nil {
+		return err
+	}
+	return meta.Put(key, data)
+}
+
+func unwrapKey(wrapper kek, key, wrapped []byte) ([]byte, error) {
+	n := wrapper.aead.NonceSize()
+	if len(wrapped) < n {
+		return nil, fmt.Errorf("wrapped key too short")
+	}
+	return wrapper.aead.Open(nil, wrapped[:n], wrapped[n:], key)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
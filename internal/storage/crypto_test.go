@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestEncryptionMigrationAndRotation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	keyFile := filepath.Join(dir, "keys")
+	keyA, _ := GenerateKey()
+	keyB, _ := GenerateKey()
+
+	open := func(keys ...string) *BoltStorage {
+		t.Helper()
+		if err := os.WriteFile(keyFile, []byte(joinLines(keys)), 0600); err != nil {
+			t.Fatal(err)
+		}
+		keyring, err := LoadKeyring(keyFile)
+		if err != nil {
+			t.Fatalf("LoadKeyring: %v", err)
+		}
+		store, err := OpenBoltStorage(path, BoltOptions{Keys: keyring})
+		if err != nil {
+			t.Fatalf("OpenBoltStorage: %v", err)
+		}
+		return store
+	}
+
+	// Start with a plaintext database
+	store, err := NewBoltStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	mustStore(t, store, "s", makeLogs("s", 20, now.Add(-time.Minute)))
+	if err := store.UpdateContext(ctx, "s", &StreamContext{StreamID: "s", Analyses: []AnalysisSummary{{Summary: "secret context"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.StoreAnalysis(ctx, &Analysis{StreamID: "s", Timestamp: now, Summary: "secret summary"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// Plaintext and sealed values coexist until the background pass finishes
+	store = open(keyA)
+	mustStore(t, store, "s", makeLogs("s", 5, now))
+	if got := mustGet(t, store, "s", GetLogsOptions{}); len(got) != 25 {
+		t.Fatalf("got %d logs before re-encryption, want 25", len(got))
+	}
+	store.StartReencryption()
+	waitReencrypted(t, store)
+	assertSealed(t, store)
+	store.Close()
+
+	if _, err := NewBoltStorage(path); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("opening without a key: got %v, want ErrNoKey", err)
+	}
+
+	// Rotate: prepend the new key, rotate, then drop the old key
+	store = open(keyA)
+	if err := os.WriteFile(keyFile, []byte(joinLines([]string{keyB, keyA})), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RotateKey(ctx); err != nil {
+		t.Fatalf("RotateKey: %v", err)
+	}
+	waitReencrypted(t, store)
+	status := store.EncryptionStatus()
+	if status.DataKeys != 1 || status.LastError != "" {
+		t.Fatalf("after rotation: %+v", status)
+	}
+	assertSealed(t, store)
+	store.Close()
+
+	store = open(keyB)
+	defer store.Close()
+	if got := mustGet(t, store, "s", GetLogsOptions{}); len(got) != 25 {
+		t.Fatalf("got %d logs after rotation, want 25", len(got))
+	}
+	streamCtx, err := store.GetContext(ctx, "s")
+	if err != nil || len(streamCtx.Analyses) != 1 || streamCtx.Analyses[0].Summary != "secret context" {
+		t.Fatalf("GetContext after rotation: %v, %+v", err, streamCtx)
+	}
+	history, err := store.GetAnalysisHistory(ctx, "s", 0)
+	if err != nil || len(history) != 1 || history[0].Summary != "secret summary" {
+		t.Fatalf("GetAnalysisHistory after rotation: %v, %+v", err, history)
+	}
+}
+
+func joinLines(lines []string) string {
+	var b bytes.Buffer
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+func waitReencrypted(t *testing.T, store *BoltStorage) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for store.EncryptionStatus().Reencrypting {
+		if time.Now().After(deadline) {
+			t.Fatal("re-encryption did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// assertSealed checks every log, context and analysis value is sealed with
+// the active data key
+func assertSealed(t *testing.T, store *BoltStorage) {
+	t.Helper()
+	store.db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{logsBucketName("s"), contextBucket, analysisBucket} {
+			tx.Bucket(name).ForEach(func(k, v []byte) error {
+				if store.crypt.stale(v) {
+					t.Errorf("%s/%s is not sealed with the active key", name, k)
+				}
+				if bytes.Contains(v, []byte("secret")) || bytes.Contains(v, []byte("line ")) {
+					t.Errorf("%s/%s leaks plaintext", name, k)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+func TestArchiveEncryption(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	keyFile := filepath.Join(dir, "keys")
+	keyA, _ := GenerateKey()
+	keyB, _ := GenerateKey()
+	logs := makeLogs("s", 20, time.Now())
+
+	open := func(keys ...string) (*BoltStorage, *SegmentArchive) {
+		t.Helper()
+		os.WriteFile(keyFile, []byte(joinLines(keys)), 0600)
+		keyring, _ := LoadKeyring(keyFile)
+		store, err := OpenBoltStorage(path, BoltOptions{Keys: keyring})
+		if err != nil {
+			t.Fatalf("OpenBoltStorage: %v", err)
+		}
+		archive, err := NewSegmentArchive(filepath.Join(dir, "archive"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.EncryptArchive(archive)
+		return store, archive
+	}
+
+	// Segments archived before encryption was enabled are plaintext
+	store, archive := open()
+	if err := archive.Write("s", logs[:10]); err != nil {
+		t.Fatal(err)
+	}
+	segments, _ := archive.segments("s")
+	if blocks := readRawBlocks(t, segments[0].path); !bytes.Contains(decodeBlock(archive, blocks[0]), []byte(`"message":"line 9"`)) {
+		t.Fatal("expected a plaintext segment to decode without keys")
+	}
+	store.Close()
+
+	// New segments are sealed, old ones resealed in the background
+	store, archive = open(keyA)
+	if err := archive.Write("s", logs[10:]); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := archive.Read(ctx, "s", GetLogsOptions{}, 0); err != nil || len(got) != 20 {
+		t.Fatalf("Read before re-encryption = %d lines, %v", len(got), err)
+	}
+	store.StartReencryption()
+	waitReencrypted(t, store)
+	assertArchiveSealed(t, store, archive)
+	store.Close()
+
+	// Rotation reseals segments before the old data key is retired
+	store, archive = open(keyA)
+	os.WriteFile(keyFile, []byte(joinLines([]string{keyB, keyA})), 0600)
+	if err := store.RotateKey(ctx); err != nil {
+		t.Fatalf("RotateKey: %v", err)
+	}
+	waitReencrypted(t, store)
+	if status := store.EncryptionStatus(); status.DataKeys != 1 || status.LastError != "" {
+		t.Fatalf("after rotation: %+v", status)
+	}
+	assertArchiveSealed(t, store, archive)
+	store.Close()
+
+	store, archive = open(keyB)
+	defer store.Close()
+	got, err := archive.Read(ctx, "s", GetLogsOptions{}, 0)
+	if err != nil || len(got) != 20 || got[19].Message != "line 19" {
+		t.Fatalf("Read after rotation = %d lines, %v", len(got), err)
+	}
+
+	// Without the keys, archived lines cannot be read
+	plain, _ := NewSegmentArchive(filepath.Join(dir, "archive"))
+	if _, err := plain.Read(ctx, "s", GetLogsOptions{}, 0); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Read without keys: got %v, want ErrNoKey", err)
+	}
+}
+
+// assertArchiveSealed checks every block of stream "s" in archive is sealed
+// with the active data key and does not decompress to its lines
+func assertArchiveSealed(t *testing.T, store *BoltStorage, archive *SegmentArchive) {
+	t.Helper()
+	segments, err := archive.segments("s")
+	if err != nil || len(segments) == 0 {
+		t.Fatalf("segments = %v, %v", segments, err)
+	}
+	for _, seg := range segments {
+		for i, block := range readRawBlocks(t, seg.path) {
+			if store.crypt.stale(block) {
+				t.Errorf("%s block %d is not sealed with the active key", seg.path, i)
+			}
+			if plain := decodeBlock(archive, block); plain != nil || bytes.Contains(block, []byte("line ")) {
+				t.Errorf("%s block %d leaks plaintext", seg.path, i)
+			}
+		}
+	}
+}
+
+// readRawBlocks returns the blocks of a segment file as stored on disk
+func readRawBlocks(t *testing.T, path string) [][]byte {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.Open(path)
+	defer f.Close()
+	index, err := readSegmentIndex(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks [][]byte
+	for _, block := range index.Blocks {
+		blocks = append(blocks, raw[block.Offset:block.Offset+block.Length])
+	}
+	return blocks
+}
+
+// decodeBlock decompresses a block without opening it, returning nil if it
+// is not plain zstd
+func decodeBlock(archive *SegmentArchive, block []byte) []byte {
+	plain, err := archive.decoder.DecodeAll(block, nil)
+	if err != nil {
+		return nil
+	}
+	return plain
+}
+
+func TestStoreLogsRejectsUnreadableContext(t *testing.T) {
+	store, err := NewBoltStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	mustStore(t, store, "s", makeLogs("s", 3, time.Now().Add(-time.Minute)))
+	store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(contextBucket).Put([]byte("s"), []byte("{not json"))
+	})
+
+	if err := store.StoreLogs(context.Background(), "s", makeLogs("s", 3, time.Now())); err == nil {
+		t.Fatal("StoreLogs overwrote an unreadable context")
+	}
+	if got := mustGet(t, store, "s", GetLogsOptions{}); len(got) != 3 {
+		t.Errorf("%d logs after the failed store, want the original 3", len(got))
+	}
+	store.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(contextBucket).Get([]byte("s")); string(v) != "{not json" {
+			t.Errorf("context replaced with %q", v)
+		}
+		return nil
+	})
+}
+
+func TestMergeStreamsRejectsUnreadableLogs(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewBoltStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	logs := makeLogs("src", 3, time.Now())
+	mustStore(t, store, "src", logs)
+	mustStore(t, store, "dst", makeLogs("dst", 1, time.Now().Add(-time.Hour)))
+	key := []byte(logs[1].Timestamp.Format(time.RFC3339Nano))
+	store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(logsBucketName("src")).Put(key, []byte{0x7f, 0})
+	})
+
+	if err := store.MergeStreams(ctx, "src", "dst"); err == nil {
+		t.Fatal("merged a stream with an unreadable log")
+	}
+	if _, err := store.GetStream(ctx, "src"); err != nil {
+		t.Errorf("source deleted by the failed merge: %v", err)
+	}
+	store.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(logsBucketName("src")).Get(key); !bytes.Equal(v, []byte{0x7f, 0}) {
+			t.Errorf("unreadable log = %q", v)
+		}
+		return nil
+	})
+	if got := mustGet(t, store, "dst", GetLogsOptions{}); len(got) != 1 {
+		t.Errorf("destination has %d logs after the failed merge, want 1", len(got))
+	}
+}
@@ -0,0 +1,20 @@
+package storage
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when a stream or rule does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrInvalid is returned for malformed arguments such as an unknown
+	// histogram resolution or retention scope
+	ErrInvalid = errors.New("invalid argument")
+
+	// ErrConflict is returned when a request contradicts existing state,
+	// such as merging a stream into itself
+	ErrConflict = errors.New("conflict")
+
+	// ErrRemoved is returned by StoreLogs for a stream that was deleted or
+	// merged into another; ResolveStream tells which
+	ErrRemoved = errors.New("stream removed")
+)
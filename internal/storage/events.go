@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// eventRetention is how long events are kept; saving an event prunes the
+// stream's older ones
+const eventRetention = 90 * 24 * time.Hour
+
+// Validate checks an event before it is saved
+func (e *Event) Validate() error {
+	if e.ID == "" || e.StreamID == "" || e.Type == "" {
+		return fmt.Errorf("%w: event needs an ID, a stream and a type", ErrInvalid)
+	}
+	if e.Timestamp.IsZero() {
+		return fmt.Errorf("%w: event needs a timestamp", ErrInvalid)
+	}
+	return nil
+}
+
+// match reports whether e passes the filters other than Limit
+func (o ListEventsOptions) match(e *Event) bool {
+	if o.StreamID != "" && e.StreamID != o.StreamID {
+		return false
+	}
+	if o.Type != "" && e.Type != o.Type {
+		return false
+	}
+	return o.Since.IsZero() || !e.Timestamp.Before(o.Since)
+}
+
+// sortEvents orders events newest first and applies the limit
+func sortEvents(events []Event, limit int) []Event {
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Timestamp.Equal(events[j].Timestamp) {
+			return events[i].Timestamp.After(events[j].Timestamp)
+		}
+		return events[i].ID > events[j].ID
+	})
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events
+}
+
+// validateFingerprints checks fingerprints before they are recorded
+func validateFingerprints(fingerprints []string) error {
+	for _, fp := range fingerprints {
+		if fp == "" {
+			return fmt.Errorf("%w: empty fingerprint", ErrInvalid)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// exportFormatVersion is written in the header record of every archive
+const exportFormatVersion = 1
+
+// importBatchSize is how many log lines are written per StoreLogs call,
+// exportPageLines how many Export reads per GetLogs call
+const (
+	importBatchSize = 1000
+	exportPageLines = 10000
+)
+
+// ExportRecord is one line of an NDJSON export archive
+type ExportRecord struct {
+	Type       string         `json:"type"` // header, stream, log, analysis, context
+	Version    int            `json:"version,omitempty"`
+	ExportedAt *time.Time     `json:"exported_at,omitempty"`
+	Stream     *Stream        `json:"stream,omitempty"`
+	Log        *LogLine       `json:"log,omitempty"`
+	Analysis   *Analysis      `json:"analysis,omitempty"`
+	Context    *StreamContext `json:"context,omitempty"`
+}
+
+// TransferFilter limits which streams and time range are exported or imported
+type TransferFilter struct {
+	Streams []string // Empty means all streams
+	Since   time.Time
+	Until   time.Time
+}
+
+// TransferStats counts records moved by Export or Import
+type TransferStats struct {
+	Streams  int `json:"streams"`
+	Logs     int `json:"logs"`
+	Analyses int `json:"analyses"`
+	Contexts int `json:"contexts"`
+}
+
+func (f TransferFilter) wantStream(streamID string) bool {
+	return len(f.Streams) == 0 || contains(f.Streams, streamID)
+}
+
+func (f TransferFilter) wantTime(t time.Time) bool {
+	if !f.Since.IsZero() && t.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && t.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Export writes streams, logs, analyses and contexts to w as NDJSON. Each
+// stream's context comes last so importing it restores the original counters.
+func Export(ctx context.Context, store Storage, w io.Writer, filter TransferFilter) (*TransferStats, error) {
+	enc := json.NewEncoder(w)
+	stats := &TransferStats{}
+
+	now := time.Now()
+	if err := enc.Encode(ExportRecord{Type: "header", Version: exportFormatVersion, ExportedAt: &now}); err != nil {
+		return stats, err
+	}
+
+	streams, err := store.ListStreams(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	for i := range streams {
+		stream := streams[i]
+		if !filter.wantStream(stream.ID) {
+			continue
+		}
+
+		if err := enc.Encode(ExportRecord{Type: "stream", Stream: &stream}); err != nil {
+			return stats, err
+		}
+		stats.Streams++
+
+		logs, err := exportLogs(ctx, store, enc, stream.ID, filter)
+		stats.Logs += logs
+		if err != nil {
+			return stats, err
+		}
+
+		analyses, err := store.GetAnalysisHistory(ctx, stream.ID, 0)
+		if err != nil {
+			return stats, fmt.Errorf("failed to read analyses of %s: %w", stream.ID, err)
+		}
+		for j := range analyses {
+			if !filter.wantTime(analyses[j].Timestamp) {
+				continue
+			}
+			if err := enc.Encode(ExportRecord{Type: "analysis", Analysis: &analyses[j]}); err != nil {
+				return stats, err
+			}
+			stats.Analyses++
+		}
+
+		streamCtx, err := store.GetContext(ctx, stream.ID)
+		if err != nil {
+			return stats, fmt.Errorf("failed to read context of %s: %w", stream.ID, err)
+		}
+		if err := enc.Encode(ExportRecord{Type: "context", Context: streamCtx}); err != nil {
+			return stats, err
+		}
+		stats.Contexts++
+	}
+
+	return stats, nil
+}
+
+// exportLogs writes a stream's logs a page at a time, newest page first, so
+// long histories are never read into memory whole. It returns the number
+// of lines written.
+func exportLogs(ctx context.Context, store Storage, enc *json.Encoder, streamID string, filter TransferFilter) (int, error) {
+	written := 0
+	until := filter.Until
+	for {
+		logs, err := store.GetLogs(ctx, streamID, GetLogsOptions{Since: filter.Since, Until: until, Limit: exportPageLines})
+		if err != nil {
+			return written, fmt.Errorf("failed to read logs of %s: %w", streamID, err)
+		}
+		for i := range logs {
+			if err := enc.Encode(ExportRecord{Type: "log", Log: &logs[i]}); err != nil {
+				return written, err
+			}
+			written++
+		}
+		if len(logs) < exportPageLines {
+			return written, nil
+		}
+		// Timestamps are unique within a stream
+		until = logs[0].Timestamp.Add(-time.Nanosecond)
+	}
+}
+
+// Import loads an archive written by Export into store
+func Import(ctx context.Context, store Storage, r io.Reader, filter TransferFilter) (*TransferStats, error) {
+	stats := &TransferStats{}
+	pending := make(map[string][]LogLine)
+
+	flush := func(streamID string) error {
+		if len(pending[streamID]) == 0 {
+			return nil
+		}
+		if err := store.StoreLogs(ctx, streamID, pending[streamID]); err != nil {
+			return fmt.Errorf("failed to import logs of %s: %w", streamID, err)
+		}
+		stats.Logs += len(pending[streamID])
+		delete(pending, streamID)
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var rec ExportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return stats, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		switch rec.Type {
+		case "header":
+			if rec.Version > exportFormatVersion {
+				return stats, fmt.Errorf("archive format v%d is newer than supported (v%d)", rec.Version, exportFormatVersion)
+			}
+
+		case "stream":
+			if rec.Stream == nil || !filter.wantStream(rec.Stream.ID) {
+				continue
+			}
+			if err := store.UpdateStream(ctx, rec.Stream); err != nil {
+				return stats, err
+			}
+			stats.Streams++
+
+		case "log":
+			if rec.Log == nil || !filter.wantStream(rec.Log.StreamID) || !filter.wantTime(rec.Log.Timestamp) {
+				continue
+			}
+			pending[rec.Log.StreamID] = append(pending[rec.Log.StreamID], *rec.Log)
+			if len(pending[rec.Log.StreamID]) >= importBatchSize {
+				if err := flush(rec.Log.StreamID); err != nil {
+					return stats, err
+				}
+			}
+
+		case "analysis":
+			if rec.Analysis == nil || !filter.wantStream(rec.Analysis.StreamID) || !filter.wantTime(rec.Analysis.Timestamp) {
+				continue
+			}
+			if err := store.StoreAnalysis(ctx, rec.Analysis); err != nil {
+				return stats, err
+			}
+			stats.Analyses++
+
+		case "context":
+			if rec.Context == nil || !filter.wantStream(rec.Context.StreamID) {
+				continue
+			}
+			// Logs must land before the context so its counters win
+			if err := flush(rec.Context.StreamID); err != nil {
+				return stats, err
+			}
+			if err := store.UpdateContext(ctx, rec.Context.StreamID, rec.Context); err != nil {
+				return stats, err
+			}
+			stats.Contexts++
+
+		default:
+			return stats, fmt.Errorf("line %d: unknown record type %q", line, rec.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, err
+	}
+
+	for streamID := range pending {
+		if err := flush(streamID); err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newExportSource returns a store with two streams, one long enough that
+// Export has to page through its logs
+func newExportSource(t *testing.T, now time.Time) Storage {
+	t.Helper()
+	ctx := context.Background()
+	store := NewMemoryStorage()
+	mustStore(t, store, "a", makeLogs("a", exportPageLines+50, now))
+	mustStore(t, store, "b", makeLogs("b", 5, now))
+	for _, id := range []string{"a", "b"} {
+		store.UpdateStream(ctx, &Stream{ID: id, Name: "stream " + id, Source: "file", Active: true})
+		store.StoreAnalysis(ctx, &Analysis{Timestamp: now, StreamID: id, Summary: "all quiet on " + id, Severity: "P3"})
+		store.MutateContext(ctx, id, func(streamCtx *StreamContext) error {
+			streamCtx.TotalLogs = 123456
+			streamCtx.Patterns.CommonErrors = []string{"disk full"}
+			return nil
+		})
+	}
+	return store
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	src := newExportSource(t, now)
+
+	var buf bytes.Buffer
+	stats, err := Export(ctx, src, &buf, TransferFilter{})
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	want := TransferStats{Streams: 2, Logs: exportPageLines + 55, Analyses: 2, Contexts: 2}
+	if *stats != want {
+		t.Fatalf("Export = %+v, want %+v", *stats, want)
+	}
+
+	// Every line is one JSON record, the header first
+	scanner := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for n := 0; scanner.Scan(); n++ {
+		var rec ExportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("line %d: %v", n+1, err)
+		}
+		if (n == 0) != (rec.Type == "header") {
+			t.Fatalf("line %d has type %q", n+1, rec.Type)
+		}
+	}
+
+	dst := NewMemoryStorage()
+	stats, err = Import(ctx, dst, bytes.NewReader(buf.Bytes()), TransferFilter{})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if *stats != want {
+		t.Fatalf("Import = %+v, want %+v", *stats, want)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		in, out := mustGet(t, src, id, GetLogsOptions{}), mustGet(t, dst, id, GetLogsOptions{})
+		if len(out) != len(in) {
+			t.Fatalf("stream %s: imported %d logs, want %d", id, len(out), len(in))
+		}
+		for i := range in {
+			if !out[i].Timestamp.Equal(in[i].Timestamp) || out[i].Message != in[i].Message || out[i].Level != in[i].Level || out[i].Labels["pod"] != "api-1" {
+				t.Fatalf("stream %s log %d = %+v, want %+v", id, i, out[i], in[i])
+			}
+		}
+
+		if stream, err := dst.GetStream(ctx, id); err != nil || stream.Name != "stream "+id || stream.Source != "file" {
+			t.Errorf("stream %s = %+v, %v", id, stream, err)
+		}
+		if history, _ := dst.GetAnalysisHistory(ctx, id, 0); len(history) != 1 || history[0].Summary != "all quiet on "+id {
+			t.Errorf("stream %s analyses = %+v", id, history)
+		}
+		// The context is imported after the logs, so its counters win
+		if streamCtx, _ := dst.GetContext(ctx, id); streamCtx.TotalLogs != 123456 || len(streamCtx.Patterns.CommonErrors) != 1 {
+			t.Errorf("stream %s context = %+v", id, streamCtx)
+		}
+	}
+}
+
+func TestExportImportFilters(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	src := newExportSource(t, now)
+
+	var buf bytes.Buffer
+	stats, err := Export(ctx, src, &buf, TransferFilter{Streams: []string{"a"}, Since: now.Add(-99 * time.Second)})
+	if err != nil || stats.Streams != 1 || stats.Logs != 100 {
+		t.Fatalf("filtered Export = %+v, %v; want 1 stream, 100 logs", stats, err)
+	}
+
+	// Import filters too
+	dst := NewMemoryStorage()
+	stats, err = Import(ctx, dst, bytes.NewReader(buf.Bytes()), TransferFilter{Until: now.Add(-90 * time.Second)})
+	if err != nil || stats.Logs != 10 {
+		t.Fatalf("filtered Import = %+v, %v; want 10 logs", stats, err)
+	}
+	if got := mustGet(t, dst, "a", GetLogsOptions{}); len(got) != 10 || !got[9].Timestamp.Equal(now.Add(-90*time.Second)) {
+		t.Errorf("imported %d logs", len(got))
+	}
+
+	for name, archive := range map[string]string{
+		"newer format": `{"type":"header","version":99}`,
+		"unknown type": `{"type":"header","version":1}` + "\n" + `{"type":"bogus"}`,
+		"bad JSON":     `{"type":`,
+	} {
+		if _, err := Import(ctx, NewMemoryStorage(), strings.NewReader(archive), TransferFilter{}); err == nil {
+			t.Errorf("%s: imported without error", name)
+		}
+	}
+}
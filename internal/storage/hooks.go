@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// StoreHook is called with logs that were just stored. It runs on the
+// writer's goroutine, so it should hand slow work off elsewhere.
+type StoreHook func(streamID string, logs []LogLine)
+
+// BeforeStoreHook is called with logs about to be stored and may change
+// them, for example to add labels. The slice is the hook's own copy, but the
+// Labels maps are shared with the caller and must be replaced, not written.
+type BeforeStoreHook func(ctx context.Context, streamID string, logs []LogLine)
+
+// HookedStorage calls hooks before and after every StoreLogs, so watchers
+// can follow incoming logs without polling
+type HookedStorage struct {
+	Storage
+
+	mu     sync.RWMutex
+	before []BeforeStoreHook
+	hooks  []StoreHook
+}
+
+func NewHookedStorage(s Storage) *HookedStorage {
+	return &HookedStorage{Storage: s}
+}
+
+// Unwrap returns the wrapped backend
+func (h *HookedStorage) Unwrap() Storage {
+	return h.Storage
+}
+
+// OnStore registers a hook for logs stored from now on
+func (h *HookedStorage) OnStore(hook StoreHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, hook)
+}
+
+// BeforeStore registers a hook for logs stored from now on
+func (h *HookedStorage) BeforeStore(hook BeforeStoreHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.before = append(h.before, hook)
+}
+
+func (h *HookedStorage) StoreLogs(ctx context.Context, streamID string, logs []LogLine) error {
+	h.mu.RLock()
+	before, hooks := h.before, h.hooks
+	h.mu.RUnlock()
+
+	if len(before) > 0 {
+		logs = slices.Clone(logs)
+		for _, hook := range before {
+			hook(ctx, streamID, logs)
+		}
+	}
+	if err := h.Storage.StoreLogs(ctx, streamID, logs); err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		hook(streamID, logs)
+	}
+	return nil
+}
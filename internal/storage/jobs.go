@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Finished reports whether the job is done or failed
+func (j *AnalysisJob) Finished() bool {
+	return j.Status == JobDone || j.Status == JobFailed
+}
+
+// Validate checks a job before it is saved
+func (j *AnalysisJob) Validate() error {
+	if j.ID == "" || j.StreamID == "" {
+		return fmt.Errorf("%w: job needs an ID and a stream", ErrInvalid)
+	}
+	switch j.Status {
+	case JobQueued, JobRunning, JobDone, JobFailed:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown job status %q", ErrInvalid, j.Status)
+}
+
+// jobFinishedBefore reports whether PruneJobs should delete job
+func jobFinishedBefore(job *AnalysisJob, before time.Time) bool {
+	return job.Finished() && job.FinishedAt.Before(before)
+}
+
+// Validate checks a digest before it is saved
+func (d *Digest) Validate() error {
+	if d.ID == "" {
+		return fmt.Errorf("%w: digest needs an ID", ErrInvalid)
+	}
+	return nil
+}
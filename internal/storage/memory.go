@@ -0,0 +1,946 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryLog is a log line with the key BoltStorage would store it under, so
+// ordering and same-timestamp overwrites behave identically
+type memoryLog struct {
+	key string
+	log LogLine
+}
+
+// MemoryStorage keeps everything in process memory. It follows the same
+// semantics as BoltStorage and is meant for tests and throwaway sessions.
+type MemoryStorage struct {
+	mu        sync.RWMutex
+	streams   map[string]Stream
+	logs      map[string][]memoryLog // sorted by key
+	contexts  map[string]StreamContext
+	analyses  map[string]Analysis // keyed like the bolt analysis bucket
+	retention map[string]RetentionRule
+	rollups   map[string]map[string]RollupBucket
+	jobs      map[string]AnalysisJob
+	digests   map[string]Digest
+	patterns  map[string][]LogPattern
+	events    map[string][]Event
+	prints    map[string]map[string]time.Time // stream -> fingerprint -> first seen
+	removed   map[string]Tombstone
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		streams:   make(map[string]Stream),
+		logs:      make(map[string][]memoryLog),
+		contexts:  make(map[string]StreamContext),
+		analyses:  make(map[string]Analysis),
+		retention: make(map[string]RetentionRule),
+		rollups:   make(map[string]map[string]RollupBucket),
+		jobs:      make(map[string]AnalysisJob),
+		digests:   make(map[string]Digest),
+		patterns:  make(map[string][]LogPattern),
+		events:    make(map[string][]Event),
+		prints:    make(map[string]map[string]time.Time),
+		removed:   make(map[string]Tombstone),
+	}
+}
+
+func (m *MemoryStorage) Close() error {
+	return nil
+}
+
+func (m *MemoryStorage) StoreLogs(ctx context.Context, streamID string, logs []LogLine) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.removed[streamID]; ok {
+		return t.err()
+	}
+
+	// Only lines that did not overwrite one are counted
+	var added []LogLine
+	errorCount := 0
+	for _, log := range logs {
+		log.StreamID = streamID
+		log.Labels = copyLabels(log.Labels)
+		if !m.putLog(streamID, log) {
+			continue
+		}
+		added = append(added, log)
+		if log.Level == "ERROR" || log.Level == "FATAL" {
+			errorCount++
+		}
+	}
+
+	m.addRollups(streamID, added)
+
+	stream, ok := m.streams[streamID]
+	if !ok {
+		stream = Stream{ID: streamID, Active: true}
+	}
+	stream.LastSeen = time.Now()
+	m.streams[streamID] = stream
+
+	// Like BoltStorage, counters only move once a context exists
+	if streamCtx, ok := m.contexts[streamID]; ok {
+		streamCtx.TotalLogs += int64(len(added))
+		streamCtx.ErrorCount += int64(errorCount)
+		streamCtx.LastSeen = time.Now()
+		if streamCtx.TotalLogs > 0 {
+			streamCtx.Patterns.ErrorRate = float64(streamCtx.ErrorCount) / float64(streamCtx.TotalLogs)
+		}
+		m.contexts[streamID] = streamCtx
+	}
+
+	return nil
+}
+
+// putLog inserts a log in key order, replacing any log with the same key.
+// It reports whether the key was new.
+func (m *MemoryStorage) putLog(streamID string, log LogLine) bool {
+	entries := m.logs[streamID]
+	key := log.Timestamp.Format(time.RFC3339Nano)
+
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].key >= key })
+	if i < len(entries) && entries[i].key == key {
+		entries[i].log = log
+		return false
+	}
+
+	entries = append(entries, memoryLog{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = memoryLog{key: key, log: log}
+	m.logs[streamID] = entries
+	return true
+}
+
+func (m *MemoryStorage) addRollups(streamID string, logs []LogLine) {
+	pending := make(map[string]*RollupBucket)
+	addToRollups(pending, logs)
+
+	rollups, ok := m.rollups[streamID]
+	if !ok {
+		rollups = make(map[string]RollupBucket)
+		m.rollups[streamID] = rollups
+	}
+	for key, bucket := range pending {
+		if existing, ok := rollups[key]; ok {
+			bucket.merge(&existing)
+		}
+		rollups[key] = *bucket
+	}
+
+	// Prune expired buckets
+	now := time.Now()
+	for key, bucket := range rollups {
+		keep := minuteRollupRetention
+		if strings.HasPrefix(key, ResolutionHour[:1]) {
+			keep = hourRollupRetention
+		}
+		if now.Sub(bucket.Start) > keep {
+			delete(rollups, key)
+		}
+	}
+}
+
+func (m *MemoryStorage) GetLogs(ctx context.Context, streamID string, opts GetLogsOptions) ([]LogLine, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var logs []LogLine
+	entries := m.logs[streamID]
+	for i := len(entries) - 1; i >= 0 && (opts.Limit == 0 || len(logs) < opts.Limit); i-- {
+		log := entries[i].log
+
+		if !opts.Since.IsZero() && log.Timestamp.Before(opts.Since) {
+			break
+		}
+		if !opts.Until.IsZero() && log.Timestamp.After(opts.Until) {
+			continue
+		}
+		if !opts.matches(log) {
+			continue
+		}
+
+		log.Labels = copyLabels(log.Labels)
+		logs = append(logs, log)
+	}
+
+	// Collected newest first
+	for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+		logs[i], logs[j] = logs[j], logs[i]
+	}
+	return logs, nil
+}
+
+func (m *MemoryStorage) ListStreams(ctx context.Context) ([]Stream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.streams))
+	for id := range m.streams {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	now := time.Now()
+	var streams []Stream
+	for _, id := range ids {
+		stream := m.streams[id]
+		stream.Labels = copyLabels(stream.Labels)
+
+		if _, ok := m.rollups[id]; ok {
+			minutes := m.readRollups(id, ResolutionMinute, now.Add(-time.Hour))
+			hours := m.readRollups(id, ResolutionHour, now.Add(-24*time.Hour))
+			applyRollupStats(&stream, minutes, hours, now)
+		}
+
+		if streamCtx, ok := m.contexts[id]; ok && len(streamCtx.Analyses) > 0 {
+			latest := streamCtx.Analyses[len(streamCtx.Analyses)-1]
+			stream.ContextSummary = fmt.Sprintf("Last: %s (%s)", latest.Summary, latest.Severity)
+		}
+
+		streams = append(streams, stream)
+	}
+
+	return streams, nil
+}
+
+func (m *MemoryStorage) GetStream(ctx context.Context, streamID string) (*Stream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stream, ok := m.streams[streamID]
+	if !ok {
+		return nil, fmt.Errorf("stream %q: %w", streamID, ErrNotFound)
+	}
+	stream.Labels = copyLabels(stream.Labels)
+	return &stream, nil
+}
+
+func (m *MemoryStorage) UpdateStream(ctx context.Context, stream *Stream) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	updated := *stream
+	updated.Labels = copyLabels(stream.Labels)
+	m.streams[stream.ID] = updated
+	delete(m.removed, stream.ID)
+	return nil
+}
+
+func (m *MemoryStorage) PatchStream(ctx context.Context, streamID string, patch StreamPatch) (*Stream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stream, ok := m.streams[streamID]
+	if !ok {
+		return nil, fmt.Errorf("stream %q: %w", streamID, ErrNotFound)
+	}
+	patch.apply(&stream)
+	stream.Labels = copyLabels(stream.Labels)
+	m.streams[streamID] = stream
+
+	result := stream
+	result.Labels = copyLabels(stream.Labels)
+	return &result, nil
+}
+
+func (m *MemoryStorage) DeleteStream(ctx context.Context, streamID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.streams[streamID]; !ok {
+		return fmt.Errorf("stream %q: %w", streamID, ErrNotFound)
+	}
+	m.deleteStream(streamID)
+	m.removed[streamID] = Tombstone{StreamID: streamID, At: time.Now()}
+	return nil
+}
+
+func (m *MemoryStorage) GetTombstone(ctx context.Context, streamID string) (*Tombstone, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.removed[streamID]
+	if !ok {
+		return nil, fmt.Errorf("stream %q has no tombstone: %w", streamID, ErrNotFound)
+	}
+	return &t, nil
+}
+
+func (m *MemoryStorage) deleteStream(streamID string) {
+	delete(m.streams, streamID)
+	delete(m.logs, streamID)
+	delete(m.contexts, streamID)
+	delete(m.rollups, streamID)
+	delete(m.patterns, streamID)
+	delete(m.events, streamID)
+	delete(m.prints, streamID)
+	delete(m.retention, string(retentionKey(ScopeStream, streamID)))
+
+	prefix := streamID + ":"
+	for key := range m.analyses {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.analyses, key)
+		}
+	}
+}
+
+func (m *MemoryStorage) MergeStreams(ctx context.Context, srcID, dstID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if srcID == dstID {
+		return fmt.Errorf("cannot merge a stream into itself: %w", ErrConflict)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range []string{srcID, dstID} {
+		if _, ok := m.streams[id]; !ok {
+			return fmt.Errorf("stream %q: %w", id, ErrNotFound)
+		}
+	}
+
+	// Logs: nudge colliding timestamps rather than overwrite
+	for _, entry := range m.logs[srcID] {
+		log := entry.log
+		log.StreamID = dstID
+		for m.hasLog(dstID, log.Timestamp) {
+			log.Timestamp = log.Timestamp.Add(time.Nanosecond)
+		}
+		m.putLog(dstID, log)
+	}
+
+	// Rollups
+	if m.rollups[dstID] == nil {
+		m.rollups[dstID] = make(map[string]RollupBucket)
+	}
+	for key, bucket := range m.rollups[srcID] {
+		if existing, ok := m.rollups[dstID][key]; ok {
+			bucket.merge(&existing)
+		}
+		m.rollups[dstID][key] = bucket
+	}
+
+	// Analyses
+	prefix := srcID + ":"
+	for key, analysis := range m.analyses {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		analysis.StreamID = dstID
+		newKey := string(analysisKey(&analysis))
+		for _, exists := m.analyses[newKey]; exists; _, exists = m.analyses[newKey] {
+			newKey += "~"
+		}
+		m.analyses[newKey] = analysis
+	}
+
+	// Events
+	for _, e := range m.events[srcID] {
+		e.StreamID = dstID
+		m.events[dstID] = append(m.events[dstID], e)
+	}
+
+	// Fingerprints, keeping the earlier first-seen time
+	if len(m.prints[srcID]) > 0 && m.prints[dstID] == nil {
+		m.prints[dstID] = make(map[string]time.Time)
+	}
+	for fp, first := range m.prints[srcID] {
+		if existing, ok := m.prints[dstID][fp]; !ok || first.Before(existing) {
+			m.prints[dstID][fp] = first
+		}
+	}
+
+	// Context
+	if src, ok := m.contexts[srcID]; ok {
+		dst, ok := m.contexts[dstID]
+		if !ok {
+			dst = StreamContext{StreamID: dstID}
+		}
+		mergeContexts(&dst, &src)
+		m.contexts[dstID] = copyContext(dst)
+	}
+
+	m.deleteStream(srcID)
+	m.removed[srcID] = Tombstone{StreamID: srcID, MergedInto: dstID, At: time.Now()}
+	return nil
+}
+
+func (m *MemoryStorage) hasLog(streamID string, ts time.Time) bool {
+	entries := m.logs[streamID]
+	key := ts.Format(time.RFC3339Nano)
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].key >= key })
+	return i < len(entries) && entries[i].key == key
+}
+
+func (m *MemoryStorage) GetHistogram(ctx context.Context, streamID string, resolution string, since time.Time) ([]RollupBucket, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	step, err := histogramStep(resolution, since)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	buckets := m.readRollups(streamID, resolution, since)
+	m.mu.RUnlock()
+
+	return fillRollupGaps(buckets, step, since, time.Now()), nil
+}
+
+// readRollups returns a stream's buckets of one resolution from since on, oldest first
+func (m *MemoryStorage) readRollups(streamID string, resolution string, since time.Time) []RollupBucket {
+	step, _ := resolutionStep(resolution)
+	from := string(rollupKey(resolution, since.Truncate(step)))
+	prefix := resolution[:1] + ":"
+
+	var keys []string
+	for key := range m.rollups[streamID] {
+		if strings.HasPrefix(key, prefix) && key >= from {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	buckets := make([]RollupBucket, 0, len(keys))
+	for _, key := range keys {
+		bucket := m.rollups[streamID][key]
+		bucket.Counts = copyCounts(bucket.Counts)
+		buckets = append(buckets, bucket)
+	}
+	return buckets
+}
+
+func (m *MemoryStorage) GetContext(ctx context.Context, streamID string) (*StreamContext, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.readContext(streamID), nil
+}
+
+// readContext returns a copy of the stored context, or an empty one if not
+// found. Callers hold m.mu.
+func (m *MemoryStorage) readContext(streamID string) *StreamContext {
+	streamCtx, ok := m.contexts[streamID]
+	if !ok {
+		return newStreamContext(streamID)
+	}
+	result := copyContext(streamCtx)
+	return &result
+}
+
+func (m *MemoryStorage) UpdateContext(ctx context.Context, streamID string, streamCtx *StreamContext) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.contexts[streamID] = copyContext(*streamCtx)
+	return nil
+}
+
+// MutateContext applies fn to a copy of the stream's context under the write
+// lock and stores it only if fn succeeds
+func (m *MemoryStorage) MutateContext(ctx context.Context, streamID string, fn func(*StreamContext) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	streamCtx := m.readContext(streamID)
+	if err := fn(streamCtx); err != nil {
+		return err
+	}
+	m.contexts[streamID] = copyContext(*streamCtx)
+	return nil
+}
+
+func (m *MemoryStorage) StoreAnalysis(ctx context.Context, analysis *Analysis) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := *analysis
+	stored.Fixes = append([]string(nil), analysis.Fixes...)
+	m.analyses[string(analysisKey(analysis))] = stored
+	return nil
+}
+
+func (m *MemoryStorage) GetAnalysisHistory(ctx context.Context, streamID string, limit int) ([]Analysis, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	prefix := streamID + ":"
+	var keys []string
+	for key := range m.analyses {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var analyses []Analysis
+	for _, key := range keys {
+		if limit > 0 && len(analyses) >= limit {
+			break
+		}
+		analysis := m.analyses[key]
+		analysis.Fixes = append([]string(nil), analysis.Fixes...)
+		analyses = append(analyses, analysis)
+	}
+	return analyses, nil
+}
+
+func (m *MemoryStorage) SaveJob(ctx context.Context, job *AnalysisJob) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := job.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.jobs[job.ID] = copyJob(*job)
+	return nil
+}
+
+func (m *MemoryStorage) GetJob(ctx context.Context, id string) (*AnalysisJob, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("job %q: %w", id, ErrNotFound)
+	}
+	job = copyJob(job)
+	return &job, nil
+}
+
+func (m *MemoryStorage) ListJobs(ctx context.Context, streamID string) ([]AnalysisJob, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.jobs))
+	for id, job := range m.jobs {
+		if streamID == "" || job.StreamID == streamID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var jobs []AnalysisJob
+	for _, id := range ids {
+		jobs = append(jobs, copyJob(m.jobs[id]))
+	}
+	return jobs, nil
+}
+
+func (m *MemoryStorage) PruneJobs(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+	for id, job := range m.jobs {
+		if jobFinishedBefore(&job, before) {
+			delete(m.jobs, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// copyJob keeps callers from sharing the stored analysis
+func copyJob(job AnalysisJob) AnalysisJob {
+	if job.Analysis != nil {
+		analysis := *job.Analysis
+		analysis.Fixes = append([]string(nil), analysis.Fixes...)
+		job.Analysis = &analysis
+	}
+	return job
+}
+
+func (m *MemoryStorage) SaveDigest(ctx context.Context, digest *Digest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := digest.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.digests[digest.ID] = copyDigest(*digest)
+	return nil
+}
+
+func (m *MemoryStorage) GetDigest(ctx context.Context, id string) (*Digest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	digest, ok := m.digests[id]
+	if !ok {
+		return nil, fmt.Errorf("digest %q: %w", id, ErrNotFound)
+	}
+	digest = copyDigest(digest)
+	return &digest, nil
+}
+
+func (m *MemoryStorage) ListDigests(ctx context.Context, limit int) ([]Digest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.digests))
+	for id := range m.digests {
+		ids = append(ids, id)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+	var digests []Digest
+	for _, id := range ids {
+		if limit > 0 && len(digests) >= limit {
+			break
+		}
+		digests = append(digests, copyDigest(m.digests[id]))
+	}
+	return digests, nil
+}
+
+// copyDigest keeps callers from sharing the stored issue lists
+func copyDigest(digest Digest) Digest {
+	digest.Issues = append([]DigestIssue(nil), digest.Issues...)
+	digest.Unresolved = append([]DigestIssue(nil), digest.Unresolved...)
+	return digest
+}
+
+func (m *MemoryStorage) SavePatterns(ctx context.Context, streamID string, patterns []LogPattern) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := validatePatterns(patterns); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := make([]LogPattern, len(patterns))
+	for i, p := range patterns {
+		stored[i] = copyPattern(p)
+		stored[i].StreamID = streamID
+	}
+	sortPatterns(stored)
+	m.patterns[streamID] = stored
+	return nil
+}
+
+func (m *MemoryStorage) GetPatterns(ctx context.Context, streamID string) ([]LogPattern, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var patterns []LogPattern
+	for _, p := range m.patterns[streamID] {
+		patterns = append(patterns, copyPattern(p))
+	}
+	return patterns, nil
+}
+
+// SaveEvent stores an event and drops the stream's events older than
+// eventRetention
+func (m *MemoryStorage) SaveEvent(ctx context.Context, event *Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := event.Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := time.Now().Add(-eventRetention)
+	var events []Event
+	for _, e := range m.events[event.StreamID] {
+		if e.ID != event.ID && !e.Timestamp.Before(cutoff) {
+			events = append(events, e)
+		}
+	}
+	if !event.Timestamp.Before(cutoff) {
+		events = append(events, *event)
+	}
+	m.events[event.StreamID] = events
+	return nil
+}
+
+func (m *MemoryStorage) ListEvents(ctx context.Context, opts ListEventsOptions) ([]Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []Event
+	for _, streamEvents := range m.events {
+		for _, e := range streamEvents {
+			if opts.match(&e) {
+				events = append(events, e)
+			}
+		}
+	}
+	return sortEvents(events, opts.Limit), nil
+}
+
+func (m *MemoryStorage) RecordFingerprints(ctx context.Context, streamID string, fingerprints []string, at time.Time) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validateFingerprints(fingerprints); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	known := m.prints[streamID]
+	if known == nil {
+		known = make(map[string]time.Time)
+		m.prints[streamID] = known
+	}
+	var novel []string
+	for _, fp := range fingerprints {
+		if _, ok := known[fp]; !ok {
+			known[fp] = at
+			novel = append(novel, fp)
+		}
+	}
+	return novel, nil
+}
+
+func (m *MemoryStorage) UnseenFingerprints(ctx context.Context, streamID string, fingerprints []string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validateFingerprints(fingerprints); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	known := m.prints[streamID]
+	var unseen []string
+	for _, fp := range fingerprints {
+		if _, ok := known[fp]; !ok && !slices.Contains(unseen, fp) {
+			unseen = append(unseen, fp)
+		}
+	}
+	return unseen, nil
+}
+
+func (m *MemoryStorage) ListRetentionRules(ctx context.Context) ([]RetentionRule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]string, 0, len(m.retention))
+	for key := range m.retention {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var rules []RetentionRule
+	for _, key := range keys {
+		rules = append(rules, m.retention[key])
+	}
+	return rules, nil
+}
+
+func (m *MemoryStorage) SetRetentionRule(ctx context.Context, rule RetentionRule) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.retention[string(retentionKey(rule.Scope, rule.Target))] = rule
+	return nil
+}
+
+func (m *MemoryStorage) DeleteRetentionRule(ctx context.Context, scope, target string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.retention, string(retentionKey(scope, target)))
+	return nil
+}
+
+func (m *MemoryStorage) EnforceRetention(ctx context.Context, streamID string, policy RetentionPolicy, evict EvictFunc) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.RLock()
+	entries := m.logs[streamID]
+	lines := len(entries)
+	var size int64
+	for _, entry := range entries {
+		size += entry.size()
+	}
+
+	var cutoff time.Time
+	if policy.MaxAge > 0 {
+		cutoff = time.Now().Add(-time.Duration(policy.MaxAge))
+	}
+
+	var doomed []memoryLog
+	for _, entry := range entries {
+		expired := !cutoff.IsZero() && entry.log.Timestamp.Before(cutoff)
+		overLines := policy.MaxLines > 0 && lines > policy.MaxLines
+		overBytes := policy.MaxBytes > 0 && size > policy.MaxBytes
+		if !expired && !overLines && !overBytes {
+			break
+		}
+		doomed = append(doomed, entry)
+		lines--
+		size -= entry.size()
+	}
+	m.mu.RUnlock()
+	if len(doomed) == 0 {
+		return 0, nil
+	}
+
+	// Archive without holding the lock, like the other backends
+	if evict != nil {
+		evicted := make([]LogLine, len(doomed))
+		for i, entry := range doomed {
+			evicted[i] = entry.log
+		}
+		if err := evict(evicted); err != nil {
+			return 0, fmt.Errorf("failed to evict logs: %w", err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// A line overwritten while it was archived is kept
+	gone := make(map[string]*LogLine, len(doomed))
+	for i := range doomed {
+		gone[doomed[i].key] = &doomed[i].log
+	}
+	var kept []memoryLog
+	deleted := 0
+	for _, entry := range m.logs[streamID] {
+		if old, ok := gone[entry.key]; ok && old.Message == entry.log.Message && old.Raw == entry.log.Raw && old.Level == entry.log.Level {
+			deleted++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if deleted > 0 {
+		m.logs[streamID] = kept
+	}
+	return deleted, nil
+}
+
+// size approximates what the entry would occupy on disk
+func (e memoryLog) size() int64 {
+	return logSize(e.key, e.log)
+}
+
+// logSize approximates the bytes a log line occupies for MaxBytes retention
+// in backends that don't measure their encoded values
+func logSize(key string, log LogLine) int64 {
+	n := len(key) + len(log.Level) + len(log.Message)
+	if log.Raw != log.Message {
+		n += len(log.Raw)
+	}
+	for k, v := range log.Labels {
+		n += len(k) + len(v)
+	}
+	return int64(n)
+}
+
+// copyContext deep-copies the slices of a context so callers can't alias state
+func copyContext(streamCtx StreamContext) StreamContext {
+	streamCtx.Analyses = append([]AnalysisSummary{}, streamCtx.Analyses...)
+	streamCtx.Patterns.CommonErrors = append([]string{}, streamCtx.Patterns.CommonErrors...)
+	streamCtx.Patterns.Signatures = append([]ErrorSignature(nil), streamCtx.Patterns.Signatures...)
+	return streamCtx
+}
+
+func copyCounts(counts map[string]int64) map[string]int64 {
+	out := make(map[string]int64, len(counts))
+	for k, v := range counts {
+		out[k] = v
+	}
+	return out
+}
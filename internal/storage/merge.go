@@ -0,0 +1,43 @@
+package storage
+
+import "sort"
+
+// apply copies the set fields of a patch onto a stream
+func (p StreamPatch) apply(stream *Stream) {
+	if p.Name != nil {
+		stream.Name = *p.Name
+	}
+	if p.Labels != nil {
+		stream.Labels = p.Labels
+	}
+}
+
+// mergeContexts folds src's history into dst: analyses are interleaved by
+// time, counters are summed and the seen range is widened. src's error
+// signatures track its own patterns and are dropped; its common errors are
+// kept until dst's signatures are ranked again.
+func mergeContexts(dst, src *StreamContext) {
+	dst.Analyses = append(dst.Analyses, src.Analyses...)
+	sort.SliceStable(dst.Analyses, func(i, j int) bool {
+		return dst.Analyses[i].Timestamp.Before(dst.Analyses[j].Timestamp)
+	})
+
+	for _, pattern := range src.Patterns.CommonErrors {
+		if !contains(dst.Patterns.CommonErrors, pattern) {
+			dst.Patterns.CommonErrors = append(dst.Patterns.CommonErrors, pattern)
+		}
+	}
+
+	dst.TotalLogs += src.TotalLogs
+	dst.ErrorCount += src.ErrorCount
+	if dst.TotalLogs > 0 {
+		dst.Patterns.ErrorRate = float64(dst.ErrorCount) / float64(dst.TotalLogs)
+	}
+
+	if dst.FirstSeen.IsZero() || (!src.FirstSeen.IsZero() && src.FirstSeen.Before(dst.FirstSeen)) {
+		dst.FirstSeen = src.FirstSeen
+	}
+	if src.LastSeen.After(dst.LastSeen) {
+		dst.LastSeen = src.LastSeen
+	}
+}
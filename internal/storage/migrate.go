@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	metaBucket       = []byte("meta")
+	schemaVersionKey = []byte("schema_version")
+)
+
+// errDryRun rolls back a dry-run transaction after all migrations succeed
+var errDryRun = errors.New("dry run")
+
+// migration upgrades the database from Version-1 to Version
+type migration struct {
+	Version     int
+	Description string
+	Up          func(tx *bolt.Tx, opts BoltOptions) error
+}
+
+// migrations must stay in ascending version order. Never edit a released
+// migration; add a new one instead.
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "create context, analysis and streams buckets",
+		Up:          createBuckets(contextBucket, analysisBucket, streamsBucket),
+	},
+	{
+		Version:     2,
+		Description: "create retention, rollups and labelsets buckets",
+		Up:          createBuckets(retentionBucket, rollupsBucket, labelsetsBucket),
+	},
+	{
+		Version:     3,
+		Description: "re-encode legacy JSON log values in the binary format",
+		Up:          reencodeLegacyLogs,
+	},
+	{
+		Version:     4,
+		Description: "create jobs bucket",
+		Up:          createBuckets(jobsBucket),
+	},
+	{
+		Version:     5,
+		Description: "create digests bucket",
+		Up:          createBuckets(digestsBucket),
+	},
+	{
+		Version:     6,
+		Description: "create patterns bucket",
+		Up:          createBuckets(patternsBucket),
+	},
+	{
+		Version:     7,
+		Description: "create events bucket",
+		Up:          createBuckets(eventsBucket),
+	},
+	{
+		Version:     8,
+		Description: "create fingerprints bucket",
+		Up:          createBuckets(fingerprintsBucket),
+	},
+	{
+		Version:     9,
+		Description: "create tombstones bucket",
+		Up:          createBuckets(tombstonesBucket),
+	},
+}
+
+// LatestSchemaVersion is the version a freshly migrated database has
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaInfo describes the schema state of a database file
+type SchemaInfo struct {
+	Version int      `json:"version"`
+	Latest  int      `json:"latest"`
+	Pending []string `json:"pending"`
+}
+
+// InspectSchema reports the schema version of a database without changing it
+func InspectSchema(path string) (*SchemaInfo, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt db: %w", err)
+	}
+	defer db.Close()
+
+	info := &SchemaInfo{Latest: LatestSchemaVersion(), Pending: []string{}}
+	err = db.View(func(tx *bolt.Tx) error {
+		info.Version = schemaVersion(tx)
+		return nil
+	})
+	for _, m := range pendingMigrations(info.Version) {
+		info.Pending = append(info.Pending, fmt.Sprintf("%d: %s", m.Version, m.Description))
+	}
+
+	return info, err
+}
+
+// DryRunMigrations applies all pending migrations in one transaction and
+// rolls it back, reporting the first failure
+func DryRunMigrations(path string, opts BoltOptions) (*SchemaInfo, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt db: %w", err)
+	}
+	defer db.Close()
+
+	info := &SchemaInfo{Latest: LatestSchemaVersion(), Pending: []string{}}
+	err = db.Update(func(tx *bolt.Tx) error {
+		info.Version = schemaVersion(tx)
+		for _, m := range pendingMigrations(info.Version) {
+			info.Pending = append(info.Pending, fmt.Sprintf("%d: %s", m.Version, m.Description))
+			if err := m.Up(tx, opts); err != nil {
+				return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+			}
+		}
+		return errDryRun
+	})
+	if errors.Is(err, errDryRun) {
+		err = nil
+	}
+
+	return info, err
+}
+
+// migrate brings db up to the latest schema, one transaction per migration
+func migrate(db *bolt.DB, path string, opts BoltOptions) error {
+	var current int
+	err := db.View(func(tx *bolt.Tx) error {
+		current = schemaVersion(tx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if current > LatestSchemaVersion() {
+		return fmt.Errorf("database schema v%d is newer than this binary supports (v%d)", current, LatestSchemaVersion())
+	}
+
+	pending := pendingMigrations(current)
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if opts.BackupBeforeMigrate && !isEmpty(db) {
+		backupPath := fmt.Sprintf("%s.v%d.bak", path, current)
+		err := db.View(func(tx *bolt.Tx) error {
+			return tx.CopyFile(backupPath, 0600)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to back up database: %w", err)
+		}
+		log.Printf("Backed up schema v%d database to %s", current, backupPath)
+	}
+
+	for _, m := range pending {
+		err := db.Update(func(tx *bolt.Tx) error {
+			if err := m.Up(tx, opts); err != nil {
+				return err
+			}
+			return setSchemaVersion(tx, m.Version)
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+		log.Printf("Applied schema migration %d: %s", m.Version, m.Description)
+	}
+
+	return nil
+}
+
+// isEmpty reports whether db has no buckets at all, i.e. was just created
+func isEmpty(db *bolt.DB) bool {
+	empty := true
+	db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(_ []byte, _ *bolt.Bucket) error {
+			empty = false
+			return errors.New("not empty")
+		})
+	})
+	return empty
+}
+
+func pendingMigrations(current int) []migration {
+	var pending []migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+func schemaVersion(tx *bolt.Tx) int {
+	bucket := tx.Bucket(metaBucket)
+	if bucket == nil {
+		return 0
+	}
+	var version int
+	fmt.Sscanf(string(bucket.Get(schemaVersionKey)), "%d", &version)
+	return version
+}
+
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+	bucket, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(schemaVersionKey, []byte(fmt.Sprintf("%d", version)))
+}
+
+func createBuckets(names ...[]byte) func(tx *bolt.Tx, opts BoltOptions) error {
+	return func(tx *bolt.Tx, opts BoltOptions) error {
+		for _, name := range names {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func reencodeLegacyLogs(tx *bolt.Tx, opts BoltOptions) error {
+	var streamIDs []string
+	tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		if bytes.HasPrefix(name, logsBucketPrefix) {
+			streamIDs = append(streamIDs, string(name[len(logsBucketPrefix):]))
+		}
+		return nil
+	})
+
+	for _, streamID := range streamIDs {
+		bucket := tx.Bucket(logsBucketName(streamID))
+		codec, err := newLogCodec(tx, streamID, opts.Compress, nil)
+		if err != nil {
+			return err
+		}
+
+		// Collect first: bolt cursors must not see their bucket modified
+		updates := make(map[string][]byte)
+		err = bucket.ForEach(func(k, v []byte) error {
+			if len(v) == 0 || v[0] != '{' {
+				return nil
+			}
+			log, err := codec.decode(v)
+			if err != nil {
+				return nil // Leave unreadable values untouched
+			}
+			data, err := codec.encode(log)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = data
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for k, v := range updates {
+			if err := bucket.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
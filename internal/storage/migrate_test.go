@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// writeBaselineDB creates a database the way releases before schema
+// versioning did: three buckets, no meta bucket and logs as JSON values
+// under RFC3339Nano keys
+func writeBaselineDB(t *testing.T, path string, logs []LogLine) {
+	t.Helper()
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{contextBucket, analysisBucket, streamsBucket} {
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		bucket, err := tx.CreateBucket(logsBucketName("s"))
+		if err != nil {
+			return err
+		}
+		for _, log := range logs {
+			data, _ := json.Marshal(log)
+			if err := bucket.Put([]byte(log.Timestamp.Format(time.RFC3339Nano)), data); err != nil {
+				return err
+			}
+		}
+		stream, _ := json.Marshal(Stream{ID: "s", Name: "legacy", Active: true})
+		return tx.Bucket(streamsBucket).Put([]byte("s"), stream)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrationsUpgradeBaselineDatabase(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	logs := makeLogs("s", 20, time.Now())
+	writeBaselineDB(t, path, logs)
+
+	info, err := InspectSchema(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != 0 || info.Latest != LatestSchemaVersion() || len(info.Pending) != len(migrations) {
+		t.Fatalf("baseline schema = %+v", info)
+	}
+
+	// A dry run applies everything and keeps nothing
+	if _, err := DryRunMigrations(path, BoltOptions{Compress: true}); err != nil {
+		t.Fatalf("DryRunMigrations: %v", err)
+	}
+	if info, _ := InspectSchema(path); info.Version != 0 {
+		t.Fatalf("dry run left schema v%d", info.Version)
+	}
+
+	store, err := OpenBoltStorage(path, BoltOptions{Compress: true, BackupBeforeMigrate: true})
+	if err != nil {
+		t.Fatalf("OpenBoltStorage: %v", err)
+	}
+	err = store.db.View(func(tx *bolt.Tx) error {
+		if v := schemaVersion(tx); v != LatestSchemaVersion() {
+			t.Errorf("schema v%d after migrating, want v%d", v, LatestSchemaVersion())
+		}
+		for _, name := range [][]byte{retentionBucket, rollupsBucket, labelsetsBucket, jobsBucket, fingerprintsBucket, tombstonesBucket} {
+			if tx.Bucket(name) == nil {
+				t.Errorf("bucket %s not created", name)
+			}
+		}
+		return tx.Bucket(logsBucketName("s")).ForEach(func(k, v []byte) error {
+			if v[0] != logFormatV1 {
+				t.Errorf("log %s not re-encoded: %q", k, v)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := mustGet(t, store, "s", GetLogsOptions{})
+	if len(got) != len(logs) {
+		t.Fatalf("read %d logs after migrating, want %d", len(got), len(logs))
+	}
+	for i := range got {
+		if got[i].Message != logs[i].Message || got[i].Level != logs[i].Level || got[i].Labels["pod"] != "api-1" {
+			t.Fatalf("log %d = %+v, want %+v", i, got[i], logs[i])
+		}
+	}
+	if stream, err := store.GetStream(ctx, "s"); err != nil || stream.Name != "legacy" {
+		t.Errorf("GetStream = %+v, %v", stream, err)
+	}
+	store.Close()
+
+	// The backup is the untouched baseline file
+	backup := path + ".v0.bak"
+	if info, err := InspectSchema(backup); err != nil || info.Version != 0 {
+		t.Errorf("backup schema = %+v, %v", info, err)
+	}
+
+	// Reopening an up-to-date database migrates and backs up nothing
+	os.Remove(backup)
+	store, err = OpenBoltStorage(path, BoltOptions{BackupBeforeMigrate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if _, err := os.Stat(backup); !os.IsNotExist(err) {
+		t.Errorf("up-to-date database backed up again: %v", err)
+	}
+}
+
+func TestMigrationFailureKeepsEarlierVersions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	writeBaselineDB(t, path, makeLogs("s", 3, time.Now()))
+
+	latest := LatestSchemaVersion()
+	saved := migrations
+	t.Cleanup(func() { migrations = saved })
+	migrations = append(migrations[:len(migrations):len(migrations)], migration{
+		Version:     latest + 1,
+		Description: "always fails",
+		Up: func(tx *bolt.Tx, opts BoltOptions) error {
+			if _, err := tx.CreateBucket([]byte("half-done")); err != nil {
+				return err
+			}
+			return errors.New("boom")
+		},
+	})
+
+	if _, err := OpenBoltStorage(path, BoltOptions{}); err == nil {
+		t.Fatal("OpenBoltStorage succeeded with a failing migration")
+	}
+	info, err := InspectSchema(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != latest || len(info.Pending) != 1 {
+		t.Errorf("after the failure: %+v, want v%d with one pending", info, latest)
+	}
+	db, _ := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("half-done")) != nil {
+			t.Error("the failed migration was partly committed")
+		}
+		return nil
+	})
+	db.Close()
+
+	if _, err := DryRunMigrations(path, BoltOptions{}); err == nil {
+		t.Error("dry run succeeded with a failing migration")
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Update(func(tx *bolt.Tx) error {
+		return setSchemaVersion(tx, LatestSchemaVersion()+1)
+	})
+	db.Close()
+
+	if _, err := OpenBoltStorage(path, BoltOptions{}); err == nil {
+		t.Error("opened a database from a newer release")
+	}
+}
+
+func TestMigrationsAreOrdered(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d", i, m.Version)
+		}
+	}
+}
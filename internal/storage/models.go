@@ -1,6 +1,9 @@
 package storage
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // LogLine represents a single log entry
 type LogLine struct {
@@ -35,8 +38,20 @@ type AnalysisSummary struct {
 
 // StreamPatterns tracks recurring issues
 type StreamPatterns struct {
-	CommonErrors []string `json:"common_errors"`
-	ErrorRate    float64  `json:"error_rate"`
+	CommonErrors []string         `json:"common_errors"` // templates of the top recurring signatures
+	ErrorRate    float64          `json:"error_rate"`
+	Signatures   []ErrorSignature `json:"signatures,omitempty"`
+}
+
+// ErrorSignature is the message template of a stream's errors. Its score
+// counts occurrences with exponential decay, so recent errors outweigh old
+// ones.
+type ErrorSignature struct {
+	PatternID string    `json:"pattern_id"` // the LogPattern it tracks
+	Template  string    `json:"template"`
+	Count     int64     `json:"count"`
+	Score     float64   `json:"score"` // as of LastSeen
+	LastSeen  time.Time `json:"last_seen"`
 }
 
 // Analysis is the full AI-generated analysis
@@ -50,6 +65,90 @@ type Analysis struct {
 	Context   string    `json:"context,omitempty"` // Historical context used
 }
 
+// Analysis job states
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// AnalysisJob tracks a requested analysis from queueing to its result
+type AnalysisJob struct {
+	ID         string    `json:"id"`
+	StreamID   string    `json:"stream_id"`
+	Status     string    `json:"status"` // queued, running, done, failed
+	Error      string    `json:"error,omitempty"`
+	Analysis   *Analysis `json:"analysis,omitempty"` // set once done
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// Digest aggregates the analyses of one scheduled run across streams
+type Digest struct {
+	ID         string        `json:"id"`
+	Schedule   string        `json:"schedule"` // name of the schedule that produced it
+	Since      time.Time     `json:"since"`
+	Until      time.Time     `json:"until"`
+	CreatedAt  time.Time     `json:"created_at"`
+	Streams    int           `json:"streams"`    // streams with logs in the window
+	Issues     []DigestIssue `json:"issues"`     // top issues in the window, most severe first
+	Unresolved []DigestIssue `json:"unresolved"` // open P0/P1 issues, including older ones
+}
+
+// DigestIssue is one analysis as listed in a digest
+type DigestIssue struct {
+	StreamID  string    `json:"stream_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Summary   string    `json:"summary"`
+	RootCause string    `json:"root_cause,omitempty"`
+	Severity  string    `json:"severity"`
+	Errors    int       `json:"errors,omitempty"` // error lines in the window
+	Recurring bool      `json:"recurring"`        // resembles an issue seen before the window
+}
+
+// LogPattern is a message template mined from a stream's logs, such as
+// "user <*> failed login from <IP>"
+type LogPattern struct {
+	ID        string    `json:"id"` // unique within the stream
+	StreamID  string    `json:"stream_id"`
+	Template  string    `json:"template"`
+	Count     int64     `json:"count"`
+	Errors    int64     `json:"errors"` // occurrences at ERROR or FATAL level
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Examples  []string  `json:"examples"` // a few of the original messages
+}
+
+// Event types
+const (
+	EventAnomaly    = "anomaly"     // volume or level mix far from the stream's baseline
+	EventNovelError = "novel_error" // an error the stream never logged before
+)
+
+// Event is something notable detected in a stream, kept as history for
+// analyses and the API
+type Event struct {
+	ID        string    `json:"id"`
+	StreamID  string    `json:"stream_id"`
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Message   string    `json:"message"`
+	Metric    string    `json:"metric,omitempty"` // what was measured, e.g. "volume"
+	Value     float64   `json:"value"`
+	Expected  float64   `json:"expected"` // the baseline for Value
+	Score     float64   `json:"score"`    // how unusual Value is; negative below the baseline
+}
+
+// ListEventsOptions filters events. Zero fields match everything.
+type ListEventsOptions struct {
+	StreamID string
+	Type     string
+	Since    time.Time
+	Limit    int
+}
+
 // Stream represents an active log stream
 type Stream struct {
 	ID          string    `json:"id"`
@@ -60,4 +159,68 @@ type Stream struct {
 	ErrorRate   float64   `json:"error_rate"`
 	LastSeen    time.Time `json:"last_seen"`
 	ContextSummary string `json:"context_summary"`
-}
\ No newline at end of file
+	ErrorsPerMin float64    `json:"errors_per_min"`
+	ErrorRates   ErrorRates `json:"error_rates"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+// StreamPatch holds the stream fields a client may change. Nil fields are
+// left alone; an empty Labels map clears the labels.
+type StreamPatch struct {
+	Name   *string           `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ErrorRates are error/fatal shares of all logs over trailing windows
+type ErrorRates struct {
+	Last5m  float64 `json:"5m"`
+	Last1h  float64 `json:"1h"`
+	Last24h float64 `json:"24h"`
+}
+
+// RollupBucket counts a stream's logs by level over one minute or one hour
+type RollupBucket struct {
+	Start  time.Time        `json:"start"`
+	Total  int64            `json:"total"`
+	Counts map[string]int64 `json:"counts"` // ERROR, WARN, INFO, DEBUG, FATAL
+}
+
+// RetentionPolicy bounds how much history a stream keeps. Zero fields are unlimited.
+type RetentionPolicy struct {
+	MaxLines int      `json:"max_lines,omitempty"`
+	MaxAge   Duration `json:"max_age,omitempty"`
+	MaxBytes int64    `json:"max_bytes,omitempty"`
+}
+
+// RetentionRule attaches a policy to a stream, a source or the default scope
+type RetentionRule struct {
+	Scope  string          `json:"scope"`            // default, source, stream
+	Target string          `json:"target,omitempty"` // source name or stream ID
+	Policy RetentionPolicy `json:"policy"`
+}
+
+// Duration is a time.Duration that reads and writes as "72h" in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		// Accept plain nanoseconds as well
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return err
+		}
+		*d = Duration(n)
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+)
+
+// validatePatterns checks patterns before they are saved
+func validatePatterns(patterns []LogPattern) error {
+	ids := make(map[string]bool, len(patterns))
+	for _, p := range patterns {
+		if p.ID == "" || p.Template == "" {
+			return fmt.Errorf("%w: pattern needs an ID and a template", ErrInvalid)
+		}
+		if ids[p.ID] {
+			return fmt.Errorf("%w: duplicate pattern ID %q", ErrInvalid, p.ID)
+		}
+		ids[p.ID] = true
+	}
+	return nil
+}
+
+// sortPatterns orders patterns most frequent first, then by ID
+func sortPatterns(patterns []LogPattern) {
+	sort.Slice(patterns, func(i, j int) bool {
+		if patterns[i].Count != patterns[j].Count {
+			return patterns[i].Count > patterns[j].Count
+		}
+		return patterns[i].ID < patterns[j].ID
+	})
+}
+
+// copyPattern keeps callers from sharing the stored examples
+func copyPattern(p LogPattern) LogPattern {
+	p.Examples = append([]string(nil), p.Examples...)
+	return p
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Retention scopes, from least to most specific
+const (
+	ScopeDefault = "default"
+	ScopeSource  = "source"
+	ScopeStream  = "stream"
+)
+
+// Validate checks that a rule has a known scope and sane limits
+func (r RetentionRule) Validate() error {
+	switch r.Scope {
+	case ScopeDefault:
+		if r.Target != "" {
+			return fmt.Errorf("%w: default retention rule cannot have a target", ErrInvalid)
+		}
+	case ScopeSource, ScopeStream:
+		if r.Target == "" {
+			return fmt.Errorf("%w: %s retention rule needs a target", ErrInvalid, r.Scope)
+		}
+	default:
+		return fmt.Errorf("%w: unknown retention scope %q", ErrInvalid, r.Scope)
+	}
+
+	if r.Policy.MaxLines < 0 || r.Policy.MaxAge < 0 || r.Policy.MaxBytes < 0 {
+		return fmt.Errorf("%w: retention limits cannot be negative", ErrInvalid)
+	}
+	return nil
+}
+
+// ResolveRetention picks the most specific rule for a stream: stream beats
+// source beats the stored default, which beats the built-in fallback.
+func ResolveRetention(rules []RetentionRule, stream Stream, fallback RetentionPolicy) RetentionPolicy {
+	policy := fallback
+	rank := 0
+
+	for _, rule := range rules {
+		switch {
+		case rule.Scope == ScopeStream && rule.Target == stream.ID && rank < 3:
+			policy, rank = rule.Policy, 3
+		case rule.Scope == ScopeSource && rule.Target == stream.Source && rank < 2:
+			policy, rank = rule.Policy, 2
+		case rule.Scope == ScopeDefault && rank < 1:
+			policy, rank = rule.Policy, 1
+		}
+	}
+
+	return policy
+}
+
+// CompactorConfig controls the background retention sweep
+type CompactorConfig struct {
+	Interval time.Duration
+	Defaults RetentionPolicy // Used when no stored rule matches
+
+	// Archive receives evicted logs instead of discarding them (optional)
+	Archive       *SegmentArchive
+	ArchiveMaxAge time.Duration // Segments older than this are deleted (0 = forever)
+}
+
+// Compactor periodically applies retention policies to every stream
+type Compactor struct {
+	store  Storage
+	config CompactorConfig
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+func NewCompactor(store Storage, cfg CompactorConfig) *Compactor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Compactor{
+		store:  store,
+		config: cfg,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start runs the sweep loop in the background until Stop is called
+func (c *Compactor) Start() {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		ticker := time.NewTicker(c.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			if err := c.RunOnce(c.ctx); err != nil && c.ctx.Err() == nil {
+				log.Printf("Retention sweep failed: %v", err)
+			}
+
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop cancels the sweep loop, if started, and waits for the current pass
+// to abort
+func (c *Compactor) Stop() {
+	c.once.Do(func() {
+		c.cancel()
+		c.wg.Wait()
+	})
+}
+
+// RunOnce applies retention to all streams a single time
+func (c *Compactor) RunOnce(ctx context.Context) error {
+	streams, err := c.store.ListStreams(ctx)
+	if err != nil {
+		return err
+	}
+	rules, err := c.store.ListRetentionRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, stream := range streams {
+		policy := ResolveRetention(rules, stream, c.config.Defaults)
+
+		var evict EvictFunc
+		if c.config.Archive != nil {
+			streamID := stream.ID
+			evict = func(logs []LogLine) error {
+				return c.config.Archive.Write(streamID, logs)
+			}
+		}
+
+		deleted, err := c.store.EnforceRetention(ctx, stream.ID, policy, evict)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Retention failed for %s: %v", stream.ID, err)
+			continue
+		}
+		if deleted > 0 {
+			log.Printf("Retention trimmed %d logs from %s", deleted, stream.ID)
+		}
+	}
+
+	if c.config.Archive != nil {
+		removed, err := c.config.Archive.Prune(c.config.ArchiveMaxAge)
+		if err != nil {
+			return fmt.Errorf("failed to prune archive: %w", err)
+		}
+		if removed > 0 {
+			log.Printf("Archive pruned %d expired segments", removed)
+		}
+	}
+
+	return nil
+}
+
+// Defaults returns the built-in policy used when no rule matches
+func (c *Compactor) Defaults() RetentionPolicy {
+	return c.config.Defaults
+}
+
+// EffectivePolicy resolves the policy currently applied to a stream
+func (c *Compactor) EffectivePolicy(ctx context.Context, streamID string) (RetentionPolicy, error) {
+	stream, err := c.store.GetStream(ctx, streamID)
+	if err != nil {
+		return RetentionPolicy{}, err
+	}
+	rules, err := c.store.ListRetentionRules(ctx)
+	if err != nil {
+		return RetentionPolicy{}, err
+	}
+	return ResolveRetention(rules, *stream, c.config.Defaults), nil
+}
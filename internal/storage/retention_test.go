@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetentionRuleValidate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		rule RetentionRule
+		ok   bool
+	}{
+		"default":           {RetentionRule{Scope: ScopeDefault, Policy: RetentionPolicy{MaxLines: 10}}, true},
+		"stream":            {RetentionRule{Scope: ScopeStream, Target: "s"}, true},
+		"default target":    {RetentionRule{Scope: ScopeDefault, Target: "s"}, false},
+		"source no target":  {RetentionRule{Scope: ScopeSource}, false},
+		"unknown scope":     {RetentionRule{Scope: "pod", Target: "s"}, false},
+		"negative limit":    {RetentionRule{Scope: ScopeStream, Target: "s", Policy: RetentionPolicy{MaxBytes: -1}}, false},
+		"negative duration": {RetentionRule{Scope: ScopeDefault, Policy: RetentionPolicy{MaxAge: Duration(-time.Hour)}}, false},
+	} {
+		err := tc.rule.Validate()
+		if tc.ok && err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v, want ErrInvalid", name, err)
+		}
+	}
+}
+
+func TestCompactorAppliesPerStreamPolicies(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStorage()
+	now := time.Now()
+	for _, id := range []string{"a", "b", "c"} {
+		mustStore(t, store, id, makeLogs(id, 10, now))
+	}
+	store.UpdateStream(ctx, &Stream{ID: "c", Source: "docker"})
+	store.SetRetentionRule(ctx, RetentionRule{Scope: ScopeStream, Target: "a", Policy: RetentionPolicy{MaxLines: 3}})
+	store.SetRetentionRule(ctx, RetentionRule{Scope: ScopeSource, Target: "docker", Policy: RetentionPolicy{MaxAge: Duration(1900 * time.Millisecond)}})
+
+	c := NewCompactor(store, CompactorConfig{Defaults: RetentionPolicy{MaxLines: 5}})
+	if err := c.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	for id, want := range map[string]int{"a": 3, "b": 5, "c": 2} {
+		if got := mustGet(t, store, id, GetLogsOptions{}); len(got) != want {
+			t.Errorf("stream %s kept %d logs, want %d", id, len(got), want)
+		}
+	}
+
+	if p, err := c.EffectivePolicy(ctx, "a"); err != nil || p.MaxLines != 3 {
+		t.Errorf("EffectivePolicy(a) = %+v, %v", p, err)
+	}
+	if p, _ := c.EffectivePolicy(ctx, "b"); p != c.Defaults() {
+		t.Errorf("EffectivePolicy(b) = %+v, want the defaults", p)
+	}
+	if _, err := c.EffectivePolicy(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("EffectivePolicy(missing): got %v, want ErrNotFound", err)
+	}
+}
+
+func TestCompactorRunsUntilStopped(t *testing.T) {
+	store := NewMemoryStorage()
+	mustStore(t, store, "s", makeLogs("s", 10, time.Now()))
+
+	c := NewCompactor(store, CompactorConfig{Interval: 10 * time.Millisecond, Defaults: RetentionPolicy{MaxLines: 4}})
+	c.Start()
+	defer c.Stop()
+
+	// The first pass runs straight away; later ones trim new logs too
+	deadline := time.Now().Add(5 * time.Second)
+	for len(mustGet(t, store, "s", GetLogsOptions{})) != 4 {
+		if time.Now().After(deadline) {
+			t.Fatal("first sweep never ran")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mustStore(t, store, "s", makeLogs("s", 3, time.Now().Add(time.Minute)))
+	for len(mustGet(t, store, "s", GetLogsOptions{})) != 4 {
+		if time.Now().After(deadline) {
+			t.Fatal("later sweep never ran")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	c.Stop()
+	c.Stop() // idempotent
+}
+
+func TestCompactorStopWithoutStart(t *testing.T) {
+	c := NewCompactor(NewMemoryStorage(), CompactorConfig{})
+	stopped := make(chan struct{})
+	go func() {
+		c.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop hung without Start")
+	}
+}
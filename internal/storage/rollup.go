@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Histogram resolutions
+const (
+	ResolutionMinute = "minute"
+	ResolutionHour   = "hour"
+)
+
+// How long rollup buckets are kept at each resolution
+const (
+	minuteRollupRetention = 24 * time.Hour
+	hourRollupRetention   = 90 * 24 * time.Hour
+)
+
+// resolutionStep returns the bucket width for a resolution
+func resolutionStep(resolution string) (time.Duration, error) {
+	switch resolution {
+	case ResolutionMinute:
+		return time.Minute, nil
+	case ResolutionHour:
+		return time.Hour, nil
+	}
+	return 0, fmt.Errorf("%w: unknown resolution %q", ErrInvalid, resolution)
+}
+
+// histogramStep returns the bucket width for a histogram from since to now,
+// refusing ranges older than the resolution keeps
+func histogramStep(resolution string, since time.Time) (time.Duration, error) {
+	step, err := resolutionStep(resolution)
+	if err != nil {
+		return 0, err
+	}
+	retention := minuteRollupRetention
+	if resolution == ResolutionHour {
+		retention = hourRollupRetention
+	}
+	if time.Since(since) > retention+step {
+		return 0, fmt.Errorf("%w: %s histograms only go back %s", ErrInvalid, resolution, retention)
+	}
+	return step, nil
+}
+
+// rollupKey orders buckets by resolution, then start time
+func rollupKey(resolution string, start time.Time) []byte {
+	return []byte(fmt.Sprintf("%s:%012d", resolution[:1], start.Unix()))
+}
+
+// addToRollups folds logs into per-minute and per-hour buckets keyed by rollupKey
+func addToRollups(buckets map[string]*RollupBucket, logs []LogLine) {
+	for _, log := range logs {
+		for _, resolution := range []string{ResolutionMinute, ResolutionHour} {
+			step, _ := resolutionStep(resolution)
+			start := log.Timestamp.Truncate(step).UTC()
+			key := string(rollupKey(resolution, start))
+
+			bucket, ok := buckets[key]
+			if !ok {
+				bucket = &RollupBucket{Start: start, Counts: map[string]int64{}}
+				buckets[key] = bucket
+			}
+			bucket.Total++
+			bucket.Counts[log.Level]++
+		}
+	}
+}
+
+// merge adds other's counts into b
+func (b *RollupBucket) merge(other *RollupBucket) {
+	if b.Counts == nil {
+		b.Counts = map[string]int64{}
+	}
+	b.Total += other.Total
+	for level, n := range other.Counts {
+		b.Counts[level] += n
+	}
+}
+
+// errors counts ERROR and FATAL logs in the bucket
+func (b RollupBucket) errors() int64 {
+	return b.Counts["ERROR"] + b.Counts["FATAL"]
+}
+
+// fillRollupGaps returns a contiguous series from since to now, adding empty
+// buckets where a stream logged nothing
+func fillRollupGaps(buckets []RollupBucket, step time.Duration, since, now time.Time) []RollupBucket {
+	byStart := make(map[int64]RollupBucket, len(buckets))
+	for _, b := range buckets {
+		byStart[b.Start.Unix()] = b
+	}
+
+	series := []RollupBucket{}
+	for t := since.Truncate(step).UTC(); !t.After(now); t = t.Add(step) {
+		if b, ok := byStart[t.Unix()]; ok {
+			series = append(series, b)
+			continue
+		}
+		series = append(series, RollupBucket{Start: t, Counts: map[string]int64{}})
+	}
+	return series
+}
+
+// applyRollupStats fills in the rate fields of a stream from its recent
+// minute buckets and last day of hour buckets
+func applyRollupStats(stream *Stream, minutes, hours []RollupBucket, now time.Time) {
+	var total5m, errors5m, total1h, errors1h, total24h, errors24h int64
+
+	for _, b := range minutes {
+		age := now.Sub(b.Start)
+		if age < 5*time.Minute {
+			total5m += b.Total
+			errors5m += b.errors()
+		}
+		if age < time.Hour {
+			total1h += b.Total
+			errors1h += b.errors()
+		}
+	}
+	for _, b := range hours {
+		if now.Sub(b.Start) < 24*time.Hour {
+			total24h += b.Total
+			errors24h += b.errors()
+		}
+	}
+
+	stream.LogsPerMin = int(total5m / 5)
+	stream.ErrorsPerMin = float64(errors5m) / 5
+	stream.ErrorRates = ErrorRates{
+		Last5m:  ratio(errors5m, total5m),
+		Last1h:  ratio(errors1h, total1h),
+		Last24h: ratio(errors24h, total24h),
+	}
+	stream.ErrorRate = stream.ErrorRates.Last1h
+}
+
+func ratio(part, whole int64) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
@@ -0,0 +1,692 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Segment file layout:
+//
+//	"LVSEG1\n" | block | block | ... | index JSON | uint64 index length | "LVIX"
+//
+// Each block holds up to segmentBlockLines NDJSON-encoded log lines,
+// zstd-compressed and, if the archive is encrypted, then sealed like a
+// database value. The index records the offset and time range of every
+// block so readers only open the blocks they need; it is never encrypted.
+var (
+	segmentMagic   = []byte("LVSEG1\n")
+	segmentTrailer = []byte("LVIX")
+)
+
+const (
+	segmentBlockLines = 1024
+	segmentExt        = ".seg"
+
+	// maxArchiveLines bounds the archived lines read for a GetLogs without
+	// a limit; Export pages through longer histories instead
+	maxArchiveLines = 100000
+)
+
+type segmentIndex struct {
+	StreamID string       `json:"stream_id"`
+	MinTime  time.Time    `json:"min_time"`
+	MaxTime  time.Time    `json:"max_time"`
+	Count    int          `json:"count"`
+	Blocks   []blockIndex `json:"blocks"`
+}
+
+type blockIndex struct {
+	Offset  int64     `json:"offset"`
+	Length  int64     `json:"length"`
+	MinTime time.Time `json:"min_time"`
+	MaxTime time.Time `json:"max_time"`
+	Count   int       `json:"count"`
+}
+
+// segmentFile is a segment on disk with the time range taken from its name
+type segmentFile struct {
+	path    string
+	minTime time.Time
+	maxTime time.Time
+}
+
+// SegmentArchive stores evicted logs as immutable, compressed segment files
+// partitioned by stream and day: <dir>/<stream>/<YYYY-MM-DD>/<min>-<max>.seg
+//
+// As in the hot tier, a stream has one line per timestamp. A line archived
+// twice, say by an eviction whose transaction rolled back after the archive
+// write, is read back once.
+type SegmentArchive struct {
+	dir     string
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	crypt   *envelope // set by BoltStorage.EncryptArchive; nil writes plaintext
+
+	mu sync.Mutex // serializes changes to the directory tree
+}
+
+func NewSegmentArchive(dir string) (*SegmentArchive, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create archive dir: %w", err)
+	}
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SegmentArchive{dir: dir, encoder: encoder, decoder: decoder}, nil
+}
+
+// Write archives logs for a stream, one new segment per day they span
+func (a *SegmentArchive) Write(streamID string, logs []LogLine) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	sorted := append([]LogLine(nil), logs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Partition by UTC day
+	var day []LogLine
+	for i, log := range sorted {
+		day = append(day, log)
+		last := i == len(sorted)-1
+		if last || dayOf(sorted[i+1].Timestamp) != dayOf(log.Timestamp) {
+			if err := a.writeSegment(streamID, day); err != nil {
+				return err
+			}
+			day = nil
+		}
+	}
+
+	return nil
+}
+
+func (a *SegmentArchive) writeSegment(streamID string, logs []LogLine) error {
+	index := segmentIndex{
+		StreamID: streamID,
+		MinTime:  logs[0].Timestamp,
+		MaxTime:  logs[len(logs)-1].Timestamp,
+		Count:    len(logs),
+	}
+
+	var blocks [][]byte
+	for start := 0; start < len(logs); start += segmentBlockLines {
+		end := start + segmentBlockLines
+		if end > len(logs) {
+			end = len(logs)
+		}
+		block := logs[start:end]
+
+		var raw bytes.Buffer
+		enc := json.NewEncoder(&raw)
+		for _, log := range block {
+			if err := enc.Encode(log); err != nil {
+				return err
+			}
+		}
+
+		sealed, err := a.crypt.seal(a.encoder.EncodeAll(raw.Bytes(), nil))
+		if err != nil {
+			return err
+		}
+		index.Blocks = append(index.Blocks, blockIndex{
+			MinTime: block[0].Timestamp,
+			MaxTime: block[len(block)-1].Timestamp,
+			Count:   len(block),
+		})
+		blocks = append(blocks, sealed)
+	}
+
+	dir := filepath.Join(a.streamDir(streamID), dayOf(index.MinTime))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%d%s", index.MinTime.UnixNano(), index.MaxTime.UnixNano(), segmentExt)
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		path = filepath.Join(dir, fmt.Sprintf("%d-%d-%d%s", index.MinTime.UnixNano(), index.MaxTime.UnixNano(), time.Now().UnixNano(), segmentExt))
+	}
+	return writeSegmentFile(path, &index, blocks)
+}
+
+// writeSegmentFile lays out blocks and their index, filling in the block
+// offsets. It writes to a temp file and renames it so readers never see a
+// partial segment.
+func writeSegmentFile(path string, index *segmentIndex, blocks [][]byte) error {
+	var buf bytes.Buffer
+	buf.Write(segmentMagic)
+	for i, block := range blocks {
+		index.Blocks[i].Offset = int64(buf.Len())
+		index.Blocks[i].Length = int64(len(block))
+		buf.Write(block)
+	}
+
+	indexData, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	buf.Write(indexData)
+	binary.Write(&buf, binary.BigEndian, uint64(len(indexData)))
+	buf.Write(segmentTrailer)
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Read returns archived logs matching opts, newest segments first, up to
+// limit lines (0 = no limit). Results are in chronological order.
+func (a *SegmentArchive) Read(ctx context.Context, streamID string, opts GetLogsOptions, limit int) ([]LogLine, error) {
+	segments, err := a.segments(streamID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Newest first so a limit keeps the most recent history
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].maxTime.After(segments[j].maxTime)
+	})
+
+	var batches [][]LogLine
+	count := 0
+	seen := make(map[logKey]bool)
+	for _, seg := range segments {
+		if limit > 0 && count >= limit {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !opts.Since.IsZero() && seg.maxTime.Before(opts.Since) {
+			continue
+		}
+		if !opts.Until.IsZero() && seg.minTime.After(opts.Until) {
+			continue
+		}
+
+		logs, err := a.readSegment(seg.path, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read segment %s: %w", seg.path, err)
+		}
+		logs = slices.DeleteFunc(logs, func(log LogLine) bool {
+			key := keyOf(log)
+			if seen[key] {
+				return true
+			}
+			seen[key] = true
+			return false
+		})
+		if limit > 0 && count+len(logs) > limit {
+			logs = logs[len(logs)-(limit-count):]
+		}
+		batches = append(batches, logs)
+		count += len(logs)
+	}
+
+	// Segments moved by a merge still carry the old stream ID
+	var result []LogLine
+	for i := len(batches) - 1; i >= 0; i-- {
+		for _, log := range batches[i] {
+			log.StreamID = streamID
+			result = append(result, log)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+
+	return result, nil
+}
+
+func (a *SegmentArchive) readSegment(path string, opts GetLogsOptions) ([]LogLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	index, err := readSegmentIndex(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var logs []LogLine
+	for _, block := range index.Blocks {
+		if !opts.Since.IsZero() && block.MaxTime.Before(opts.Since) {
+			continue
+		}
+		if !opts.Until.IsZero() && block.MinTime.After(opts.Until) {
+			continue
+		}
+
+		data := make([]byte, block.Length)
+		if _, err := f.ReadAt(data, block.Offset); err != nil {
+			return nil, err
+		}
+		compressed, err := a.crypt.open(data)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := a.decoder.DecodeAll(compressed, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		for {
+			var log LogLine
+			if err := dec.Decode(&log); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+
+			if !opts.Since.IsZero() && log.Timestamp.Before(opts.Since) {
+				continue
+			}
+			if !opts.Until.IsZero() && log.Timestamp.After(opts.Until) {
+				continue
+			}
+			if !opts.matches(log) {
+				continue
+			}
+			logs = append(logs, log)
+		}
+	}
+
+	return logs, nil
+}
+
+func readSegmentIndex(f *os.File) (*segmentIndex, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	footerLen := int64(8 + len(segmentTrailer))
+	if info.Size() < int64(len(segmentMagic))+footerLen {
+		return nil, fmt.Errorf("segment too short")
+	}
+
+	footer := make([]byte, footerLen)
+	if _, err := f.ReadAt(footer, info.Size()-footerLen); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(footer[8:], segmentTrailer) {
+		return nil, fmt.Errorf("bad segment trailer")
+	}
+
+	indexLen := int64(binary.BigEndian.Uint64(footer[:8]))
+	if indexLen > info.Size()-footerLen {
+		return nil, fmt.Errorf("bad segment index length")
+	}
+	indexData := make([]byte, indexLen)
+	if _, err := f.ReadAt(indexData, info.Size()-footerLen-indexLen); err != nil {
+		return nil, err
+	}
+
+	var index segmentIndex
+	if err := json.Unmarshal(indexData, &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// Prune removes segments whose newest line is older than maxAge, and the
+// directories they leave empty
+func (a *SegmentArchive) Prune(maxAge time.Duration) (int, error) {
+	if maxAge <= 0 {
+		return 0, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	streamIDs, err := a.streams()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, streamID := range streamIDs {
+		segments, err := a.segments(streamID)
+		if err != nil {
+			return removed, err
+		}
+		for _, seg := range segments {
+			if seg.maxTime.Before(cutoff) {
+				if err := os.Remove(seg.path); err != nil {
+					return removed, err
+				}
+				removed++
+			}
+		}
+		if err := a.removeEmptyDirs(streamID); err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// removeEmptyDirs deletes a stream's day directories that hold no files,
+// then the stream's directory if nothing is left in it
+func (a *SegmentArchive) removeEmptyDirs(streamID string) error {
+	dir := a.streamDir(streamID)
+	days, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	left := 0
+	for _, day := range days {
+		if !day.IsDir() {
+			left++
+			continue
+		}
+		path := filepath.Join(dir, day.Name())
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			left++
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	if left == 0 {
+		return os.Remove(dir)
+	}
+	return nil
+}
+
+// reseal rewrites the segments holding blocks in plaintext or under an old
+// data key, one segment at a time so archive writes are not held up. It
+// returns the number of blocks resealed.
+func (a *SegmentArchive) reseal(ctx context.Context) (int, error) {
+	if a.crypt == nil {
+		return 0, nil
+	}
+	streamIDs, err := a.streams()
+	if err != nil {
+		return 0, err
+	}
+
+	resealed := 0
+	for _, streamID := range streamIDs {
+		segments, err := a.segments(streamID)
+		if err != nil {
+			return resealed, err
+		}
+		for _, seg := range segments {
+			if err := ctx.Err(); err != nil {
+				return resealed, err
+			}
+			n, err := a.resealSegment(seg.path)
+			if err != nil {
+				return resealed, fmt.Errorf("segment %s: %w", seg.path, err)
+			}
+			resealed += n
+		}
+	}
+	return resealed, nil
+}
+
+func (a *SegmentArchive) resealSegment(path string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil // Pruned, merged or deleted since it was listed
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	index, err := readSegmentIndex(f)
+	if err != nil {
+		return 0, err
+	}
+	blocks := make([][]byte, len(index.Blocks))
+	stale := 0
+	for i, block := range index.Blocks {
+		data := make([]byte, block.Length)
+		if _, err := f.ReadAt(data, block.Offset); err != nil {
+			return 0, err
+		}
+		if a.crypt.stale(data) {
+			plain, err := a.crypt.open(data)
+			if err != nil {
+				return 0, err
+			}
+			if data, err = a.crypt.seal(plain); err != nil {
+				return 0, err
+			}
+			stale++
+		}
+		blocks[i] = data
+	}
+	if stale == 0 {
+		return 0, nil
+	}
+	return stale, writeSegmentFile(path, index, blocks)
+}
+
+// streams lists the IDs of streams with an archive directory
+func (a *SegmentArchive) streams() ([]string, error) {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, err
+	}
+	var streamIDs []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if streamID, err := url.PathUnescape(entry.Name()); err == nil {
+			streamIDs = append(streamIDs, streamID)
+		}
+	}
+	return streamIDs, nil
+}
+
+// segments lists a stream's segment files using only their names
+func (a *SegmentArchive) segments(streamID string) ([]segmentFile, error) {
+	var segments []segmentFile
+
+	err := filepath.WalkDir(a.streamDir(streamID), func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, segmentExt) {
+			return nil
+		}
+
+		parts := strings.Split(strings.TrimSuffix(d.Name(), segmentExt), "-")
+		if len(parts) < 2 {
+			return nil
+		}
+		minNano, err1 := strconv.ParseInt(parts[0], 10, 64)
+		maxNano, err2 := strconv.ParseInt(parts[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil
+		}
+		segments = append(segments, segmentFile{
+			path:    path,
+			minTime: time.Unix(0, minNano),
+			maxTime: time.Unix(0, maxNano),
+		})
+		return nil
+	})
+
+	return segments, err
+}
+
+func (a *SegmentArchive) streamDir(streamID string) string {
+	return filepath.Join(a.dir, url.PathEscape(streamID))
+}
+
+// logKey tells copies of one line apart from other lines at the same time.
+// Archived segments keep their timestamps when streams are merged, so they
+// may collide with the destination's lines.
+type logKey struct {
+	nano    int64
+	message string
+}
+
+func keyOf(log LogLine) logKey {
+	return logKey{log.Timestamp.UnixNano(), log.Message}
+}
+
+func dayOf(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// TieredStorage serves GetLogs from hot storage first and tops up from the
+// segment archive when the hot tier has fewer lines than requested. Without
+// a limit, at most maxArchiveLines archived lines are added.
+type TieredStorage struct {
+	Storage
+	archive *SegmentArchive
+}
+
+func NewTieredStorage(hot Storage, archive *SegmentArchive) *TieredStorage {
+	return &TieredStorage{Storage: hot, archive: archive}
+}
+
+// Unwrap returns the hot storage backend
+func (t *TieredStorage) Unwrap() Storage {
+	return t.Storage
+}
+
+func (t *TieredStorage) GetLogs(ctx context.Context, streamID string, opts GetLogsOptions) ([]LogLine, error) {
+	hot, err := t.Storage.GetLogs(ctx, streamID, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Limit > 0 && len(hot) >= opts.Limit {
+		return hot, nil
+	}
+
+	remaining := maxArchiveLines
+	if opts.Limit > 0 {
+		remaining = opts.Limit - len(hot)
+	}
+	// Read enough to make up for archived copies of lines that are still hot
+	cold, err := t.archive.Read(ctx, streamID, opts, remaining+len(hot))
+	if err != nil {
+		return nil, err
+	}
+	inHot := make(map[logKey]bool, len(hot))
+	for _, log := range hot {
+		inHot[keyOf(log)] = true
+	}
+	cold = slices.DeleteFunc(cold, func(log LogLine) bool {
+		return inHot[keyOf(log)]
+	})
+	if len(cold) > remaining {
+		cold = cold[len(cold)-remaining:]
+	}
+	if len(cold) == 0 {
+		return hot, nil
+	}
+
+	logs := append(cold, hot...)
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].Timestamp.Before(logs[j].Timestamp)
+	})
+	return logs, nil
+}
+
+// DeleteStream removes all archived segments of a stream
+func (a *SegmentArchive) DeleteStream(streamID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return os.RemoveAll(a.streamDir(streamID))
+}
+
+// MergeStreams moves srcID's segments under dstID
+func (a *SegmentArchive) MergeStreams(srcID, dstID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	segments, err := a.segments(srcID)
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range segments {
+		rel, err := filepath.Rel(a.streamDir(srcID), seg.path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(a.streamDir(dstID), rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		if _, err := os.Stat(target); err == nil {
+			target = strings.TrimSuffix(target, segmentExt) + fmt.Sprintf("-%d%s", time.Now().UnixNano(), segmentExt)
+		}
+		if err := os.Rename(seg.path, target); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(a.streamDir(srcID))
+}
+
+// DeleteStream removes the stream from both tiers
+func (t *TieredStorage) DeleteStream(ctx context.Context, streamID string) error {
+	if err := t.Storage.DeleteStream(ctx, streamID); err != nil {
+		return err
+	}
+	return t.archive.DeleteStream(streamID)
+}
+
+// MergeStreams merges the hot tier, then moves archived segments
+func (t *TieredStorage) MergeStreams(ctx context.Context, srcID, dstID string) error {
+	if err := t.Storage.MergeStreams(ctx, srcID, dstID); err != nil {
+		return err
+	}
+	return t.archive.MergeStreams(srcID, dstID)
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestArchive(t *testing.T) *SegmentArchive {
+	t.Helper()
+	archive, err := NewSegmentArchive(filepath.Join(t.TempDir(), "archive"))
+	if err != nil {
+		t.Fatalf("NewSegmentArchive: %v", err)
+	}
+	return archive
+}
+
+func TestSegmentArchiveRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	archive := newTestArchive(t)
+
+	// Two blocks on one day and a few lines on the next
+	midnight := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	logs := append(makeLogs("s", segmentBlockLines+500, midnight.Add(-time.Second)), makeLogs("s", 10, midnight.Add(9*time.Second))...)
+	if err := archive.Write("s", logs); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	for _, day := range []string{"2024-03-01", "2024-03-02"} {
+		if files, _ := os.ReadDir(filepath.Join(archive.streamDir("s"), day)); len(files) != 1 {
+			t.Errorf("day %s has %d segments, want 1", day, len(files))
+		}
+	}
+
+	got, err := archive.Read(ctx, "s", GetLogsOptions{}, 0)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(got) != len(logs) {
+		t.Fatalf("read %d lines, want %d", len(got), len(logs))
+	}
+	for i := range got {
+		if !got[i].Timestamp.Equal(logs[i].Timestamp) || got[i].Message != logs[i].Message || got[i].Labels["pod"] != "api-1" {
+			t.Fatalf("line %d = %+v, want %+v", i, got[i], logs[i])
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		opts  GetLogsOptions
+		limit int
+		want  int
+	}{
+		"limit keeps newest": {GetLogsOptions{}, 20, 20},
+		"next day only":      {GetLogsOptions{Since: midnight}, 0, 10},
+		"until":              {GetLogsOptions{Until: midnight.Add(-time.Second)}, 0, segmentBlockLines + 500},
+		"level":              {GetLogsOptions{Levels: []string{"ERROR"}, Since: midnight}, 0, 4},
+		"search":             {GetLogsOptions{Search: "LINE 1523"}, 0, 1},
+	} {
+		got, err := archive.Read(ctx, "s", tc.opts, tc.limit)
+		if err != nil {
+			t.Fatalf("%s: Read: %v", name, err)
+		}
+		if len(got) != tc.want {
+			t.Errorf("%s: read %d lines, want %d", name, len(got), tc.want)
+		}
+	}
+	if got, _ := archive.Read(ctx, "s", GetLogsOptions{}, 20); got[19].Message != "line 9" {
+		t.Errorf("limited read ends with %q, want the newest line", got[19].Message)
+	}
+
+	// Writing the same lines again, as a retried eviction would, adds no lines
+	if err := archive.Write("s", logs[len(logs)-50:]); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := archive.Read(ctx, "s", GetLogsOptions{}, 0); len(got) != len(logs) {
+		t.Errorf("after rewriting: read %d lines, want %d", len(got), len(logs))
+	}
+}
+
+func TestSegmentArchivePrune(t *testing.T) {
+	ctx := context.Background()
+	archive := newTestArchive(t)
+	now := time.Now()
+	archive.Write("old", makeLogs("old", 5, now.Add(-10*24*time.Hour)))
+	archive.Write("mixed", makeLogs("mixed", 5, now.Add(-10*24*time.Hour)))
+	archive.Write("mixed", makeLogs("mixed", 5, now))
+
+	removed, err := archive.Prune(24 * time.Hour)
+	if err != nil || removed != 2 {
+		t.Fatalf("Prune = %d, %v; want 2 segments", removed, err)
+	}
+	if _, err := os.Stat(archive.streamDir("old")); !os.IsNotExist(err) {
+		t.Errorf("emptied stream directory left behind: %v", err)
+	}
+	days, _ := os.ReadDir(archive.streamDir("mixed"))
+	if len(days) != 1 || days[0].Name() != dayOf(now) {
+		t.Errorf("mixed stream keeps days %v, want only today's", days)
+	}
+	if got, _ := archive.Read(ctx, "mixed", GetLogsOptions{}, 0); len(got) != 5 {
+		t.Errorf("read %d lines after pruning, want 5", len(got))
+	}
+}
+
+// newTestTiered returns a tiered store whose compactor archives everything
+// past the newest maxLines lines of a stream
+func newTestTiered(t *testing.T, maxLines int) (*TieredStorage, *BoltStorage, *SegmentArchive, *Compactor) {
+	t.Helper()
+	hot, err := NewBoltStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hot.Close() })
+	archive := newTestArchive(t)
+	store := NewTieredStorage(hot, archive)
+	compactor := NewCompactor(store, CompactorConfig{Defaults: RetentionPolicy{MaxLines: maxLines}, Archive: archive})
+	return store, hot, archive, compactor
+}
+
+func TestTieredStorageReadsAcrossTiers(t *testing.T) {
+	ctx := context.Background()
+	store, hot, archive, compactor := newTestTiered(t, 10)
+	logs := makeLogs("s", 30, time.Now())
+	mustStore(t, store, "s", logs)
+	if err := compactor.RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustGet(t, hot, "s", GetLogsOptions{}); len(got) != 10 {
+		t.Fatalf("hot tier kept %d lines, want 10", len(got))
+	}
+
+	got := mustGet(t, store, "s", GetLogsOptions{})
+	if len(got) != 30 {
+		t.Fatalf("tiered read returned %d lines, want 30", len(got))
+	}
+	for i := range got {
+		if got[i].Message != logs[i].Message || got[i].StreamID != "s" {
+			t.Fatalf("line %d = %+v, want %q", i, got[i], logs[i].Message)
+		}
+	}
+	if got := mustGet(t, store, "s", GetLogsOptions{Limit: 15}); len(got) != 15 || got[0].Message != "line 15" || got[14].Message != "line 29" {
+		t.Errorf("limited read across tiers = %d lines from %q", len(got), got[0].Message)
+	}
+	if got := mustGet(t, store, "s", GetLogsOptions{Levels: []string{"ERROR"}}); len(got) != 10 {
+		t.Errorf("ERROR lines across tiers = %d, want 10", len(got))
+	}
+
+	// An eviction that fails after archiving leaves the lines in both tiers
+	_, err := hot.EnforceRetention(ctx, "s", RetentionPolicy{MaxLines: 5}, func(evicted []LogLine) error {
+		if err := archive.Write("s", evicted); err != nil {
+			return err
+		}
+		return errors.New("rolled back")
+	})
+	if err == nil {
+		t.Fatal("expected the eviction to fail")
+	}
+	if got := mustGet(t, store, "s", GetLogsOptions{}); len(got) != 30 {
+		t.Errorf("after a rolled-back eviction: %d lines, want 30", len(got))
+	}
+	if got := mustGet(t, store, "s", GetLogsOptions{Limit: 12}); len(got) != 12 || got[0].Message != "line 18" {
+		t.Errorf("limited read after a rolled-back eviction = %d lines from %q", len(got), got[0].Message)
+	}
+
+	// Export reads both tiers
+	var buf bytes.Buffer
+	stats, err := Export(ctx, store, &buf, TransferFilter{})
+	if err != nil || stats.Logs != 30 {
+		t.Errorf("Export = %+v, %v; want 30 logs", stats, err)
+	}
+}
+
+func TestTieredStorageMergeAndDelete(t *testing.T) {
+	ctx := context.Background()
+	store, _, archive, compactor := newTestTiered(t, 2)
+	mustStore(t, store, "a", makeLogs("a", 5, time.Now().Add(-time.Hour)))
+	mustStore(t, store, "b", makeLogs("b", 5, time.Now()))
+	if err := compactor.RunOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.MergeStreams(ctx, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	got := mustGet(t, store, "b", GetLogsOptions{})
+	if len(got) != 10 {
+		t.Fatalf("merged stream has %d lines, want 10", len(got))
+	}
+	for _, log := range got {
+		if log.StreamID != "b" {
+			t.Fatalf("archived line kept stream %q", log.StreamID)
+		}
+	}
+	if _, err := os.Stat(archive.streamDir("a")); !os.IsNotExist(err) {
+		t.Errorf("source archive left behind: %v", err)
+	}
+
+	if err := store.DeleteStream(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if segments, _ := archive.segments("b"); len(segments) != 0 {
+		t.Errorf("%d segments left after DeleteStream", len(segments))
+	}
+}
+
+func TestSegmentFileFormat(t *testing.T) {
+	archive := newTestArchive(t)
+	archive.Write("s", makeLogs("s", 3, time.Now()))
+	segments, _ := archive.segments("s")
+	if len(segments) != 1 {
+		t.Fatalf("got %d segments", len(segments))
+	}
+
+	f, err := os.Open(segments[0].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	header := make([]byte, len(segmentMagic))
+	f.ReadAt(header, 0)
+	if !bytes.Equal(header, segmentMagic) {
+		t.Errorf("header = %q", header)
+	}
+	index, err := readSegmentIndex(f)
+	if err != nil {
+		t.Fatalf("readSegmentIndex: %v", err)
+	}
+	data, _ := json.Marshal(index)
+	if index.StreamID != "s" || index.Count != 3 || len(index.Blocks) != 1 {
+		t.Errorf("index = %s", data)
+	}
+	if !index.MinTime.Equal(segments[0].minTime) || !index.MaxTime.Equal(segments[0].maxTime) {
+		t.Errorf("file name %s disagrees with index %s", filepath.Base(segments[0].path), data)
+	}
+
+	// A truncated segment is an error, not an empty read
+	raw, _ := os.ReadFile(segments[0].path)
+	os.WriteFile(segments[0].path, raw[:len(raw)-3], 0600)
+	if _, err := archive.Read(context.Background(), "s", GetLogsOptions{}, 0); err == nil {
+		t.Error("truncated segment read without error")
+	}
+}
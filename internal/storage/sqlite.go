@@ -0,0 +1,1437 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	_ "modernc.org/sqlite" // Registers the pure-Go "sqlite" driver
+)
+
+// sqliteMigrations are applied in order; PRAGMA user_version records how
+// many have run. Append new steps, never edit old ones.
+var sqliteMigrations = []string{
+	// 1: core tables, label index and trigram full-text index on messages
+	`CREATE TABLE streams (
+		id   TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	);
+
+	CREATE TABLE logs (
+		id        INTEGER PRIMARY KEY,
+		stream_id TEXT NOT NULL,
+		ts        INTEGER NOT NULL,
+		tz        INTEGER NOT NULL,
+		level     TEXT NOT NULL,
+		message   TEXT NOT NULL,
+		raw       TEXT NOT NULL,
+		labels    TEXT NOT NULL,
+		size      INTEGER NOT NULL,
+		UNIQUE (stream_id, ts)
+	);
+	CREATE INDEX logs_stream_level_ts ON logs (stream_id, level, ts);
+
+	CREATE TABLE log_labels (
+		log_id INTEGER NOT NULL REFERENCES logs (id) ON DELETE CASCADE,
+		key    TEXT NOT NULL,
+		value  TEXT NOT NULL,
+		PRIMARY KEY (log_id, key)
+	);
+	CREATE INDEX log_labels_key_value ON log_labels (key, value, log_id);
+
+	CREATE VIRTUAL TABLE logs_fts USING fts5 (
+		message, content = 'logs', content_rowid = 'id', tokenize = 'trigram'
+	);
+	CREATE TRIGGER logs_fts_insert AFTER INSERT ON logs BEGIN
+		INSERT INTO logs_fts (rowid, message) VALUES (new.id, new.message);
+	END;
+	CREATE TRIGGER logs_fts_delete AFTER DELETE ON logs BEGIN
+		INSERT INTO logs_fts (logs_fts, rowid, message) VALUES ('delete', old.id, old.message);
+	END;
+	CREATE TRIGGER logs_fts_update AFTER UPDATE OF message ON logs BEGIN
+		INSERT INTO logs_fts (logs_fts, rowid, message) VALUES ('delete', old.id, old.message);
+		INSERT INTO logs_fts (rowid, message) VALUES (new.id, new.message);
+	END;
+
+	CREATE TABLE rollups (
+		stream_id  TEXT NOT NULL,
+		resolution TEXT NOT NULL,
+		start      INTEGER NOT NULL,
+		total      INTEGER NOT NULL,
+		counts     TEXT NOT NULL,
+		PRIMARY KEY (stream_id, resolution, start)
+	);
+
+	CREATE TABLE contexts (
+		stream_id TEXT PRIMARY KEY,
+		data      TEXT NOT NULL
+	);
+
+	CREATE TABLE analyses (
+		key       TEXT PRIMARY KEY,
+		stream_id TEXT NOT NULL,
+		data      TEXT NOT NULL
+	);
+	CREATE INDEX analyses_stream ON analyses (stream_id, key);
+
+	CREATE TABLE retention (
+		scope  TEXT NOT NULL,
+		target TEXT NOT NULL,
+		data   TEXT NOT NULL,
+		PRIMARY KEY (scope, target)
+	);`,
+
+	// 2: analysis jobs; finished is 0 until the job is done or failed
+	`CREATE TABLE jobs (
+		id        TEXT PRIMARY KEY,
+		stream_id TEXT NOT NULL,
+		finished  INTEGER NOT NULL,
+		data      TEXT NOT NULL
+	);
+	CREATE INDEX jobs_stream ON jobs (stream_id, id);
+	CREATE INDEX jobs_finished ON jobs (finished);`,
+
+	// 3: digests, keyed by time-ordered IDs
+	`CREATE TABLE digests (
+		id   TEXT PRIMARY KEY,
+		data TEXT NOT NULL
+	);`,
+
+	// 4: mined log patterns
+	`CREATE TABLE patterns (
+		stream_id TEXT NOT NULL,
+		id        TEXT NOT NULL,
+		count     INTEGER NOT NULL,
+		data      TEXT NOT NULL,
+		PRIMARY KEY (stream_id, id)
+	);`,
+
+	// 5: events
+	`CREATE TABLE events (
+		stream_id TEXT NOT NULL,
+		id        TEXT NOT NULL,
+		type      TEXT NOT NULL,
+		ts        INTEGER NOT NULL,
+		data      TEXT NOT NULL,
+		PRIMARY KEY (stream_id, id)
+	);
+	CREATE INDEX events_ts ON events (ts);`,
+
+	// 6: error fingerprints
+	`CREATE TABLE fingerprints (
+		stream_id   TEXT NOT NULL,
+		fingerprint TEXT NOT NULL,
+		first_seen  INTEGER NOT NULL,
+		PRIMARY KEY (stream_id, fingerprint)
+	);`,
+
+	// 7: deleted and merged streams
+	`CREATE TABLE tombstones (
+		stream_id TEXT PRIMARY KEY,
+		data      TEXT NOT NULL
+	);`,
+}
+
+// logColumns is the column list scanned by scanLog
+const logColumns = "id, ts, tz, level, message, raw, labels"
+
+// sqliteDeleteBatch bounds the number of ids in one DELETE ... IN (...)
+const sqliteDeleteBatch = 500
+
+// SQLiteStorage keeps logs in SQLite so filters run as indexed queries
+type SQLiteStorage struct {
+	db *sql.DB
+
+	// writer admits one write transaction at a time. SQLite only allows one
+	// anyway, and its busy handler starves writers under heavy contention.
+	writer chan struct{}
+}
+
+// sqlQuerier is satisfied by both *sql.DB and *sql.Tx
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
+	// WAL lets readers run alongside the single writer; immediate transactions
+	// take the write lock up front so busy_timeout applies instead of failing
+	// on lock upgrades
+	dsn := path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SQLiteStorage{db: db, writer: make(chan struct{}, 1)}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLiteStorage) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("database schema v%d is newer than this binary supports (v%d)", version, len(sqliteMigrations))
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		err := s.withTx(context.Background(), func(tx *sql.Tx) error {
+			if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+				return err
+			}
+			_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("sqlite migration %d failed: %w", i+1, err)
+		}
+		log.Printf("Applied sqlite schema migration %d", i+1)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// Backup writes a consistent snapshot taken with VACUUM INTO
+func (s *SQLiteStorage) Backup(w io.Writer) (int64, error) {
+	dir, err := os.MkdirTemp("", "logvoyant-backup-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "backup.db")
+	if _, err := s.db.Exec("VACUUM INTO ?", path); err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(w, f)
+}
+
+// withTx runs fn in a write transaction bound to ctx, committing if it succeeds
+func (s *SQLiteStorage) withTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	select {
+	case s.writer <- struct{}{}:
+		defer func() { <-s.writer }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// StoreLogs inserts logs, replacing any with the same timestamp, and updates
+// rollups, stream metadata and context counters in the same transaction
+func (s *SQLiteStorage) StoreLogs(ctx context.Context, streamID string, logs []LogLine) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if t, err := readTombstoneSQL(ctx, tx, streamID); err == nil {
+			return t.err()
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		deleteLog, err := tx.PrepareContext(ctx, "DELETE FROM logs WHERE stream_id = ? AND ts = ?")
+		if err != nil {
+			return err
+		}
+		defer deleteLog.Close()
+		insertLog, err := tx.PrepareContext(ctx, `INSERT INTO logs (stream_id, ts, tz, level, message, raw, labels, size)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer insertLog.Close()
+		insertLabel, err := tx.PrepareContext(ctx, "INSERT INTO log_labels (log_id, key, value) VALUES (?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer insertLabel.Close()
+
+		// Only lines that did not overwrite one are counted
+		var added []LogLine
+		errorCount := 0
+		for _, log := range logs {
+			ts := log.Timestamp.UnixNano()
+			_, offset := log.Timestamp.Zone()
+			labels, err := json.Marshal(copyLabels(log.Labels))
+			if err != nil {
+				return err
+			}
+			size := logSize(log.Timestamp.Format(time.RFC3339Nano), log)
+
+			res, err := deleteLog.ExecContext(ctx, streamID, ts)
+			if err != nil {
+				return err
+			}
+			replaced, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			res, err = insertLog.ExecContext(ctx, streamID, ts, offset, log.Level, log.Message, log.Raw, string(labels), size)
+			if err != nil {
+				return err
+			}
+			id, err := res.LastInsertId()
+			if err != nil {
+				return err
+			}
+			for k, v := range log.Labels {
+				if _, err := insertLabel.ExecContext(ctx, id, k, v); err != nil {
+					return err
+				}
+			}
+
+			if replaced > 0 {
+				continue
+			}
+			added = append(added, log)
+			if log.Level == "ERROR" || log.Level == "FATAL" {
+				errorCount++
+			}
+		}
+
+		if err := updateRollupsSQL(ctx, tx, streamID, added); err != nil {
+			return err
+		}
+
+		// Update stream metadata
+		stream, err := readStreamSQL(ctx, tx, streamID)
+		if errors.Is(err, ErrNotFound) {
+			stream = &Stream{ID: streamID, Active: true}
+		} else if err != nil {
+			return err
+		}
+		stream.LastSeen = time.Now()
+		if err := putStreamSQL(ctx, tx, stream); err != nil {
+			return err
+		}
+
+		// Counters only move for streams that have a context
+		streamCtx, exists, err := readContextSQL(ctx, tx, streamID)
+		if err != nil || !exists {
+			return err
+		}
+		streamCtx.TotalLogs += int64(len(added))
+		streamCtx.ErrorCount += int64(errorCount)
+		streamCtx.LastSeen = time.Now()
+		if streamCtx.TotalLogs > 0 {
+			streamCtx.Patterns.ErrorRate = float64(streamCtx.ErrorCount) / float64(streamCtx.TotalLogs)
+		}
+		return putContextSQL(ctx, tx, streamID, streamCtx)
+	})
+}
+
+// GetLogs pushes every filter into the query: time bounds and levels use the
+// (stream, ts) and (stream, level, ts) indexes, labels the label index and
+// search the trigram FTS index
+func (s *SQLiteStorage) GetLogs(ctx context.Context, streamID string, opts GetLogsOptions) ([]LogLine, error) {
+	query := "SELECT " + logColumns + " FROM logs WHERE stream_id = ?"
+	args := []any{streamID}
+
+	if !opts.Since.IsZero() {
+		query += " AND ts >= ?"
+		args = append(args, opts.Since.UnixNano())
+	}
+	if !opts.Until.IsZero() {
+		query += " AND ts <= ?"
+		args = append(args, opts.Until.UnixNano())
+	}
+	if len(opts.Levels) > 0 {
+		query += " AND level IN (?" + strings.Repeat(", ?", len(opts.Levels)-1) + ")"
+		for _, level := range opts.Levels {
+			args = append(args, level)
+		}
+	}
+	for k, v := range opts.Labels {
+		query += " AND id IN (SELECT log_id FROM log_labels WHERE key = ? AND value = ?)"
+		args = append(args, k, v)
+	}
+	if opts.Search != "" {
+		// Trigrams need at least three characters to match anything
+		if utf8.RuneCountInString(opts.Search) >= 3 {
+			query += " AND id IN (SELECT rowid FROM logs_fts WHERE logs_fts MATCH ?)"
+			args = append(args, `"`+strings.ReplaceAll(opts.Search, `"`, `""`)+`"`)
+		} else {
+			query += " AND instr(lower(message), lower(?)) > 0"
+			args = append(args, opts.Search)
+		}
+	}
+
+	query += " ORDER BY ts DESC"
+	if opts.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, opts.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []LogLine
+	for rows.Next() {
+		var id int64
+		log, err := scanLog(rows, streamID, &id)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Collected newest first
+	for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+		logs[i], logs[j] = logs[j], logs[i]
+	}
+	return logs, nil
+}
+
+func (s *SQLiteStorage) ListStreams(ctx context.Context) ([]Stream, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT data FROM streams ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	var streams []Stream
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		var stream Stream
+		if err := json.Unmarshal([]byte(data), &stream); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		streams = append(streams, stream)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i := range streams {
+		stream := &streams[i]
+
+		// Compute rates from rollups
+		minutes, err := readRollupsSQL(ctx, s.db, stream.ID, ResolutionMinute, now.Add(-time.Hour))
+		if err != nil {
+			return nil, err
+		}
+		hours, err := readRollupsSQL(ctx, s.db, stream.ID, ResolutionHour, now.Add(-24*time.Hour))
+		if err != nil {
+			return nil, err
+		}
+		applyRollupStats(stream, minutes, hours, now)
+
+		// Enrich with context data
+		streamCtx, exists, err := readContextSQL(ctx, s.db, stream.ID)
+		if err != nil {
+			return nil, err
+		}
+		if exists && len(streamCtx.Analyses) > 0 {
+			latest := streamCtx.Analyses[len(streamCtx.Analyses)-1]
+			stream.ContextSummary = fmt.Sprintf("Last: %s (%s)", latest.Summary, latest.Severity)
+		}
+	}
+
+	return streams, nil
+}
+
+func (s *SQLiteStorage) GetStream(ctx context.Context, streamID string) (*Stream, error) {
+	return readStreamSQL(ctx, s.db, streamID)
+}
+
+func (s *SQLiteStorage) UpdateStream(ctx context.Context, stream *Stream) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM tombstones WHERE stream_id = ?", stream.ID); err != nil {
+			return err
+		}
+		return putStreamSQL(ctx, tx, stream)
+	})
+}
+
+// PatchStream renames or relabels a stream
+func (s *SQLiteStorage) PatchStream(ctx context.Context, streamID string, patch StreamPatch) (*Stream, error) {
+	var stream *Stream
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		stream, err = readStreamSQL(ctx, tx, streamID)
+		if err != nil {
+			return err
+		}
+		patch.apply(stream)
+		return putStreamSQL(ctx, tx, stream)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// DeleteStream removes a stream with its logs, rollups, context, analyses,
+// patterns, events and error fingerprints
+func (s *SQLiteStorage) DeleteStream(ctx context.Context, streamID string) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := readStreamSQL(ctx, tx, streamID); err != nil {
+			return err
+		}
+		if err := deleteStreamSQL(ctx, tx, streamID); err != nil {
+			return err
+		}
+		return putTombstoneSQL(ctx, tx, Tombstone{StreamID: streamID, At: time.Now()})
+	})
+}
+
+// MergeStreams folds srcID's logs, rollups, analyses, events, error
+// fingerprints and context into dstID, then deletes srcID. Its patterns are
+// dropped; dstID's are relearned from new logs.
+func (s *SQLiteStorage) MergeStreams(ctx context.Context, srcID, dstID string) error {
+	if srcID == dstID {
+		return fmt.Errorf("cannot merge a stream into itself: %w", ErrConflict)
+	}
+
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range []string{srcID, dstID} {
+			if _, err := readStreamSQL(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+
+		if err := mergeLogsSQL(ctx, tx, srcID, dstID); err != nil {
+			return err
+		}
+		if err := mergeRollupsSQL(ctx, tx, srcID, dstID); err != nil {
+			return err
+		}
+		if err := mergeAnalysesSQL(ctx, tx, srcID, dstID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE events SET stream_id = ? WHERE stream_id = ?", dstID, srcID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO fingerprints (stream_id, fingerprint, first_seen)
+			SELECT ?, fingerprint, first_seen FROM fingerprints WHERE stream_id = ?
+			ON CONFLICT (stream_id, fingerprint) DO UPDATE SET first_seen = MIN(first_seen, excluded.first_seen)`, dstID, srcID)
+		if err != nil {
+			return err
+		}
+		if err := mergeContextSQL(ctx, tx, srcID, dstID); err != nil {
+			return err
+		}
+
+		if err := deleteStreamSQL(ctx, tx, srcID); err != nil {
+			return err
+		}
+		return putTombstoneSQL(ctx, tx, Tombstone{StreamID: srcID, MergedInto: dstID, At: time.Now()})
+	})
+}
+
+// GetTombstone returns the record of a deleted or merged stream
+func (s *SQLiteStorage) GetTombstone(ctx context.Context, streamID string) (*Tombstone, error) {
+	return readTombstoneSQL(ctx, s.db, streamID)
+}
+
+// GetHistogram returns a contiguous series of rollup buckets from since to now
+func (s *SQLiteStorage) GetHistogram(ctx context.Context, streamID string, resolution string, since time.Time) ([]RollupBucket, error) {
+	step, err := histogramStep(resolution, since)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets, err := readRollupsSQL(ctx, s.db, streamID, resolution, since)
+	if err != nil {
+		return nil, err
+	}
+	return fillRollupGaps(buckets, step, since, time.Now()), nil
+}
+
+func (s *SQLiteStorage) GetContext(ctx context.Context, streamID string) (*StreamContext, error) {
+	streamCtx, _, err := readContextSQL(ctx, s.db, streamID)
+	return streamCtx, err
+}
+
+func (s *SQLiteStorage) UpdateContext(ctx context.Context, streamID string, streamCtx *StreamContext) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		return putContextSQL(ctx, tx, streamID, streamCtx)
+	})
+}
+
+// MutateContext applies fn to the stream's context inside one transaction.
+// If fn returns an error nothing is written.
+func (s *SQLiteStorage) MutateContext(ctx context.Context, streamID string, fn func(*StreamContext) error) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		streamCtx, _, err := readContextSQL(ctx, tx, streamID)
+		if err != nil {
+			return err
+		}
+		if err := fn(streamCtx); err != nil {
+			return err
+		}
+		return putContextSQL(ctx, tx, streamID, streamCtx)
+	})
+}
+
+func (s *SQLiteStorage) StoreAnalysis(ctx context.Context, analysis *Analysis) error {
+	data, err := json.Marshal(analysis)
+	if err != nil {
+		return err
+	}
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO analyses (key, stream_id, data) VALUES (?, ?, ?)
+			ON CONFLICT (key) DO UPDATE SET stream_id = excluded.stream_id, data = excluded.data`,
+			string(analysisKey(analysis)), analysis.StreamID, string(data))
+		return err
+	})
+}
+
+func (s *SQLiteStorage) GetAnalysisHistory(ctx context.Context, streamID string, limit int) ([]Analysis, error) {
+	query := "SELECT data FROM analyses WHERE stream_id = ? ORDER BY key"
+	args := []any{streamID}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var analyses []Analysis
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var analysis Analysis
+		if err := json.Unmarshal([]byte(data), &analysis); err != nil {
+			continue
+		}
+		analyses = append(analyses, analysis)
+	}
+	return analyses, rows.Err()
+}
+
+func (s *SQLiteStorage) SaveJob(ctx context.Context, job *AnalysisJob) error {
+	if err := job.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	var finished int64
+	if job.Finished() {
+		finished = job.FinishedAt.UnixNano()
+	}
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO jobs (id, stream_id, finished, data) VALUES (?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET stream_id = excluded.stream_id, finished = excluded.finished, data = excluded.data`,
+			job.ID, job.StreamID, finished, string(data))
+		return err
+	})
+}
+
+func (s *SQLiteStorage) GetJob(ctx context.Context, id string) (*AnalysisJob, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, "SELECT data FROM jobs WHERE id = ?", id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("job %q: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job AnalysisJob
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *SQLiteStorage) ListJobs(ctx context.Context, streamID string) ([]AnalysisJob, error) {
+	query := "SELECT data FROM jobs ORDER BY id"
+	var args []any
+	if streamID != "" {
+		query = "SELECT data FROM jobs WHERE stream_id = ? ORDER BY id"
+		args = append(args, streamID)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []AnalysisJob
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var job AnalysisJob
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *SQLiteStorage) PruneJobs(ctx context.Context, before time.Time) (int, error) {
+	var deleted int64
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM jobs WHERE finished > 0 AND finished < ?", before.UnixNano())
+		if err != nil {
+			return err
+		}
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	return int(deleted), err
+}
+
+func (s *SQLiteStorage) SaveDigest(ctx context.Context, digest *Digest) error {
+	if err := digest.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(digest)
+	if err != nil {
+		return err
+	}
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO digests (id, data) VALUES (?, ?)
+			ON CONFLICT (id) DO UPDATE SET data = excluded.data`,
+			digest.ID, string(data))
+		return err
+	})
+}
+
+func (s *SQLiteStorage) GetDigest(ctx context.Context, id string) (*Digest, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, "SELECT data FROM digests WHERE id = ?", id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("digest %q: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var digest Digest
+	if err := json.Unmarshal([]byte(data), &digest); err != nil {
+		return nil, err
+	}
+	return &digest, nil
+}
+
+func (s *SQLiteStorage) ListDigests(ctx context.Context, limit int) ([]Digest, error) {
+	query := "SELECT data FROM digests ORDER BY id DESC"
+	var args []any
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var digests []Digest
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var digest Digest
+		if err := json.Unmarshal([]byte(data), &digest); err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+	return digests, rows.Err()
+}
+
+// SavePatterns replaces the stream's patterns
+func (s *SQLiteStorage) SavePatterns(ctx context.Context, streamID string, patterns []LogPattern) error {
+	if err := validatePatterns(patterns); err != nil {
+		return err
+	}
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM patterns WHERE stream_id = ?", streamID); err != nil {
+			return err
+		}
+		for _, p := range patterns {
+			p.StreamID = streamID
+			data, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, "INSERT INTO patterns (stream_id, id, count, data) VALUES (?, ?, ?, ?)",
+				streamID, p.ID, p.Count, string(data))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteStorage) GetPatterns(ctx context.Context, streamID string) ([]LogPattern, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT data FROM patterns WHERE stream_id = ? ORDER BY count DESC, id", streamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var patterns []LogPattern
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var p LogPattern
+		if err := json.Unmarshal([]byte(data), &p); err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, rows.Err()
+}
+
+// SaveEvent stores an event and drops the stream's events older than
+// eventRetention
+func (s *SQLiteStorage) SaveEvent(ctx context.Context, event *Event) error {
+	if err := event.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO events (stream_id, id, type, ts, data) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (stream_id, id) DO UPDATE SET type = excluded.type, ts = excluded.ts, data = excluded.data`,
+			event.StreamID, event.ID, event.Type, event.Timestamp.UnixNano(), string(data))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM events WHERE stream_id = ? AND ts < ?",
+			event.StreamID, time.Now().Add(-eventRetention).UnixNano())
+		return err
+	})
+}
+
+// ListEvents reads the stream ID from its column, which merges rewrite
+func (s *SQLiteStorage) ListEvents(ctx context.Context, opts ListEventsOptions) ([]Event, error) {
+	query := "SELECT stream_id, data FROM events WHERE 1 = 1"
+	var args []any
+	if opts.StreamID != "" {
+		query += " AND stream_id = ?"
+		args = append(args, opts.StreamID)
+	}
+	if opts.Type != "" {
+		query += " AND type = ?"
+		args = append(args, opts.Type)
+	}
+	if !opts.Since.IsZero() {
+		query += " AND ts >= ?"
+		args = append(args, opts.Since.UnixNano())
+	}
+	query += " ORDER BY ts DESC, id DESC"
+	if opts.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, opts.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var streamID, data string
+		if err := rows.Scan(&streamID, &data); err != nil {
+			return nil, err
+		}
+		var event Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return nil, err
+		}
+		event.StreamID = streamID
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// RecordFingerprints adds fingerprints to the stream's history and returns
+// those it did not have, in one transaction
+func (s *SQLiteStorage) RecordFingerprints(ctx context.Context, streamID string, fingerprints []string, at time.Time) ([]string, error) {
+	if err := validateFingerprints(fingerprints); err != nil {
+		return nil, err
+	}
+
+	var novel []string
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		novel = nil
+		for _, fp := range fingerprints {
+			res, err := tx.ExecContext(ctx, `INSERT INTO fingerprints (stream_id, fingerprint, first_seen) VALUES (?, ?, ?)
+				ON CONFLICT (stream_id, fingerprint) DO NOTHING`, streamID, fp, at.UnixNano())
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n == 1 {
+				novel = append(novel, fp)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return novel, nil
+}
+
+// UnseenFingerprints returns the fingerprints missing from the stream's
+// history, like RecordFingerprints but without adding them
+func (s *SQLiteStorage) UnseenFingerprints(ctx context.Context, streamID string, fingerprints []string) ([]string, error) {
+	if err := validateFingerprints(fingerprints); err != nil {
+		return nil, err
+	}
+
+	var unseen []string
+	for _, fp := range fingerprints {
+		var one int
+		err := s.db.QueryRowContext(ctx, "SELECT 1 FROM fingerprints WHERE stream_id = ? AND fingerprint = ?", streamID, fp).Scan(&one)
+		if errors.Is(err, sql.ErrNoRows) {
+			if !slices.Contains(unseen, fp) {
+				unseen = append(unseen, fp)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return unseen, nil
+}
+
+func (s *SQLiteStorage) ListRetentionRules(ctx context.Context) ([]RetentionRule, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT data FROM retention ORDER BY scope, target")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []RetentionRule
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var rule RetentionRule
+		if err := json.Unmarshal([]byte(data), &rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *SQLiteStorage) SetRetentionRule(ctx context.Context, rule RetentionRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO retention (scope, target, data) VALUES (?, ?, ?)
+			ON CONFLICT (scope, target) DO UPDATE SET data = excluded.data`,
+			rule.Scope, rule.Target, string(data))
+		return err
+	})
+}
+
+func (s *SQLiteStorage) DeleteRetentionRule(ctx context.Context, scope, target string) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM retention WHERE scope = ? AND target = ?", scope, target)
+		return err
+	})
+}
+
+// EnforceRetention trims the oldest logs of a stream until it satisfies policy,
+// handing them to evict (if set) first. The lines to drop are picked outside
+// the write transaction so evict's archive I/O does not hold up writers.
+func (s *SQLiteStorage) EnforceRetention(ctx context.Context, streamID string, policy RetentionPolicy, evict EvictFunc) (int, error) {
+	var lines int
+	var size int64
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(SUM(size), 0) FROM logs WHERE stream_id = ?", streamID).
+		Scan(&lines, &size)
+	if err != nil {
+		return 0, err
+	}
+
+	var cutoff time.Time
+	if policy.MaxAge > 0 {
+		cutoff = time.Now().Add(-time.Duration(policy.MaxAge))
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+logColumns+", size FROM logs WHERE stream_id = ? ORDER BY ts", streamID)
+	if err != nil {
+		return 0, err
+	}
+	var doomed []int64
+	var evicted []LogLine
+	for rows.Next() {
+		var id, rowSize int64
+		log, err := scanLog(rows, streamID, &id, &rowSize)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		expired := !cutoff.IsZero() && log.Timestamp.Before(cutoff)
+		overLines := policy.MaxLines > 0 && lines > policy.MaxLines
+		overBytes := policy.MaxBytes > 0 && size > policy.MaxBytes
+		if !expired && !overLines && !overBytes {
+			break
+		}
+		doomed = append(doomed, id)
+		if evict != nil {
+			evicted = append(evicted, log)
+		}
+		lines--
+		size -= rowSize
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(doomed) == 0 {
+		return 0, nil
+	}
+
+	if evict != nil && len(evicted) > 0 {
+		if err := evict(evicted); err != nil {
+			return 0, fmt.Errorf("failed to evict logs: %w", err)
+		}
+	}
+
+	deleted := 0
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		deleted = 0
+		for rest := doomed; len(rest) > 0; {
+			batch := rest
+			if len(batch) > sqliteDeleteBatch {
+				batch = batch[:sqliteDeleteBatch]
+			}
+			rest = rest[len(batch):]
+
+			args := make([]any, len(batch))
+			for i, id := range batch {
+				args[i] = id
+			}
+			query := "DELETE FROM logs WHERE id IN (?" + strings.Repeat(", ?", len(batch)-1) + ")"
+			res, err := tx.ExecContext(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			deleted += int(n)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// scanLog reads a row selected with logColumns into a log line and its row
+// id. Any columns selected after those are scanned into extra.
+func scanLog(rows *sql.Rows, streamID string, id *int64, extra ...any) (LogLine, error) {
+	var ts int64
+	var tz int
+	var labels string
+	log := LogLine{StreamID: streamID}
+
+	dest := append([]any{id, &ts, &tz, &log.Level, &log.Message, &log.Raw, &labels}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return log, err
+	}
+
+	log.Timestamp = time.Unix(0, ts).In(zoneFor(tz))
+	if err := json.Unmarshal([]byte(labels), &log.Labels); err != nil {
+		return log, err
+	}
+	if log.Labels == nil {
+		log.Labels = map[string]string{}
+	}
+	return log, nil
+}
+
+func readStreamSQL(ctx context.Context, q sqlQuerier, streamID string) (*Stream, error) {
+	var data string
+	err := q.QueryRowContext(ctx, "SELECT data FROM streams WHERE id = ?", streamID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("stream %q: %w", streamID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stream Stream
+	if err := json.Unmarshal([]byte(data), &stream); err != nil {
+		return nil, err
+	}
+	return &stream, nil
+}
+
+func putStreamSQL(ctx context.Context, q sqlQuerier, stream *Stream) error {
+	data, err := json.Marshal(stream)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, `INSERT INTO streams (id, data) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data`, stream.ID, string(data))
+	return err
+}
+
+func readTombstoneSQL(ctx context.Context, q sqlQuerier, streamID string) (*Tombstone, error) {
+	var data string
+	err := q.QueryRowContext(ctx, "SELECT data FROM tombstones WHERE stream_id = ?", streamID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("stream %q has no tombstone: %w", streamID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t := Tombstone{StreamID: streamID}
+	if err := json.Unmarshal([]byte(data), &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func putTombstoneSQL(ctx context.Context, q sqlQuerier, t Tombstone) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, `INSERT INTO tombstones (stream_id, data) VALUES (?, ?)
+		ON CONFLICT (stream_id) DO UPDATE SET data = excluded.data`, t.StreamID, string(data))
+	return err
+}
+
+// readContextSQL returns the stored context, or an empty one and false if
+// the stream has none
+func readContextSQL(ctx context.Context, q sqlQuerier, streamID string) (*StreamContext, bool, error) {
+	var data string
+	err := q.QueryRowContext(ctx, "SELECT data FROM contexts WHERE stream_id = ?", streamID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return newStreamContext(streamID), false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var streamCtx StreamContext
+	if err := json.Unmarshal([]byte(data), &streamCtx); err != nil {
+		return nil, false, err
+	}
+	return &streamCtx, true, nil
+}
+
+func putContextSQL(ctx context.Context, q sqlQuerier, streamID string, streamCtx *StreamContext) error {
+	data, err := json.Marshal(streamCtx)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, `INSERT INTO contexts (stream_id, data) VALUES (?, ?)
+		ON CONFLICT (stream_id) DO UPDATE SET data = excluded.data`, streamID, string(data))
+	return err
+}
+
+// readRollupsSQL returns a stream's buckets of one resolution starting at since
+func readRollupsSQL(ctx context.Context, q sqlQuerier, streamID, resolution string, since time.Time) ([]RollupBucket, error) {
+	step, err := resolutionStep(resolution)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT start, total, counts FROM rollups
+		WHERE stream_id = ? AND resolution = ? AND start >= ? ORDER BY start`,
+		streamID, resolution, since.Truncate(step).Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []RollupBucket
+	for rows.Next() {
+		var start int64
+		var counts string
+		bucket := RollupBucket{}
+		if err := rows.Scan(&start, &bucket.Total, &counts); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(counts), &bucket.Counts); err != nil {
+			continue
+		}
+		bucket.Start = time.Unix(start, 0).UTC()
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
+
+// addRollupSQL adds bucket's counts to the stored bucket with the same start
+func addRollupSQL(ctx context.Context, tx *sql.Tx, streamID, resolution string, bucket RollupBucket) error {
+	var total int64
+	var counts string
+	err := tx.QueryRowContext(ctx, "SELECT total, counts FROM rollups WHERE stream_id = ? AND resolution = ? AND start = ?",
+		streamID, resolution, bucket.Start.Unix()).Scan(&total, &counts)
+	if err == nil {
+		existing := RollupBucket{Total: total}
+		if json.Unmarshal([]byte(counts), &existing.Counts) == nil {
+			bucket.merge(&existing)
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	data, err := json.Marshal(bucket.Counts)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO rollups (stream_id, resolution, start, total, counts) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (stream_id, resolution, start) DO UPDATE SET total = excluded.total, counts = excluded.counts`,
+		streamID, resolution, bucket.Start.Unix(), bucket.Total, string(data))
+	return err
+}
+
+// updateRollupsSQL adds logs to the stream's minute/hour counters and drops
+// buckets that have aged out
+func updateRollupsSQL(ctx context.Context, tx *sql.Tx, streamID string, logs []LogLine) error {
+	pending := make(map[string]*RollupBucket)
+	addToRollups(pending, logs)
+
+	for key, bucket := range pending {
+		resolution := ResolutionMinute
+		if strings.HasPrefix(key, ResolutionHour[:1]) {
+			resolution = ResolutionHour
+		}
+		if err := addRollupSQL(ctx, tx, streamID, resolution, *bucket); err != nil {
+			return err
+		}
+	}
+
+	// Prune expired buckets
+	now := time.Now()
+	for resolution, keep := range map[string]time.Duration{
+		ResolutionMinute: minuteRollupRetention,
+		ResolutionHour:   hourRollupRetention,
+	} {
+		_, err := tx.ExecContext(ctx, "DELETE FROM rollups WHERE stream_id = ? AND resolution = ? AND start < ?",
+			streamID, resolution, now.Add(-keep).Unix())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mergeLogsSQL(ctx context.Context, tx *sql.Tx, srcID, dstID string) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, ts FROM logs WHERE stream_id = ? ORDER BY ts", srcID)
+	if err != nil {
+		return err
+	}
+	type move struct{ id, ts int64 }
+	var moves []move
+	for rows.Next() {
+		var m move
+		if err := rows.Scan(&m.id, &m.ts); err != nil {
+			rows.Close()
+			return err
+		}
+		moves = append(moves, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range moves {
+		// Nudge the timestamp until it is free rather than overwrite
+		for {
+			var taken int
+			err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM logs WHERE stream_id = ? AND ts = ?", dstID, m.ts).Scan(&taken)
+			if err != nil {
+				return err
+			}
+			if taken == 0 {
+				break
+			}
+			m.ts++
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE logs SET stream_id = ?, ts = ? WHERE id = ?", dstID, m.ts, m.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mergeRollupsSQL(ctx context.Context, tx *sql.Tx, srcID, dstID string) error {
+	for _, resolution := range []string{ResolutionMinute, ResolutionHour} {
+		buckets, err := readRollupsSQL(ctx, tx, srcID, resolution, time.Unix(0, 0))
+		if err != nil {
+			return err
+		}
+		for _, bucket := range buckets {
+			if err := addRollupSQL(ctx, tx, dstID, resolution, bucket); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func mergeAnalysesSQL(ctx context.Context, tx *sql.Tx, srcID, dstID string) error {
+	rows, err := tx.QueryContext(ctx, "SELECT data FROM analyses WHERE stream_id = ? ORDER BY key", srcID)
+	if err != nil {
+		return err
+	}
+	var analyses []Analysis
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			rows.Close()
+			return err
+		}
+		var analysis Analysis
+		if err := json.Unmarshal([]byte(data), &analysis); err != nil {
+			continue
+		}
+		analyses = append(analyses, analysis)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, analysis := range analyses {
+		analysis.StreamID = dstID
+		key := string(analysisKey(&analysis))
+		for {
+			var taken int
+			if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM analyses WHERE key = ?", key).Scan(&taken); err != nil {
+				return err
+			}
+			if taken == 0 {
+				break
+			}
+			key += "~"
+		}
+
+		data, err := json.Marshal(analysis)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO analyses (key, stream_id, data) VALUES (?, ?, ?)", key, dstID, string(data))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mergeContextSQL(ctx context.Context, tx *sql.Tx, srcID, dstID string) error {
+	src, exists, err := readContextSQL(ctx, tx, srcID)
+	if err != nil || !exists {
+		return err
+	}
+
+	dst, exists, err := readContextSQL(ctx, tx, dstID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		dst = &StreamContext{StreamID: dstID}
+	}
+
+	mergeContexts(dst, src)
+	return putContextSQL(ctx, tx, dstID, dst)
+}
+
+// deleteStreamSQL removes every trace of a stream inside tx. Label rows go
+// with their logs through ON DELETE CASCADE.
+func deleteStreamSQL(ctx context.Context, tx *sql.Tx, streamID string) error {
+	statements := []string{
+		"DELETE FROM streams WHERE id = ?",
+		"DELETE FROM contexts WHERE stream_id = ?",
+		"DELETE FROM logs WHERE stream_id = ?",
+		"DELETE FROM rollups WHERE stream_id = ?",
+		"DELETE FROM analyses WHERE stream_id = ?",
+		"DELETE FROM patterns WHERE stream_id = ?",
+		"DELETE FROM events WHERE stream_id = ?",
+		"DELETE FROM fingerprints WHERE stream_id = ?",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt, streamID); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, "DELETE FROM retention WHERE scope = ? AND target = ?", ScopeStream, streamID)
+	return err
+}
@@ -1,33 +1,132 @@
 package storage
 
-import "time"
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+)
 
-// Storage interface for log and context management
+// Storage interface for log and context management. Every method honours
+// cancellation and deadlines on ctx, and lookups of missing streams return
+// an error wrapping ErrNotFound.
 type Storage interface {
 	// Logs
-	StoreLogs(streamID string, logs []LogLine) error
-	GetLogs(streamID string, opts GetLogsOptions) ([]LogLine, error)
+	StoreLogs(ctx context.Context, streamID string, logs []LogLine) error
+	GetLogs(ctx context.Context, streamID string, opts GetLogsOptions) ([]LogLine, error)
 	
 	// Streams
-	ListStreams() ([]Stream, error)
-	GetStream(streamID string) (*Stream, error)
-	UpdateStream(stream *Stream) error
+	ListStreams(ctx context.Context) ([]Stream, error)
+	GetStream(ctx context.Context, streamID string) (*Stream, error)
+	UpdateStream(ctx context.Context, stream *Stream) error
+	PatchStream(ctx context.Context, streamID string, patch StreamPatch) (*Stream, error)
+	DeleteStream(ctx context.Context, streamID string) error
+	MergeStreams(ctx context.Context, srcID, dstID string) error
+	GetTombstone(ctx context.Context, streamID string) (*Tombstone, error) // ErrNotFound unless deleted or merged away
+	GetHistogram(ctx context.Context, streamID string, resolution string, since time.Time) ([]RollupBucket, error)
 	
 	// Context
-	GetContext(streamID string) (*StreamContext, error)
-	UpdateContext(streamID string, ctx *StreamContext) error
+	GetContext(ctx context.Context, streamID string) (*StreamContext, error)
+	UpdateContext(ctx context.Context, streamID string, streamCtx *StreamContext) error
+	MutateContext(ctx context.Context, streamID string, fn func(*StreamContext) error) error
 	
 	// Analysis
-	StoreAnalysis(analysis *Analysis) error
-	GetAnalysisHistory(streamID string, limit int) ([]Analysis, error)
+	StoreAnalysis(ctx context.Context, analysis *Analysis) error
+	GetAnalysisHistory(ctx context.Context, streamID string, limit int) ([]Analysis, error)
+	
+	// Analysis jobs
+	SaveJob(ctx context.Context, job *AnalysisJob) error
+	GetJob(ctx context.Context, id string) (*AnalysisJob, error)
+	ListJobs(ctx context.Context, streamID string) ([]AnalysisJob, error) // "" lists all, oldest first
+	PruneJobs(ctx context.Context, before time.Time) (int, error)        // finished jobs only
+	
+	// Digests
+	SaveDigest(ctx context.Context, digest *Digest) error
+	GetDigest(ctx context.Context, id string) (*Digest, error)
+	ListDigests(ctx context.Context, limit int) ([]Digest, error) // newest first; 0 lists all
+	
+	// Log patterns
+	SavePatterns(ctx context.Context, streamID string, patterns []LogPattern) error // replaces the stream's patterns
+	GetPatterns(ctx context.Context, streamID string) ([]LogPattern, error)         // most frequent first
+	
+	// Events
+	SaveEvent(ctx context.Context, event *Event) error
+	ListEvents(ctx context.Context, opts ListEventsOptions) ([]Event, error) // newest first
+	
+	// Error fingerprints
+	RecordFingerprints(ctx context.Context, streamID string, fingerprints []string, at time.Time) ([]string, error) // returns those new to the stream
+	UnseenFingerprints(ctx context.Context, streamID string, fingerprints []string) ([]string, error)              // same, without recording them
+	
+	// Retention
+	ListRetentionRules(ctx context.Context) ([]RetentionRule, error)
+	SetRetentionRule(ctx context.Context, rule RetentionRule) error
+	DeleteRetentionRule(ctx context.Context, scope, target string) error
+	EnforceRetention(ctx context.Context, streamID string, policy RetentionPolicy, evict EvictFunc) (int, error)
 	
 	// Lifecycle
 	Close() error
 }
 
+// newStreamContext is the empty context returned for streams without one
+func newStreamContext(streamID string) *StreamContext {
+	return &StreamContext{
+		StreamID:  streamID,
+		FirstSeen: time.Now(),
+		Analyses:  []AnalysisSummary{},
+		Patterns:  StreamPatterns{CommonErrors: []string{}},
+	}
+}
+
+// EvictFunc receives logs just before retention deletes them. Returning an
+// error keeps the logs in place.
+type EvictFunc func(logs []LogLine) error
+
 // GetLogsOptions for filtering logs
 type GetLogsOptions struct {
 	Limit  int
 	Since  time.Time
-	Levels []string // ERROR, WARN, INFO, DEBUG
-}
\ No newline at end of file
+	Until  time.Time
+	Levels []string          // ERROR, WARN, INFO, DEBUG
+	Labels map[string]string // Every pair must match
+	Search string            // Case-insensitive substring of the message
+}
+
+// matches applies the level, label and search filters; time bounds are left
+// to the caller, which can usually stop scanning early
+func (o GetLogsOptions) matches(log LogLine) bool {
+	if len(o.Levels) > 0 && !contains(o.Levels, log.Level) {
+		return false
+	}
+	for k, v := range o.Labels {
+		if got, ok := log.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	if o.Search != "" && !strings.Contains(strings.ToLower(log.Message), strings.ToLower(o.Search)) {
+		return false
+	}
+	return true
+}
+
+// Backuper is implemented by backends that can stream a consistent snapshot
+// of their database file while running
+type Backuper interface {
+	Backup(w io.Writer) (int64, error)
+}
+
+// KeyRotator is implemented by backends that encrypt data at rest
+type KeyRotator interface {
+	RotateKey(ctx context.Context) error
+	EncryptionStatus() EncryptionStatus
+}
+
+// Unwrap returns the innermost backend beneath wrappers such as TieredStorage
+func Unwrap(s Storage) Storage {
+	for {
+		w, ok := s.(interface{ Unwrap() Storage })
+		if !ok {
+			return s
+		}
+		s = w.Unwrap()
+	}
+}
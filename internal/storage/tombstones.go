@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxTombstoneHops bounds how long a chain of merges ResolveStream follows
+const maxTombstoneHops = 32
+
+// Tombstone records that a stream was deleted or merged into another, so
+// sources still producing its logs do not bring it back. UpdateStream
+// removes it, since creating a stream explicitly is deliberate.
+type Tombstone struct {
+	StreamID   string    `json:"stream_id"`
+	MergedInto string    `json:"merged_into,omitempty"` // empty if deleted
+	At         time.Time `json:"at"`
+}
+
+// err is what StoreLogs returns for the removed stream
+func (t *Tombstone) err() error {
+	if t.MergedInto != "" {
+		return fmt.Errorf("stream %q was merged into %q: %w", t.StreamID, t.MergedInto, ErrRemoved)
+	}
+	return fmt.Errorf("stream %q was deleted: %w", t.StreamID, ErrRemoved)
+}
+
+// ResolveStream follows merges from streamID to the stream that now holds
+// its logs, which is streamID itself unless it was removed. It returns
+// ErrRemoved if that stream was deleted.
+func ResolveStream(ctx context.Context, store Storage, streamID string) (string, error) {
+	for range maxTombstoneHops {
+		t, err := store.GetTombstone(ctx, streamID)
+		if errors.Is(err, ErrNotFound) {
+			return streamID, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if t.MergedInto == "" {
+			return "", t.err()
+		}
+		streamID = t.MergedInto
+	}
+	return "", fmt.Errorf("stream %q: too many merges to follow: %w", streamID, ErrConflict)
+}
@@ -1,30 +1,91 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"embed"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
+	"logvoyant/internal/analyzer"
 	"logvoyant/internal/ingest"
 	"logvoyant/internal/server"
 	"logvoyant/internal/storage"
 )
 
+const adminTokenEnv = "LOGVOYANT_ADMIN_TOKEN"
+
 //go:embed internal/web/ui
 var staticFiles embed.FS
 
 var (
-	port     = flag.Int("port", 3100, "HTTP server port")
-	groqKey  = flag.String("groq-key", "", "Groq API key for LLM analysis (optional)")
-	dbPath   = flag.String("db", "./logvoyant.db", "BoltDB database path")
-	discover = flag.Bool("discover", true, "Auto-discover log sources")
+	port       = flag.Int("port", 3100, "HTTP server port")
+	groqKey    = flag.String("groq-key", "", "Groq API key for LLM analysis (optional; implies -llm-provider groq)")
+	dbPath     = flag.String("db", "./logvoyant.db", "Database path, or :memory: for an ephemeral in-memory store")
+	backend    = flag.String("storage", "bolt", "Storage backend: bolt or sqlite")
+	discover   = flag.Bool("discover", true, "Auto-discover log sources")
+	adminToken = flag.String("admin-token", "", "Bearer token required by /api/admin (default: $"+adminTokenEnv+"; if unset, those endpoints only answer localhost)")
+
+	retentionLines  = flag.Int("retention-lines", 10000, "Default max log lines kept per stream (0 = unlimited)")
+	retentionAge    = flag.Duration("retention-age", 0, "Default max age of logs kept per stream (0 = unlimited)")
+	retentionBytes  = flag.Int64("retention-bytes", 0, "Default max bytes of logs kept per stream (0 = unlimited)")
+	compactInterval = flag.Duration("compact-interval", time.Minute, "How often retention policies are enforced")
+	archiveDir      = flag.String("archive-dir", "", "Directory for compressed segments of logs evicted by retention (empty = discard)")
+	archiveAge      = flag.Duration("archive-age", 0, "Delete archived segments older than this (0 = keep forever)")
+
+	compress      = flag.Bool("compress", false, "Compress larger log values in the database with zstd")
+	backupMigrate = flag.Bool("backup-before-migrate", true, "Copy the database aside before applying schema migrations")
+	keyFile       = flag.String("encryption-key-file", "", keyFileUsage)
+
+	llmProvider    = flag.String("llm-provider", "", "LLM provider: groq, openai (any OpenAI-compatible API) or ollama (default: groq if -groq-key is set, else none)")
+	llmBaseURL     = flag.String("llm-base-url", "", "LLM API base URL (default: the provider's public endpoint)")
+	llmAPIKey      = flag.String("llm-api-key", "", "LLM API key (default: -groq-key)")
+	llmModel       = flag.String("llm-model", "", "LLM model name (default: the provider's default)")
+	llmTemperature = flag.Float64("llm-temperature", 0.3, "LLM sampling temperature")
+	llmTimeout     = flag.Duration("llm-timeout", 30*time.Second, "Timeout for each LLM request attempt, or for a streamed reply to start")
+	llmRetries     = flag.Int("llm-retries", 2, "Retries after an LLM rate limit, server error or timeout")
+	llmThreshold   = flag.Int("llm-breaker-threshold", 5, "Consecutive LLM failures before analysis skips straight to pattern matching")
+	llmCooldown    = flag.Duration("llm-breaker-cooldown", 30*time.Second, "How long to skip the LLM after the breaker opens")
+	llmTokens      = flag.Int("llm-prompt-tokens", 6000, "Approximate token budget for each analysis prompt")
+	configDir      = flag.String("config-dir", defaultConfigDir(), "Configuration directory; prompt templates are read from its prompts/ subdirectory and digest schedules from schedules.json")
+
+	analysisWorkers = flag.Int("analysis-workers", 2, "Analyses run at once; further requests wait in the queue")
+	analysisQueue   = flag.Int("analysis-queue", 100, "Analysis requests that may wait for a worker before new ones are refused")
+
+	autoAnalyze    = flag.Bool("auto-analyze", true, "Analyze a stream automatically when its errors spike")
+	spikeThreshold = flag.Float64("spike-threshold", 0.2, "Share of a minute's lines that are errors before a stream counts as spiking (0 = off)")
+	spikeSigma     = flag.Float64("spike-sigma", 3, "Standard deviations above a stream's usual errors per minute that count as a spike (0 = off)")
+	spikeMinErrors = flag.Int("spike-min-errors", 5, "Errors a minute needs before it can count as a spike")
+	spikeCooldown  = flag.Duration("spike-cooldown", 10*time.Minute, "Minimum time between automatic analyses of one stream")
+
+	detectAnomalies  = flag.Bool("detect-anomalies", true, "Record an event when a stream's hourly volume or WARN/ERROR mix is far from its usual level for that hour of the week")
+	anomalyThreshold = flag.Float64("anomaly-threshold", 3.5, "Robust z-score beyond which volume or level mix counts as anomalous")
+
+	detectNovelErrors = flag.Bool("detect-novel-errors", true, "Label the first occurrence of an error never seen before on its stream and record it as an event")
+
+	errorHalfLife = flag.Duration("error-half-life", 24*time.Hour, "How fast a stream's recurring errors fade from its common errors without new occurrences")
 )
 
 func main() {
+	// Subcommands have their own flags; "start" is the default and optional
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatalf("%s: %v", os.Args[1], err)
+			}
+			return
+		}
+		if os.Args[1] == "start" {
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+
 	flag.Parse()
 
 	fmt.Printf(`
@@ -34,19 +95,113 @@ func main() {
 Context-Aware Log Analysis
 `)
 
-	// Initialize storage
-	store, err := storage.NewBoltStorage(*dbPath)
-	if err != nil {
-		log.Fatalf("Failed to initialize storage: %v", err)
+	// Initialize storage; ":memory:" keeps everything in RAM for throwaway sessions
+	var store storage.Storage
+	if *dbPath == ":memory:" {
+		log.Println("Using in-memory storage; nothing will be persisted")
+		store = storage.NewMemoryStorage()
+	} else {
+		keys, err := storage.LoadKeyring(*keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load encryption keys: %v", err)
+		}
+		store, err = openStorage(*backend, *dbPath, storage.BoltOptions{
+			Compress:            *compress,
+			BackupBeforeMigrate: *backupMigrate,
+			Keys:                keys,
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize storage: %v", err)
+		}
+
+		if keys != nil {
+			log.Printf("Encryption at rest enabled (key %s)", keys.PrimaryID())
+		}
 	}
 	defer store.Close()
 
+	// Archive evicted logs to cold segments and read across both tiers
+	logStore := store
+	var archive *storage.SegmentArchive
+	if *archiveDir != "" {
+		var err error
+		archive, err = storage.NewSegmentArchive(*archiveDir)
+		if err != nil {
+			log.Fatalf("Failed to initialize archive: %v", err)
+		}
+		logStore = storage.NewTieredStorage(store, archive)
+	}
+
+	// Seal anything still in plaintext or under a retired data key,
+	// archived segments included
+	if bolt, ok := store.(*storage.BoltStorage); ok {
+		if archive != nil {
+			bolt.EncryptArchive(archive)
+		}
+		bolt.StartReencryption()
+	}
+
+	// Let watchers such as the spike detector and pattern miner see logs as
+	// they are stored
+	logStore = storage.NewHookedStorage(logStore)
+
+	// Enforce retention in the background
+	compactor := storage.NewCompactor(logStore, storage.CompactorConfig{
+		Interval: *compactInterval,
+		Defaults: storage.RetentionPolicy{
+			MaxLines: *retentionLines,
+			MaxAge:   storage.Duration(*retentionAge),
+			MaxBytes: *retentionBytes,
+		},
+		Archive:       archive,
+		ArchiveMaxAge: *archiveAge,
+	})
+	compactor.Start()
+	defer compactor.Stop()
+
+	// LLM analysis is optional; without a provider the pattern matcher is used
+	llm, err := newLLMProvider()
+	if err != nil {
+		log.Fatalf("Failed to initialize LLM provider: %v", err)
+	}
+
+	// Prompt templates can be overridden and are reloaded when edited
+	templates, err := analyzer.LoadTemplates(filepath.Join(*configDir, "prompts"))
+	if err != nil {
+		log.Fatalf("Failed to load prompt templates: %v", err)
+	}
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	go templates.Watch(watchCtx, 2*time.Second)
+
+	// Digest schedules are optional
+	schedules, err := analyzer.LoadDigestSchedules(filepath.Join(*configDir, "schedules.json"))
+	if err != nil {
+		log.Fatalf("Failed to load digest schedules: %v", err)
+	}
+	for _, schedule := range schedules {
+		log.Printf("Digest %s scheduled at %q", schedule.Name, schedule.Cron)
+	}
+
 	// Initialize server
 	srv := server.New(&server.Config{
 		Port:        *port,
-		Storage:     store,
+		Storage:     logStore,
 		StaticFiles: staticFiles,
-		GroqAPIKey:  *groqKey,
+		LLM:         llm,
+		Budget:      promptBudget(),
+		Templates:   templates,
+		Jobs: analyzer.JobConfig{
+			Workers:   *analysisWorkers,
+			QueueSize: *analysisQueue,
+		},
+		Spikes:      spikeConfig(),
+		Patterns:    analyzer.PatternConfig{ErrorHalfLife: *errorHalfLife},
+		Anomalies:   anomalyConfig(),
+		NovelErrors: *detectNovelErrors,
+		Digests:     schedules,
+		Compactor:   compactor,
+		AdminToken:  cmp.Or(*adminToken, os.Getenv(adminTokenEnv)),
 	})
 
 	// Start server
@@ -57,11 +212,15 @@ Context-Aware Log Analysis
 		}
 	}()
 
+	// Tailers run until shutdown
+	ingestCtx, stopIngest := context.WithCancel(context.Background())
+	defer stopIngest()
+
 	// Auto-discover sources
 	if *discover {
 		fmt.Println("🔍 Auto-discovering log sources...")
 		go func() {
-			if err := ingest.DiscoverAndStart(store, srv.Hub()); err != nil {
+			if err := ingest.DiscoverAndStart(ingestCtx, logStore, srv.Hub()); err != nil {
 				log.Printf("Discovery error: %v", err)
 			}
 		}()
@@ -73,6 +232,77 @@ Context-Aware Log Analysis
 	<-sig
 
 	fmt.Println("\n👋 Shutting down gracefully...")
+	stopIngest()
 	srv.Stop()
 	fmt.Println("✓ Goodbye!")
+}
+
+// newLLMProvider builds the provider chosen by the -llm-* flags, or returns
+// nil if none is configured
+func newLLMProvider() (analyzer.LLMProvider, error) {
+	provider, apiKey := *llmProvider, *llmAPIKey
+	if apiKey == "" {
+		apiKey = *groqKey
+	}
+	if provider == "" && *groqKey != "" {
+		provider = "groq"
+	}
+	if provider == "" || provider == "none" {
+		return nil, nil
+	}
+
+	llm, err := analyzer.NewProvider(analyzer.LLMConfig{
+		Provider:    provider,
+		BaseURL:     *llmBaseURL,
+		APIKey:      apiKey,
+		Model:       *llmModel,
+		Temperature: *llmTemperature,
+		Timeout:     *llmTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("LLM analysis via %s", llm.Name())
+
+	resilience := analyzer.DefaultResilienceConfig()
+	resilience.Timeout = *llmTimeout
+	resilience.MaxRetries = *llmRetries
+	resilience.FailureThreshold = *llmThreshold
+	resilience.Cooldown = *llmCooldown
+	return analyzer.NewResilientProvider(llm, resilience), nil
+}
+
+func promptBudget() analyzer.PromptBudget {
+	budget := analyzer.DefaultPromptBudget()
+	budget.MaxTokens = *llmTokens
+	return budget
+}
+
+// spikeConfig returns the -spike-* settings, or nil if -auto-analyze is off
+func spikeConfig() *analyzer.SpikeConfig {
+	if !*autoAnalyze {
+		return nil
+	}
+	cfg := analyzer.DefaultSpikeConfig()
+	cfg.Threshold = *spikeThreshold
+	cfg.Sigma = *spikeSigma
+	cfg.MinErrors = *spikeMinErrors
+	cfg.Cooldown = *spikeCooldown
+	return &cfg
+}
+
+func anomalyConfig() *analyzer.AnomalyConfig {
+	if !*detectAnomalies {
+		return nil
+	}
+	return &analyzer.AnomalyConfig{Threshold: *anomalyThreshold}
+}
+
+// defaultConfigDir is ~/.logvoyant, or .logvoyant if there is no home directory
+func defaultConfigDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".logvoyant"
+	}
+	return filepath.Join(home, ".logvoyant")
 }
\ No newline at end of file